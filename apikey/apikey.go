@@ -0,0 +1,80 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Scopes which can be granted to an API key.
+const (
+	// ScopeRead allows GET requests.
+	ScopeRead = "read"
+	// ScopeCommand allows requests which change state or queue commands, except erasing devices.
+	ScopeCommand = "command"
+	// ScopeErase allows erasing devices.
+	ScopeErase = "erase"
+	// ScopeAdmin allows creating and revoking API keys.
+	ScopeAdmin = "admin"
+)
+
+var (
+	// ErrNotFound is returned if no active key matches.
+	ErrNotFound = errors.New("api key not found")
+	// ErrInvalidScope is returned when creating a key with an unknown scope.
+	ErrInvalidScope = errors.New("invalid api key scope")
+)
+
+// Key is an API key for the management API. Only a hash of the secret token is stored,
+// the token itself is returned once when the key is created.
+type Key struct {
+	UUID      string         `db:"key_uuid" json:"uuid"`
+	Name      string         `db:"name" json:"name"`
+	Scopes    pq.StringArray `db:"scopes" json:"scopes"`
+	CreatedAt time.Time      `db:"created_at" json:"created_at"`
+	RevokedAt *time.Time     `db:"revoked_at" json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the key was granted scope.
+func (k Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidScopes reports whether every scope is known.
+func ValidScopes(scopes []string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+	for _, s := range scopes {
+		switch s {
+		case ScopeRead, ScopeCommand, ScopeErase, ScopeAdmin:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newToken returns a random token and the hash which is stored.
+func newToken() (token, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
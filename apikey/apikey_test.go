@@ -0,0 +1,48 @@
+package apikey
+
+import "testing"
+
+func TestValidScopes(t *testing.T) {
+	var tests = []struct {
+		scopes []string
+		valid  bool
+	}{
+		{[]string{ScopeRead}, true},
+		{[]string{ScopeRead, ScopeCommand, ScopeErase, ScopeAdmin}, true},
+		{[]string{ScopeRead, "write"}, false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		if have := ValidScopes(tt.scopes); have != tt.valid {
+			t.Errorf("%v: expected %v, got %v", tt.scopes, tt.valid, have)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	key := Key{Scopes: []string{ScopeRead, ScopeCommand}}
+	if !key.HasScope(ScopeCommand) {
+		t.Error("expected key to have the command scope")
+	}
+	if key.HasScope(ScopeErase) {
+		t.Error("expected key not to have the erase scope")
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	token, hash, err := newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != hashToken(token) {
+		t.Error("expected the stored hash to match the token")
+	}
+	other, _, err := newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == other {
+		t.Error("expected tokens to be random")
+	}
+}
@@ -0,0 +1,108 @@
+package apikey
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/micromdm/micromdm/dbpool"
+	"github.com/pkg/errors"
+)
+
+var selectKeysStmt = `SELECT
+		key_uuid,
+		name,
+		scopes,
+		created_at,
+		revoked_at
+		FROM api_keys`
+
+// Datastore manages the API keys of the management API.
+type Datastore interface {
+	// Create stores a new key and returns it with its secret token.
+	Create(name string, scopes []string) (*Key, string, error)
+	// ByToken returns the key with token, unless it was revoked.
+	ByToken(token string) (*Key, error)
+	Keys() ([]Key, error)
+	Revoke(uuid string) error
+}
+
+type pgStore struct {
+	*sqlx.DB
+}
+
+func NewDB(driver, conn string, logger kitlog.Logger, opts ...dbpool.Option) (Datastore, error) {
+	switch driver {
+	case "postgres":
+		db, err := sqlx.Open(driver, conn)
+		if err != nil {
+			return nil, errors.Wrap(err, "api keys datastore")
+		}
+		dbpool.Configure(db.DB, opts...)
+		var dbError error
+		maxAttempts := 20
+		for attempts := 1; attempts <= maxAttempts; attempts++ {
+			dbError = db.Ping()
+			if dbError == nil {
+				break
+			}
+			logger.Log("msg", fmt.Sprintf("could not connect to postgres: %v", dbError))
+			time.Sleep(time.Duration(attempts) * time.Second)
+		}
+		if dbError != nil {
+			return nil, errors.Wrap(dbError, "api keys datastore")
+		}
+		return pgStore{DB: db}, nil
+	default:
+		return nil, errors.New("unknown driver")
+	}
+}
+
+func (store pgStore) Create(name string, scopes []string) (*Key, string, error) {
+	if !ValidScopes(scopes) {
+		return nil, "", ErrInvalidScope
+	}
+	token, hash, err := newToken()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "pgStore Create")
+	}
+	key := Key{Name: name, Scopes: pq.StringArray(scopes), CreatedAt: time.Now().UTC()}
+	err = store.QueryRow(`INSERT INTO api_keys (name, token_sha256, scopes, created_at)
+	VALUES ($1, $2, $3, $4) RETURNING key_uuid`, key.Name, hash, key.Scopes, key.CreatedAt).Scan(&key.UUID)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "pgStore Create")
+	}
+	return &key, token, nil
+}
+
+func (store pgStore) ByToken(token string) (*Key, error) {
+	var key Key
+	err := store.Get(&key, selectKeysStmt+` WHERE token_sha256 = $1 AND revoked_at IS NULL`, hashToken(token))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore ByToken")
+	}
+	return &key, nil
+}
+
+func (store pgStore) Keys() ([]Key, error) {
+	var keys []Key
+	err := store.Select(&keys, selectKeysStmt+` ORDER BY created_at`)
+	return keys, errors.Wrap(err, "pgStore Keys")
+}
+
+func (store pgStore) Revoke(uuid string) error {
+	res, err := store.Exec(`UPDATE api_keys SET revoked_at = $2 WHERE key_uuid = $1 AND revoked_at IS NULL`, uuid, time.Now().UTC())
+	if err != nil {
+		return errors.Wrap(err, "pgStore Revoke")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// Package apns instruments push notifications sent to the Apple Push Notification service.
+package apns
+
+import (
+	"time"
+
+	"github.com/RobotsAndPencils/buford/push"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+)
+
+// Pusher sends push notifications to a device token.
+// It is implemented by *push.Service.
+type Pusher interface {
+	Push(deviceToken string, headers *push.Headers, payload interface{}) (string, error)
+}
+
+// reasons maps the errors returned by the push package back to the
+// reason string APNs responded with.
+var reasons = map[error]string{
+	push.ErrPayloadEmpty:              "PayloadEmpty",
+	push.ErrPayloadTooLarge:           "PayloadTooLarge",
+	push.ErrBadTopic:                  "BadTopic",
+	push.ErrTopicDisallowed:           "TopicDisallowed",
+	push.ErrBadMessageID:              "BadMessageId",
+	push.ErrBadExpirationDate:         "BadExpirationDate",
+	push.ErrBadPriority:               "BadPriority",
+	push.ErrMissingDeviceToken:        "MissingDeviceToken",
+	push.ErrBadDeviceToken:            "BadDeviceToken",
+	push.ErrDeviceTokenNotForTopic:    "DeviceTokenNotForTopic",
+	push.ErrUnregistered:              "Unregistered",
+	push.ErrDuplicateHeaders:          "DuplicateHeaders",
+	push.ErrBadCertificateEnvironment: "BadCertificateEnvironment",
+	push.ErrBadCertificate:            "BadCertificate",
+	push.ErrForbidden:                 "Forbidden",
+	push.ErrBadPath:                   "BadPath",
+	push.ErrMethodNotAllowed:          "MethodNotAllowed",
+	push.ErrTooManyRequests:           "TooManyRequests",
+	push.ErrIdleTimeout:               "IdleTimeout",
+	push.ErrShutdown:                  "Shutdown",
+	push.ErrInternalServerError:       "InternalServerError",
+	push.ErrServiceUnavailable:        "ServiceUnavailable",
+	push.ErrMissingTopic:              "MissingTopic",
+	push.ErrBadRequest:                "BadRequest",
+	push.ErrUnknown:                   "Unknown",
+}
+
+// Reason returns the APNs reason for a failed push.
+// Errors which did not come from APNs, like a failed connection, return "Transport".
+func Reason(err error) string {
+	if e, ok := err.(*push.Error); ok {
+		err = e.Err
+	}
+	if reason, ok := reasons[err]; ok {
+		return reason
+	}
+	return "Transport"
+}
+
+// IsUnregistered returns true if APNs responded with 410 Unregistered,
+// meaning the device token is no longer valid for the topic.
+func IsUnregistered(err error) bool {
+	return err != nil && Reason(err) == "Unregistered"
+}
+
+// Instrument records the outcome and latency of every push sent through next.
+// pushes is labeled with status (success or failure) and the APNs reason.
+func Instrument(next Pusher, pushes metrics.Counter, latency metrics.Histogram, logger kitlog.Logger) Pusher {
+	return instrumentingPusher{
+		next:    next,
+		pushes:  pushes,
+		latency: latency,
+		logger:  logger,
+	}
+}
+
+type instrumentingPusher struct {
+	next    Pusher
+	pushes  metrics.Counter
+	latency metrics.Histogram
+	logger  kitlog.Logger
+}
+
+func (p instrumentingPusher) Push(deviceToken string, headers *push.Headers, payload interface{}) (string, error) {
+	defer func(begin time.Time) {
+		p.latency.Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	id, err := p.next.Push(deviceToken, headers, payload)
+	if err != nil {
+		reason := Reason(err)
+		p.pushes.With("status", "failure", "reason", reason).Add(1)
+		if reason == "Unregistered" {
+			p.logger.Log("msg", "device token unregistered with APNs", "token", deviceToken, "err", err)
+		}
+		return id, err
+	}
+	p.pushes.With("status", "success", "reason", "").Add(1)
+	return id, nil
+}
@@ -0,0 +1,73 @@
+package apns
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RobotsAndPencils/buford/push"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+// labelCounter records the label values of every call to Add.
+type labelCounter struct {
+	lvs   []string
+	added *[][]string
+}
+
+func (c labelCounter) With(labelValues ...string) metrics.Counter {
+	return labelCounter{lvs: append(c.lvs, labelValues...), added: c.added}
+}
+
+func (c labelCounter) Add(delta float64) {
+	*c.added = append(*c.added, c.lvs)
+}
+
+func TestInstrumentUnregistered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte(`{"reason":"Unregistered","timestamp":1475000000000}`))
+	}))
+	defer server.Close()
+
+	var added [][]string
+	pusher := Instrument(
+		&push.Service{Client: server.Client(), Host: server.URL},
+		labelCounter{added: &added},
+		discard.NewHistogram(),
+		kitlog.NewNopLogger(),
+	)
+
+	_, err := pusher.Push("abc123", nil, map[string]string{"mdm": "magic"})
+	if !IsUnregistered(err) {
+		t.Fatalf("expected an unregistered error, got %v", err)
+	}
+	if len(added) != 1 {
+		t.Fatalf("expected 1 push to be counted, got %d", len(added))
+	}
+	want := []string{"status", "failure", "reason", "Unregistered"}
+	for i, v := range want {
+		if added[0][i] != v {
+			t.Errorf("have labels %v, want %v", added[0], want)
+			break
+		}
+	}
+}
+
+func TestReason(t *testing.T) {
+	var tests = []struct {
+		err    error
+		reason string
+	}{
+		{push.ErrBadDeviceToken, "BadDeviceToken"},
+		{&push.Error{Err: push.ErrUnregistered}, "Unregistered"},
+		{http.ErrHandlerTimeout, "Transport"},
+	}
+	for _, tt := range tests {
+		if have := Reason(tt.err); have != tt.reason {
+			t.Errorf("Reason(%v): have %s, want %s", tt.err, have, tt.reason)
+		}
+	}
+}
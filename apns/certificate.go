@@ -0,0 +1,126 @@
+package apns
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/RobotsAndPencils/buford/certificate"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// certLoader is a strategy which decodes a push certificate and its private key.
+type certLoader struct {
+	name string
+	load func(data []byte, password string) (*x509.Certificate, *rsa.PrivateKey, error)
+}
+
+// certLoaders are tried in order until one decodes the file.
+var certLoaders = []certLoader{
+	{"pkcs12", loadPKCS12},
+	// some exports have no password, even though one was configured.
+	{"pkcs12 without password", func(data []byte, _ string) (*x509.Certificate, *rsa.PrivateKey, error) {
+		return loadPKCS12(data, "")
+	}},
+	{"pem bundle", loadPEM},
+}
+
+// LoadCertificate loads a push certificate and its private key from a PKCS#12 file,
+// with or without a password, or from a PEM bundle with the certificate and the RSA
+// private key. It returns the name of the strategy which decoded the file.
+// An expired certificate returns certificate.ErrExpired.
+func LoadCertificate(path, password string) (*x509.Certificate, *rsa.PrivateKey, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	var failures []string
+	for _, loader := range certLoaders {
+		cert, key, err := loader.load(data, password)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", loader.name, err))
+			continue
+		}
+		now := time.Now()
+		if now.After(cert.NotAfter) || now.Before(cert.NotBefore) {
+			return nil, nil, loader.name, certificate.ErrExpired
+		}
+		return cert, key, loader.name, nil
+	}
+	return nil, nil, "", fmt.Errorf("loading push certificate %s: %s", path, strings.Join(failures, "; "))
+}
+
+func loadPKCS12(data []byte, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	key, cert, err := pkcs12.Decode(data, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("private key is not an RSA key")
+	}
+	return cert, priv, nil
+}
+
+// loadPEM decodes a PEM bundle with certificates and an RSA private key, in any order.
+// The certificate is the one which matches the key. An encrypted key is decrypted with the password.
+func loadPEM(data []byte, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	var certs []*x509.Certificate
+	var key *rsa.PrivateKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			certs = append(certs, cert)
+		case "RSA PRIVATE KEY", "PRIVATE KEY":
+			der := block.Bytes
+			if x509.IsEncryptedPEMBlock(block) {
+				var err error
+				if der, err = x509.DecryptPEMBlock(block, []byte(password)); err != nil {
+					return nil, nil, err
+				}
+			}
+			var err error
+			if key, err = parseRSAKey(der); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if key == nil {
+		return nil, nil, errors.New("no RSA private key found")
+	}
+	for _, cert := range certs {
+		if pub, ok := cert.PublicKey.(*rsa.PublicKey); ok && pub.N.Cmp(key.N) == 0 {
+			return cert, key, nil
+		}
+	}
+	return nil, nil, errors.New("no certificate matches the private key")
+}
+
+func parseRSAKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return priv, nil
+}
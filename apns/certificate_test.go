@@ -0,0 +1,110 @@
+package apns
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RobotsAndPencils/buford/certificate"
+)
+
+func selfSigned(t *testing.T, cn string, notAfter time.Time) ([]byte, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, key
+}
+
+func writeBundle(t *testing.T, dir string, blocks ...*pem.Block) string {
+	var buf bytes.Buffer
+	for _, block := range blocks {
+		pem.Encode(&buf, block)
+	}
+	path := filepath.Join(dir, "push.pem")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCertificatePEMBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pushDER, pushKey := selfSigned(t, "APSP:push", time.Now().Add(time.Hour))
+	caDER, _ := selfSigned(t, "Apple WWDR", time.Now().Add(time.Hour))
+	keyBlock := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(pushKey)}
+
+	// the key comes first and the chain includes an intermediate.
+	path := writeBundle(t, dir,
+		keyBlock,
+		&pem.Block{Type: "CERTIFICATE", Bytes: caDER},
+		&pem.Block{Type: "CERTIFICATE", Bytes: pushDER},
+	)
+	cert, key, strategy, err := LoadCertificate(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strategy != "pem bundle" {
+		t.Errorf("have strategy %q, want pem bundle", strategy)
+	}
+	if cert.Subject.CommonName != "APSP:push" || key.N.Cmp(pushKey.N) != 0 {
+		t.Errorf("have certificate %q, want the certificate matching the key", cert.Subject.CommonName)
+	}
+
+	// an encrypted key is decrypted with the push certificate password.
+	encrypted, err := x509.EncryptPEMBlock(rand.Reader, keyBlock.Type, keyBlock.Bytes, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = writeBundle(t, dir, &pem.Block{Type: "CERTIFICATE", Bytes: pushDER}, encrypted)
+	if _, _, _, err := LoadCertificate(path, "secret"); err != nil {
+		t.Errorf("loading bundle with encrypted key: %s", err)
+	}
+
+	// a key without its certificate is not a push identity.
+	path = writeBundle(t, dir, keyBlock, &pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	if _, _, _, err := LoadCertificate(path, ""); err == nil {
+		t.Error("expected an error for a bundle without the certificate of the key")
+	}
+}
+
+func TestLoadCertificateExpired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	der, key := selfSigned(t, "APSP:push", time.Now().Add(-time.Minute))
+	path := writeBundle(t, dir,
+		&pem.Block{Type: "CERTIFICATE", Bytes: der},
+		&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+	)
+	if _, _, _, err := LoadCertificate(path, ""); err != certificate.ErrExpired {
+		t.Errorf("have %v, want %v", err, certificate.ErrExpired)
+	}
+}
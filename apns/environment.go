@@ -0,0 +1,55 @@
+package apns
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/RobotsAndPencils/buford/push"
+)
+
+// APNs environments.
+const (
+	Production  = "production"
+	Development = "development"
+)
+
+// Apple push certificates carry an extension for each environment they can be used with.
+var (
+	oidDevelopment = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 3, 1}
+	oidProduction  = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 3, 2}
+)
+
+// Host returns the APNs host of the environment.
+func Host(env string) (string, error) {
+	switch env {
+	case Production:
+		return push.Production, nil
+	case Development:
+		return push.Development, nil
+	default:
+		return "", fmt.Errorf("unknown APNs environment %q, must be %s or %s", env, Production, Development)
+	}
+}
+
+// ValidForEnvironment returns false if the push certificate has environment
+// extensions and none of them is for env. Certificates without environment
+// extensions are assumed to be valid.
+func ValidForEnvironment(cert *x509.Certificate, env string) bool {
+	var development, production bool
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidDevelopment):
+			development = true
+		case ext.Id.Equal(oidProduction):
+			production = true
+		}
+	}
+	if !development && !production {
+		return true
+	}
+	if env == Development {
+		return development
+	}
+	return production
+}
@@ -0,0 +1,35 @@
+package apns
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestValidForEnvironment(t *testing.T) {
+	ext := func(oids ...asn1.ObjectIdentifier) *x509.Certificate {
+		cert := &x509.Certificate{}
+		for _, oid := range oids {
+			cert.Extensions = append(cert.Extensions, pkix.Extension{Id: oid})
+		}
+		return cert
+	}
+	var tests = []struct {
+		cert  *x509.Certificate
+		env   string
+		valid bool
+	}{
+		{ext(oidProduction), Production, true},
+		{ext(oidProduction), Development, false},
+		{ext(oidDevelopment), Production, false},
+		{ext(oidDevelopment, oidProduction), Development, true},
+		// no environment extensions, like MDM push certificates
+		{ext(), Development, true},
+	}
+	for i, tt := range tests {
+		if have := ValidForEnvironment(tt.cert, tt.env); have != tt.valid {
+			t.Errorf("%d: %s: expected %v, got %v", i, tt.env, tt.valid, have)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package apns
+
+import (
+	"time"
+
+	"github.com/RobotsAndPencils/buford/push"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/micromdm/micromdm/device"
+)
+
+// transient are the failures which may succeed when the push is sent again.
+var transient = map[string]bool{
+	"Transport":           true,
+	"TooManyRequests":     true,
+	"IdleTimeout":         true,
+	"Shutdown":            true,
+	"InternalServerError": true,
+	"ServiceUnavailable":  true,
+}
+
+// IsTransient returns true if a push failed because APNs could not be
+// reached or was temporarily unable to accept it.
+func IsTransient(err error) bool {
+	return err != nil && transient[Reason(err)]
+}
+
+// Retry sends a push again up to attempts times while it fails with a transient error,
+// waiting backoff before the first retry and doubling the wait after every retry.
+// The device is marked as push pending when the first attempt fails, and the retries
+// run in the background so that the caller does not wait for them. Push returns the
+// error of the first attempt. The marker is cleared by the next successful push,
+// or if every retry fails, when the device checks in or the push is resent at startup.
+func Retry(attempts int, backoff time.Duration, devices device.Datastore, logger kitlog.Logger) func(Pusher) Pusher {
+	return func(next Pusher) Pusher {
+		return retryingPusher{
+			next:     next,
+			attempts: attempts,
+			backoff:  backoff,
+			devices:  devices,
+			logger:   logger,
+			sleep:    time.Sleep,
+			async:    func(f func()) { go f() },
+		}
+	}
+}
+
+type retryingPusher struct {
+	next     Pusher
+	attempts int
+	backoff  time.Duration
+	devices  device.Datastore
+	logger   kitlog.Logger
+	sleep    func(time.Duration)
+	async    func(func())
+}
+
+func (p retryingPusher) Push(deviceToken string, headers *push.Headers, payload interface{}) (string, error) {
+	id, err := p.next.Push(deviceToken, headers, payload)
+	if err == nil {
+		p.clearPending(deviceToken)
+		return id, nil
+	}
+	if !IsTransient(err) {
+		return id, err
+	}
+	if err := p.devices.MarkPushPending(deviceToken); err != nil {
+		p.logger.Log("msg", "marking push pending", "token", deviceToken, "err", err)
+	}
+	if p.attempts > 1 {
+		p.async(func() { p.retry(deviceToken, headers, payload, err) })
+	}
+	return id, err
+}
+
+// retry sends the push again after the first attempt failed with err.
+func (p retryingPusher) retry(deviceToken string, headers *push.Headers, payload interface{}, err error) {
+	wait := p.backoff
+	for attempt := 1; attempt < p.attempts; attempt++ {
+		p.logger.Log("msg", "retrying push", "token", deviceToken, "attempt", attempt, "wait", wait, "err", err)
+		p.sleep(wait)
+		wait *= 2
+		_, err = p.next.Push(deviceToken, headers, payload)
+		if err == nil {
+			p.clearPending(deviceToken)
+			return
+		}
+		if !IsTransient(err) {
+			break
+		}
+	}
+	p.logger.Log("msg", "giving up on push, the push is resent at startup", "token", deviceToken, "err", err)
+}
+
+func (p retryingPusher) clearPending(deviceToken string) {
+	if err := p.devices.ClearPushPending(deviceToken); err != nil {
+		p.logger.Log("msg", "clearing pending push", "token", deviceToken, "err", err)
+	}
+}
@@ -0,0 +1,110 @@
+package apns
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RobotsAndPencils/buford/push"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/micromdm/micromdm/device"
+)
+
+// pendingStore records the tokens marked as push pending.
+type pendingStore struct {
+	device.Datastore
+	pending map[string]bool
+}
+
+func (s *pendingStore) MarkPushPending(token string) error {
+	s.pending[token] = true
+	return nil
+}
+
+func (s *pendingStore) ClearPushPending(token string) error {
+	delete(s.pending, token)
+	return nil
+}
+
+// failingPusher returns the next error in errs for every push.
+type failingPusher struct {
+	errs  []error
+	calls *int
+}
+
+func (p failingPusher) Push(deviceToken string, headers *push.Headers, payload interface{}) (string, error) {
+	err := p.errs[*p.calls]
+	*p.calls++
+	return "id", err
+}
+
+func TestRetry(t *testing.T) {
+	unreachable := errors.New("dial tcp: connection refused")
+	var tests = []struct {
+		name    string
+		errs    []error
+		calls   int
+		err     bool
+		retried bool
+		pending bool
+	}{
+		{"sent", []error{nil}, 1, false, false, false},
+		{"recovers", []error{unreachable, push.ErrServiceUnavailable, nil}, 3, true, true, false},
+		{"gives up", []error{unreachable, unreachable, unreachable}, 3, true, true, true},
+		{"permanent failure", []error{push.ErrBadDeviceToken}, 1, true, false, false},
+	}
+	for _, tt := range tests {
+		var calls int
+		var waits []time.Duration
+		var retried bool
+		store := &pendingStore{pending: make(map[string]bool)}
+		pusher := Retry(3, time.Second, store, kitlog.NewNopLogger())(failingPusher{errs: tt.errs, calls: &calls}).(retryingPusher)
+		pusher.sleep = func(d time.Duration) { waits = append(waits, d) }
+		var background func()
+		pusher.async = func(f func()) {
+			retried = true
+			background = f
+		}
+
+		_, err := pusher.Push("abc123", nil, nil)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.err, err)
+		}
+		if len(waits) != 0 {
+			t.Errorf("%s: expected Push not to wait for the retries", tt.name)
+		}
+		if retried != tt.retried {
+			t.Errorf("%s: expected retried %v, got %v", tt.name, tt.retried, retried)
+		}
+		if retried && !store.pending["abc123"] {
+			t.Errorf("%s: expected the device to be pending while the push is retried", tt.name)
+		}
+		if background != nil {
+			background()
+		}
+
+		if calls != tt.calls {
+			t.Errorf("%s: expected %d attempts, got %d", tt.name, tt.calls, calls)
+		}
+		if pending := store.pending["abc123"]; pending != tt.pending {
+			t.Errorf("%s: expected pending %v, got %v", tt.name, tt.pending, pending)
+		}
+		for i, wait := range waits {
+			if want := time.Second << uint(i); wait != want {
+				t.Errorf("%s: expected retry %d to wait %v, got %v", tt.name, i+1, want, wait)
+			}
+		}
+	}
+}
+
+func TestRetryClearsPending(t *testing.T) {
+	var calls int
+	store := &pendingStore{pending: map[string]bool{"abc123": true}}
+	pusher := Retry(3, time.Second, store, kitlog.NewNopLogger())(failingPusher{errs: []error{nil}, calls: &calls})
+	if _, err := pusher.Push("abc123", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if store.pending["abc123"] {
+		t.Error("expected a successful push to clear the pending push")
+	}
+}
@@ -0,0 +1,47 @@
+package apns
+
+import (
+	"github.com/RobotsAndPencils/buford/push"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/micromdm/micromdm/device"
+)
+
+// UnenrollUnregistered marks devices as no longer enrolled when APNs responds
+// with 410 Unregistered for their push token. APNs only does this once the
+// MDM profile has been removed from the device, typically by a wipe which
+// never sent a CheckOut.
+func UnenrollUnregistered(devices device.Datastore, logger kitlog.Logger) func(Pusher) Pusher {
+	return func(next Pusher) Pusher {
+		return unenrollingPusher{next: next, devices: devices, logger: logger}
+	}
+}
+
+type unenrollingPusher struct {
+	next    Pusher
+	devices device.Datastore
+	logger  kitlog.Logger
+}
+
+func (p unenrollingPusher) Push(deviceToken string, headers *push.Headers, payload interface{}) (string, error) {
+	id, err := p.next.Push(deviceToken, headers, payload)
+	if IsUnregistered(err) {
+		p.unenroll(deviceToken)
+	}
+	return id, err
+}
+
+func (p unenrollingPusher) unenroll(deviceToken string) {
+	devices, err := p.devices.Devices(device.PushToken{Token: deviceToken})
+	if err != nil {
+		p.logger.Log("msg", "looking up unregistered device", "token", deviceToken, "err", err)
+		return
+	}
+	for _, dev := range devices {
+		dev.Enrolled = false
+		if err := p.devices.Save("checkout", &dev); err != nil {
+			p.logger.Log("msg", "marking device unenrolled", "device_uuid", dev.UUID, "err", err)
+			continue
+		}
+		p.logger.Log("msg", "device unenrolled after APNs 410", "device_uuid", dev.UUID)
+	}
+}
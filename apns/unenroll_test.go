@@ -0,0 +1,68 @@
+package apns
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RobotsAndPencils/buford/push"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/micromdm/micromdm/device"
+)
+
+// deviceStore is a device.Datastore holding devices in memory.
+// Only the methods used by unenrollingPusher are implemented.
+type deviceStore struct {
+	device.Datastore
+	devices map[string]*device.Device
+	saved   []string
+}
+
+func (s *deviceStore) Devices(params ...interface{}) ([]device.Device, error) {
+	var devices []device.Device
+	for _, p := range params {
+		if f, ok := p.(device.PushToken); ok {
+			for _, dev := range s.devices {
+				if dev.Token == f.Token {
+					devices = append(devices, *dev)
+				}
+			}
+		}
+	}
+	return devices, nil
+}
+
+func (s *deviceStore) Save(msg string, dev *device.Device) error {
+	s.saved = append(s.saved, msg)
+	*s.devices[dev.UUID] = *dev
+	return nil
+}
+
+func TestUnenrollUnregistered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte(`{"reason":"Unregistered","timestamp":1475000000000}`))
+	}))
+	defer server.Close()
+
+	store := &deviceStore{devices: map[string]*device.Device{
+		"wiped":  {UUID: "wiped", Token: "abc123", Enrolled: true},
+		"active": {UUID: "active", Token: "def456", Enrolled: true},
+	}}
+	pusher := UnenrollUnregistered(store, kitlog.NewNopLogger())(
+		&push.Service{Client: server.Client(), Host: server.URL},
+	)
+
+	if _, err := pusher.Push("abc123", nil, map[string]string{"mdm": "magic"}); !IsUnregistered(err) {
+		t.Fatalf("expected an unregistered error, got %v", err)
+	}
+	if store.devices["wiped"].Enrolled {
+		t.Error("expected device to be marked unenrolled after a 410")
+	}
+	if !store.devices["active"].Enrolled {
+		t.Error("expected other devices to remain enrolled")
+	}
+	if len(store.saved) != 1 || store.saved[0] != "checkout" {
+		t.Errorf("have saves %v, want [checkout]", store.saved)
+	}
+}
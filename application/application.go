@@ -2,6 +2,11 @@ package application
 
 import "database/sql"
 
+// StateUnmanaged is the install state of an application installed by MDM
+// which is missing from the last ManagedApplicationList response.
+// The app was either removed or the user took over its management.
+const StateUnmanaged = "Unmanaged"
+
 type Application struct {
 	UUID         string         `plist:",omitempty" json:"uuid,omitempty" db:"application_uuid"`
 	Identifier   sql.NullString `plist:",omitempty" json:"identifier,omitempty" db:"identifier"`
@@ -15,6 +20,13 @@ type Application struct {
 
 	// iOS only.
 	IsValidated sql.NullBool `plist:",omitempty" json:"is_validated,omitempty" db:"is_validated"`
+
+	// The last state reported for an InstallApplication command, ex: Installing, Managed.
+	// Only set for applications installed by MDM.
+	InstallState sql.NullString `plist:",omitempty" json:"install_state,omitempty" db:"install_state"`
+
+	// ManagementFlags reported in the last ManagedApplicationList response.
+	ManagementFlags sql.NullInt64 `plist:",omitempty" json:"management_flags,omitempty" db:"management_flags"`
 }
 
 type DeviceApplication struct {
@@ -32,4 +44,18 @@ type DeviceApplication struct {
 
 	// iOS only.
 	IsValidated sql.NullBool `plist:",omitempty" json:"is_validated,omitempty" db:"is_validated"`
+
+	// The last state reported for an InstallApplication command, ex: Installing, Managed.
+	// Only set for applications installed by MDM.
+	InstallState sql.NullString `plist:",omitempty" json:"install_state,omitempty" db:"install_state"`
+
+	// ManagementFlags reported in the last ManagedApplicationList response.
+	ManagementFlags sql.NullInt64 `plist:",omitempty" json:"management_flags,omitempty" db:"management_flags"`
+}
+
+// ManagedApplication is an entry of a ManagedApplicationList response.
+type ManagedApplication struct {
+	Identifier      string
+	Status          string
+	ManagementFlags int
 }
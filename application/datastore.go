@@ -1,34 +1,54 @@
 package application
 
 import (
+	"database/sql"
 	"fmt"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq" // postgres driver
+	"github.com/lib/pq"
+	"github.com/micromdm/micromdm/dbpool"
 	"github.com/pkg/errors"
+	"strings"
 	"time"
 )
 
 // This Datastore manages a list of known applications, and their relationship to devices.
 type Datastore interface {
 	NewDeviceApp(da *DeviceApplication) error
+	// ReplaceDeviceApps replaces the applications a device reported in its InstalledApplicationList.
+	ReplaceDeviceApps(deviceUUID string, apps []DeviceApplication) error
 	Applications(params ...interface{}) ([]Application, error)
 	GetApplicationsByDeviceUUID(deviceUUID string) ([]Application, error)
 	SaveApplicationByDeviceUUID(deviceUUID string, app *Application) error
 	DeleteDeviceApplications(deviceUUID string) error
+	SaveDeviceAppInstallState(deviceUUID, identifier, state string) error
+	DeleteDeviceApp(deviceUUID, identifier string) error
+	ReconcileManagedApps(deviceUUID string, apps []ManagedApplication, complete bool) error
+
+	// fleet
+	AppCounts(query string) ([]AppCount, error)
+	AppInstalls(identifier string) ([]AppInstall, error)
+	AppVersions(identifier string) ([]AppVersion, error)
+
+	// vpp
+	SaveVPPApp(app *VPPApp) error
+	VPPApps() ([]VPPApp, error)
+	AssignVPPLicense(l *VPPLicense) error
+	ReleaseVPPLicense(deviceUUID, identifier string) error
 }
 
 type pgStore struct {
 	*sqlx.DB
 }
 
-func NewDB(driver, conn string, logger kitlog.Logger) (Datastore, error) {
+func NewDB(driver, conn string, logger kitlog.Logger, opts ...dbpool.Option) (Datastore, error) {
 	switch driver {
 	case "postgres":
 		db, err := sqlx.Open(driver, conn)
 		if err != nil {
 			return nil, errors.Wrap(err, "applications datastore")
 		}
+		dbpool.Configure(db.DB, opts...)
 		var dbError error
 		maxAttempts := 20
 		for attempts := 1; attempts <= maxAttempts; attempts++ {
@@ -51,7 +71,37 @@ func NewDB(driver, conn string, logger kitlog.Logger) (Datastore, error) {
 // This function inserts a new application into the applications table.
 // Applications are uniquely identifier by both their name and their long form version because some do not have
 // identifiers, and some do not have short versions.
+// If the device has a record for an application installed by MDM with the same identifier,
+// that record is updated instead, keeping its install state.
 func (store pgStore) NewDeviceApp(da *DeviceApplication) error {
+	if da.Identifier.Valid {
+		err := store.QueryRow(
+			`UPDATE devices_applications SET
+				name = $3,
+				short_version = $4,
+				version = $5,
+				bundle_size = $6,
+				dynamic_size = $7,
+				is_validated = $8
+			WHERE device_uuid = $1 AND identifier = $2 AND install_state IS NOT NULL
+			RETURNING application_uuid;`,
+			da.DeviceUUID,
+			da.Identifier,
+			da.Name,
+			da.ShortVersion,
+			da.Version,
+			da.BundleSize,
+			da.DynamicSize,
+			da.IsValidated,
+		).Scan(&da.ApplicationUUID)
+		if err == nil {
+			return nil
+		}
+		if err != sql.ErrNoRows {
+			return fmt.Errorf("updating application: %s", err)
+		}
+	}
+
 	err := store.QueryRow(
 		`INSERT INTO devices_applications (
 			device_uuid,
@@ -82,15 +132,232 @@ func (store pgStore) NewDeviceApp(da *DeviceApplication) error {
 	return nil
 }
 
+// insertAppsBatch is the number of applications inserted with one statement.
+// Postgres allows at most 65535 parameters in a statement.
+const insertAppsBatch = 500
+
+// ReplaceDeviceApps deletes the applications the device reported before and inserts apps
+// in a single transaction, with one statement per batch of applications.
+// Applications installed by MDM keep their record and install state and are updated instead,
+// like in NewDeviceApp.
+func (store pgStore) ReplaceDeviceApps(deviceUUID string, apps []DeviceApplication) error {
+	tx, err := store.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "pgStore ReplaceDeviceApps")
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM devices_applications WHERE device_uuid = $1 AND install_state IS NULL`,
+		deviceUUID,
+	); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "pgStore ReplaceDeviceApps")
+	}
+
+	var installed []string
+	if err := tx.Select(&installed,
+		`SELECT identifier FROM devices_applications
+		WHERE device_uuid = $1 AND install_state IS NOT NULL AND identifier IS NOT NULL`,
+		deviceUUID,
+	); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "pgStore ReplaceDeviceApps")
+	}
+	installedByMDM := make(map[string]bool, len(installed))
+	for _, identifier := range installed {
+		installedByMDM[identifier] = true
+	}
+
+	var inserts []DeviceApplication
+	for _, app := range apps {
+		if !app.Identifier.Valid || !installedByMDM[app.Identifier.String] {
+			inserts = append(inserts, app)
+			continue
+		}
+		if _, err := tx.Exec(
+			`UPDATE devices_applications SET
+				name = $3,
+				short_version = $4,
+				version = $5,
+				bundle_size = $6,
+				dynamic_size = $7,
+				is_validated = $8
+			WHERE device_uuid = $1 AND identifier = $2 AND install_state IS NOT NULL`,
+			deviceUUID,
+			app.Identifier,
+			app.Name,
+			app.ShortVersion,
+			app.Version,
+			app.BundleSize,
+			app.DynamicSize,
+			app.IsValidated,
+		); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "pgStore ReplaceDeviceApps")
+		}
+	}
+
+	for start := 0; start < len(inserts); start += insertAppsBatch {
+		end := start + insertAppsBatch
+		if end > len(inserts) {
+			end = len(inserts)
+		}
+		query, args := insertDeviceAppsStmt(deviceUUID, inserts[start:end])
+		if _, err := tx.Exec(query, args...); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "pgStore ReplaceDeviceApps")
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "pgStore ReplaceDeviceApps")
+}
+
+// insertDeviceAppsStmt builds a multi-row INSERT of apps for the device.
+func insertDeviceAppsStmt(deviceUUID string, apps []DeviceApplication) (string, []interface{}) {
+	const columns = 8
+	values := make([]string, len(apps))
+	args := make([]interface{}, 0, len(apps)*columns)
+	for i, app := range apps {
+		n := i * columns
+		values[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8)
+		args = append(args,
+			deviceUUID,
+			app.Name,
+			app.Identifier,
+			app.ShortVersion,
+			app.Version,
+			app.BundleSize,
+			app.DynamicSize,
+			app.IsValidated,
+		)
+	}
+	query := `INSERT INTO devices_applications (
+			device_uuid,
+			name,
+			identifier,
+			short_version,
+			version,
+			bundle_size,
+			dynamic_size,
+			is_validated
+			)
+		VALUES ` + strings.Join(values, ", ")
+	return query, args
+}
+
+// DeleteDeviceApplications removes the applications reported by the device.
+// Applications installed by MDM are kept so that their install state is not lost.
 func (store pgStore) DeleteDeviceApplications(deviceUUID string) error {
 	_, err := store.Exec(
-		`DELETE FROM devices_applications WHERE device_uuid = $1`,
+		`DELETE FROM devices_applications WHERE device_uuid = $1 AND install_state IS NULL`,
 		deviceUUID,
 	)
 
 	return err
 }
 
+// DeleteDeviceApp removes every version of the application with the given bundle identifier from the device.
+func (store pgStore) DeleteDeviceApp(deviceUUID, identifier string) error {
+	if deviceUUID == "" || identifier == "" {
+		return errors.New("empty device uuid or identifier supplied to DeleteDeviceApp")
+	}
+
+	_, err := store.Exec(
+		`DELETE FROM devices_applications WHERE device_uuid = $1 AND identifier = $2`,
+		deviceUUID, identifier,
+	)
+	return errors.Wrap(err, "deleting application")
+}
+
+// SaveDeviceAppInstallState records the progress of an InstallApplication command,
+// creating the application record for the device if it does not exist yet.
+func (store pgStore) SaveDeviceAppInstallState(deviceUUID, identifier, state string) error {
+	if deviceUUID == "" || identifier == "" {
+		return errors.New("empty device uuid or identifier supplied to SaveDeviceAppInstallState")
+	}
+
+	res, err := store.Exec(
+		`UPDATE devices_applications SET install_state = $3
+		WHERE device_uuid = $1 AND identifier = $2`,
+		deviceUUID, identifier, state,
+	)
+	if err != nil {
+		return errors.Wrap(err, "updating application install state")
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 0 {
+		return err
+	}
+
+	// the name is unknown until the device reports its installed applications.
+	_, err = store.Exec(
+		`INSERT INTO devices_applications (device_uuid, name, identifier, install_state)
+		VALUES ($1, $2, $2, $3)`,
+		deviceUUID, identifier, state,
+	)
+	return errors.Wrap(err, "inserting application install state")
+}
+
+// ReconcileManagedApps records the status and ManagementFlags of the applications
+// in a ManagedApplicationList response. If the response is complete, applications
+// installed by MDM which are missing from the list are marked as StateUnmanaged.
+func (store pgStore) ReconcileManagedApps(deviceUUID string, apps []ManagedApplication, complete bool) error {
+	if deviceUUID == "" {
+		return errors.New("empty device uuid supplied to ReconcileManagedApps")
+	}
+
+	tx, err := store.Beginx()
+	if err != nil {
+		return err
+	}
+
+	identifiers := make([]string, len(apps))
+	for i, app := range apps {
+		identifiers[i] = app.Identifier
+		res, err := tx.Exec(
+			`UPDATE devices_applications SET install_state = $3, management_flags = $4
+			WHERE device_uuid = $1 AND identifier = $2`,
+			deviceUUID, app.Identifier, app.Status, app.ManagementFlags,
+		)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "updating managed application")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if n != 0 {
+			continue
+		}
+		// the name is unknown until the device reports its installed applications.
+		_, err = tx.Exec(
+			`INSERT INTO devices_applications (device_uuid, name, identifier, install_state, management_flags)
+			VALUES ($1, $2, $2, $3, $4)`,
+			deviceUUID, app.Identifier, app.Status, app.ManagementFlags,
+		)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "inserting managed application")
+		}
+	}
+
+	if !complete {
+		return tx.Commit()
+	}
+	_, err = tx.Exec(
+		`UPDATE devices_applications SET install_state = $2
+		WHERE device_uuid = $1 AND install_state IS NOT NULL AND NOT identifier = ANY($3)`,
+		deviceUUID, StateUnmanaged, pq.Array(identifiers),
+	)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "marking unmanaged applications")
+	}
+
+	return tx.Commit()
+}
+
 // Retrieve a list of applications
 func (store pgStore) Applications(params ...interface{}) ([]Application, error) {
 	stmt := `SELECT
@@ -129,7 +396,9 @@ func (store pgStore) GetApplicationsByDeviceUUID(deviceUUID string) ([]Applicati
 		version,
 		bundle_size,
 		dynamic_size,
-		is_validated
+		is_validated,
+		install_state,
+		management_flags
 	FROM applications
 	RIGHT JOIN devices_applications ON applications.application_uuid = devices_applications.application_uuid
 	WHERE devices_applications.device_uuid=$1`
@@ -2,7 +2,11 @@ package application
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"os"
 	"testing"
 )
 
@@ -65,3 +69,266 @@ func TestNewDB(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestInstallThenRemoveDeviceApp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := pgStore{DB: sqlx.NewDb(db, "mock")}
+	defer store.Close()
+
+	deviceUUID := "00000000-1111-2222-3333-444455556666"
+	identifier := "com.example.app"
+	appColumns := []string{"application_uuid", "name", "identifier", "install_state"}
+
+	// InstallApplication acknowledged, no existing record for the device
+	mock.ExpectExec("UPDATE devices_applications SET install_state").
+		WithArgs(deviceUUID, identifier, "Managed").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO devices_applications").
+		WithArgs(deviceUUID, identifier, "Managed").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("RIGHT JOIN devices_applications").
+		WithArgs(deviceUUID).
+		WillReturnRows(sqlmock.NewRows(appColumns).
+			AddRow("90000000-1111-2222-3333-444455556666", identifier, identifier, "Managed"))
+
+	// RemoveApplication acknowledged
+	mock.ExpectExec("DELETE FROM devices_applications").
+		WithArgs(deviceUUID, identifier).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("RIGHT JOIN devices_applications").
+		WithArgs(deviceUUID).
+		WillReturnRows(sqlmock.NewRows(appColumns))
+
+	if err := store.SaveDeviceAppInstallState(deviceUUID, identifier, "Managed"); err != nil {
+		t.Fatal(err)
+	}
+	apps, err := store.GetApplicationsByDeviceUUID(deviceUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 1 || apps[0].InstallState.String != "Managed" {
+		t.Fatalf("expected one managed application after install, got %v", apps)
+	}
+
+	if err := store.DeleteDeviceApp(deviceUUID, identifier); err != nil {
+		t.Fatal(err)
+	}
+	apps, err = store.GetApplicationsByDeviceUUID(deviceUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 0 {
+		t.Errorf("expected no applications after removal, got %v", apps)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestReconcileManagedApps(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := pgStore{DB: sqlx.NewDb(db, "mock")}
+	defer store.Close()
+
+	deviceUUID := "00000000-1111-2222-3333-444455556666"
+	apps := []ManagedApplication{
+		{Identifier: "com.example.app", Status: "Managed", ManagementFlags: 1},
+		{Identifier: "com.example.new", Status: "Installing"},
+	}
+
+	mock.ExpectBegin()
+	// known application
+	mock.ExpectExec("UPDATE devices_applications SET install_state = (.+), management_flags").
+		WithArgs(deviceUUID, "com.example.app", "Managed", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// application without a record for the device
+	mock.ExpectExec("UPDATE devices_applications SET install_state = (.+), management_flags").
+		WithArgs(deviceUUID, "com.example.new", "Installing", 0).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO devices_applications").
+		WithArgs(deviceUUID, "com.example.new", "Installing", 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	// managed applications missing from the list
+	mock.ExpectExec("UPDATE devices_applications SET install_state = (.+) WHERE (.+) NOT identifier = ANY").
+		WithArgs(deviceUUID, StateUnmanaged, `{"com.example.app","com.example.new"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := store.ReconcileManagedApps(deviceUUID, apps, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAppCountsQuery(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := pgStore{DB: sqlx.NewDb(db, "mock")}
+	defer store.Close()
+
+	// LIKE wildcards in the query are matched literally,
+	// rows without a reported version are not installed.
+	mock.ExpectQuery(`FROM devices_applications (.+)\.short_version, ''\) <> '' OR (.+) GROUP BY identifier`).
+		WithArgs(`%100\%%`).
+		WillReturnRows(sqlmock.NewRows([]string{"identifier", "name", "devices"}).
+			AddRow("com.example.percent", "100% Secure", 3))
+
+	counts, err := store.AppCounts(" 100% ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 1 || counts[0].Devices != 3 {
+		t.Errorf("expected one application on 3 devices, got %v", counts)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAppVersionsUnknown(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := pgStore{DB: sqlx.NewDb(db, "mock")}
+	defer store.Close()
+
+	mock.ExpectQuery(`FROM devices_applications (.+)\.short_version, ''\) <> '' OR (.+) GROUP BY 1`).
+		WithArgs("com.example.app", UnknownVersion).
+		WillReturnRows(sqlmock.NewRows([]string{"short_version", "devices"}).
+			AddRow("2.0", 10).
+			AddRow(UnknownVersion, 2))
+
+	versions, err := store.AppVersions("com.example.app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[1].ShortVersion != UnknownVersion || versions[1].Devices != 2 {
+		t.Errorf("expected devices without a version to be counted as unknown, got %v", versions)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestReplaceDeviceApps(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := pgStore{DB: sqlx.NewDb(db, "mock")}
+	defer store.Close()
+
+	deviceUUID := "00000000-1111-2222-3333-444455556666"
+	apps := []DeviceApplication{
+		{Name: "Managed", Identifier: sql.NullString{String: "com.example.managed", Valid: true}},
+		{Name: "Reported", Identifier: sql.NullString{String: "com.example.app", Valid: true}},
+		{Name: "No Identifier"},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM devices_applications WHERE (.+) install_state IS NULL").
+		WithArgs(deviceUUID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery("SELECT identifier FROM devices_applications").
+		WithArgs(deviceUUID).
+		WillReturnRows(sqlmock.NewRows([]string{"identifier"}).AddRow("com.example.managed"))
+	mock.ExpectExec("UPDATE devices_applications SET").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO devices_applications (.+) VALUES \(\$1, (.+)\), \(\$9, (.+)\)$`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	if err := store.ReplaceDeviceApps(deviceUUID, apps); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func benchmarkApps(deviceUUID string, n int) []DeviceApplication {
+	apps := make([]DeviceApplication, n)
+	for i := range apps {
+		apps[i] = DeviceApplication{
+			DeviceUUID: deviceUUID,
+			Name:       MockName,
+			Identifier: sql.NullString{String: fmt.Sprintf("com.example.app%d", i), Valid: true},
+		}
+	}
+	return apps
+}
+
+// benchmarkStore connects to the migrated postgres database in MICROMDM_BENCH_DB
+// and adds a device for the benchmark. The round trips are what is measured,
+// so the benchmarks are skipped without a database.
+func benchmarkStore(b *testing.B) (store pgStore, deviceUUID string, cleanup func()) {
+	conn := os.Getenv("MICROMDM_BENCH_DB")
+	if conn == "" {
+		b.Skip("set MICROMDM_BENCH_DB to a migrated postgres database to run the benchmark")
+	}
+	db, err := sqlx.Connect("postgres", conn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = db.QueryRow(`INSERT INTO devices (udid) VALUES (uuid_generate_v4()::text) RETURNING device_uuid`).Scan(&deviceUUID)
+	if err != nil {
+		db.Close()
+		b.Fatal(err)
+	}
+	cleanup = func() {
+		// the device applications are deleted with the device.
+		db.Exec(`DELETE FROM devices WHERE device_uuid = $1`, deviceUUID)
+		db.Close()
+	}
+	return pgStore{DB: db}, deviceUUID, cleanup
+}
+
+// BenchmarkNewDeviceAppLoop saves an InstalledApplicationList of 300 apps
+// with one round trip per application, as connect did before ReplaceDeviceApps.
+func BenchmarkNewDeviceAppLoop(b *testing.B) {
+	store, deviceUUID, cleanup := benchmarkStore(b)
+	defer cleanup()
+	apps := benchmarkApps(deviceUUID, 300)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := store.DeleteDeviceApplications(deviceUUID); err != nil {
+			b.Fatal(err)
+		}
+		for j := range apps {
+			if err := store.NewDeviceApp(&apps[j]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkReplaceDeviceApps saves the same list with a multi-row INSERT in a transaction.
+func BenchmarkReplaceDeviceApps(b *testing.B) {
+	store, deviceUUID, cleanup := benchmarkStore(b)
+	defer cleanup()
+	apps := benchmarkApps(deviceUUID, 300)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := store.ReplaceDeviceApps(deviceUUID, apps); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
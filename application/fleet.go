@@ -0,0 +1,98 @@
+package application
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AppCount is an application aggregated across all devices by bundle identifier.
+type AppCount struct {
+	Identifier string `json:"identifier" db:"identifier"`
+	Name       string `json:"name" db:"name"`
+	Devices    int    `json:"devices" db:"devices"`
+}
+
+// AppInstall is a device which has an application installed.
+type AppInstall struct {
+	DeviceUUID   string `json:"device_uuid" db:"device_uuid"`
+	UDID         string `json:"udid" db:"udid"`
+	SerialNumber string `json:"serial_number,omitempty" db:"serial_number"`
+	DeviceName   string `json:"device_name,omitempty" db:"device_name"`
+	Name         string `json:"name" db:"name"`
+	ShortVersion string `json:"short_version,omitempty" db:"short_version"`
+	Version      string `json:"version,omitempty" db:"version"`
+}
+
+// UnknownVersion is the ShortVersion of applications which did not report one.
+const UnknownVersion = "unknown"
+
+// AppVersion is the number of devices with a version of an application installed.
+type AppVersion struct {
+	ShortVersion string `json:"short_version" db:"short_version"`
+	Devices      int    `json:"devices" db:"devices"`
+}
+
+// reportedApp only counts the applications a device reported in an InstalledApplicationList
+// response. Rows which only carry an install_state, like a pending or failed InstallApplication
+// or an application which was removed from the device, have neither version.
+const reportedApp = `(COALESCE(devices_applications.short_version, '') <> '' OR COALESCE(devices_applications.version, '') <> '')`
+
+// sql statements
+var (
+	selectAppCountsStmt = `SELECT identifier, MAX(name) AS name, COUNT(DISTINCT device_uuid) AS devices
+						   FROM devices_applications
+						   WHERE identifier IS NOT NULL
+						   AND ` + reportedApp + `
+						   AND (name ILIKE $1 OR identifier ILIKE $1)
+						   GROUP BY identifier
+						   ORDER BY devices DESC, identifier`
+
+	selectAppInstallsStmt = `SELECT devices.device_uuid, devices.udid,
+							 COALESCE(devices.serial_number, '') AS serial_number,
+							 devices.device_name,
+							 devices_applications.name,
+							 COALESCE(devices_applications.short_version, '') AS short_version,
+							 COALESCE(devices_applications.version, '') AS version
+							 FROM devices_applications
+							 JOIN devices ON devices.device_uuid = devices_applications.device_uuid
+							 WHERE devices_applications.identifier = $1
+							 AND ` + reportedApp + `
+							 ORDER BY devices.serial_number`
+
+	selectAppVersionsStmt = `SELECT COALESCE(NULLIF(short_version, ''), $2) AS short_version,
+							 COUNT(DISTINCT device_uuid) AS devices
+							 FROM devices_applications
+							 WHERE identifier = $1
+							 AND ` + reportedApp + `
+							 GROUP BY 1
+							 ORDER BY devices DESC, short_version`
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// AppCounts returns the applications installed on any device with the number of
+// devices they are installed on, most installed first. A non empty query only
+// returns applications with a name or identifier containing query, ignoring case.
+func (store pgStore) AppCounts(query string) ([]AppCount, error) {
+	pattern := "%" + likeEscaper.Replace(strings.TrimSpace(query)) + "%"
+	var counts []AppCount
+	err := store.Select(&counts, selectAppCountsStmt, pattern)
+	return counts, errors.Wrap(err, "pgStore AppCounts")
+}
+
+// AppInstalls returns the devices which have the application with the bundle identifier installed.
+func (store pgStore) AppInstalls(identifier string) ([]AppInstall, error) {
+	var installs []AppInstall
+	err := store.Select(&installs, selectAppInstallsStmt, identifier)
+	return installs, errors.Wrap(err, "pgStore AppInstalls")
+}
+
+// AppVersions returns the installed versions of the application with the bundle identifier
+// and the number of devices they are installed on. Devices which did not report
+// a ShortVersion are counted as UnknownVersion.
+func (store pgStore) AppVersions(identifier string) ([]AppVersion, error) {
+	var versions []AppVersion
+	err := store.Select(&versions, selectAppVersionsStmt, identifier, UnknownVersion)
+	return versions, errors.Wrap(err, "pgStore AppVersions")
+}
@@ -0,0 +1,44 @@
+package application
+
+import (
+	"errors"
+	"time"
+)
+
+// PurchaseMethodVPP is the InstallApplication PurchaseMethod for apps assigned
+// to the device through the VPP service. The device installs the app
+// without a redemption code.
+const PurchaseMethodVPP = 1
+
+// ErrVPPAppNotFound is returned when a VPP app is not tracked.
+var ErrVPPAppNotFound = errors.New("vpp app not found")
+
+// VPPApp is an app purchased through the Volume Purchase Program.
+type VPPApp struct {
+	ITunesStoreID int    `json:"itunes_store_id" db:"itunes_store_id"`
+	Name          string `json:"name" db:"name"`
+	// Identifier is the bundle identifier, used to match
+	// ApplyRedemptionCode and RemoveApplication commands.
+	Identifier    string `json:"identifier,omitempty" db:"identifier"`
+	TotalLicenses int    `json:"total_licenses" db:"total_licenses"`
+	UsedLicenses  int    `json:"used_licenses" db:"used_licenses"`
+}
+
+// AvailableLicenses returns the number of licenses which are not assigned to a device.
+func (a VPPApp) AvailableLicenses() int {
+	if a.UsedLicenses > a.TotalLicenses {
+		return 0
+	}
+	return a.TotalLicenses - a.UsedLicenses
+}
+
+// VPPLicense is a license of a VPP app assigned to a device.
+// RedemptionCode is only set for licenses assigned with the legacy
+// code based flow.
+type VPPLicense struct {
+	ITunesStoreID  int       `json:"itunes_store_id" db:"itunes_store_id"`
+	Identifier     string    `json:"identifier,omitempty" db:"-"`
+	DeviceUUID     string    `json:"device_uuid" db:"device_uuid"`
+	RedemptionCode string    `json:"redemption_code,omitempty" db:"redemption_code"`
+	AssignedAt     time.Time `json:"assigned_at" db:"assigned_at"`
+}
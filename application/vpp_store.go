@@ -0,0 +1,57 @@
+package application
+
+import (
+	"github.com/pkg/errors"
+)
+
+// sql statements
+var (
+	saveVPPAppStmt = `INSERT INTO vpp_apps (itunes_store_id, name, identifier, total_licenses)
+					  VALUES ($1, $2, $3, $4)
+					  ON CONFLICT (itunes_store_id) DO UPDATE SET
+					  name = $2, identifier = $3, total_licenses = $4;`
+
+	selectVPPAppsStmt = `SELECT vpp_apps.itunes_store_id, name, identifier, total_licenses,
+						 COUNT(vpp_licenses.device_uuid) AS used_licenses
+						 FROM vpp_apps
+						 LEFT JOIN vpp_licenses ON vpp_licenses.itunes_store_id = vpp_apps.itunes_store_id
+						 GROUP BY vpp_apps.itunes_store_id
+						 ORDER BY name`
+
+	// the license is matched to the app by the iTunesStoreID,
+	// or by the bundle identifier for ApplyRedemptionCode commands.
+	assignVPPLicenseStmt = `INSERT INTO vpp_licenses (itunes_store_id, device_uuid, redemption_code, assigned_at)
+							SELECT itunes_store_id, $3, $4, $5 FROM vpp_apps
+							WHERE itunes_store_id = $1 OR (identifier <> '' AND identifier = $2)
+							ON CONFLICT (itunes_store_id, device_uuid) DO UPDATE SET
+							redemption_code = $4, assigned_at = $5;`
+
+	releaseVPPLicenseStmt = `DELETE FROM vpp_licenses
+							 USING vpp_apps
+							 WHERE vpp_licenses.itunes_store_id = vpp_apps.itunes_store_id
+							 AND vpp_licenses.device_uuid = $1 AND vpp_apps.identifier = $2;`
+)
+
+func (store pgStore) SaveVPPApp(app *VPPApp) error {
+	_, err := store.Exec(saveVPPAppStmt, app.ITunesStoreID, app.Name, app.Identifier, app.TotalLicenses)
+	return errors.Wrap(err, "pgStore save vpp app")
+}
+
+func (store pgStore) VPPApps() ([]VPPApp, error) {
+	var apps []VPPApp
+	err := store.Select(&apps, selectVPPAppsStmt)
+	return apps, errors.Wrap(err, "pgStore list vpp apps")
+}
+
+// AssignVPPLicense records a license assignment. Apps which are not
+// tracked as VPP apps are ignored.
+func (store pgStore) AssignVPPLicense(l *VPPLicense) error {
+	_, err := store.Exec(assignVPPLicenseStmt, l.ITunesStoreID, l.Identifier, l.DeviceUUID, l.RedemptionCode, l.AssignedAt)
+	return errors.Wrap(err, "pgStore assign vpp license")
+}
+
+// ReleaseVPPLicense frees the license of the app with the bundle identifier on the device.
+func (store pgStore) ReleaseVPPLicense(deviceUUID, identifier string) error {
+	_, err := store.Exec(releaseVPPLicenseStmt, deviceUUID, identifier)
+	return errors.Wrap(err, "pgStore release vpp license")
+}
@@ -0,0 +1,20 @@
+package application
+
+import "testing"
+
+func TestAvailableLicenses(t *testing.T) {
+	var tests = []struct {
+		total, used, available int
+	}{
+		{total: 10, used: 0, available: 10},
+		{total: 10, used: 4, available: 6},
+		// licenses revoked in the VPP portal while still assigned
+		{total: 2, used: 3, available: 0},
+	}
+	for _, tt := range tests {
+		app := VPPApp{TotalLicenses: tt.total, UsedLicenses: tt.used}
+		if have := app.AvailableLicenses(); have != tt.available {
+			t.Errorf("%d total, %d used: expected %d available, got %d", tt.total, tt.used, tt.available, have)
+		}
+	}
+}
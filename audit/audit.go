@@ -0,0 +1,40 @@
+package audit
+
+import (
+	"strings"
+	"time"
+)
+
+// Entry records a mutating request to the management API.
+type Entry struct {
+	UUID   string `db:"entry_uuid" json:"uuid"`
+	Method string `db:"method" json:"method"`
+	Path   string `db:"path" json:"path"`
+	// Actor is the name of the API key, ActorUUID is empty for the key configured with --api-key.
+	Actor     string    `db:"actor" json:"actor"`
+	ActorUUID string    `db:"actor_uuid" json:"actor_uuid,omitempty"`
+	Device    string    `db:"device" json:"device,omitempty"`
+	Status    int       `db:"status" json:"status"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// DeviceFromPath returns the device UUID or UDID in a management API path
+// like /management/v1/devices/{id}/erase or /management/v1/groups/{uuid}/devices/{id},
+// or an empty string.
+func DeviceFromPath(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 4 || parts[0] != "management" {
+		return ""
+	}
+	switch {
+	case parts[2] == "devices":
+		switch parts[3] {
+		case "commands", "fetch", "search", "reconcile":
+			return ""
+		}
+		return parts[3]
+	case parts[2] == "groups" && len(parts) == 6 && parts[4] == "devices":
+		return parts[5]
+	}
+	return ""
+}
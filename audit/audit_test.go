@@ -0,0 +1,25 @@
+package audit
+
+import "testing"
+
+func TestDeviceFromPath(t *testing.T) {
+	var tests = []struct {
+		path   string
+		device string
+	}{
+		{"/management/v1/devices/1F8A1C2E-0D4B-4C4B-9E5B-5B1C8F2D9A10/erase", "1F8A1C2E-0D4B-4C4B-9E5B-5B1C8F2D9A10"},
+		{"/management/v1/devices/1F8A1C2E-0D4B-4C4B-9E5B-5B1C8F2D9A10", "1F8A1C2E-0D4B-4C4B-9E5B-5B1C8F2D9A10"},
+		{"/management/v1/devices/commands", ""},
+		{"/management/v1/devices/fetch", ""},
+		{"/management/v1/devices/reconcile", ""},
+		{"/management/v1/devices", ""},
+		{"/management/v1/groups/1F8A1C2E-0D4B-4C4B-9E5B-5B1C8F2D9A10/devices/abc", "abc"},
+		{"/management/v1/groups/1F8A1C2E-0D4B-4C4B-9E5B-5B1C8F2D9A10", ""},
+	}
+
+	for _, tt := range tests {
+		if have := DeviceFromPath(tt.path); have != tt.device {
+			t.Errorf("%s: expected %q, got %q", tt.path, tt.device, have)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq" // postgres driver
+	"github.com/micromdm/micromdm/dbpool"
+	"github.com/pkg/errors"
+)
+
+var (
+	insertEntryStmt = `INSERT INTO audit_log (
+		method,
+		path,
+		actor,
+		actor_uuid,
+		device,
+		status,
+		created_at
+	) VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING entry_uuid;`
+
+	selectEntriesStmt = `SELECT
+		entry_uuid,
+		method,
+		path,
+		actor,
+		actor_uuid,
+		device,
+		status,
+		created_at
+		FROM audit_log`
+)
+
+// Datastore keeps the audit log of the management API.
+type Datastore interface {
+	Record(e *Entry) error
+	// Entries returns the most recent entries, newest first.
+	// If device or actor are not empty, only matching entries are returned.
+	// actor matches the name or the UUID of the API key.
+	Entries(device, actor string, limit int) ([]Entry, error)
+}
+
+type pgStore struct {
+	*sqlx.DB
+}
+
+func NewDB(driver, conn string, logger kitlog.Logger, opts ...dbpool.Option) (Datastore, error) {
+	switch driver {
+	case "postgres":
+		db, err := sqlx.Open(driver, conn)
+		if err != nil {
+			return nil, errors.Wrap(err, "audit datastore")
+		}
+		dbpool.Configure(db.DB, opts...)
+		var dbError error
+		maxAttempts := 20
+		for attempts := 1; attempts <= maxAttempts; attempts++ {
+			dbError = db.Ping()
+			if dbError == nil {
+				break
+			}
+			logger.Log("msg", fmt.Sprintf("could not connect to postgres: %v", dbError))
+			time.Sleep(time.Duration(attempts) * time.Second)
+		}
+		if dbError != nil {
+			return nil, errors.Wrap(dbError, "audit datastore")
+		}
+		return pgStore{DB: db}, nil
+	default:
+		return nil, errors.New("unknown driver")
+	}
+}
+
+func (store pgStore) Record(e *Entry) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	err := store.QueryRow(
+		insertEntryStmt,
+		e.Method,
+		e.Path,
+		e.Actor,
+		e.ActorUUID,
+		e.Device,
+		e.Status,
+		e.CreatedAt,
+	).Scan(&e.UUID)
+	if err != nil {
+		return errors.Wrap(err, "pgStore Record")
+	}
+	return nil
+}
+
+func (store pgStore) Entries(device, actor string, limit int) ([]Entry, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if device != "" {
+		args = append(args, device)
+		where = append(where, fmt.Sprintf("device = $%d", len(args)))
+	}
+	if actor != "" {
+		args = append(args, actor)
+		where = append(where, fmt.Sprintf("(actor = $%d OR actor_uuid = $%d)", len(args), len(args)))
+	}
+	query := selectEntriesStmt
+	if len(where) != 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d", len(args))
+
+	var entries []Entry
+	err := store.Select(&entries, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore Entries")
+	}
+	return entries, nil
+}
@@ -1,9 +1,48 @@
 package certificate
 
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"time"
+)
+
 type Certificate struct {
 	UUID       string `db:"certificate_uuid" json:"uuid"`
 	DeviceUUID string `db:"device_uuid" json:"device_uuid"`
 	Data       []byte `db:"data" json:"data,omitempty"`
 	CommonName string `db:"common_name" json:"common_name,omitempty"`
 	IsIdentity bool   `db:"is_identity" json:"is_identity"`
+
+	// NotAfter is the expiry parsed from the DER encoded Data.
+	// It is nil if Data is not a valid certificate.
+	NotAfter *time.Time `db:"not_after" json:"not_after,omitempty"`
+	// Issuer is the distinguished name of the issuing CA.
+	Issuer string `db:"issuer" json:"issuer,omitempty"`
+	// SerialNumber is hex encoded.
+	SerialNumber string `db:"serial_number" json:"serial_number,omitempty"`
+	// KeyUsage is a bitmap of x509.KeyUsage values.
+	KeyUsage int `db:"key_usage" json:"key_usage,omitempty"`
+	// SHA256Fingerprint is the hex encoded SHA-256 of Data. The same certificate
+	// installed on several devices has the same fingerprint.
+	SHA256Fingerprint string `db:"sha256_fingerprint" json:"sha256_fingerprint,omitempty"`
+}
+
+// parse fills the fields which are derived from the DER encoded Data.
+func (c *Certificate) parse() error {
+	crt, err := x509.ParseCertificate(c.Data)
+	if err != nil {
+		return err
+	}
+	if c.CommonName == "" {
+		c.CommonName = crt.Subject.CommonName
+	}
+	notAfter := crt.NotAfter.UTC()
+	c.NotAfter = &notAfter
+	c.Issuer = crt.Issuer.String()
+	c.SerialNumber = hex.EncodeToString(crt.SerialNumber.Bytes())
+	c.KeyUsage = int(crt.KeyUsage)
+	sum := sha256.Sum256(c.Data)
+	c.SHA256Fingerprint = hex.EncodeToString(sum[:])
+	return nil
 }
@@ -0,0 +1,69 @@
+package certificate
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func selfSigned(t *testing.T, cn string, notAfter time.Time) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(0x1f2e),
+		Subject:      pkix.Name{CommonName: cn},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestParse(t *testing.T) {
+	notAfter := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &Certificate{Data: selfSigned(t, "device identity", notAfter)}
+	if err := c.parse(); err != nil {
+		t.Fatal(err)
+	}
+	if c.CommonName != "device identity" {
+		t.Errorf("expected the CommonName from the subject, got %q", c.CommonName)
+	}
+	if c.NotAfter == nil || !c.NotAfter.Equal(notAfter) {
+		t.Errorf("expected NotAfter %v, got %v", notAfter, c.NotAfter)
+	}
+	// self signed, the issuer is the subject.
+	if c.Issuer != "CN=device identity" {
+		t.Errorf("expected the issuer to be the subject, got %q", c.Issuer)
+	}
+	if c.SerialNumber != "1f2e" {
+		t.Errorf("expected serial number 1f2e, got %q", c.SerialNumber)
+	}
+	if c.KeyUsage != int(x509.KeyUsageDigitalSignature) {
+		t.Errorf("expected digital signature key usage, got %d", c.KeyUsage)
+	}
+	sum := sha256.Sum256(c.Data)
+	if want := hex.EncodeToString(sum[:]); c.SHA256Fingerprint != want {
+		t.Errorf("expected fingerprint %s, got %s", want, c.SHA256Fingerprint)
+	}
+
+	// malformed data leaves the expiry unknown.
+	c = &Certificate{CommonName: "reported", Data: []byte("not a certificate")}
+	if err := c.parse(); err == nil {
+		t.Error("expected an error for malformed data")
+	}
+	if c.NotAfter != nil || c.CommonName != "reported" {
+		t.Errorf("expected the certificate to be left alone, got %+v", c)
+	}
+}
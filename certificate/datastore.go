@@ -5,6 +5,7 @@ import (
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq" // postgres driver
+	"github.com/micromdm/micromdm/dbpool"
 	"github.com/pkg/errors"
 	"strings"
 	"time"
@@ -15,8 +16,13 @@ var (
 		device_uuid,
 		common_name,
 		data,
-		is_identity
-	) VALUES ($1, $2, $3, $4)
+		is_identity,
+		not_after,
+		issuer,
+		serial_number,
+		key_usage,
+		sha256_fingerprint
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 	RETURNING certificate_uuid;`
 
 	selectCertificatesStmt = `SELECT
@@ -24,7 +30,12 @@ var (
 		device_uuid,
 		common_name,
 		data,
-		is_identity
+		is_identity,
+		not_after,
+		issuer,
+		serial_number,
+		key_usage,
+		sha256_fingerprint
 		FROM devices_certificates`
 
 	selectCertificatesByDeviceUdidStmt = `SELECT
@@ -32,7 +43,12 @@ var (
 		devices_certificates.device_uuid device_uuid,
 		common_name,
 		data,
-		is_identity
+		is_identity,
+		not_after,
+		issuer,
+		serial_number,
+		key_usage,
+		sha256_fingerprint
 		FROM devices_certificates
 		INNER JOIN devices ON devices_certificates.device_uuid = devices.device_uuid
 		WHERE devices.udid = $1`
@@ -42,10 +58,43 @@ var (
 		devices_certificates.device_uuid device_uuid,
 		common_name,
 		data,
-		is_identity
+		is_identity,
+		not_after,
+		issuer,
+		serial_number,
+		key_usage,
+		sha256_fingerprint
 		FROM devices_certificates
 		INNER JOIN devices ON devices_certificates.device_uuid = devices.device_uuid
 		WHERE devices.device_uuid = $1`
+
+	selectExpiringCertificatesStmt = `SELECT
+		certificate_uuid,
+		device_uuid,
+		common_name,
+		is_identity,
+		not_after,
+		issuer,
+		serial_number,
+		key_usage,
+		sha256_fingerprint
+		FROM devices_certificates
+		WHERE not_after < $1
+		ORDER BY not_after`
+
+	selectCertificatesByIssuerStmt = `SELECT
+		certificate_uuid,
+		device_uuid,
+		common_name,
+		is_identity,
+		not_after,
+		issuer,
+		serial_number,
+		key_usage,
+		sha256_fingerprint
+		FROM devices_certificates
+		WHERE issuer ILIKE $1
+		ORDER BY sha256_fingerprint, device_uuid`
 )
 
 // This Datastore manages a list of certificates assigned to devices.
@@ -55,19 +104,25 @@ type Datastore interface {
 	GetCertificatesByDeviceUDID(udid string) ([]Certificate, error)
 	GetCertificatesByDeviceUUID(uuid string) ([]Certificate, error)
 	ReplaceCertificatesByDeviceUUID(uuid string, certificates []Certificate) error
+	// Expiring returns the certificates which expire before the given time,
+	// soonest first. Expired certificates are included.
+	Expiring(before time.Time) ([]Certificate, error)
+	// IssuedBy returns the certificates with an issuer containing the given name, ignoring case.
+	IssuedBy(issuer string) ([]Certificate, error)
 }
 
 type pgStore struct {
 	*sqlx.DB
 }
 
-func NewDB(driver, conn string, logger kitlog.Logger) (Datastore, error) {
+func NewDB(driver, conn string, logger kitlog.Logger, opts ...dbpool.Option) (Datastore, error) {
 	switch driver {
 	case "postgres":
 		db, err := sqlx.Open(driver, conn)
 		if err != nil {
 			return nil, errors.Wrap(err, "certificates datastore")
 		}
+		dbpool.Configure(db.DB, opts...)
 		var dbError error
 		maxAttempts := 20
 		for attempts := 1; attempts <= maxAttempts; attempts++ {
@@ -88,7 +143,8 @@ func NewDB(driver, conn string, logger kitlog.Logger) (Datastore, error) {
 }
 
 func (store pgStore) New(c *Certificate) (string, error) {
-	if err := store.QueryRow(insertCertificateStmt, c.DeviceUUID, c.CommonName, "", c.IsIdentity).Scan(&c.UUID); err != nil {
+	c.parse()
+	if err := store.QueryRow(insertCertificateStmt, c.DeviceUUID, c.CommonName, c.Data, c.IsIdentity, c.NotAfter, c.Issuer, c.SerialNumber, c.KeyUsage, c.SHA256Fingerprint).Scan(&c.UUID); err != nil {
 		return "", err
 	}
 
@@ -124,28 +180,50 @@ func (store pgStore) GetCertificatesByDeviceUUID(uuid string) ([]Certificate, er
 	return certificates, nil
 }
 
+// ReplaceCertificatesByDeviceUUID replaces the certificates of a device in a single transaction,
+// so the previous list is kept if any insert fails.
 func (store pgStore) ReplaceCertificatesByDeviceUUID(uuid string, certificates []Certificate) error {
 	tx, err := store.Beginx()
 	if err != nil {
-		tx.Rollback()
-		return err
+		return errors.Wrap(err, "pgStore ReplaceCertificatesByDeviceUUID")
 	}
 
-	tx.MustExec("DELETE FROM devices_certificates WHERE device_uuid = $1", uuid)
+	if _, err := tx.Exec("DELETE FROM devices_certificates WHERE device_uuid = $1", uuid); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "pgStore ReplaceCertificatesByDeviceUUID")
+	}
 
-	var insertedUuids []string = []string{}
 	for _, cert := range certificates {
-		if err := tx.QueryRow(insertCertificateStmt, cert.DeviceUUID, cert.CommonName, "", cert.IsIdentity).Scan(&cert.UUID); err != nil {
+		// the expiry is unknown if the device sent a malformed certificate.
+		cert.parse()
+		if err := tx.QueryRow(insertCertificateStmt, cert.DeviceUUID, cert.CommonName, cert.Data, cert.IsIdentity, cert.NotAfter, cert.Issuer, cert.SerialNumber, cert.KeyUsage, cert.SHA256Fingerprint).Scan(&cert.UUID); err != nil {
 			tx.Rollback()
-			return err
+			return errors.Wrap(err, "pgStore ReplaceCertificatesByDeviceUUID")
 		}
+	}
+
+	return errors.Wrap(tx.Commit(), "pgStore ReplaceCertificatesByDeviceUUID")
+}
 
-		insertedUuids = append(insertedUuids, cert.UUID)
+func (store pgStore) Expiring(before time.Time) ([]Certificate, error) {
+	var certificates []Certificate
+	err := store.Select(&certificates, selectExpiringCertificatesStmt, before)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore Expiring")
 	}
+	return certificates, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
 
-	tx.Commit()
-	fmt.Println(strings.Join(insertedUuids, ","))
-	return nil
+func (store pgStore) IssuedBy(issuer string) ([]Certificate, error) {
+	pattern := "%" + likeEscaper.Replace(strings.TrimSpace(issuer)) + "%"
+	var certificates []Certificate
+	err := store.Select(&certificates, selectCertificatesByIssuerStmt, pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore IssuedBy")
+	}
+	return certificates, nil
 }
 
 // add WHERE clause from params
@@ -39,3 +39,33 @@ func TestNewDatastore(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestReplaceCertificatesRollback(t *testing.T) {
+	setup()
+	defer teardown()
+	store := pgStore{DB: dbx}
+
+	deviceUUID := "00000000-1111-2222-3333-444455556666"
+	certs := []Certificate{
+		{DeviceUUID: deviceUUID, CommonName: "first"},
+		{DeviceUUID: deviceUUID, CommonName: "second"},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM devices_certificates").
+		WithArgs(deviceUUID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery("INSERT INTO devices_certificates").
+		WillReturnRows(sqlmock.NewRows([]string{"certificate_uuid"}).AddRow("90000000-1111-2222-3333-444455556666"))
+	mock.ExpectQuery("INSERT INTO devices_certificates").
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	if err := store.ReplaceCertificatesByDeviceUUID(deviceUUID, certs); err == nil {
+		t.Fatal("expected the failed insert to be returned")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,76 @@
+package checkin
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/micromdm/micromdm/device"
+)
+
+// ErrUnsupportedEndpoint is returned for DeclarativeManagement checkins to other
+// endpoints than status. The server does not send declarations to devices.
+var ErrUnsupportedEndpoint = errors.New("unsupported DeclarativeManagement endpoint")
+
+// ErrInvalidStatusReport is returned if the Data of a DeclarativeManagement status
+// checkin is not a JSON status report.
+var ErrInvalidStatusReport = errors.New("invalid DeclarativeManagement status report")
+
+// ErrNotEnrolled is returned for DeclarativeManagement checkins of devices which are not enrolled.
+var ErrNotEnrolled = errors.New("device is not enrolled")
+
+// statusEndpoint is the Endpoint of DeclarativeManagement status reports.
+const statusEndpoint = "status"
+
+// statusReport is the JSON document of a DeclarativeManagement status report.
+// The first report after enrollment is complete, later reports only hold changed items.
+type statusReport struct {
+	StatusItems struct {
+		Management struct {
+			Declarations struct {
+				Activations    []declarationItem `json:"activations"`
+				Configurations []declarationItem `json:"configurations"`
+				Assets         []declarationItem `json:"assets"`
+				Management     []declarationItem `json:"management"`
+			} `json:"declarations"`
+		} `json:"management"`
+	}
+}
+
+type declarationItem struct {
+	Identifier  string          `json:"identifier"`
+	Active      bool            `json:"active"`
+	Valid       string          `json:"valid"`
+	ServerToken string          `json:"server-token"`
+	Reasons     json.RawMessage `json:"reasons"`
+}
+
+// declarationStatuses returns the declaration statuses of the device in the report.
+func (r statusReport) declarationStatuses(udid string, now time.Time) []device.DeclarationStatus {
+	declarations := r.StatusItems.Management.Declarations
+	kinds := []struct {
+		kind  string
+		items []declarationItem
+	}{
+		{device.DeclarationActivation, declarations.Activations},
+		{device.DeclarationConfiguration, declarations.Configurations},
+		{device.DeclarationAsset, declarations.Assets},
+		{device.DeclarationManagement, declarations.Management},
+	}
+	var statuses []device.DeclarationStatus
+	for _, k := range kinds {
+		for _, item := range k.items {
+			statuses = append(statuses, device.DeclarationStatus{
+				DeviceUDID:  udid,
+				Identifier:  item.Identifier,
+				Kind:        k.kind,
+				Active:      item.Active,
+				Valid:       item.Valid,
+				ServerToken: item.ServerToken,
+				Reasons:     item.Reasons,
+				UpdatedAt:   now,
+			})
+		}
+	}
+	return statuses
+}
@@ -13,6 +13,12 @@ var errInvalidMessageType = errors.New("invalid message type")
 
 type mdmCheckinRequest struct {
 	mdm.CheckinCommand
+	// Enrollment is the name of the enrollment profile, from the CheckInURL query.
+	Enrollment string `plist:"-"`
+
+	// Endpoint and Data are sent with the DeclarativeManagement message type.
+	Endpoint string `plist:",omitempty"`
+	Data     []byte `plist:",omitempty"`
 }
 
 type mdmCheckinResponse struct {
@@ -27,11 +33,16 @@ func makeCheckinEndpoint(svc Service) endpoint.Endpoint {
 		var err error
 		switch req.MessageType {
 		case "Authenticate":
-			err = svc.Authenticate(req.CheckinCommand)
+			err = svc.Authenticate(req.CheckinCommand, req.Enrollment)
 		case "TokenUpdate":
 			err = svc.TokenUpdate(req.CheckinCommand)
 		case "CheckOut":
 			err = svc.Checkout(req.CheckinCommand)
+		case "DeclarativeManagement":
+			if req.Endpoint != statusEndpoint {
+				return mdmCheckinResponse{ErrUnsupportedEndpoint}, nil
+			}
+			err = svc.DeclarationStatus(req.UDID, req.Data)
 		default:
 			return mdmCheckinResponse{errInvalidMessageType}, nil
 		}
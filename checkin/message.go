@@ -0,0 +1,119 @@
+package checkin
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/groob/plist"
+	"golang.org/x/net/context"
+)
+
+// Message is a raw checkin message sent by a device, with its secrets redacted.
+type Message struct {
+	UUID        string    `db:"message_uuid" json:"uuid"`
+	UDID        string    `db:"udid" json:"udid"`
+	MessageType string    `db:"message_type" json:"message_type"`
+	Body        string    `db:"body" json:"body"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+// recordedMessageTypes are the checkin messages stored by RecordMessages.
+var recordedMessageTypes = map[string]bool{
+	"Authenticate": true,
+	"TokenUpdate":  true,
+	"CheckOut":     true,
+}
+
+// redactedKeys are the checkin message keys which hold secrets.
+// A token or push magic is enough to send push notifications to the device.
+var redactedKeys = []string{
+	"Challenge",
+	"PushMagic",
+	"Token",
+	"UnlockToken",
+	"BootstrapToken",
+}
+
+const redacted = "REDACTED"
+
+// maxMessageSize is the largest checkin message RecordMessages reads.
+const maxMessageSize = 1 << 20
+
+// redactMessage decodes a checkin message and encodes it again with the values of
+// redactedKeys replaced.
+func redactMessage(body []byte) (map[string]interface{}, []byte, error) {
+	var msg map[string]interface{}
+	if err := plist.Unmarshal(body, &msg); err != nil {
+		return nil, nil, err
+	}
+	for _, key := range redactedKeys {
+		if _, ok := msg[key]; ok {
+			msg[key] = redacted
+		}
+	}
+	out, err := plist.MarshalIndent(msg, "\t")
+	return msg, out, err
+}
+
+// RecordMessages stores the Authenticate, TokenUpdate and CheckOut messages sent to next,
+// with their secrets redacted. Failing to store a message does not fail the checkin.
+func RecordMessages(next http.Handler, store MessageStore, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err := recordMessage(store, body); err != nil {
+			logger.Log("msg", "recording checkin message", "err", err)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func recordMessage(store MessageStore, body []byte) error {
+	msg, redactedBody, err := redactMessage(body)
+	if err != nil {
+		return err
+	}
+	messageType, _ := msg["MessageType"].(string)
+	if !recordedMessageTypes[messageType] {
+		return nil
+	}
+	udid, _ := msg["UDID"].(string)
+	return store.Record(&Message{
+		UDID:        udid,
+		MessageType: messageType,
+		Body:        string(redactedBody),
+	})
+}
+
+// PruneMessages deletes the checkin messages older than retention every interval,
+// until ctx is done.
+func PruneMessages(ctx context.Context, store MessageStore, retention, interval time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := store.DeleteBefore(time.Now().UTC().Add(-retention))
+			if err != nil {
+				logger.Log("msg", "pruning checkin messages", "err", err)
+				continue
+			}
+			if deleted > 0 {
+				logger.Log("msg", "pruned checkin messages", "deleted", deleted)
+			}
+		}
+	}
+}
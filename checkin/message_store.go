@@ -0,0 +1,79 @@
+package checkin
+
+import (
+	"fmt"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq" // postgres driver
+	"github.com/micromdm/micromdm/dbpool"
+	"github.com/pkg/errors"
+)
+
+var insertMessageStmt = `INSERT INTO checkin_messages (
+	udid,
+	message_type,
+	body,
+	created_at
+) VALUES ($1, $2, $3, $4)
+RETURNING message_uuid;`
+
+// MessageStore keeps the raw checkin messages recorded for debugging.
+type MessageStore interface {
+	Record(m *Message) error
+	// DeleteBefore deletes the messages created before t and returns how many were deleted.
+	DeleteBefore(t time.Time) (int64, error)
+}
+
+type pgStore struct {
+	*sqlx.DB
+}
+
+// NewMessageDB creates a MessageStore.
+func NewMessageDB(driver, conn string, logger kitlog.Logger, opts ...dbpool.Option) (MessageStore, error) {
+	switch driver {
+	case "postgres":
+		db, err := sqlx.Open(driver, conn)
+		if err != nil {
+			return nil, errors.Wrap(err, "checkin message datastore")
+		}
+		dbpool.Configure(db.DB, opts...)
+		var dbError error
+		maxAttempts := 20
+		for attempts := 1; attempts <= maxAttempts; attempts++ {
+			dbError = db.Ping()
+			if dbError == nil {
+				break
+			}
+			logger.Log("msg", fmt.Sprintf("could not connect to postgres: %v", dbError))
+			time.Sleep(time.Duration(attempts) * time.Second)
+		}
+		if dbError != nil {
+			return nil, errors.Wrap(dbError, "checkin message datastore")
+		}
+		return pgStore{DB: db}, nil
+	default:
+		return nil, errors.New("unknown driver")
+	}
+}
+
+func (store pgStore) Record(m *Message) error {
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now().UTC()
+	}
+	err := store.QueryRow(insertMessageStmt, m.UDID, m.MessageType, m.Body, m.CreatedAt).Scan(&m.UUID)
+	if err != nil {
+		return errors.Wrap(err, "pgStore Record")
+	}
+	return nil
+}
+
+func (store pgStore) DeleteBefore(t time.Time) (int64, error) {
+	result, err := store.Exec(`DELETE FROM checkin_messages WHERE created_at < $1`, t)
+	if err != nil {
+		return 0, errors.Wrap(err, "pgStore DeleteBefore")
+	}
+	deleted, err := result.RowsAffected()
+	return deleted, errors.Wrap(err, "pgStore DeleteBefore")
+}
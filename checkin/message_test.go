@@ -0,0 +1,88 @@
+package checkin
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/micromdm/micromdm/device"
+	"golang.org/x/net/context"
+)
+
+type mockMessages struct {
+	messages []*Message
+}
+
+func (mm *mockMessages) Record(m *Message) error {
+	mm.messages = append(mm.messages, m)
+	return nil
+}
+
+func (mm *mockMessages) DeleteBefore(t time.Time) (int64, error) { return 0, nil }
+
+func TestRecordMessages(t *testing.T) {
+	devices := &mockDevices{devices: make(map[string]*device.Device)}
+	devices.GetDeviceByUDID("UDID-UNLOCK-TOKEN")
+	svc := NewService(devices, mockManagement{}, mockCommands{}, nil)
+	messages := &mockMessages{}
+	handler := RecordMessages(ServiceHandler(context.Background(), svc, log.NewNopLogger()), messages, log.NewNopLogger())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	req, err := http.NewRequest("PUT", server.URL+"/mdm/checkin", bytes.NewBufferString(fmt.Sprintf(tokenUpdate, unlockTokenKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", resp.StatusCode)
+	}
+
+	// the checkin must still see the complete body
+	if dev := devices.devices["UDID-UNLOCK-TOKEN"]; dev.Token == "" || dev.UnlockToken == "" {
+		t.Error("expected the TokenUpdate to be saved")
+	}
+
+	if len(messages.messages) != 1 {
+		t.Fatalf("expected 1 recorded message, got %d", len(messages.messages))
+	}
+	msg := messages.messages[0]
+	if msg.UDID != "UDID-UNLOCK-TOKEN" || msg.MessageType != "TokenUpdate" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	for _, secret := range []string{"some-push-magic", "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=", "3q2+7wEC"} {
+		if strings.Contains(msg.Body, secret) {
+			t.Errorf("expected %q to be redacted from the message body", secret)
+		}
+	}
+	if !strings.Contains(msg.Body, "com.apple.mgmt.External.test") {
+		t.Error("expected the Topic to be kept in the message body")
+	}
+}
+
+func TestRecordMessagesTooLarge(t *testing.T) {
+	messages := &mockMessages{}
+	var served bool
+	handler := RecordMessages(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}), messages, log.NewNopLogger())
+
+	body := strings.Repeat("a", maxMessageSize+1)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("PUT", "/mdm/checkin", strings.NewReader(body)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+	if served || len(messages.messages) != 0 {
+		t.Error("expected a message over the size limit to be rejected")
+	}
+}
@@ -1,6 +1,8 @@
 package checkin
 
 import (
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -13,9 +15,14 @@ import (
 
 // Service defines methods for and MDM Checkin service
 type Service interface {
-	Authenticate(mdm.CheckinCommand) error
+	// Authenticate registers a device. enrollment is the name of the
+	// enrollment profile the device enrolled with, empty for the default profile.
+	Authenticate(cmd mdm.CheckinCommand, enrollment string) error
 	TokenUpdate(mdm.CheckinCommand) error
 	Checkout(mdm.CheckinCommand) error
+	// DeclarationStatus stores the declaration status in the JSON status report
+	// of a DeclarativeManagement checkin.
+	DeclarationStatus(udid string, report []byte) error
 	// EnrollDEP returns an enrollment profile
 	// during DEP Enrollment
 	EnrollDEP(udid, serial string) ([]byte, error)
@@ -39,7 +46,7 @@ type service struct {
 	profile  []byte
 }
 
-func (svc service) Authenticate(cmd mdm.CheckinCommand) error {
+func (svc service) Authenticate(cmd mdm.CheckinCommand, enrollment string) error {
 	var udid, serialNumber device.JsonNullString
 
 	if err := udid.Scan(cmd.UDID); err != nil {
@@ -56,12 +63,14 @@ func (svc service) Authenticate(cmd mdm.CheckinCommand) error {
 		OSVersion:    cmd.OSVersion,
 		BuildVersion: cmd.BuildVersion,
 		ProductName:  cmd.ProductName,
+		Platform:     device.PlatformFromProductName(cmd.ProductName),
 		IMEI:         cmd.IMEI,
 		MEID:         cmd.MEID,
 		MDMTopic:     cmd.Topic,
 		Model:        cmd.Model,
 		DeviceName:   cmd.DeviceName,
 		LastCheckin:  time.Now().UTC(),
+		Enrollment:   enrollment,
 	}
 
 	_, err := svc.devices.New("authenticate", dev)
@@ -70,8 +79,7 @@ func (svc service) Authenticate(cmd mdm.CheckinCommand) error {
 
 func (svc service) TokenUpdate(cmd mdm.CheckinCommand) error {
 	if cmd.UserID != "" {
-		// don't handle user updates for now
-		return nil
+		return svc.userTokenUpdate(cmd)
 	}
 	token := cmd.Token.String()
 	unlockToken := cmd.UnlockToken.String()
@@ -91,22 +99,71 @@ func (svc service) TokenUpdate(cmd mdm.CheckinCommand) error {
 	if err != nil {
 		return err
 	}
+	// The UnlockToken is only sent with the first TokenUpdate after enrollment.
+	// Save it separately so that later updates don't erase the escrowed token.
+	if unlockToken != "" {
+		err = svc.devices.Save("unlockToken", existing)
+		if err != nil {
+			return err
+		}
+	}
 	// trigger a push notification
 	svc.mgmt.Push(cmd.UDID)
 	return nil
 }
 
+// userTokenUpdate saves the push token of a user channel.
+// The device token is left alone, a user channel has its own token and push magic.
+func (svc service) userTokenUpdate(cmd mdm.CheckinCommand) error {
+	return svc.devices.SaveUser(&device.User{
+		DeviceUDID: cmd.UDID,
+		UserID:     cmd.UserID,
+		ShortName:  cmd.UserShortName,
+		LongName:   cmd.UserLongName,
+		Token:      cmd.Token.String(),
+		PushMagic:  cmd.PushMagic,
+		UpdatedAt:  time.Now().UTC(),
+	})
+}
+
+// Checkout marks the device as no longer enrolled and clears its push token.
+// Devices send a CheckOut when the MDM profile is removed.
 func (svc service) Checkout(cmd mdm.CheckinCommand) error {
 	existing, err := svc.devices.GetDeviceByUDID(cmd.UDID, []string{"device_uuid"}...)
 	if err != nil {
 		return err
 	}
+	now := time.Now().UTC()
 	existing.Enrolled = false
+	existing.CheckedOutAt = &now
 	err = svc.devices.Save("checkout", existing)
 	if err != nil {
 		return err
 	}
-	return nil
+	// the declarations are reported again if the device enrolls again
+	return svc.devices.DeleteDeclarationStatus(cmd.UDID)
+}
+
+func (svc service) DeclarationStatus(udid string, report []byte) error {
+	dev, err := svc.devices.GetDeviceByUDID(udid, "mdm_enrolled")
+	if err == sql.ErrNoRows {
+		return ErrNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if !dev.Enrolled {
+		return ErrNotEnrolled
+	}
+	var r statusReport
+	if err := json.Unmarshal(report, &r); err != nil {
+		return ErrInvalidStatusReport
+	}
+	statuses := r.declarationStatuses(udid, time.Now().UTC())
+	if len(statuses) == 0 {
+		return nil
+	}
+	return svc.devices.SaveDeclarationStatus(statuses)
 }
 
 func (svc service) EnrollDEP(udid, serial string) ([]byte, error) {
@@ -1,7 +1,6 @@
 package checkin
 
 import (
-	"fmt"
 	"io/ioutil"
 	"net/http"
 
@@ -9,6 +8,7 @@ import (
 
 	"github.com/fullsailor/pkcs7"
 	kitlog "github.com/go-kit/kit/log"
+	level "github.com/go-kit/kit/log/experimental_level"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 	"github.com/groob/plist"
@@ -24,7 +24,7 @@ func ServiceHandler(ctx context.Context, svc Service, logger kitlog.Logger) http
 	checkinHandler := kithttp.NewServer(
 		ctx,
 		makeCheckinEndpoint(svc),
-		decodeMDMCheckinRequest,
+		decodeMDMCheckinRequest(level.Debug(logger)),
 		encodeResponse,
 		opts...,
 	)
@@ -42,17 +42,24 @@ func ServiceHandler(ctx context.Context, svc Service, logger kitlog.Logger) http
 	return r
 }
 
-func decodeMDMCheckinRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	data, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return nil, err
-	}
-	fmt.Println(string(data))
-	var request mdmCheckinRequest
-	if err := plist.Unmarshal(data, &request); err != nil {
-		return nil, err
+// decodeMDMCheckinRequest logs the plist to debug, with its secrets redacted, before decoding it
+func decodeMDMCheckinRequest(debug kitlog.Logger) kithttp.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if _, redactedBody, err := redactMessage(data); err == nil {
+			debug.Log("msg", "checkin request", "body", string(redactedBody))
+		}
+		var request mdmCheckinRequest
+		if err := plist.Unmarshal(data, &request); err != nil {
+			return nil, err
+		}
+		// named enrollment profiles add ?enrollment=<name> to the CheckInURL
+		request.Enrollment = r.URL.Query().Get("enrollment")
+		return request, nil
 	}
-	return request, nil
 }
 
 // The enrollment request is PkCS7 signed.
@@ -126,6 +133,10 @@ func encodeError(_ context.Context, err error, w http.ResponseWriter) {
 		err = httperr.Err
 	}
 	switch err {
+	case ErrUnsupportedEndpoint, ErrInvalidStatusReport:
+		w.WriteHeader(http.StatusBadRequest)
+	case ErrNotEnrolled:
+		w.WriteHeader(http.StatusNotFound)
 	default:
 		w.WriteHeader(http.StatusInternalServerError)
 	}
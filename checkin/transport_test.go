@@ -0,0 +1,411 @@
+package checkin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/micromdm/micromdm/command"
+	"github.com/micromdm/micromdm/device"
+	"github.com/micromdm/micromdm/management"
+	"golang.org/x/net/context"
+)
+
+// mockDevices is an in memory device.Datastore keyed by UDID
+type mockDevices struct {
+	device.Datastore
+	devices map[string]*device.Device
+	users   map[string]*device.User
+
+	declarations []device.DeclarationStatus
+}
+
+func (md *mockDevices) GetDeviceByUDID(udid string, fields ...string) (*device.Device, error) {
+	dev, ok := md.devices[udid]
+	if !ok {
+		dev = &device.Device{UUID: "00000000-1111-2222-3333-444455556666"}
+		dev.UDID.Scan(udid)
+		md.devices[udid] = dev
+	}
+	copied := *dev
+	return &copied, nil
+}
+
+func (md *mockDevices) Save(msg string, dev *device.Device) error {
+	existing := md.devices[dev.UDID.String]
+	switch msg {
+	case "tokenUpdate":
+		existing.Token = dev.Token
+		existing.PushMagic = dev.PushMagic
+		existing.Enrolled = dev.Enrolled
+	case "unlockToken":
+		existing.UnlockToken = dev.UnlockToken
+	case "checkout":
+		existing.Enrolled = dev.Enrolled
+		existing.CheckedOutAt = dev.CheckedOutAt
+		existing.Token = ""
+		existing.PushMagic = ""
+	}
+	return nil
+}
+
+func (md *mockDevices) New(src string, dev *device.Device) (string, error) {
+	copied := *dev
+	md.devices[dev.UDID.String] = &copied
+	return "00000000-1111-2222-3333-444455556666", nil
+}
+
+func (md *mockDevices) SaveUser(u *device.User) error {
+	if md.users == nil {
+		md.users = make(map[string]*device.User)
+	}
+	copied := *u
+	md.users[u.DeviceUDID+"/"+u.UserID] = &copied
+	return nil
+}
+
+func (md *mockDevices) SaveDeclarationStatus(statuses []device.DeclarationStatus) error {
+	md.declarations = append(md.declarations, statuses...)
+	return nil
+}
+
+func (md *mockDevices) DeleteDeclarationStatus(udid string) error {
+	var kept []device.DeclarationStatus
+	for _, status := range md.declarations {
+		if status.DeviceUDID != udid {
+			kept = append(kept, status)
+		}
+	}
+	md.declarations = kept
+	return nil
+}
+
+type mockManagement struct {
+	management.Service
+}
+
+func (mm mockManagement) Push(udid string) (string, error) { return "", nil }
+
+type mockCommands struct {
+	command.Service
+}
+
+var unlockToken = []byte{0xDE, 0xAD, 0xBE, 0xEF, 0x01, 0x02}
+
+const tokenUpdate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>TokenUpdate</string>
+	<key>PushMagic</key>
+	<string>some-push-magic</string>
+	<key>Token</key>
+	<data>AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=</data>
+	<key>Topic</key>
+	<string>com.apple.mgmt.External.test</string>
+	<key>UDID</key>
+	<string>UDID-UNLOCK-TOKEN</string>
+	%s
+</dict>
+</plist>`
+
+const unlockTokenKey = `<key>UnlockToken</key>
+	<data>3q2+7wEC</data>`
+
+func TestTokenUpdateUnlockToken(t *testing.T) {
+	devices := &mockDevices{devices: make(map[string]*device.Device)}
+	// make sure the device exists and has the correct UDID set.
+	devices.GetDeviceByUDID("UDID-UNLOCK-TOKEN")
+	svc := NewService(devices, mockManagement{}, mockCommands{}, nil)
+	server := httptest.NewServer(ServiceHandler(context.Background(), svc, log.NewNopLogger()))
+	defer server.Close()
+
+	// the first TokenUpdate carries the UnlockToken,
+	// the second one must not erase it.
+	for _, body := range []string{
+		fmt.Sprintf(tokenUpdate, unlockTokenKey),
+		fmt.Sprintf(tokenUpdate, ""),
+	} {
+		req, err := http.NewRequest("PUT", server.URL+"/mdm/checkin", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal("expected", http.StatusOK, "got", resp.StatusCode)
+		}
+	}
+
+	dev, err := devices.GetDeviceByUDID("UDID-UNLOCK-TOKEN", "unlock_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hex.EncodeToString(unlockToken); dev.UnlockToken != want {
+		t.Fatal("expected", want, "got", dev.UnlockToken)
+	}
+}
+
+func TestCheckinDebugLogRedacted(t *testing.T) {
+	devices := &mockDevices{devices: make(map[string]*device.Device)}
+	devices.GetDeviceByUDID("UDID-UNLOCK-TOKEN")
+	svc := NewService(devices, mockManagement{}, mockCommands{}, nil)
+	var logs bytes.Buffer
+	server := httptest.NewServer(ServiceHandler(context.Background(), svc, log.NewLogfmtLogger(&logs)))
+	defer server.Close()
+
+	body := fmt.Sprintf(tokenUpdate, unlockTokenKey)
+	req, err := http.NewRequest("PUT", server.URL+"/mdm/checkin", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if !bytes.Contains(logs.Bytes(), []byte("UDID-UNLOCK-TOKEN")) {
+		t.Fatalf("expected the checkin body to be logged, got %s", logs.String())
+	}
+	for _, secret := range []string{"some-push-magic", "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=", "3q2+7wEC"} {
+		if bytes.Contains(logs.Bytes(), []byte(secret)) {
+			t.Errorf("expected %s to be redacted, got %s", secret, logs.String())
+		}
+	}
+}
+
+const userTokenKeys = `<key>UserID</key>
+	<string>A1B2C3D4-0000-1111-2222-333344445555</string>
+	<key>UserShortName</key>
+	<string>jappleseed</string>`
+
+func TestTokenUpdateUserChannel(t *testing.T) {
+	devices := &mockDevices{devices: make(map[string]*device.Device)}
+	dev, _ := devices.GetDeviceByUDID("UDID-UNLOCK-TOKEN")
+	dev.Token = "0001020304"
+	dev.PushMagic = "device-push-magic"
+	devices.devices["UDID-UNLOCK-TOKEN"] = dev
+	svc := NewService(devices, mockManagement{}, mockCommands{}, nil)
+	server := httptest.NewServer(ServiceHandler(context.Background(), svc, log.NewNopLogger()))
+	defer server.Close()
+
+	body := fmt.Sprintf(tokenUpdate, userTokenKeys)
+	req, err := http.NewRequest("PUT", server.URL+"/mdm/checkin", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", resp.StatusCode)
+	}
+
+	user, ok := devices.users["UDID-UNLOCK-TOKEN/A1B2C3D4-0000-1111-2222-333344445555"]
+	if !ok {
+		t.Fatal("expected the user channel token to be saved")
+	}
+	if user.PushMagic != "some-push-magic" || user.ShortName != "jappleseed" || user.Token == "" {
+		t.Errorf("unexpected user channel %+v", user)
+	}
+
+	// the device channel token must be left alone.
+	dev, _ = devices.GetDeviceByUDID("UDID-UNLOCK-TOKEN")
+	if dev.Token != "0001020304" || dev.PushMagic != "device-push-magic" {
+		t.Error("expected the device token to be unchanged")
+	}
+}
+
+const checkOut = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>CheckOut</string>
+	<key>Topic</key>
+	<string>com.apple.mgmt.External.test</string>
+	<key>UDID</key>
+	<string>UDID-CHECKOUT</string>
+</dict>
+</plist>`
+
+func TestCheckOut(t *testing.T) {
+	devices := &mockDevices{devices: make(map[string]*device.Device)}
+	dev, _ := devices.GetDeviceByUDID("UDID-CHECKOUT")
+	dev.Enrolled = true
+	dev.Token = "0001020304"
+	dev.PushMagic = "some-push-magic"
+	devices.devices["UDID-CHECKOUT"] = dev
+	devices.declarations = []device.DeclarationStatus{{DeviceUDID: "UDID-CHECKOUT", Identifier: "config.passcode"}}
+	svc := NewService(devices, mockManagement{}, mockCommands{}, nil)
+	server := httptest.NewServer(ServiceHandler(context.Background(), svc, log.NewNopLogger()))
+	defer server.Close()
+
+	req, err := http.NewRequest("PUT", server.URL+"/mdm/checkin", bytes.NewBufferString(checkOut))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", resp.StatusCode)
+	}
+
+	dev, err = devices.GetDeviceByUDID("UDID-CHECKOUT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.Enrolled {
+		t.Error("expected device to be unenrolled after CheckOut")
+	}
+	if dev.CheckedOutAt == nil {
+		t.Error("expected the CheckOut time to be recorded")
+	}
+	if dev.Token != "" || dev.PushMagic != "" {
+		t.Error("expected the push token to be cleared")
+	}
+	if len(devices.declarations) != 0 {
+		t.Error("expected the declaration status to be deleted")
+	}
+}
+
+const authenticate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>Authenticate</string>
+	<key>SerialNumber</key>
+	<string>C02ENROLLMENT</string>
+	<key>Topic</key>
+	<string>com.apple.mgmt.External.test</string>
+	<key>UDID</key>
+	<string>UDID-ENROLLMENT</string>
+</dict>
+</plist>`
+
+func TestAuthenticateEnrollment(t *testing.T) {
+	devices := &mockDevices{devices: make(map[string]*device.Device)}
+	svc := NewService(devices, mockManagement{}, mockCommands{}, nil)
+	server := httptest.NewServer(ServiceHandler(context.Background(), svc, log.NewNopLogger()))
+	defer server.Close()
+
+	// the CheckInURL of a named enrollment profile carries the enrollment name
+	req, err := http.NewRequest("PUT", server.URL+"/mdm/checkin?enrollment=byod", bytes.NewBufferString(authenticate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "got", resp.StatusCode)
+	}
+
+	dev := devices.devices["UDID-ENROLLMENT"]
+	if dev == nil {
+		t.Fatal("expected the device to be created")
+	}
+	if dev.Enrollment != "byod" {
+		t.Errorf("expected enrollment byod, got %q", dev.Enrollment)
+	}
+}
+
+const declarativeManagement = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Data</key>
+	<data>%s</data>
+	<key>Endpoint</key>
+	<string>%s</string>
+	<key>MessageType</key>
+	<string>DeclarativeManagement</string>
+	<key>UDID</key>
+	<string>%s</string>
+</dict>
+</plist>`
+
+const statusReportJSON = `{
+	"StatusItems": {
+		"management": {
+			"declarations": {
+				"activations": [
+					{"identifier": "activation.default", "active": true, "valid": "valid", "server-token": "1"}
+				],
+				"configurations": [
+					{"identifier": "config.passcode", "active": false, "valid": "invalid", "server-token": "2",
+					 "reasons": [{"code": "Error.ConfigurationCannotBeApplied"}]}
+				]
+			}
+		}
+	},
+	"Errors": []
+}`
+
+func TestDeclarativeManagementStatus(t *testing.T) {
+	devices := &mockDevices{devices: make(map[string]*device.Device)}
+	dev, _ := devices.GetDeviceByUDID("UDID-DECLARATIVE")
+	dev.Enrolled = true
+	devices.devices["UDID-DECLARATIVE"] = dev
+	svc := NewService(devices, mockManagement{}, mockCommands{}, nil)
+	server := httptest.NewServer(ServiceHandler(context.Background(), svc, log.NewNopLogger()))
+	defer server.Close()
+
+	data := base64.StdEncoding.EncodeToString([]byte(statusReportJSON))
+	invalid := base64.StdEncoding.EncodeToString([]byte("not json"))
+	var tests = []struct {
+		udid     string
+		data     string
+		endpoint string
+		status   int
+	}{
+		{"UDID-DECLARATIVE", data, "status", http.StatusOK},
+		{"UDID-DECLARATIVE", data, "declaration-items", http.StatusBadRequest},
+		{"UDID-DECLARATIVE", invalid, "status", http.StatusBadRequest},
+		{"UDID-NOT-ENROLLED", data, "status", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		body := fmt.Sprintf(declarativeManagement, tt.data, tt.endpoint, tt.udid)
+		req, err := http.NewRequest("PUT", server.URL+"/mdm/checkin", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s endpoint %s: expected %d, got %d", tt.udid, tt.endpoint, tt.status, resp.StatusCode)
+		}
+	}
+
+	if len(devices.declarations) != 2 {
+		t.Fatalf("expected 2 declaration statuses, got %d", len(devices.declarations))
+	}
+	activation, config := devices.declarations[0], devices.declarations[1]
+	if activation.DeviceUDID != "UDID-DECLARATIVE" || activation.Kind != device.DeclarationActivation || !activation.Active {
+		t.Errorf("unexpected activation status %+v", activation)
+	}
+	if config.Identifier != "config.passcode" || config.Valid != "invalid" || config.ServerToken != "2" || len(config.Reasons) == 0 {
+		t.Errorf("unexpected configuration status %+v", config)
+	}
+}
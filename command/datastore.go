@@ -2,20 +2,32 @@ package command
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/groob/plist"
 	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/dbpool"
+)
+
+const (
+	// failed commands are kept around for operators to inspect
+	failedCommandTTL  = 30 * 24 * 3600
+	maxFailedCommands = 100
 )
 
 var (
 	// ErrNoKey is returned if there is no key in redis
 	ErrNoKey = errors.New("There is no such key in redis.")
+
+	// ErrNotQueued is returned when cancelling a command which is not in the device queue
+	ErrNotQueued = errors.New("command is not queued for the device")
 )
 
 // Datastore provides methods for saving and retrieving MDM commands
@@ -23,22 +35,51 @@ type Datastore interface {
 	// Saves the payload in redis
 	// SET CommandUUID plistData
 	SavePayload(payload *mdm.Payload) error
-	// Adds MDM commands to a queue in redis list
-	// LPUSH deviceUDID commandUUID
-	QueueCommand(deviceUDID, commandUUID string) error
+	// Adds MDM commands to a queue in redis list, ordered by
+	// priority and then by the time they were queued
+	// Commands with a notBefore time in the future are not returned by NextCommand
+	// until that time has passed.
+	QueueCommand(deviceUDID, commandUUID string, priority int, notBefore time.Time) error
+	// ReleaseDeferred returns the UDIDs of devices with deferred commands which became
+	// eligible before now. Each device is only returned once per command.
+	ReleaseDeferred(now time.Time) ([]string, error)
 	NextCommand(deviceUDID string) ([]byte, int, error)
 	DeleteCommand(deviceUDID, commandUUID string) (int, error)
+	// Removes a command which was not yet acknowledged from the device queue.
+	// Returns ErrNotQueued if the command is not in the queue.
+	DeleteQueuedCommand(deviceUDID, commandUUID string) (int, error)
+	// Removes a command the device acknowledged and remembers the
+	// acknowledgement, so that a repeated Acknowledge can be detected
+	AcknowledgeCommand(deviceUDID, commandUUID string) (int, error)
+	// Acknowledged returns true if the device already acknowledged the command
+	Acknowledged(commandUUID string) (bool, error)
+	// Removes a command the device responded to with an Error status
+	// and records it as failed, along with the ErrorChain of the response
+	FailCommand(deviceUDID, commandUUID string, chain []mdm.ErrorChainItem) (int, error)
+	// FailedCommands returns the commands which failed on a device, most recent first
+	FailedCommands(deviceUDID string) ([]FailedCommand, error)
 	Commands(deviceUDID string) ([]mdm.Payload, error)
+	// ListQueued returns the commands waiting in a device queue, in the order
+	// they will be sent to the device
+	ListQueued(deviceUDID string) ([]QueuedCommand, error)
 	Find(commandUUID string) (*mdm.Payload, error)
+	// QueueLength returns the number of commands queued for a device
+	QueueLength(deviceUDID string) (int, error)
+	// Ping checks the connection to redis
+	Ping() error
 }
 
 //NewDB creates a Datastore
-func NewDB(driver, conn string, logger kitlog.Logger) (Datastore, error) {
+func NewDB(driver, conn string, logger kitlog.Logger, opts ...dbpool.Option) (Datastore, error) {
 	var ds Datastore
 	switch driver {
 	case "redis":
 		ds = redisDB{pool: redisPool(conn, logger)}
 		return ds, nil
+	case "postgres":
+		return newPostgresDB(conn, logger, opts...)
+	case "memory":
+		return NewMemoryDB(), nil
 	default:
 		return nil, errors.New("unknown driver")
 	}
@@ -66,31 +107,133 @@ func (rds redisDB) SavePayload(payload *mdm.Payload) error {
 	return nil
 }
 
-func (rds redisDB) QueueCommand(deviceUDID, commandUUID string) error {
+func (rds redisDB) QueueCommand(deviceUDID, commandUUID string, priority int, notBefore time.Time) error {
 	// get connection from redis pool
 	conn := rds.pool.Get()
 	defer conn.Close()
-	_, err := conn.Do("lpush", deviceUDID, commandUUID)
+	if !notBefore.IsZero() {
+		_, err := conn.Do("hset", notBeforeKey(deviceUDID), commandUUID, notBefore.Unix())
+		if err != nil {
+			return err
+		}
+		_, err = conn.Do("zadd", deferredKey, notBefore.Unix(), deferredMember(deviceUDID, commandUUID))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := queueScript.Do(conn, deviceUDID, priorityKey(deviceUDID), commandUUID, priority)
+	if err != nil {
+		return err
+	}
+	// remember when the command was queued
+	_, err = conn.Do("hset", queuedAtKey(deviceUDID), commandUUID, time.Now().UTC().Unix())
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+func queuedAtKey(deviceUDID string) string {
+	return deviceUDID + ":queued_at"
+}
+
+func priorityKey(deviceUDID string) string {
+	return deviceUDID + ":priority"
+}
+
+func notBeforeKey(deviceUDID string) string {
+	return deviceUDID + ":not_before"
+}
+
+// deferredKey is a sorted set of deferred commands across all devices,
+// scored by the time they become eligible.
+const deferredKey = "commands:deferred"
+
+func deferredMember(deviceUDID, commandUUID string) string {
+	return deviceUDID + " " + commandUUID
+}
+
+func (rds redisDB) ReleaseDeferred(now time.Time) ([]string, error) {
+	conn := rds.pool.Get()
+	defer conn.Close()
+	members, err := redis.Strings(releaseScript.Do(conn, deferredKey, now.Unix()))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var udids []string
+	for _, member := range members {
+		udid := strings.SplitN(member, " ", 2)[0]
+		if !seen[udid] {
+			seen[udid] = true
+			udids = append(udids, udid)
+		}
+	}
+	return udids, nil
+}
+
+// insertCommand places a command in the queue after all commands
+// of the same or higher priority. Commands without a priority are 0.
+const insertCommand = `
+local function insert(queue, priorities, id)
+	local priority = tonumber(redis.call('HGET', priorities, id) or '0')
+	for _, other in ipairs(redis.call('LRANGE', queue, 0, -1)) do
+		if tonumber(redis.call('HGET', priorities, other) or '0') < priority then
+			return redis.call('LINSERT', queue, 'BEFORE', other, id)
+		end
+	end
+	return redis.call('RPUSH', queue, id)
+end
+`
+
+var (
+	// KEYS: queue, priorities ARGV: commandUUID, priority
+	queueScript = redis.NewScript(2, insertCommand+`
+if tonumber(ARGV[2]) ~= 0 then
+	redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+end
+return insert(KEYS[1], KEYS[2], ARGV[1])
+`)
+
+	// nextScript takes the first command which is not deferred past now
+	// and puts it back in the queue behind commands of the same priority.
+	// KEYS: queue, priorities, not before ARGV: now
+	nextScript = redis.NewScript(3, insertCommand+`
+for _, id in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	if tonumber(redis.call('HGET', KEYS[3], id) or '0') <= tonumber(ARGV[1]) then
+		redis.call('LREM', KEYS[1], 1, id)
+		insert(KEYS[1], KEYS[2], id)
+		return id
+	end
+end
+return false
+`)
+
+	// releaseScript removes and returns the deferred commands which became eligible.
+	// KEYS: deferred ARGV: now
+	releaseScript = redis.NewScript(1, `
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if #due > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+end
+return due
+`)
+)
+
 func (rds redisDB) NextCommand(deviceUDID string) ([]byte, int, error) {
 	// get connection from redis pool
 	conn := rds.pool.Get()
 	defer conn.Close()
-	// pop the first command
-	commandUUID, err := redis.String(conn.Do("lpop", deviceUDID))
+	// take the first command, keeping it in the queue until it is acknowledged
+	commandUUID, err := redis.String(nextScript.Do(conn,
+		deviceUDID, priorityKey(deviceUDID), notBeforeKey(deviceUDID), time.Now().Unix()))
 	if err != nil && err != redis.ErrNil {
 		return nil, 0, err
 	}
-	// if the list is empty
+	// if the list is empty or all commands are deferred
 	if err == redis.ErrNil {
 		return []byte{}, 0, nil
 	}
-	// push the redis command back to the end of the list
-	_, err = conn.Do("rpush", deviceUDID, commandUUID)
 	command, err := redis.String(conn.Do("get", commandUUID))
 	if err == redis.ErrNil {
 		return nil, 0, ErrNoKey
@@ -113,6 +256,22 @@ func (rds redisDB) DeleteCommand(deviceUDID, commandUUID string) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	_, err = conn.Do("hdel", queuedAtKey(deviceUDID), commandUUID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = conn.Do("hdel", priorityKey(deviceUDID), commandUUID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = conn.Do("hdel", notBeforeKey(deviceUDID), commandUUID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = conn.Do("zrem", deferredKey, deferredMember(deviceUDID, commandUUID))
+	if err != nil {
+		return 0, err
+	}
 	// set the key to expire in an hour
 	_, err = conn.Do("expire", commandUUID, 3600)
 	if err != nil {
@@ -126,6 +285,104 @@ func (rds redisDB) DeleteCommand(deviceUDID, commandUUID string) (int, error) {
 	return total, nil
 }
 
+func (rds redisDB) DeleteQueuedCommand(deviceUDID, commandUUID string) (int, error) {
+	conn := rds.pool.Get()
+	defer conn.Close()
+	removed, err := redis.Int(conn.Do("lrem", deviceUDID, 0, commandUUID))
+	if err != nil {
+		return 0, err
+	}
+	if removed == 0 {
+		return 0, ErrNotQueued
+	}
+	// the payload is kept for an hour in case the device already received it
+	return rds.DeleteCommand(deviceUDID, commandUUID)
+}
+
+func (rds redisDB) FailCommand(deviceUDID, commandUUID string, chain []mdm.ErrorChainItem) (int, error) {
+	failed := FailedCommand{
+		CommandUUID: commandUUID,
+		Status:      StatusFailed,
+		ErrorChain:  chain,
+		FailedAt:    time.Now().UTC(),
+	}
+	if payload, err := rds.Find(commandUUID); err == nil && payload.Command != nil {
+		failed.RequestType = payload.Command.RequestType
+	}
+	data, err := json.Marshal(failed)
+	if err != nil {
+		return 0, err
+	}
+
+	conn := rds.pool.Get()
+	defer conn.Close()
+	conn.Send("multi")
+	conn.Send("set", failureKey(commandUUID), data, "ex", failedCommandTTL)
+	conn.Send("lpush", failedKey(deviceUDID), commandUUID)
+	conn.Send("ltrim", failedKey(deviceUDID), 0, maxFailedCommands-1)
+	if _, err := conn.Do("exec"); err != nil {
+		return 0, err
+	}
+	return rds.DeleteCommand(deviceUDID, commandUUID)
+}
+
+func (rds redisDB) FailedCommands(deviceUDID string) ([]FailedCommand, error) {
+	conn := rds.pool.Get()
+	defer conn.Close()
+
+	commandUUIDs, err := redis.Strings(conn.Do("lrange", failedKey(deviceUDID), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	var failed []FailedCommand
+	for _, commandUUID := range commandUUIDs {
+		data, err := redis.Bytes(conn.Do("get", failureKey(commandUUID)))
+		if err == redis.ErrNil {
+			// the record expired
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var fc FailedCommand
+		if err := json.Unmarshal(data, &fc); err != nil {
+			return nil, err
+		}
+		failed = append(failed, fc)
+	}
+	return failed, nil
+}
+
+func failureKey(commandUUID string) string {
+	return commandUUID + ":failure"
+}
+
+func failedKey(deviceUDID string) string {
+	return deviceUDID + ":failed"
+}
+
+func (rds redisDB) AcknowledgeCommand(deviceUDID, commandUUID string) (int, error) {
+	conn := rds.pool.Get()
+	defer conn.Close()
+	// devices re-send an Acknowledge shortly after the first one,
+	// so the marker only needs to live as long as the payload
+	_, err := conn.Do("set", acknowledgedKey(commandUUID), 1, "ex", 3600)
+	if err != nil {
+		return 0, err
+	}
+	return rds.DeleteCommand(deviceUDID, commandUUID)
+}
+
+func (rds redisDB) Acknowledged(commandUUID string) (bool, error) {
+	conn := rds.pool.Get()
+	defer conn.Close()
+	return redis.Bool(conn.Do("exists", acknowledgedKey(commandUUID)))
+}
+
+func acknowledgedKey(commandUUID string) string {
+	return commandUUID + ":acknowledged"
+}
+
 func (rds redisDB) Commands(deviceUDID string) ([]mdm.Payload, error) {
 	conn := rds.pool.Get()
 	defer conn.Close()
@@ -151,11 +408,64 @@ func (rds redisDB) Commands(deviceUDID string) ([]mdm.Payload, error) {
 	return payloads, nil
 }
 
+func (rds redisDB) ListQueued(deviceUDID string) ([]QueuedCommand, error) {
+	conn := rds.pool.Get()
+	defer conn.Close()
+
+	commandUUIDs, err := redis.Strings(conn.Do("lrange", deviceUDID, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	queued := make([]QueuedCommand, 0, len(commandUUIDs))
+	for _, commandUUID := range commandUUIDs {
+		qc := QueuedCommand{CommandUUID: commandUUID}
+		payloadData, err := redis.Bytes(conn.Do("get", commandUUID))
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+		if err == nil {
+			var payload mdm.Payload
+			if err := plist.NewDecoder(bytes.NewReader(payloadData)).Decode(&payload); err != nil {
+				return nil, err
+			}
+			if payload.Command != nil {
+				qc.RequestType = payload.Command.RequestType
+			}
+		}
+		// commands queued before the time was recorded have no timestamp
+		queuedAt, err := redis.Int64(conn.Do("hget", queuedAtKey(deviceUDID), commandUUID))
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+		if err == nil {
+			qc.QueuedAt = time.Unix(queuedAt, 0).UTC()
+		}
+		priority, err := redis.Int(conn.Do("hget", priorityKey(deviceUDID), commandUUID))
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+		qc.Priority = priority
+		notBefore, err := redis.Int64(conn.Do("hget", notBeforeKey(deviceUDID), commandUUID))
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+		if err == nil {
+			t := time.Unix(notBefore, 0).UTC()
+			qc.NotBefore = &t
+		}
+		queued = append(queued, qc)
+	}
+	return queued, nil
+}
+
 func (rds redisDB) Find(commandUUID string) (*mdm.Payload, error) {
 	conn := rds.pool.Get()
 	defer conn.Close()
 
 	payloadData, err := redis.Bytes(conn.Do("GET", commandUUID))
+	if err == redis.ErrNil {
+		return nil, ErrNoKey
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -168,6 +478,19 @@ func (rds redisDB) Find(commandUUID string) (*mdm.Payload, error) {
 	return payload, nil
 }
 
+func (rds redisDB) QueueLength(deviceUDID string) (int, error) {
+	conn := rds.pool.Get()
+	defer conn.Close()
+	return redis.Int(conn.Do("llen", deviceUDID))
+}
+
+func (rds redisDB) Ping() error {
+	conn := rds.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return err
+}
+
 func redisPool(conn string, logger kitlog.Logger) *redis.Pool {
 	pool := &redis.Pool{
 		MaxIdle:     3,
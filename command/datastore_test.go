@@ -13,14 +13,14 @@ type datastoreFixtures struct {
 	logger log.Logger
 }
 
-func setup() (datastoreFixtures, error) {
+func setup(conn string) (datastoreFixtures, error) {
 	logger := log.NewLogfmtLogger(os.Stdout)
-	commandsDb, err := NewDB("redis", "localhost", logger)
+	commandsDb, err := NewDB("redis", conn, logger)
 	if err != nil {
-		return nil, err
+		return datastoreFixtures{}, err
 	}
 
-	return datastoreFixtures{ds: commandsDb, logger: logger}
+	return datastoreFixtures{ds: commandsDb, logger: logger}, nil
 }
 
 func teardown() {
@@ -28,7 +28,12 @@ func teardown() {
 }
 
 func TestService_Commands(t *testing.T) {
-	fixtures, err := setup()
+	// NewDB exits if it cannot connect to redis.
+	conn := os.Getenv("MICROMDM_TEST_REDIS")
+	if conn == "" {
+		t.Skip("set MICROMDM_TEST_REDIS to the address of a redis server to run the test")
+	}
+	fixtures, err := setup(conn)
 	defer teardown()
 	if err != nil {
 		t.Errorf("error making new datastore: %v", err)
@@ -0,0 +1,61 @@
+package command
+
+import (
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"golang.org/x/net/context"
+)
+
+// PushDeferred checks for deferred commands every interval and calls push for
+// each device with a command which became eligible, so that the device checks
+// in without waiting for another command to be queued. It returns when ctx is done.
+func PushDeferred(ctx context.Context, svc Service, push func(udid string) (string, error), interval time.Duration, logger kitlog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			udids, err := svc.ReleaseDeferred(now)
+			if err != nil {
+				logger.Log("msg", "releasing deferred commands", "err", err)
+				continue
+			}
+			for _, udid := range udids {
+				if _, err := push(udid); err != nil {
+					logger.Log("msg", "push for deferred command", "udid", udid, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// pruner is implemented by the datastores which do not expire
+// removed commands on their own.
+type pruner interface {
+	Prune(now time.Time) error
+}
+
+// PruneExpired deletes expired payloads, acknowledgements and failures from
+// the datastore every interval. It returns right away if the datastore expires
+// them on its own, otherwise when ctx is done.
+func PruneExpired(ctx context.Context, db Datastore, interval time.Duration, logger kitlog.Logger) {
+	p, ok := db.(pruner)
+	if !ok {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := p.Prune(now); err != nil {
+				logger.Log("msg", "pruning expired commands", "err", err)
+			}
+		}
+	}
+}
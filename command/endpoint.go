@@ -2,6 +2,7 @@ package command
 
 import (
 	"errors"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -18,6 +19,20 @@ var (
 // newCommandRequest represents an HTTP Request for a new MDM Command
 type newCommandRequest struct {
 	*mdm.CommandRequest
+	// Confirmation must be set to the device serial number for an EraseDevice command.
+	Confirmation string `json:"confirmation,omitempty"`
+	// Priority moves the command ahead of queued commands with a lower priority.
+	Priority int `json:"priority,omitempty"`
+	// NotBefore defers the command until the given time.
+	NotBefore time.Time `json:"not_before,omitempty"`
+	// ProfileName installs a profile from the profile library with an InstallProfile command.
+	// ProfileVersion selects the version, the latest version is used if it is not set.
+	ProfileName    string `json:"profile_name,omitempty"`
+	ProfileVersion int    `json:"profile_version,omitempty"`
+	// UserID sends the command to a user channel of the device.
+	// User channel commands are queued under the UserID, which the device
+	// sends along with its UDID when it connects on the user channel.
+	UserID string `json:"user_id,omitempty"`
 }
 
 // newCommandResponse is a command reponse
@@ -34,7 +49,20 @@ func makeNewCommandEndpoint(svc Service) endpoint.Endpoint {
 		if req.UDID == "" || req.RequestType == "" {
 			return newCommandResponse{Err: ErrEmptyRequest}, nil
 		}
-		payload, err := svc.NewCommand(req.CommandRequest)
+		opts := []Option{WithPriority(req.Priority), NotBefore(req.NotBefore)}
+		if req.ProfileName != "" {
+			opts = append(opts, FromLibrary(req.ProfileName, req.ProfileVersion))
+		}
+		if req.UserID != "" {
+			opts = append(opts, ForUser(req.UserID))
+		}
+		var payload *mdm.Payload
+		var err error
+		if req.RequestType == "EraseDevice" {
+			payload, err = svc.EraseDevice(req.CommandRequest, req.Confirmation, opts...)
+		} else {
+			payload, err = svc.NewCommand(req.CommandRequest, opts...)
+		}
 		if err != nil {
 			return newCommandResponse{Err: err}, nil
 		}
@@ -99,6 +127,32 @@ func makeDeleteCommandEndpoint(svc Service) endpoint.Endpoint {
 	}
 }
 
+type cancelCommandRequest struct {
+	UDID string
+	UUID string
+}
+
+type cancelCommandResponse struct {
+	Total int   `json:"remaining_payloads"`
+	Err   error `json:"error,omitempty"`
+}
+
+func (r cancelCommandResponse) error() error { return r.Err }
+
+func makeCancelCommandEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(cancelCommandRequest)
+		if req.UDID == "" {
+			return cancelCommandResponse{Err: ErrEmptyRequest}, nil
+		}
+		total, err := svc.DeleteQueuedCommand(req.UDID, req.UUID)
+		if err != nil {
+			return cancelCommandResponse{Err: err}, nil
+		}
+		return cancelCommandResponse{Total: total}, nil
+	}
+}
+
 type getCommandsRequest struct {
 	UDID string
 }
@@ -121,3 +175,28 @@ func makeGetCommandsEndpoint(svc Service) endpoint.Endpoint {
 		return getCommandsResponse{Commands: commands}, nil
 	}
 }
+
+type listQueuedRequest struct {
+	UDID string
+}
+
+type listQueuedResponse struct {
+	Commands []QueuedCommand `json:"commands"`
+	Err      error           `json:"error,omitempty"`
+}
+
+func (r listQueuedResponse) error() error { return r.Err }
+
+func makeListQueuedEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(listQueuedRequest)
+		if req.UDID == "" {
+			return listQueuedResponse{Err: ErrEmptyRequest}, nil
+		}
+		commands, err := svc.ListQueued(req.UDID)
+		if err != nil {
+			return listQueuedResponse{Err: err}, nil
+		}
+		return listQueuedResponse{Commands: commands}, nil
+	}
+}
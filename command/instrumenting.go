@@ -0,0 +1,106 @@
+package command
+
+import (
+	"sync"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/micromdm/mdm"
+)
+
+// InstrumentingMiddleware records the depth of the command queue and the
+// number of commands created by RequestType.
+// queued is set to the total number of commands waiting across all devices
+// the service has seen since it started. Queue depths are read back from
+// the Datastore after every change, so the gauge stays in line with redis.
+func InstrumentingMiddleware(ds Datastore, queued metrics.Gauge, created metrics.Counter) func(Service) Service {
+	return func(next Service) Service {
+		return &instrumentingMiddleware{
+			Service: next,
+			db:      ds,
+			queued:  queued,
+			created: created,
+			depths:  make(map[string]int),
+		}
+	}
+}
+
+type instrumentingMiddleware struct {
+	Service
+	db      Datastore
+	queued  metrics.Gauge
+	created metrics.Counter
+
+	mu     sync.Mutex
+	depths map[string]int
+	total  int
+}
+
+func (mw *instrumentingMiddleware) NewCommand(request *mdm.CommandRequest, opts ...Option) (*mdm.Payload, error) {
+	payload, err := mw.Service.NewCommand(request, opts...)
+	if err == nil {
+		mw.commandCreated(request)
+	}
+	return payload, err
+}
+
+func (mw *instrumentingMiddleware) EraseDevice(request *mdm.CommandRequest, confirmation string, opts ...Option) (*mdm.Payload, error) {
+	payload, err := mw.Service.EraseDevice(request, confirmation, opts...)
+	if err == nil {
+		mw.commandCreated(request)
+	}
+	return payload, err
+}
+
+func (mw *instrumentingMiddleware) DeleteCommand(deviceUDID, commandUUID string) (int, error) {
+	total, err := mw.Service.DeleteCommand(deviceUDID, commandUUID)
+	if err == nil {
+		mw.setDepth(deviceUDID, total)
+	}
+	return total, err
+}
+
+func (mw *instrumentingMiddleware) DeleteQueuedCommand(deviceUDID, commandUUID string) (int, error) {
+	total, err := mw.Service.DeleteQueuedCommand(deviceUDID, commandUUID)
+	if err == nil {
+		mw.setDepth(deviceUDID, total)
+	}
+	return total, err
+}
+
+func (mw *instrumentingMiddleware) AcknowledgeCommand(deviceUDID, commandUUID string) (int, error) {
+	total, err := mw.Service.AcknowledgeCommand(deviceUDID, commandUUID)
+	if err == nil {
+		mw.setDepth(deviceUDID, total)
+	}
+	return total, err
+}
+
+func (mw *instrumentingMiddleware) FailCommand(deviceUDID, commandUUID string, chain []mdm.ErrorChainItem) (int, error) {
+	total, err := mw.Service.FailCommand(deviceUDID, commandUUID, chain)
+	if err == nil {
+		mw.setDepth(deviceUDID, total)
+	}
+	return total, err
+}
+
+func (mw *instrumentingMiddleware) commandCreated(request *mdm.CommandRequest) {
+	mw.created.With("request_type", request.RequestType).Add(1)
+	total, err := mw.db.QueueLength(request.UDID)
+	if err != nil {
+		// the command was queued, the gauge will catch up on the next change
+		return
+	}
+	mw.setDepth(request.UDID, total)
+}
+
+func (mw *instrumentingMiddleware) setDepth(udid string, total int) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	mw.total += total - mw.depths[udid]
+	if total == 0 {
+		delete(mw.depths, udid)
+	} else {
+		mw.depths[udid] = total
+	}
+	mw.queued.Set(float64(mw.total))
+}
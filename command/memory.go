@@ -0,0 +1,369 @@
+package command
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/mdm"
+)
+
+// memoryDB is a Datastore which keeps the command queues in memory.
+// Queued commands are lost when the process exits, so it is only meant
+// for single node deployments with a few devices and for testing.
+type memoryDB struct {
+	mu sync.Mutex
+
+	payloads map[string][]byte
+	// expires holds when the payload of a removed command is forgotten.
+	expires map[string]time.Time
+	// queues holds the command UUIDs of each device in the order they are sent.
+	queues map[string][]string
+	// meta is keyed by command UUID.
+	meta map[string]*queuedMeta
+	// deferred holds the commands with a notBefore time which were not released yet.
+	deferred     []deferredCommand
+	acknowledged map[string]time.Time
+	failed       map[string][]FailedCommand
+	lastPrune    time.Time
+
+	now func() time.Time
+}
+
+type queuedMeta struct {
+	priority  int
+	queuedAt  time.Time
+	notBefore time.Time
+}
+
+type deferredCommand struct {
+	udid        string
+	commandUUID string
+	notBefore   time.Time
+}
+
+// NewMemoryDB creates a Datastore which keeps the command queues in memory.
+func NewMemoryDB() Datastore {
+	return &memoryDB{
+		payloads:     make(map[string][]byte),
+		expires:      make(map[string]time.Time),
+		queues:       make(map[string][]string),
+		meta:         make(map[string]*queuedMeta),
+		acknowledged: make(map[string]time.Time),
+		failed:       make(map[string][]FailedCommand),
+		now:          time.Now,
+	}
+}
+
+func (m *memoryDB) SavePayload(payload *mdm.Payload) error {
+	var buf bytes.Buffer
+	if err := plist.NewEncoder(&buf).Encode(payload); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.payloads[payload.CommandUUID] = buf.Bytes()
+	delete(m.expires, payload.CommandUUID)
+	return nil
+}
+
+func (m *memoryDB) QueueCommand(deviceUDID, commandUUID string, priority int, notBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.meta[commandUUID] = &queuedMeta{
+		priority:  priority,
+		queuedAt:  m.now().UTC(),
+		notBefore: notBefore,
+	}
+	if !notBefore.IsZero() {
+		m.deferred = append(m.deferred, deferredCommand{deviceUDID, commandUUID, notBefore})
+	}
+	m.insert(deviceUDID, commandUUID)
+	return nil
+}
+
+// insert places a command in the queue after all commands of the same or higher priority.
+func (m *memoryDB) insert(deviceUDID, commandUUID string) {
+	queue := m.queues[deviceUDID]
+	priority := m.priority(commandUUID)
+	i := 0
+	for ; i < len(queue); i++ {
+		if m.priority(queue[i]) < priority {
+			break
+		}
+	}
+	queue = append(queue, "")
+	copy(queue[i+1:], queue[i:])
+	queue[i] = commandUUID
+	m.queues[deviceUDID] = queue
+}
+
+func (m *memoryDB) priority(commandUUID string) int {
+	if meta, ok := m.meta[commandUUID]; ok {
+		return meta.priority
+	}
+	return 0
+}
+
+// remove takes a command out of the device queue and reports whether it was queued.
+func (m *memoryDB) remove(deviceUDID, commandUUID string) bool {
+	queue := m.queues[deviceUDID]
+	for i, id := range queue {
+		if id == commandUUID {
+			m.queues[deviceUDID] = append(queue[:i], queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryDB) ReleaseDeferred(now time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[string]bool)
+	var udids []string
+	pending := m.deferred[:0]
+	for _, d := range m.deferred {
+		if d.notBefore.After(now) {
+			pending = append(pending, d)
+			continue
+		}
+		if !seen[d.udid] {
+			seen[d.udid] = true
+			udids = append(udids, d.udid)
+		}
+	}
+	m.deferred = pending
+	return udids, nil
+}
+
+func (m *memoryDB) NextCommand(deviceUDID string) ([]byte, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for _, commandUUID := range m.queues[deviceUDID] {
+		if meta, ok := m.meta[commandUUID]; ok && meta.notBefore.After(now) {
+			continue
+		}
+		// keep the command in the queue until it is acknowledged,
+		// behind the other commands of the same priority.
+		m.remove(deviceUDID, commandUUID)
+		m.insert(deviceUDID, commandUUID)
+		payload, ok := m.payload(commandUUID)
+		if !ok {
+			return nil, 0, ErrNoKey
+		}
+		return payload, len(m.queues[deviceUDID]), nil
+	}
+	return []byte{}, 0, nil
+}
+
+// payload returns the payload of a command unless it expired.
+func (m *memoryDB) payload(commandUUID string) ([]byte, bool) {
+	if expires, ok := m.expires[commandUUID]; ok && m.now().After(expires) {
+		delete(m.payloads, commandUUID)
+		delete(m.expires, commandUUID)
+	}
+	payload, ok := m.payloads[commandUUID]
+	return payload, ok
+}
+
+func (m *memoryDB) DeleteCommand(deviceUDID, commandUUID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteCommand(deviceUDID, commandUUID)
+	return len(m.queues[deviceUDID]), nil
+}
+
+func (m *memoryDB) deleteCommand(deviceUDID, commandUUID string) {
+	m.remove(deviceUDID, commandUUID)
+	delete(m.meta, commandUUID)
+	for i, d := range m.deferred {
+		if d.udid == deviceUDID && d.commandUUID == commandUUID {
+			m.deferred = append(m.deferred[:i], m.deferred[i+1:]...)
+			break
+		}
+	}
+	// the payload is kept for an hour in case the device already received it
+	if _, ok := m.payloads[commandUUID]; ok {
+		m.expires[commandUUID] = m.now().Add(time.Hour)
+	}
+	m.prune()
+}
+
+// pruneInterval is how often removed commands are checked for expiry.
+const pruneInterval = time.Minute
+
+// prune forgets expired payloads, acknowledgements and failed commands.
+// Without it the maps would only shrink when an expired entry is read.
+func (m *memoryDB) prune() {
+	now := m.now()
+	if now.Sub(m.lastPrune) < pruneInterval {
+		return
+	}
+	m.lastPrune = now
+	for commandUUID, expires := range m.expires {
+		if now.After(expires) {
+			delete(m.payloads, commandUUID)
+			delete(m.expires, commandUUID)
+		}
+	}
+	for commandUUID, expires := range m.acknowledged {
+		if now.After(expires) {
+			delete(m.acknowledged, commandUUID)
+		}
+	}
+	cutoff := now.Add(-failedCommandTTL * time.Second)
+	for udid, list := range m.failed {
+		var kept []FailedCommand
+		for _, fc := range list {
+			if fc.FailedAt.After(cutoff) {
+				kept = append(kept, fc)
+			}
+		}
+		if len(kept) == 0 {
+			delete(m.failed, udid)
+			continue
+		}
+		m.failed[udid] = kept
+	}
+	for udid, queue := range m.queues {
+		if len(queue) == 0 {
+			delete(m.queues, udid)
+		}
+	}
+}
+
+func (m *memoryDB) DeleteQueuedCommand(deviceUDID, commandUUID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.remove(deviceUDID, commandUUID) {
+		return 0, ErrNotQueued
+	}
+	m.deleteCommand(deviceUDID, commandUUID)
+	return len(m.queues[deviceUDID]), nil
+}
+
+func (m *memoryDB) AcknowledgeCommand(deviceUDID, commandUUID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.acknowledged[commandUUID] = m.now().Add(time.Hour)
+	m.deleteCommand(deviceUDID, commandUUID)
+	return len(m.queues[deviceUDID]), nil
+}
+
+func (m *memoryDB) Acknowledged(commandUUID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	expires, ok := m.acknowledged[commandUUID]
+	if ok && m.now().After(expires) {
+		delete(m.acknowledged, commandUUID)
+		return false, nil
+	}
+	return ok, nil
+}
+
+func (m *memoryDB) FailCommand(deviceUDID, commandUUID string, chain []mdm.ErrorChainItem) (int, error) {
+	failed := FailedCommand{
+		CommandUUID: commandUUID,
+		Status:      StatusFailed,
+		ErrorChain:  chain,
+		FailedAt:    m.now().UTC(),
+	}
+	if payload, err := m.Find(commandUUID); err == nil && payload.Command != nil {
+		failed.RequestType = payload.Command.RequestType
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := append([]FailedCommand{failed}, m.failed[deviceUDID]...)
+	if len(list) > maxFailedCommands {
+		list = list[:maxFailedCommands]
+	}
+	m.failed[deviceUDID] = list
+	m.deleteCommand(deviceUDID, commandUUID)
+	return len(m.queues[deviceUDID]), nil
+}
+
+func (m *memoryDB) FailedCommands(deviceUDID string) ([]FailedCommand, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cutoff := m.now().Add(-failedCommandTTL * time.Second)
+	var failed []FailedCommand
+	for _, fc := range m.failed[deviceUDID] {
+		if fc.FailedAt.After(cutoff) {
+			failed = append(failed, fc)
+		}
+	}
+	return failed, nil
+}
+
+func (m *memoryDB) Commands(deviceUDID string) ([]mdm.Payload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	queue := m.queues[deviceUDID]
+	payloads := make([]mdm.Payload, len(queue))
+	for i, commandUUID := range queue {
+		data, ok := m.payload(commandUUID)
+		if !ok {
+			return nil, ErrNoKey
+		}
+		if err := plist.NewDecoder(bytes.NewReader(data)).Decode(&payloads[i]); err != nil {
+			return nil, err
+		}
+	}
+	return payloads, nil
+}
+
+func (m *memoryDB) ListQueued(deviceUDID string) ([]QueuedCommand, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	queue := m.queues[deviceUDID]
+	queued := make([]QueuedCommand, 0, len(queue))
+	for _, commandUUID := range queue {
+		qc := QueuedCommand{CommandUUID: commandUUID}
+		if data, ok := m.payload(commandUUID); ok {
+			var payload mdm.Payload
+			if err := plist.NewDecoder(bytes.NewReader(data)).Decode(&payload); err != nil {
+				return nil, err
+			}
+			if payload.Command != nil {
+				qc.RequestType = payload.Command.RequestType
+			}
+		}
+		if meta, ok := m.meta[commandUUID]; ok {
+			qc.QueuedAt = meta.queuedAt
+			qc.Priority = meta.priority
+			if !meta.notBefore.IsZero() {
+				t := meta.notBefore.UTC()
+				qc.NotBefore = &t
+			}
+		}
+		queued = append(queued, qc)
+	}
+	return queued, nil
+}
+
+func (m *memoryDB) Find(commandUUID string) (*mdm.Payload, error) {
+	m.mu.Lock()
+	data, ok := m.payload(commandUUID)
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrNoKey
+	}
+	var payload *mdm.Payload
+	if err := plist.NewDecoder(bytes.NewReader(data)).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (m *memoryDB) QueueLength(deviceUDID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.queues[deviceUDID]), nil
+}
+
+// Ping always succeeds, there is no connection to check.
+func (m *memoryDB) Ping() error { return nil }
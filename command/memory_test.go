@@ -0,0 +1,201 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	"github.com/micromdm/mdm"
+)
+
+type memoryFixture struct {
+	db  *memoryDB
+	now time.Time
+}
+
+func newMemoryFixture() *memoryFixture {
+	f := &memoryFixture{now: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)}
+	f.db = NewMemoryDB().(*memoryDB)
+	f.db.now = func() time.Time { return f.now }
+	return f
+}
+
+func (f *memoryFixture) queue(t *testing.T, udid, commandUUID, requestType string, priority int, notBefore time.Time) {
+	payload, err := mdm.NewPayload(&mdm.CommandRequest{UDID: udid, RequestType: requestType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload.CommandUUID = commandUUID
+	if err := f.db.SavePayload(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.db.QueueCommand(udid, commandUUID, priority, notBefore); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func queuedUUIDs(t *testing.T, db Datastore, udid string) []string {
+	queued, err := db.ListQueued(udid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uuids []string
+	for _, qc := range queued {
+		uuids = append(uuids, qc.CommandUUID)
+	}
+	return uuids
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryQueueOrder(t *testing.T) {
+	f := newMemoryFixture()
+	f.queue(t, "udid", "low-1", "DeviceInformation", 0, time.Time{})
+	f.queue(t, "udid", "high", "DeviceLock", 10, time.Time{})
+	f.queue(t, "udid", "low-2", "ProfileList", 0, time.Time{})
+
+	want := []string{"high", "low-1", "low-2"}
+	if got := queuedUUIDs(t, f.db, "udid"); !equalStrings(got, want) {
+		t.Errorf("queue order = %v, want %v", got, want)
+	}
+
+	// a sent command stays queued behind the commands of the same priority.
+	if _, err := f.db.AcknowledgeCommand("udid", "high"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.db.NextCommand("udid"); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"low-2", "low-1"}
+	if got := queuedUUIDs(t, f.db, "udid"); !equalStrings(got, want) {
+		t.Errorf("queue order after NextCommand = %v, want %v", got, want)
+	}
+}
+
+func TestMemoryDeferred(t *testing.T) {
+	f := newMemoryFixture()
+	notBefore := f.now.Add(time.Hour)
+	f.queue(t, "udid", "later", "DeviceInformation", 0, notBefore)
+
+	payload, n, err := f.db.NextCommand("udid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) != 0 || n != 0 {
+		t.Errorf("deferred command was sent before notBefore")
+	}
+	udids, err := f.db.ReleaseDeferred(f.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(udids) != 0 {
+		t.Errorf("released %v before notBefore", udids)
+	}
+
+	f.now = notBefore.Add(time.Second)
+	udids, err = f.db.ReleaseDeferred(f.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalStrings(udids, []string{"udid"}) {
+		t.Errorf("released %v, want [udid]", udids)
+	}
+	payload, _, err = f.db.NextCommand("udid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) == 0 {
+		t.Error("released command was not sent")
+	}
+}
+
+func TestMemoryAcknowledge(t *testing.T) {
+	f := newMemoryFixture()
+	f.queue(t, "udid", "cmd", "DeviceInformation", 0, time.Time{})
+
+	n, err := f.db.AcknowledgeCommand("udid", "cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("queue length = %d, want 0", n)
+	}
+	if ok, _ := f.db.Acknowledged("cmd"); !ok {
+		t.Error("command is not acknowledged")
+	}
+	// the payload is still available to a device which received it.
+	if _, err := f.db.Find("cmd"); err != nil {
+		t.Errorf("Find after acknowledge: %v", err)
+	}
+	if _, err := f.db.DeleteQueuedCommand("udid", "cmd"); err != ErrNotQueued {
+		t.Errorf("DeleteQueuedCommand of an acknowledged command = %v, want ErrNotQueued", err)
+	}
+}
+
+func TestMemoryFailCommand(t *testing.T) {
+	f := newMemoryFixture()
+	f.queue(t, "udid", "cmd", "EraseDevice", 0, time.Time{})
+
+	if _, err := f.db.FailCommand("udid", "cmd", nil); err != nil {
+		t.Fatal(err)
+	}
+	failed, err := f.db.FailedCommands("udid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].CommandUUID != "cmd" || failed[0].RequestType != "EraseDevice" {
+		t.Errorf("failed commands = %+v", failed)
+	}
+	if got := queuedUUIDs(t, f.db, "udid"); len(got) != 0 {
+		t.Errorf("failed command is still queued: %v", got)
+	}
+}
+
+func TestMemoryPruneOnAck(t *testing.T) {
+	f := newMemoryFixture()
+	f.queue(t, "udid", "old", "DeviceInformation", 0, time.Time{})
+	f.queue(t, "udid", "failed", "DeviceInformation", 0, time.Time{})
+	if _, err := f.db.AcknowledgeCommand("udid", "old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.db.FailCommand("udid", "failed", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// acknowledging another command after the TTLs ran out
+	// forgets the old entries without them being read.
+	f.now = f.now.Add(failedCommandTTL*time.Second + time.Hour)
+	f.queue(t, "other", "new", "DeviceInformation", 0, time.Time{})
+	if _, err := f.db.AcknowledgeCommand("other", "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	for _, commandUUID := range []string{"old", "failed"} {
+		if _, ok := f.db.payloads[commandUUID]; ok {
+			t.Errorf("payload of %s was not pruned", commandUUID)
+		}
+	}
+	if _, ok := f.db.acknowledged["old"]; ok {
+		t.Error("acknowledgement was not pruned")
+	}
+	if _, ok := f.db.failed["udid"]; ok {
+		t.Error("failed commands were not pruned")
+	}
+	if _, ok := f.db.queues["udid"]; ok {
+		t.Error("empty queue was not pruned")
+	}
+	if _, ok := f.db.acknowledged["new"]; !ok {
+		t.Error("the new acknowledgement was pruned")
+	}
+}
@@ -0,0 +1,314 @@
+package command
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/groob/plist"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq" // postgres driver
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/dbpool"
+	"github.com/pkg/errors"
+)
+
+// pgStore is a Datastore for deployments which already run postgres and not redis.
+// Commands are ordered by priority and then by seq, which is renewed when a
+// command is sent so that it moves behind commands of the same priority.
+type pgStore struct {
+	*sqlx.DB
+}
+
+func newPostgresDB(conn string, logger kitlog.Logger, opts ...dbpool.Option) (Datastore, error) {
+	db, err := sqlx.Open("postgres", conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "command datastore")
+	}
+	dbpool.Configure(db.DB, opts...)
+	var dbError error
+	maxAttempts := 20
+	for attempts := 1; attempts <= maxAttempts; attempts++ {
+		dbError = db.Ping()
+		if dbError == nil {
+			break
+		}
+		logger.Log("msg", fmt.Sprintf("could not connect to postgres: %v", dbError))
+		time.Sleep(time.Duration(attempts) * time.Second)
+	}
+	if dbError != nil {
+		return nil, errors.Wrap(dbError, "command datastore")
+	}
+	return pgStore{DB: db}, nil
+}
+
+// a payload is kept for an hour after the command left the queue,
+// in case the device already received it.
+const payloadTTL = time.Hour
+
+func (store pgStore) SavePayload(payload *mdm.Payload) error {
+	var buf bytes.Buffer
+	if err := plist.NewEncoder(&buf).Encode(payload); err != nil {
+		return err
+	}
+	_, err := store.Exec(`INSERT INTO command_payloads (command_uuid, payload) VALUES ($1, $2)
+	ON CONFLICT (command_uuid) DO UPDATE SET payload = EXCLUDED.payload, expires_at = NULL`,
+		payload.CommandUUID, buf.Bytes())
+	return errors.Wrap(err, "pgStore SavePayload")
+}
+
+func (store pgStore) QueueCommand(deviceUDID, commandUUID string, priority int, notBefore time.Time) error {
+	var nb *time.Time
+	if !notBefore.IsZero() {
+		t := notBefore.UTC()
+		nb = &t
+	}
+	_, err := store.Exec(`INSERT INTO command_queue (udid, command_uuid, priority, queued_at, not_before, deferred)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (udid, command_uuid) DO NOTHING`,
+		deviceUDID, commandUUID, priority, time.Now().UTC(), nb, nb != nil)
+	return errors.Wrap(err, "pgStore QueueCommand")
+}
+
+func (store pgStore) ReleaseDeferred(now time.Time) ([]string, error) {
+	var udids []string
+	err := store.Select(&udids, `WITH released AS (
+		UPDATE command_queue SET deferred = false
+		WHERE deferred AND not_before <= $1
+		RETURNING udid
+	) SELECT DISTINCT udid FROM released`, now.UTC())
+	return udids, errors.Wrap(err, "pgStore ReleaseDeferred")
+}
+
+func (store pgStore) NextCommand(deviceUDID string) ([]byte, int, error) {
+	var commandUUID string
+	err := store.Get(&commandUUID, `UPDATE command_queue SET seq = nextval('command_queue_seq')
+	WHERE udid = $1 AND command_uuid = (
+		SELECT command_uuid FROM command_queue
+		WHERE udid = $1 AND (not_before IS NULL OR not_before <= $2)
+		ORDER BY priority DESC, seq
+		LIMIT 1
+	) RETURNING command_uuid`, deviceUDID, time.Now().UTC())
+	if err == sql.ErrNoRows {
+		// the queue is empty or all commands are deferred
+		return []byte{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "pgStore NextCommand")
+	}
+	payload, err := store.payload(commandUUID)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := store.QueueLength(deviceUDID)
+	return payload, total, err
+}
+
+func (store pgStore) payload(commandUUID string) ([]byte, error) {
+	var payload []byte
+	err := store.Get(&payload, `SELECT payload FROM command_payloads
+	WHERE command_uuid = $1 AND (expires_at IS NULL OR expires_at > $2)`, commandUUID, time.Now().UTC())
+	if err == sql.ErrNoRows {
+		return nil, ErrNoKey
+	}
+	return payload, errors.Wrap(err, "pgStore payload")
+}
+
+func (store pgStore) DeleteCommand(deviceUDID, commandUUID string) (int, error) {
+	if _, err := store.delete(deviceUDID, commandUUID); err != nil {
+		return 0, err
+	}
+	return store.QueueLength(deviceUDID)
+}
+
+// delete removes a command from the queue, starts the expiry of its payload
+// and reports whether the command was queued.
+func (store pgStore) delete(deviceUDID, commandUUID string) (bool, error) {
+	res, err := store.Exec(`DELETE FROM command_queue WHERE udid = $1 AND command_uuid = $2`, deviceUDID, commandUUID)
+	if err != nil {
+		return false, errors.Wrap(err, "pgStore delete")
+	}
+	_, err = store.Exec(`UPDATE command_payloads SET expires_at = $2 WHERE command_uuid = $1`,
+		commandUUID, time.Now().UTC().Add(payloadTTL))
+	if err != nil {
+		return false, errors.Wrap(err, "pgStore delete")
+	}
+	n, _ := res.RowsAffected()
+	return n != 0, nil
+}
+
+func (store pgStore) DeleteQueuedCommand(deviceUDID, commandUUID string) (int, error) {
+	queued, err := store.delete(deviceUDID, commandUUID)
+	if err != nil {
+		return 0, err
+	}
+	if !queued {
+		return 0, ErrNotQueued
+	}
+	return store.QueueLength(deviceUDID)
+}
+
+func (store pgStore) AcknowledgeCommand(deviceUDID, commandUUID string) (int, error) {
+	_, err := store.Exec(`INSERT INTO command_acknowledged (command_uuid, expires_at) VALUES ($1, $2)
+	ON CONFLICT (command_uuid) DO UPDATE SET expires_at = EXCLUDED.expires_at`,
+		commandUUID, time.Now().UTC().Add(payloadTTL))
+	if err != nil {
+		return 0, errors.Wrap(err, "pgStore AcknowledgeCommand")
+	}
+	return store.DeleteCommand(deviceUDID, commandUUID)
+}
+
+func (store pgStore) Acknowledged(commandUUID string) (bool, error) {
+	var acknowledged bool
+	err := store.Get(&acknowledged, `SELECT EXISTS (
+		SELECT 1 FROM command_acknowledged WHERE command_uuid = $1 AND expires_at > $2
+	)`, commandUUID, time.Now().UTC())
+	return acknowledged, errors.Wrap(err, "pgStore Acknowledged")
+}
+
+func (store pgStore) FailCommand(deviceUDID, commandUUID string, chain []mdm.ErrorChainItem) (int, error) {
+	var requestType string
+	if payload, err := store.Find(commandUUID); err == nil && payload.Command != nil {
+		requestType = payload.Command.RequestType
+	}
+	data, err := json.Marshal(chain)
+	if err != nil {
+		return 0, err
+	}
+	_, err = store.Exec(`INSERT INTO command_failures (udid, command_uuid, request_type, error_chain, failed_at)
+	VALUES ($1, $2, $3, $4, $5)`, deviceUDID, commandUUID, requestType, string(data), time.Now().UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "pgStore FailCommand")
+	}
+	return store.DeleteCommand(deviceUDID, commandUUID)
+}
+
+func (store pgStore) FailedCommands(deviceUDID string) ([]FailedCommand, error) {
+	var rows []struct {
+		CommandUUID string         `db:"command_uuid"`
+		RequestType string         `db:"request_type"`
+		ErrorChain  sql.NullString `db:"error_chain"`
+		FailedAt    time.Time      `db:"failed_at"`
+	}
+	err := store.Select(&rows, `SELECT command_uuid, request_type, error_chain, failed_at
+	FROM command_failures
+	WHERE udid = $1 AND failed_at > $2
+	ORDER BY failed_at DESC
+	LIMIT $3`, deviceUDID, time.Now().UTC().Add(-failedCommandTTL*time.Second), maxFailedCommands)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore FailedCommands")
+	}
+	var failed []FailedCommand
+	for _, row := range rows {
+		fc := FailedCommand{
+			CommandUUID: row.CommandUUID,
+			RequestType: row.RequestType,
+			Status:      StatusFailed,
+			FailedAt:    row.FailedAt,
+		}
+		if row.ErrorChain.Valid {
+			if err := json.Unmarshal([]byte(row.ErrorChain.String), &fc.ErrorChain); err != nil {
+				return nil, err
+			}
+		}
+		failed = append(failed, fc)
+	}
+	return failed, nil
+}
+
+// queuedRow is a command in the queue joined with its payload.
+type queuedRow struct {
+	CommandUUID string     `db:"command_uuid"`
+	Priority    int        `db:"priority"`
+	QueuedAt    time.Time  `db:"queued_at"`
+	NotBefore   *time.Time `db:"not_before"`
+	Payload     []byte     `db:"payload"`
+}
+
+func (store pgStore) queued(deviceUDID string) ([]queuedRow, error) {
+	var rows []queuedRow
+	err := store.Select(&rows, `SELECT q.command_uuid, q.priority, q.queued_at, q.not_before, p.payload
+	FROM command_queue q
+	LEFT JOIN command_payloads p ON p.command_uuid = q.command_uuid
+	WHERE q.udid = $1
+	ORDER BY q.priority DESC, q.seq`, deviceUDID)
+	return rows, errors.Wrap(err, "pgStore queued")
+}
+
+func (store pgStore) Commands(deviceUDID string) ([]mdm.Payload, error) {
+	rows, err := store.queued(deviceUDID)
+	if err != nil {
+		return nil, err
+	}
+	payloads := make([]mdm.Payload, len(rows))
+	for i, row := range rows {
+		if row.Payload == nil {
+			return nil, ErrNoKey
+		}
+		if err := plist.NewDecoder(bytes.NewReader(row.Payload)).Decode(&payloads[i]); err != nil {
+			return nil, err
+		}
+	}
+	return payloads, nil
+}
+
+func (store pgStore) ListQueued(deviceUDID string) ([]QueuedCommand, error) {
+	rows, err := store.queued(deviceUDID)
+	if err != nil {
+		return nil, err
+	}
+	queued := make([]QueuedCommand, 0, len(rows))
+	for _, row := range rows {
+		qc := QueuedCommand{
+			CommandUUID: row.CommandUUID,
+			Priority:    row.Priority,
+			QueuedAt:    row.QueuedAt,
+			NotBefore:   row.NotBefore,
+		}
+		if row.Payload != nil {
+			var payload mdm.Payload
+			if err := plist.NewDecoder(bytes.NewReader(row.Payload)).Decode(&payload); err != nil {
+				return nil, err
+			}
+			if payload.Command != nil {
+				qc.RequestType = payload.Command.RequestType
+			}
+		}
+		queued = append(queued, qc)
+	}
+	return queued, nil
+}
+
+func (store pgStore) Find(commandUUID string) (*mdm.Payload, error) {
+	data, err := store.payload(commandUUID)
+	if err != nil {
+		return nil, err
+	}
+	var payload *mdm.Payload
+	if err := plist.NewDecoder(bytes.NewReader(data)).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (store pgStore) QueueLength(deviceUDID string) (int, error) {
+	var total int
+	err := store.Get(&total, `SELECT count(*) FROM command_queue WHERE udid = $1`, deviceUDID)
+	return total, errors.Wrap(err, "pgStore QueueLength")
+}
+
+// Prune deletes the payloads, acknowledgements and failures which expired before now.
+func (store pgStore) Prune(now time.Time) error {
+	now = now.UTC()
+	if _, err := store.Exec(`DELETE FROM command_payloads WHERE expires_at <= $1`, now); err != nil {
+		return errors.Wrap(err, "pgStore Prune payloads")
+	}
+	if _, err := store.Exec(`DELETE FROM command_acknowledged WHERE expires_at <= $1`, now); err != nil {
+		return errors.Wrap(err, "pgStore Prune acknowledged")
+	}
+	_, err := store.Exec(`DELETE FROM command_failures WHERE failed_at <= $1`, now.Add(-failedCommandTTL*time.Second))
+	return errors.Wrap(err, "pgStore Prune failures")
+}
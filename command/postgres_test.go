@@ -0,0 +1,81 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestPostgresPrune(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := pgStore{DB: sqlx.NewDb(db, "mock")}
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectExec("DELETE FROM command_payloads WHERE expires_at").
+		WithArgs(now).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM command_acknowledged WHERE expires_at").
+		WithArgs(now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM command_failures WHERE failed_at").
+		WithArgs(now.Add(-failedCommandTTL * time.Second)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := store.Prune(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresDeleteQueuedCommand(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := pgStore{DB: sqlx.NewDb(db, "mock")}
+
+	mock.ExpectExec("DELETE FROM command_queue").
+		WithArgs("udid", "cmd").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE command_payloads SET expires_at").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if _, err := store.DeleteQueuedCommand("udid", "cmd"); err != ErrNotQueued {
+		t.Errorf("DeleteQueuedCommand = %v, want ErrNotQueued", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresNextCommandEmpty(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := pgStore{DB: sqlx.NewDb(db, "mock")}
+
+	mock.ExpectQuery("UPDATE command_queue SET seq").
+		WillReturnRows(sqlmock.NewRows([]string{"command_uuid"}))
+
+	payload, total, err := store.NextCommand("udid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) != 0 || total != 0 {
+		t.Errorf("NextCommand of an empty queue = %q, %d", payload, total)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
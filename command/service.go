@@ -1,28 +1,239 @@
 package command
 
-import "github.com/micromdm/mdm"
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/device"
+	"github.com/micromdm/micromdm/enroll"
+	"github.com/micromdm/micromdm/profile"
+)
+
+var (
+	// ErrEraseNotConfirmed is returned if an EraseDevice command is requested
+	// without a confirmation matching the serial number of the device
+	ErrEraseNotConfirmed = errors.New("EraseDevice requires a confirmation matching the device serial number")
+
+	// ErrNoUnlockToken is returned if a ClearPasscode command is requested for a device
+	// which never escrowed an UnlockToken during TokenUpdate
+	ErrNoUnlockToken = errors.New("no UnlockToken escrowed for device, ClearPasscode is not possible")
+
+	// ErrNotInLostMode is returned if a PlayLostModeSound command is requested for a device
+	// which is not in Lost Mode. The device would reject the command.
+	ErrNotInLostMode = errors.New("device is not in Lost Mode, PlayLostModeSound is not possible")
+)
+
+// PlatformError is returned if a command is requested for a device on a platform
+// which does not support it. The device would reject the command.
+type PlatformError struct {
+	RequestType string
+	// Platform of the device, empty if the device did not report its ProductName yet.
+	Platform  string
+	Supported []string
+}
+
+func (e PlatformError) Error() string {
+	platform := e.Platform
+	if platform == "" {
+		platform = "unknown"
+	}
+	return fmt.Sprintf("%s is only supported on %s devices, the device platform is %s",
+		e.RequestType, strings.Join(e.Supported, " and "), platform)
+}
+
+// commandPlatforms are the platforms which support a command.
+// Commands which are not listed are sent to every platform.
+var commandPlatforms = map[string][]string{
+	"EnableRemoteDesktop":  {device.PlatformMacOS},
+	"DisableRemoteDesktop": {device.PlatformMacOS},
+	"EnableLostMode":       {device.PlatformIOS},
+	"DisableLostMode":      {device.PlatformIOS},
+	"PlayLostModeSound":    {device.PlatformIOS},
+	"DeviceLocation":       {device.PlatformIOS},
+	"ClearPasscode":        {device.PlatformIOS},
+	"ApplyRedemptionCode":  {device.PlatformIOS},
+	"LogOutUser":           {device.PlatformIOS},
+}
+
+// StatusFailed is the status of a command the device responded to with an Error.
+const StatusFailed = "failed"
+
+// FailedCommand is a command which could not be executed by the device.
+type FailedCommand struct {
+	CommandUUID string               `json:"command_uuid"`
+	RequestType string               `json:"request_type,omitempty"`
+	Status      string               `json:"status"`
+	ErrorChain  []mdm.ErrorChainItem `json:"error_chain"`
+	FailedAt    time.Time            `json:"failed_at"`
+}
+
+// QueuedCommand is a command waiting to be sent to a device.
+type QueuedCommand struct {
+	CommandUUID string     `json:"command_uuid"`
+	RequestType string     `json:"request_type"`
+	Priority    int        `json:"priority,omitempty"`
+	QueuedAt    time.Time  `json:"queued_at"`
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+}
+
+// Option configures how a new command is queued.
+type Option func(*queueOptions)
+
+type queueOptions struct {
+	priority       int
+	notBefore      time.Time
+	profileName    string
+	profileVersion int
+	userID         string
+}
+
+// WithPriority queues the command ahead of commands with a lower priority.
+// Commands without a priority have priority 0 and are sent in the order they were queued.
+func WithPriority(priority int) Option {
+	return func(opts *queueOptions) {
+		opts.priority = priority
+	}
+}
+
+// NotBefore defers the command until t. The command stays in the queue,
+// but is not sent to the device before then.
+func NotBefore(t time.Time) Option {
+	return func(opts *queueOptions) {
+		opts.notBefore = t
+	}
+}
+
+// FromLibrary sets the payload of an InstallProfile command to a version of
+// a named profile in the profile library. A version of 0 uses the latest version.
+func FromLibrary(name string, version int) Option {
+	return func(opts *queueOptions) {
+		opts.profileName = name
+		opts.profileVersion = version
+	}
+}
+
+// ForUser queues the command for a user channel of the device. The request UDID
+// stays the UDID of the device, which is used to check the command, but the
+// command is queued under the UserID the device sends on the user channel.
+func ForUser(userID string) Option {
+	return func(opts *queueOptions) {
+		opts.userID = userID
+	}
+}
 
 // Service defines methods for managing MDM commands
 type Service interface {
-	NewCommand(*mdm.CommandRequest) (*mdm.Payload, error)
+	NewCommand(request *mdm.CommandRequest, opts ...Option) (*mdm.Payload, error)
 	NextCommand(udid string) ([]byte, int, error)
 	DeleteCommand(deviceUDID, commandUUID string) (int, error)
+	// DeleteQueuedCommand cancels a command which has not been acknowledged by the device.
+	// It returns ErrNotQueued if the command is not in the device queue.
+	DeleteQueuedCommand(deviceUDID, commandUUID string) (int, error)
+	// AcknowledgeCommand removes an acknowledged command from the device queue
+	AcknowledgeCommand(deviceUDID, commandUUID string) (int, error)
+	// Acknowledged returns true if the command was already acknowledged by the device
+	Acknowledged(commandUUID string) (bool, error)
+	// ReleaseDeferred returns the UDIDs of devices with deferred commands
+	// which became eligible before now
+	ReleaseDeferred(now time.Time) ([]string, error)
+	// QueueLength returns the number of commands queued for a device
+	QueueLength(deviceUDID string) (int, error)
+	// FailCommand removes a failed command from the device queue,
+	// recording the ErrorChain the device responded with
+	FailCommand(deviceUDID, commandUUID string, chain []mdm.ErrorChainItem) (int, error)
+	// FailedCommands returns the commands which failed on a device, most recent first
+	FailedCommands(deviceUDID string) ([]FailedCommand, error)
 	Commands(deviceUDID string) ([]mdm.Payload, error)
+	// ListQueued returns the commands waiting for a device, in the order
+	// they will be sent
+	ListQueued(deviceUDID string) ([]QueuedCommand, error)
 	Find(commandUUID string) (*mdm.Payload, error)
+
+	// EraseDevice queues an EraseDevice command. Because the command wipes the device,
+	// confirmation must equal the serial number of the device.
+	EraseDevice(request *mdm.CommandRequest, confirmation string, opts ...Option) (*mdm.Payload, error)
 }
 
-// NewService returns a new command service
-func NewService(ds Datastore) Service {
+// NewService returns a new command service.
+// enrollmentProfiles are the PayloadIdentifiers of the configured enrollment profiles,
+// which RemoveProfile must not remove. The generated profile is always included.
+func NewService(ds Datastore, devices device.Datastore, profiles profile.Datastore, enrollmentProfiles []string) Service {
+	enrollment := map[string]bool{enroll.ProfileIdentifier: true}
+	for _, identifier := range enrollmentProfiles {
+		enrollment[identifier] = true
+	}
 	return &service{
-		db: ds,
+		db:         ds,
+		devices:    devices,
+		profiles:   profiles,
+		enrollment: enrollment,
 	}
 }
 
 type service struct {
-	db Datastore
+	db       Datastore
+	devices  device.Datastore
+	profiles profile.Datastore
+
+	// enrollment holds the PayloadIdentifiers of the enrollment profiles.
+	enrollment map[string]bool
+}
+
+func (svc service) NewCommand(request *mdm.CommandRequest, opts ...Option) (*mdm.Payload, error) {
+	// destructive commands must go through their own confirmation path
+	if request.RequestType == "EraseDevice" {
+		return nil, ErrEraseNotConfirmed
+	}
+	return svc.newCommand(request, opts...)
 }
 
-func (svc service) NewCommand(request *mdm.CommandRequest) (*mdm.Payload, error) {
+func (svc service) newCommand(request *mdm.CommandRequest, opts ...Option) (*mdm.Payload, error) {
+	var options queueOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if request.RequestType == "InstallProfile" && options.profileName != "" {
+		p, err := svc.profiles.LibraryProfile(options.profileName, options.profileVersion)
+		if err != nil {
+			return nil, err
+		}
+		request.InstallProfile.Payload = p.Data
+	}
+	if err := validate(request); err != nil {
+		return nil, err
+	}
+	if request.RequestType == "RemoveProfile" && svc.enrollment[request.RemoveProfile.Identifier] {
+		return nil, ErrRemoveEnrollmentProfile
+	}
+	queueID := request.UDID
+	if options.userID != "" {
+		if _, err := svc.devices.User(request.UDID, options.userID); err != nil {
+			return nil, err
+		}
+		queueID = options.userID
+	}
+	if err := svc.checkPlatform(request); err != nil {
+		return nil, err
+	}
+	switch request.RequestType {
+	case "ClearPasscode":
+		if err := svc.addUnlockToken(request); err != nil {
+			return nil, err
+		}
+	case "PlayLostModeSound":
+		if err := svc.checkLostMode(request.UDID); err != nil {
+			return nil, err
+		}
+	case "DeviceLock":
+		if err := svc.checkLockPIN(request); err != nil {
+			return nil, err
+		}
+	}
 	// create a payload
 	payload, err := mdm.NewPayload(request)
 	if err != nil {
@@ -34,7 +245,7 @@ func (svc service) NewCommand(request *mdm.CommandRequest) (*mdm.Payload, error)
 		return nil, err
 	}
 	// add command to a queue in redis
-	err = svc.db.QueueCommand(request.UDID, payload.CommandUUID)
+	err = svc.db.QueueCommand(queueID, payload.CommandUUID, options.priority, options.notBefore)
 	if err != nil {
 		return nil, err
 	}
@@ -42,6 +253,98 @@ func (svc service) NewCommand(request *mdm.CommandRequest) (*mdm.Payload, error)
 	return payload, nil
 }
 
+func (svc service) EraseDevice(request *mdm.CommandRequest, confirmation string, opts ...Option) (*mdm.Payload, error) {
+	request.RequestType = "EraseDevice"
+	dev, err := svc.getDevice(request.UDID, "serial_number")
+	if err != nil {
+		return nil, err
+	}
+	if confirmation == "" || !dev.SerialNumber.Valid || device.NormalizeSerial(confirmation) != dev.SerialNumber.String {
+		return nil, ErrEraseNotConfirmed
+	}
+	return svc.newCommand(request, opts...)
+}
+
+// addUnlockToken sets the UnlockToken the device sent in its TokenUpdate message
+// on a ClearPasscode request.
+func (svc service) addUnlockToken(request *mdm.CommandRequest) error {
+	// unlock_token is NULL until the first TokenUpdate
+	dev, err := svc.getDevice(request.UDID, "COALESCE(unlock_token, '') AS unlock_token")
+	if err != nil {
+		return err
+	}
+	if dev.UnlockToken == "" {
+		return ErrNoUnlockToken
+	}
+	// the token is stored hex encoded by the checkin service
+	token, err := hex.DecodeString(dev.UnlockToken)
+	if err != nil {
+		return fmt.Errorf("decoding UnlockToken for device %s: %s", request.UDID, err)
+	}
+	request.ClearPasscode.UnlockToken = token
+	return nil
+}
+
+// checkLostMode returns ErrNotInLostMode unless the device acknowledged EnableLostMode.
+func (svc service) checkLostMode(udid string) error {
+	dev, err := svc.getDevice(udid, "lost_mode")
+	if err != nil {
+		return err
+	}
+	if !dev.LostMode {
+		return ErrNotInLostMode
+	}
+	return nil
+}
+
+// checkLockPIN returns ErrInvalidPIN if a DeviceLock without a PIN is sent to a device
+// which is not known to be an iOS device. A Mac cannot be unlocked without the PIN.
+func (svc service) checkLockPIN(request *mdm.CommandRequest) error {
+	if request.DeviceLock.PIN != "" {
+		return nil
+	}
+	dev, err := svc.getDevice(request.UDID, "platform")
+	if err != nil {
+		return err
+	}
+	if dev.Platform != device.PlatformIOS {
+		return ErrInvalidPIN
+	}
+	return nil
+}
+
+// getDevice returns the device a command is sent to,
+// or device.ErrNotFound if there is no device with the UDID.
+func (svc service) getDevice(udid string, fields ...string) (*device.Device, error) {
+	dev, err := svc.devices.GetDeviceByUDID(udid, fields...)
+	if err == sql.ErrNoRows {
+		return nil, device.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("retrieving device by UDID: %s", err)
+	}
+	return dev, nil
+}
+
+// checkPlatform returns a PlatformError if the command is not supported on the platform of the device.
+// A device which did not report its ProductName yet is not known to support a platform specific command.
+func (svc service) checkPlatform(request *mdm.CommandRequest) error {
+	supported, ok := commandPlatforms[request.RequestType]
+	if !ok {
+		return nil
+	}
+	dev, err := svc.getDevice(request.UDID, "platform")
+	if err != nil {
+		return err
+	}
+	for _, platform := range supported {
+		if dev.Platform == platform {
+			return nil
+		}
+	}
+	return PlatformError{RequestType: request.RequestType, Platform: dev.Platform, Supported: supported}
+}
+
 // NextCommand returns an MDM Payload from a list of queued payloads
 func (svc service) NextCommand(udid string) ([]byte, int, error) {
 	return svc.db.NextCommand(udid)
@@ -52,10 +355,42 @@ func (svc service) DeleteCommand(deviceUDID, commandUUID string) (int, error) {
 	return svc.db.DeleteCommand(deviceUDID, commandUUID)
 }
 
+func (svc service) DeleteQueuedCommand(deviceUDID, commandUUID string) (int, error) {
+	return svc.db.DeleteQueuedCommand(deviceUDID, commandUUID)
+}
+
+func (svc service) AcknowledgeCommand(deviceUDID, commandUUID string) (int, error) {
+	return svc.db.AcknowledgeCommand(deviceUDID, commandUUID)
+}
+
+func (svc service) Acknowledged(commandUUID string) (bool, error) {
+	return svc.db.Acknowledged(commandUUID)
+}
+
+func (svc service) ReleaseDeferred(now time.Time) ([]string, error) {
+	return svc.db.ReleaseDeferred(now)
+}
+
+func (svc service) QueueLength(deviceUDID string) (int, error) {
+	return svc.db.QueueLength(deviceUDID)
+}
+
+func (svc service) FailCommand(deviceUDID, commandUUID string, chain []mdm.ErrorChainItem) (int, error) {
+	return svc.db.FailCommand(deviceUDID, commandUUID, chain)
+}
+
+func (svc service) FailedCommands(deviceUDID string) ([]FailedCommand, error) {
+	return svc.db.FailedCommands(deviceUDID)
+}
+
 func (svc service) Commands(deviceUDID string) ([]mdm.Payload, error) {
 	return svc.db.Commands(deviceUDID)
 }
 
+func (svc service) ListQueued(deviceUDID string) ([]QueuedCommand, error) {
+	return svc.db.ListQueued(deviceUDID)
+}
+
 func (svc service) Find(commandUUID string) (*mdm.Payload, error) {
 	return svc.db.Find(commandUUID)
 }
@@ -0,0 +1,201 @@
+package command
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/device"
+)
+
+// deviceStore is a device.Datastore with the device and user channels in memory.
+type deviceStore struct {
+	device.Datastore
+	devices map[string]*device.Device
+	users   map[string]*device.User
+}
+
+func (ds deviceStore) GetDeviceByUDID(udid string, fields ...string) (*device.Device, error) {
+	dev, ok := ds.devices[udid]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return dev, nil
+}
+
+func (ds deviceStore) User(deviceUDID, userID string) (*device.User, error) {
+	u, ok := ds.users[deviceUDID+"/"+userID]
+	if !ok {
+		return nil, device.ErrUserNotFound
+	}
+	return u, nil
+}
+
+func TestNewCommandForUser(t *testing.T) {
+	devices := deviceStore{
+		devices: map[string]*device.Device{"device-udid": {LostMode: true, Platform: "ios"}},
+		users:   map[string]*device.User{"device-udid/user-id": {DeviceUDID: "device-udid", UserID: "user-id"}},
+	}
+	db := NewMemoryDB()
+	svc := NewService(db, devices, nil, nil)
+
+	request := &mdm.CommandRequest{UDID: "device-udid", RequestType: "PlayLostModeSound"}
+	if _, err := svc.NewCommand(request, ForUser("user-id")); err != nil {
+		t.Fatalf("queueing a command for a user channel: %v", err)
+	}
+	if n, _ := db.QueueLength("user-id"); n != 1 {
+		t.Errorf("user channel queue length = %d, want 1", n)
+	}
+	if n, _ := db.QueueLength("device-udid"); n != 0 {
+		t.Errorf("device queue length = %d, want 0", n)
+	}
+
+	request = &mdm.CommandRequest{UDID: "device-udid", RequestType: "PlayLostModeSound"}
+	if _, err := svc.NewCommand(request, ForUser("unknown")); err != device.ErrUserNotFound {
+		t.Errorf("unknown user channel = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestCheckPlatform(t *testing.T) {
+	var tests = []struct {
+		productName string
+		requestType string
+		platform    string
+		supported   bool
+	}{
+		{"iPhone9,3", "EnableLostMode", device.PlatformIOS, true},
+		{"iPad6,11", "ClearPasscode", device.PlatformIOS, true},
+		{"iPod7,1", "DeviceLocation", device.PlatformIOS, true},
+		{"MacBookPro14,1", "EnableRemoteDesktop", device.PlatformMacOS, true},
+		{"iMac18,3", "DisableRemoteDesktop", device.PlatformMacOS, true},
+		{"Macmini8,1", "EnableLostMode", device.PlatformMacOS, false},
+		{"VirtualMac2,1", "LogOutUser", device.PlatformMacOS, false},
+		{"AppleTV5,3", "PlayLostModeSound", device.PlatformTVOS, false},
+		{"iPhone9,3", "EnableRemoteDesktop", device.PlatformIOS, false},
+		{"Watch3,4", "EnableLostMode", "", false},
+		// commands which are not platform specific are sent to any device.
+		{"Watch3,4", "DeviceInformation", "", true},
+		{"AppleTV5,3", "RestartDevice", device.PlatformTVOS, true},
+	}
+	for _, tt := range tests {
+		platform := device.PlatformFromProductName(tt.productName)
+		if platform != tt.platform {
+			t.Errorf("%s: platform = %q, want %q", tt.productName, platform, tt.platform)
+		}
+		devices := deviceStore{devices: map[string]*device.Device{"udid": {Platform: platform}}}
+		svc := service{db: NewMemoryDB(), devices: devices}
+		err := svc.checkPlatform(&mdm.CommandRequest{UDID: "udid", RequestType: tt.requestType})
+		if tt.supported && err != nil {
+			t.Errorf("%s on %s: %v", tt.requestType, tt.productName, err)
+		}
+		if _, ok := err.(PlatformError); !tt.supported && !ok {
+			t.Errorf("%s on %s: expected a PlatformError, got %v", tt.requestType, tt.productName, err)
+		}
+	}
+}
+
+func TestCheckPlatformUnknownDevice(t *testing.T) {
+	svc := service{db: NewMemoryDB(), devices: deviceStore{}}
+	err := svc.checkPlatform(&mdm.CommandRequest{UDID: "unknown", RequestType: "EnableLostMode"})
+	if err != device.ErrNotFound {
+		t.Errorf("expected device.ErrNotFound, got %v", err)
+	}
+}
+
+func TestDeviceLockPIN(t *testing.T) {
+	devices := deviceStore{devices: map[string]*device.Device{
+		"iphone": {Platform: device.PlatformIOS},
+		"mac":    {Platform: device.PlatformMacOS},
+		"new":    {},
+	}}
+	svc := service{db: NewMemoryDB(), devices: devices}
+	var tests = []struct {
+		udid string
+		pin  string
+		err  error
+	}{
+		{"iphone", "", nil},
+		{"iphone", "123456", nil},
+		{"mac", "123456", nil},
+		{"mac", "", ErrInvalidPIN},
+		{"mac", "12345", ErrInvalidPIN},
+		{"mac", "12345a", ErrInvalidPIN},
+		// the platform of a device which did not report its ProductName is not known.
+		{"new", "", ErrInvalidPIN},
+		{"unknown", "", device.ErrNotFound},
+	}
+	for _, tt := range tests {
+		request := &mdm.CommandRequest{UDID: tt.udid, RequestType: "DeviceLock"}
+		request.DeviceLock.PIN = tt.pin
+		if _, err := svc.NewCommand(request); err != tt.err {
+			t.Errorf("%s with PIN %q: got %v, want %v", tt.udid, tt.pin, err, tt.err)
+		}
+	}
+}
+
+func TestCheckLostMode(t *testing.T) {
+	devices := deviceStore{devices: map[string]*device.Device{
+		"lost":  {LostMode: true},
+		"found": {},
+	}}
+	svc := service{db: NewMemoryDB(), devices: devices}
+	var tests = []struct {
+		udid string
+		err  error
+	}{
+		{"lost", nil},
+		{"found", ErrNotInLostMode},
+		{"unknown", device.ErrNotFound},
+	}
+	for _, tt := range tests {
+		if err := svc.checkLostMode(tt.udid); err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.udid, err, tt.err)
+		}
+	}
+}
+
+func TestRemoteDesktopOnlyOnMacs(t *testing.T) {
+	devices := deviceStore{devices: map[string]*device.Device{
+		"mac":    {Platform: device.PlatformFromProductName("MacBookPro14,1")},
+		"iphone": {Platform: device.PlatformFromProductName("iPhone9,3")},
+	}}
+	db := NewMemoryDB()
+	svc := NewService(db, devices, nil, nil)
+
+	for _, requestType := range []string{"EnableRemoteDesktop", "DisableRemoteDesktop"} {
+		if _, err := svc.NewCommand(&mdm.CommandRequest{UDID: "mac", RequestType: requestType}); err != nil {
+			t.Errorf("%s on a Mac: %v", requestType, err)
+		}
+		_, err := svc.NewCommand(&mdm.CommandRequest{UDID: "iphone", RequestType: requestType})
+		if _, ok := err.(PlatformError); !ok {
+			t.Errorf("%s on an iPhone: expected a PlatformError, got %v", requestType, err)
+		}
+	}
+	if n, _ := db.QueueLength("mac"); n != 2 {
+		t.Errorf("expected 2 commands queued for the Mac, got %d", n)
+	}
+	if n, _ := db.QueueLength("iphone"); n != 0 {
+		t.Errorf("expected no commands queued for the iPhone, got %d", n)
+	}
+}
+
+func TestRemoveEnrollmentProfile(t *testing.T) {
+	devices := deviceStore{devices: map[string]*device.Device{"device-udid": {Platform: "ios"}}}
+	svc := NewService(NewMemoryDB(), devices, nil, []string{"com.example.enroll"})
+
+	var tests = []struct {
+		identifier string
+		err        error
+	}{
+		{identifier: "com.github.micromdm.micromdm.mdm", err: ErrRemoveEnrollmentProfile},
+		{identifier: "com.example.enroll", err: ErrRemoveEnrollmentProfile},
+		{identifier: "com.example.wifi", err: nil},
+	}
+	for _, tt := range tests {
+		request := &mdm.CommandRequest{UDID: "device-udid", RequestType: "RemoveProfile"}
+		request.RemoveProfile.Identifier = tt.identifier
+		if _, err := svc.NewCommand(request); err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.identifier, err, tt.err)
+		}
+	}
+}
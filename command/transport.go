@@ -7,6 +7,9 @@ import (
 	kitlog "github.com/go-kit/kit/log"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/device"
+	"github.com/micromdm/micromdm/profile"
 	"golang.org/x/net/context"
 )
 
@@ -45,20 +48,39 @@ func ServiceHandler(ctx context.Context, svc Service, logger kitlog.Logger) http
 		encodeResponse,
 		opts...,
 	)
+	listQueuedHandler := kithttp.NewServer(
+		ctx,
+		makeListQueuedEndpoint(svc),
+		decodeListQueuedRequest,
+		encodeResponse,
+		opts...,
+	)
+	cancelCommandHandler := kithttp.NewServer(
+		ctx,
+		makeCancelCommandEndpoint(svc),
+		decodeCancelCommandRequest,
+		encodeResponse,
+		opts...,
+	)
 
 	r := mux.NewRouter()
 
 	r.Handle("/mdm/commands/{udid}", getCommandsHandler).Methods("GET")
 	r.Handle("/mdm/commands", newCommandHandler).Methods("POST")
+	r.Handle("/mdm/commands", listQueuedHandler).Methods("GET")
 	r.Handle("/mdm/commands/{udid}/next", nextCommandHandler).Methods("GET")
 	r.Handle("/mdm/commands/{udid}/{uuid}", deleteCommandHandler).Methods("DELETE")
+	r.Handle("/mdm/commands/{uuid}", cancelCommandHandler).Methods("DELETE")
 
 	return r
 }
 
 func decodeNewCommandRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var request newCommandRequest
-	err := json.NewDecoder(r.Body).Decode(&request.CommandRequest)
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if request.CommandRequest == nil {
+		request.CommandRequest = &mdm.CommandRequest{}
+	}
 	return request, err
 }
 
@@ -100,6 +122,19 @@ func decodeGetCommandsRequest(_ context.Context, r *http.Request) (interface{},
 	return request, nil
 }
 
+func decodeListQueuedRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return listQueuedRequest{UDID: r.URL.Query().Get("udid")}, nil
+}
+
+func decodeCancelCommandRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	uuid, ok := vars["uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+	return cancelCommandRequest{UUID: uuid, UDID: r.URL.Query().Get("udid")}, nil
+}
+
 type errorer interface {
 	error() error
 }
@@ -142,6 +177,15 @@ func encodeError(_ context.Context, err error, w http.ResponseWriter) {
 	}
 
 	switch err {
+	case ErrEmptyRequest, ErrInvalidPIN, ErrEraseNotConfirmed, ErrNoUnlockToken, ErrInvalidAppSource, ErrMissingIdentifier,
+		ErrInvalidInstallAction, ErrMissingLostModeMessage, ErrNotInLostMode,
+		ErrMissingProfileIdentifier, ErrRemoveEnrollmentProfile, ErrMissingUserName,
+		ErrMissingStoreID, ErrMissingRedemptionCode, ErrInvalidSettings,
+		ErrInvalidWallpaper, ErrMissingAppConfigIdentifier,
+		profile.ErrInvalidProfile, profile.ErrNotConfiguration:
+		w.WriteHeader(http.StatusBadRequest)
+	case ErrNotQueued, profile.ErrLibraryProfileNotFound, device.ErrUserNotFound, device.ErrNotFound:
+		w.WriteHeader(http.StatusNotFound)
 	// case ErrNotFound:
 	// 	w.WriteHeader(http.StatusNotFound)
 	// case errEmptyRequest, errBadUUID:
@@ -149,6 +193,18 @@ func encodeError(_ context.Context, err error, w http.ResponseWriter) {
 	// case workflow.ErrExists:
 	// 	w.WriteHeader(http.StatusConflict)
 	default:
+		if _, ok := err.(InvalidQueryError); ok {
+			w.WriteHeader(http.StatusBadRequest)
+			break
+		}
+		if _, ok := err.(InvalidSettingError); ok {
+			w.WriteHeader(http.StatusBadRequest)
+			break
+		}
+		if _, ok := err.(PlatformError); ok {
+			w.WriteHeader(http.StatusBadRequest)
+			break
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
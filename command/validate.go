@@ -0,0 +1,296 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/application"
+	"github.com/micromdm/micromdm/profile"
+)
+
+var (
+	// ErrInvalidPIN is returned if a DeviceLock or EraseDevice PIN is not a six digit number,
+	// or if a DeviceLock without a PIN is sent to a device which is not an iOS device
+	ErrInvalidPIN = errors.New("PIN must be exactly six digits")
+
+	// ErrInvalidAppSource is returned if an InstallApplication request does not specify
+	// exactly one of iTunesStoreID or ManifestURL
+	ErrInvalidAppSource = errors.New("InstallApplication requires exactly one of iTunesStoreID or ManifestURL")
+
+	// ErrMissingIdentifier is returned if a RemoveApplication request has no bundle Identifier
+	ErrMissingIdentifier = errors.New("RemoveApplication requires an Identifier")
+
+	// ErrMissingProfileIdentifier is returned if a RemoveProfile request has no PayloadIdentifier
+	ErrMissingProfileIdentifier = errors.New("RemoveProfile requires an Identifier")
+
+	// ErrRemoveEnrollmentProfile is returned if a RemoveProfile request targets one of the
+	// MDM enrollment profiles. Removing it would unenroll the device.
+	ErrRemoveEnrollmentProfile = errors.New("RemoveProfile cannot remove the MDM enrollment profile, the device would be unenrolled")
+
+	// ErrMissingLostModeMessage is returned if an EnableLostMode request has
+	// neither a Message nor a PhoneNumber to show on the lock screen
+	ErrMissingLostModeMessage = errors.New("EnableLostMode requires a Message or a PhoneNumber")
+
+	// ErrInvalidInstallAction is returned if a ScheduleOSUpdate request has no updates
+	// or an update with an unknown InstallAction
+	ErrInvalidInstallAction = errors.New("ScheduleOSUpdate updates require a valid InstallAction")
+
+	// ErrMissingStoreID is returned if an InstallApplication request for a device based
+	// VPP app has no iTunesStoreID
+	ErrMissingStoreID = errors.New("InstallApplication with the VPP PurchaseMethod requires an iTunesStoreID")
+
+	// ErrMissingRedemptionCode is returned if an ApplyRedemptionCode request
+	// does not specify both the app Identifier and the RedemptionCode
+	ErrMissingRedemptionCode = errors.New("ApplyRedemptionCode requires an Identifier and a RedemptionCode")
+
+	// ErrMissingUserName is returned if a DeleteUser request does not name the user to delete
+	ErrMissingUserName = errors.New("DeleteUser requires a UserName")
+
+	// ErrInvalidSettings is returned if a Settings request has no settings,
+	// or a DeviceName setting without a name
+	ErrInvalidSettings = errors.New("Settings requires at least one setting, DeviceName requires a device_name")
+
+	// ErrInvalidWallpaper is returned if a Wallpaper setting has no Image,
+	// or a Where other than the lock screen (1), home screen (2) or both (3)
+	ErrInvalidWallpaper = errors.New("Wallpaper requires an image and a where of 1, 2 or 3")
+
+	// ErrMissingAppConfigIdentifier is returned if an ApplicationConfiguration setting
+	// does not name the managed app to configure
+	ErrMissingAppConfigIdentifier = errors.New("ApplicationConfiguration requires an identifier")
+)
+
+// InvalidQueryError is returned if a DeviceInformation request asks for
+// a query key which is not part of the MDM protocol.
+type InvalidQueryError struct {
+	Key string
+}
+
+func (e InvalidQueryError) Error() string {
+	return fmt.Sprintf("unknown DeviceInformation query %q", e.Key)
+}
+
+// InvalidSettingError is returned if a Settings request has an item
+// which is not part of the MDM protocol.
+type InvalidSettingError struct {
+	Item string
+}
+
+func (e InvalidSettingError) Error() string {
+	return fmt.Sprintf("unknown Settings item %q", e.Item)
+}
+
+// settingsItems are the Item keys a Settings command may change.
+var settingsItems = map[string]bool{
+	"DeviceName":               true,
+	"HostName":                 true,
+	"Wallpaper":                true,
+	"ApplicationConfiguration": true,
+	"VoiceRoaming":             true,
+	"DataRoaming":              true,
+	"PersonalHotspot":          true,
+	"Bluetooth":                true,
+}
+
+// deviceInformationQueries are the keys a DeviceInformation command may query.
+var deviceInformationQueries = map[string]bool{
+	// general
+	"UDID":                       true,
+	"Languages":                  true,
+	"Locales":                    true,
+	"DeviceID":                   true,
+	"OrganizationInfo":           true,
+	"LastCloudBackupDate":        true,
+	"AwaitingConfiguration":      true,
+	"MDMOptions":                 true,
+	"iTunesStoreAccountIsActive": true,
+	"iTunesStoreAccountHash":     true,
+	// device
+	"DeviceName":                       true,
+	"OSVersion":                        true,
+	"BuildVersion":                     true,
+	"ModelName":                        true,
+	"Model":                            true,
+	"ProductName":                      true,
+	"SerialNumber":                     true,
+	"DeviceCapacity":                   true,
+	"AvailableDeviceCapacity":          true,
+	"BatteryLevel":                     true,
+	"CellularTechnology":               true,
+	"IMEI":                             true,
+	"MEID":                             true,
+	"ModemFirmwareVersion":             true,
+	"IsSupervised":                     true,
+	"IsDeviceLocatorServiceEnabled":    true,
+	"IsActivationLockEnabled":          true,
+	"IsDoNotDisturbInEffect":           true,
+	"EASDeviceIdentifier":              true,
+	"IsCloudBackupEnabled":             true,
+	"OSUpdateSettings":                 true,
+	"LocalHostName":                    true,
+	"HostName":                         true,
+	"SystemIntegrityProtectionEnabled": true,
+	"ActiveManagedUsers":               true,
+	"IsMDMLostModeEnabled":             true,
+	"MaximumResidentUsers":             true,
+	"IsMultiUser":                      true,
+	"ServiceSubscriptions":             true,
+	// network
+	"ICCID":                    true,
+	"BluetoothMAC":             true,
+	"WiFiMAC":                  true,
+	"EthernetMACs":             true,
+	"CurrentCarrierNetwork":    true,
+	"SIMCarrierNetwork":        true,
+	"SubscriberCarrierNetwork": true,
+	"CarrierSettingsVersion":   true,
+	"PhoneNumber":              true,
+	"VoiceRoamingEnabled":      true,
+	"DataRoamingEnabled":       true,
+	"IsRoaming":                true,
+	"PersonalHotspotEnabled":   true,
+	"SubscriberMCC":            true,
+	"SubscriberMNC":            true,
+	"CurrentMCC":               true,
+	"CurrentMNC":               true,
+}
+
+// installActions are the InstallAction values accepted by ScheduleOSUpdate.
+var installActions = map[string]bool{
+	"Default":      true,
+	"DownloadOnly": true,
+	"InstallASAP":  true,
+	"NotifyOnly":   true,
+	"InstallLater": true,
+}
+
+// validate checks the command specific fields of a request
+// before a payload is created and queued.
+func validate(request *mdm.CommandRequest) error {
+	switch request.RequestType {
+	case "DeviceInformation":
+		// without Queries the full inventory is requested.
+		for _, key := range request.DeviceInformation.Queries {
+			if !deviceInformationQueries[key] {
+				return InvalidQueryError{Key: key}
+			}
+		}
+	case "RestartDevice", "ShutDownDevice":
+		// no fields, supervised devices only.
+		// unsupervised devices respond with an Error status.
+	case "DeviceLock":
+		// iOS devices lock without a PIN, but macOS requires one. NewCommand checks the platform.
+		if request.DeviceLock.PIN != "" && !isSixDigits(request.DeviceLock.PIN) {
+			return ErrInvalidPIN
+		}
+	case "EraseDevice":
+		// the PIN is only used by macOS to set a firmware lock after the erase.
+		if request.EraseDevice.PIN != "" && !isSixDigits(request.EraseDevice.PIN) {
+			return ErrInvalidPIN
+		}
+	case "InstallApplication":
+		// store apps are installed by iTunesStoreID, enterprise apps from a manifest.
+		hasStoreID := request.InstallApplication.ITunesStoreID != 0
+		hasManifest := request.InstallApplication.ManifestURL != ""
+		if hasStoreID == hasManifest {
+			return ErrInvalidAppSource
+		}
+		// device based VPP apps are installed without a redemption code,
+		// the license is assigned to the device through the VPP service.
+		options := request.InstallApplication.Options
+		if options != nil && options.PurchaseMethod == application.PurchaseMethodVPP && !hasStoreID {
+			return ErrMissingStoreID
+		}
+	case "ApplyRedemptionCode":
+		// legacy VPP apps are redeemed with a code after the device reports NeedsRedemption.
+		if request.ApplyRedemptionCode.Identifier == "" || request.ApplyRedemptionCode.RedemptionCode == "" {
+			return ErrMissingRedemptionCode
+		}
+	case "RemoveApplication":
+		if request.RemoveApplication.Identifier == "" {
+			return ErrMissingIdentifier
+		}
+	case "ManagedApplicationList":
+		// without Identifiers every managed app is listed.
+	case "InstallProfile":
+		if _, err := profile.ParseConfiguration(request.InstallProfile.Payload); err != nil {
+			return err
+		}
+	case "RemoveProfile":
+		// the enrollment profiles are rejected by the service, which knows the configured identifiers.
+		if request.RemoveProfile.Identifier == "" {
+			return ErrMissingProfileIdentifier
+		}
+	case "EnableLostMode":
+		if request.EnableLostMode.Message == "" && request.EnableLostMode.PhoneNumber == "" {
+			return ErrMissingLostModeMessage
+		}
+	case "ScheduleOSUpdate":
+		if len(request.ScheduleOSUpdate.Updates) == 0 {
+			return ErrInvalidInstallAction
+		}
+		for _, update := range request.ScheduleOSUpdate.Updates {
+			if !installActions[update.InstallAction] {
+				return ErrInvalidInstallAction
+			}
+		}
+	case "Settings":
+		if len(request.Settings.Settings) == 0 {
+			return ErrInvalidSettings
+		}
+		for _, setting := range request.Settings.Settings {
+			if err := validateSetting(setting); err != nil {
+				return err
+			}
+		}
+	case "ActivationLockBypassCode":
+		// no fields, supervised devices only.
+		// the code is only available until the device is erased or Activation Lock is disabled.
+	case "EnableRemoteDesktop", "DisableRemoteDesktop":
+		// no fields, macOS 10.14.4 and later. NewCommand checks the platform of the device.
+	case "UserList", "LogOutUser":
+		// no fields, shared iPads only.
+	case "DeleteUser":
+		// ForceDeletion deletes the user even if it has data which is not synced yet.
+		if request.DeleteUser.UserName == "" {
+			return ErrMissingUserName
+		}
+	}
+	return nil
+}
+
+// validateSetting checks the fields of a single Settings item.
+func validateSetting(setting mdm.Setting) error {
+	if !settingsItems[setting.Item] {
+		return InvalidSettingError{Item: setting.Item}
+	}
+	switch setting.Item {
+	case "DeviceName":
+		if setting.DeviceName == nil || *setting.DeviceName == "" {
+			return ErrInvalidSettings
+		}
+	case "Wallpaper":
+		// the image is sent base64 encoded in JSON, and as data in the plist.
+		if len(setting.Image) == 0 || setting.Where == nil || *setting.Where < 1 || *setting.Where > 3 {
+			return ErrInvalidWallpaper
+		}
+	case "ApplicationConfiguration":
+		// a missing Configuration removes the managed app configuration.
+		if setting.Identifier == nil || *setting.Identifier == "" {
+			return ErrMissingAppConfigIdentifier
+		}
+	}
+	return nil
+}
+
+func isSixDigits(pin string) bool {
+	if len(pin) != 6 {
+		return false
+	}
+	for _, c := range pin {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/micromdm/mdm"
+)
+
+func TestValidateSetting(t *testing.T) {
+	name, empty, bundleID := "iPad", "", "com.example.app"
+	lockScreen, tooLarge := 1, 4
+	var tests = []struct {
+		name    string
+		setting mdm.Setting
+		err     error
+	}{
+		{"device name", mdm.Setting{Item: "DeviceName", DeviceName: &name}, nil},
+		{"missing device name", mdm.Setting{Item: "DeviceName"}, ErrInvalidSettings},
+		{"empty device name", mdm.Setting{Item: "DeviceName", DeviceName: &empty}, ErrInvalidSettings},
+		{"wallpaper", mdm.Setting{Item: "Wallpaper", Image: []byte{0x89}, Where: &lockScreen}, nil},
+		{"wallpaper without image", mdm.Setting{Item: "Wallpaper", Where: &lockScreen}, ErrInvalidWallpaper},
+		{"wallpaper without where", mdm.Setting{Item: "Wallpaper", Image: []byte{0x89}}, ErrInvalidWallpaper},
+		{"wallpaper where out of range", mdm.Setting{Item: "Wallpaper", Image: []byte{0x89}, Where: &tooLarge}, ErrInvalidWallpaper},
+		{"app configuration", mdm.Setting{Item: "ApplicationConfiguration", Identifier: &bundleID}, nil},
+		{"app configuration without identifier", mdm.Setting{Item: "ApplicationConfiguration"}, ErrMissingAppConfigIdentifier},
+		{"app configuration with empty identifier", mdm.Setting{Item: "ApplicationConfiguration", Identifier: &empty}, ErrMissingAppConfigIdentifier},
+		{"unknown item", mdm.Setting{Item: "Brightness"}, InvalidSettingError{Item: "Brightness"}},
+		{"missing item", mdm.Setting{}, InvalidSettingError{}},
+	}
+	for _, tt := range tests {
+		if err := validateSetting(tt.setting); err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	name := "iPad"
+	request := &mdm.CommandRequest{RequestType: "Settings"}
+	if err := validate(request); err != ErrInvalidSettings {
+		t.Errorf("Settings without items: got %v, want %v", err, ErrInvalidSettings)
+	}
+
+	// a single invalid item rejects the whole command.
+	request.Settings.Settings = []mdm.Setting{
+		{Item: "DeviceName", DeviceName: &name},
+		{Item: "Wallpaper"},
+	}
+	if err := validate(request); err != ErrInvalidWallpaper {
+		t.Errorf("Settings with an invalid item: got %v, want %v", err, ErrInvalidWallpaper)
+	}
+
+	// every item the MDM protocol knows is accepted.
+	for item := range settingsItems {
+		if err := validateSetting(mdm.Setting{Item: item}); err != nil {
+			if _, ok := err.(InvalidSettingError); ok {
+				t.Errorf("%s: rejected as unknown item", item)
+			}
+		}
+	}
+}
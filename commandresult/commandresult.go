@@ -0,0 +1,47 @@
+package commandresult
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/micromdm/mdm"
+)
+
+// Result is the response a device sent for an MDM command.
+type Result struct {
+	UUID        string     `db:"result_uuid" json:"uuid"`
+	UDID        string     `db:"udid" json:"udid"`
+	CommandUUID string     `db:"command_uuid" json:"command_uuid"`
+	RequestType string     `db:"request_type" json:"request_type,omitempty"`
+	Status      string     `db:"status" json:"status"`
+	ErrorChain  ErrorChain `db:"error_chain" json:"error_chain,omitempty"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+}
+
+// ErrorChain is the list of errors a device responded with
+// when the command failed.
+type ErrorChain []mdm.ErrorChainItem
+
+// Value implements Valuer from database/sql
+func (chain ErrorChain) Value() (driver.Value, error) {
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(chain)
+}
+
+// Scan implements Scanner from database/sql
+func (chain *ErrorChain) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*chain = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, chain)
+	case string:
+		return json.Unmarshal([]byte(v), chain)
+	}
+	return errors.New("failed to scan ErrorChain")
+}
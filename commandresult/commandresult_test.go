@@ -0,0 +1,34 @@
+package commandresult
+
+import (
+	"testing"
+
+	"github.com/micromdm/mdm"
+)
+
+func TestErrorChainValueScan(t *testing.T) {
+	chain := ErrorChain{
+		{ErrorCode: 12021, ErrorDomain: "MCMDMErrorDomain", USEnglishDescription: "Device is not supervised"},
+	}
+	value, err := chain.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scanned ErrorChain
+	if err := scanned.Scan(value); err != nil {
+		t.Fatal(err)
+	}
+	if len(scanned) != 1 || scanned[0] != chain[0] {
+		t.Errorf("have %v, want %v", scanned, chain)
+	}
+
+	// acknowledged commands have no error chain
+	value, err = ErrorChain([]mdm.ErrorChainItem{}).Value()
+	if err != nil || value != nil {
+		t.Errorf("expected an empty chain to be stored as NULL, got %v, %v", value, err)
+	}
+	if err := scanned.Scan(nil); err != nil || scanned != nil {
+		t.Errorf("expected NULL to scan as an empty chain, got %v, %v", scanned, err)
+	}
+}
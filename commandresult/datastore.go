@@ -0,0 +1,103 @@
+package commandresult
+
+import (
+	"fmt"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq" // postgres driver
+	"github.com/micromdm/micromdm/dbpool"
+	"github.com/pkg/errors"
+)
+
+var (
+	insertResultStmt = `INSERT INTO command_results (
+		udid,
+		command_uuid,
+		request_type,
+		status,
+		error_chain,
+		created_at
+	) VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING result_uuid;`
+
+	selectResultsByUDIDStmt = `SELECT
+		result_uuid,
+		udid,
+		command_uuid,
+		request_type,
+		status,
+		error_chain,
+		created_at
+		FROM command_results
+		WHERE udid = $1
+		ORDER BY created_at DESC
+		LIMIT $2`
+)
+
+// Datastore keeps a history of the responses devices sent for MDM commands.
+type Datastore interface {
+	Save(r *Result) error
+	// GetResultsByUDID returns the most recent results for a device, newest first
+	GetResultsByUDID(udid string, limit int) ([]Result, error)
+}
+
+type pgStore struct {
+	*sqlx.DB
+}
+
+func NewDB(driver, conn string, logger kitlog.Logger, opts ...dbpool.Option) (Datastore, error) {
+	switch driver {
+	case "postgres":
+		db, err := sqlx.Open(driver, conn)
+		if err != nil {
+			return nil, errors.Wrap(err, "command results datastore")
+		}
+		dbpool.Configure(db.DB, opts...)
+		var dbError error
+		maxAttempts := 20
+		for attempts := 1; attempts <= maxAttempts; attempts++ {
+			dbError = db.Ping()
+			if dbError == nil {
+				break
+			}
+			logger.Log("msg", fmt.Sprintf("could not connect to postgres: %v", dbError))
+			time.Sleep(time.Duration(attempts) * time.Second)
+		}
+		if dbError != nil {
+			return nil, errors.Wrap(dbError, "command results datastore")
+		}
+		return pgStore{DB: db}, nil
+	default:
+		return nil, errors.New("unknown driver")
+	}
+}
+
+func (store pgStore) Save(r *Result) error {
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now().UTC()
+	}
+	err := store.QueryRow(
+		insertResultStmt,
+		r.UDID,
+		r.CommandUUID,
+		r.RequestType,
+		r.Status,
+		r.ErrorChain,
+		r.CreatedAt,
+	).Scan(&r.UUID)
+	if err != nil {
+		return errors.Wrap(err, "pgStore Save")
+	}
+	return nil
+}
+
+func (store pgStore) GetResultsByUDID(udid string, limit int) ([]Result, error) {
+	var results []Result
+	err := store.Select(&results, selectResultsByUDIDStmt, udid, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore GetResultsByUDID")
+	}
+	return results, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// loadConfigFile reads a flat TOML (key = value) or YAML (key: value) file.
+// Keys are flag names, with underscores allowed in place of dashes.
+// The format is chosen by the file extension.
+func loadConfigFile(path string) (map[string]string, error) {
+	var sep string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		sep = "="
+	case ".yaml", ".yml":
+		sep = ":"
+	default:
+		return nil, fmt.Errorf("config: unsupported file type %q, use .toml, .yaml or .yml", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		parts := strings.SplitN(line, sep, 2)
+		if len(parts) != 2 || strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("config: %s:%d: expected key %s value", path, lineNum, sep)
+		}
+		key := strings.Replace(strings.TrimSpace(parts[0]), "_", "-", -1)
+		value, err := parseConfigValue(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("config: %s:%d: %s", path, lineNum, err)
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// parseConfigValue unquotes a value and strips trailing comments.
+func parseConfigValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		end := strings.Index(raw[1:], `"`)
+		for end != -1 && raw[end] == '\\' {
+			next := strings.Index(raw[end+2:], `"`)
+			if next == -1 {
+				end = -1
+				break
+			}
+			end += next + 1
+		}
+		if end == -1 {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		return strconv.Unquote(raw[:end+2])
+	case strings.HasPrefix(raw, "'"):
+		end := strings.Index(raw[1:], "'")
+		if end == -1 {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		return raw[1 : end+1], nil
+	default:
+		if i := strings.Index(raw, "#"); i != -1 {
+			raw = raw[:i]
+		}
+		return strings.TrimSpace(raw), nil
+	}
+}
+
+// applyConfig sets the flags which were not passed on the command line from the config file values.
+// Flag defaults are read from the environment, so the resulting precedence is flags, then file, then env.
+func applyConfig(fs *flag.FlagSet, values map[string]string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for key, value := range values {
+		if fs.Lookup(key) == nil || key == "config" {
+			return fmt.Errorf("config: unknown key %q", key)
+		}
+		if explicit[key] {
+			continue
+		}
+		if err := fs.Set(key, value); err != nil {
+			return fmt.Errorf("config: invalid value for %q: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// missingKeys returns the sorted names of the required options which are empty.
+func missingKeys(required map[string]string) []string {
+	var missing []string
+	for key, value := range required {
+		if value == "" {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// splitNamed removes the keys of the form <prefix><name>.<option> from the
+// config file values and returns the options by name.
+func splitNamed(values map[string]string, prefix string) (map[string]map[string]string, error) {
+	named := make(map[string]map[string]string)
+	for key, value := range values {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		delete(values, key)
+		parts := strings.SplitN(strings.TrimPrefix(key, prefix), ".", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("config: invalid key %q, expected %s<name>.<option>", key, strings.Replace(prefix, "-", "_", -1))
+		}
+		if named[parts[0]] == nil {
+			named[parts[0]] = make(map[string]string)
+		}
+		named[parts[0]][parts[1]] = value
+	}
+	return named, nil
+}
+
+// depAccountPrefix starts the config keys of additional DEP accounts, for example
+// dep_account.school.consumer_key = "CK_..."
+const depAccountPrefix = "dep-account."
+
+// depAccountConfig holds the credentials of a DEP account from the config file.
+type depAccountConfig struct {
+	ConsumerKey    string
+	ConsumerSecret string
+	AccessToken    string
+	AccessSecret   string
+	ServerURL      string
+}
+
+// splitDEPAccounts removes the DEP account keys from the config file values
+// and returns the accounts by name.
+func splitDEPAccounts(values map[string]string) (map[string]*depAccountConfig, error) {
+	named, err := splitNamed(values, depAccountPrefix)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make(map[string]*depAccountConfig)
+	for name, options := range named {
+		if name == "default" {
+			return nil, fmt.Errorf("config: the default DEP account is configured with the dep-* keys")
+		}
+		account := &depAccountConfig{}
+		for option, value := range options {
+			switch option {
+			case "consumer-key":
+				account.ConsumerKey = value
+			case "consumer-secret":
+				account.ConsumerSecret = value
+			case "access-token":
+				account.AccessToken = value
+			case "access-secret":
+				account.AccessSecret = value
+			case "server-url":
+				account.ServerURL = value
+			default:
+				return nil, fmt.Errorf("config: unknown DEP account option %q", option)
+			}
+		}
+		if checkEmptyArgs(account.ConsumerKey, account.ConsumerSecret, account.AccessToken, account.AccessSecret) {
+			return nil, fmt.Errorf("config: DEP account %q requires consumer_key, consumer_secret, access_token and access_secret", name)
+		}
+		accounts[name] = account
+	}
+	return accounts, nil
+}
+
+// enrollmentPrefix starts the config keys of named enrollment profiles, served at /mdm/enroll/<name>:
+// enrollment.byod.scep_challenge = "secret"
+// enrollment.kiosk.profile = "/path/to/kiosk.mobileconfig"
+// enrollment.byod.organization = "Example Inc."
+const enrollmentPrefix = "enrollment."
+
+// enrollmentConfig holds the options of a named enrollment profile from the config file.
+// If Profile is set, the file is served instead of a generated profile.
+type enrollmentConfig struct {
+	SCEPChallenge string
+	Profile       string
+	DisplayName   string
+	Organization  string
+	Description   string
+}
+
+// splitEnrollments removes the enrollment keys from the config file values
+// and returns the enrollment profiles by name.
+func splitEnrollments(values map[string]string) (map[string]*enrollmentConfig, error) {
+	named, err := splitNamed(values, enrollmentPrefix)
+	if err != nil {
+		return nil, err
+	}
+	enrollments := make(map[string]*enrollmentConfig)
+	for name, options := range named {
+		if !validEnrollmentName(name) {
+			return nil, fmt.Errorf("config: enrollment name %q may only contain letters, digits and dashes", name)
+		}
+		enrollment := &enrollmentConfig{}
+		for option, value := range options {
+			switch option {
+			case "scep-challenge":
+				enrollment.SCEPChallenge = value
+			case "profile":
+				enrollment.Profile = value
+			case "display-name":
+				enrollment.DisplayName = value
+			case "organization":
+				enrollment.Organization = value
+			case "description":
+				enrollment.Description = value
+			default:
+				return nil, fmt.Errorf("config: unknown enrollment option %q", option)
+			}
+		}
+		enrollments[name] = enrollment
+	}
+	return enrollments, nil
+}
+
+// validEnrollmentName reports whether name can be used in the enrollment URL path.
+func validEnrollmentName(name string) bool {
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return name != ""
+}
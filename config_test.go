@@ -0,0 +1,248 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeConfig writes a config file with the name and contents to a temporary directory.
+func writeConfig(t *testing.T, name, contents string) (path string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	want := map[string]string{
+		"server-url": "https://mdm.example.com",
+		"tls":        "false",
+		"push-pass":  `se"cret # not a comment`,
+		"profile":    "/path with spaces/kiosk.mobileconfig",
+	}
+	var tests = []struct {
+		name     string
+		contents string
+	}{
+		{"micromdm.toml", `# micromdm config
+server_url = "https://mdm.example.com"
+tls = false # plain http behind a proxy
+
+push-pass = "se\"cret # not a comment"
+profile = '/path with spaces/kiosk.mobileconfig'
+`},
+		{"micromdm.yaml", `---
+server_url: "https://mdm.example.com"
+tls: false # plain http behind a proxy
+
+push-pass: "se\"cret # not a comment"
+profile: '/path with spaces/kiosk.mobileconfig'
+`},
+	}
+	for _, tt := range tests {
+		path, cleanup := writeConfig(t, tt.name, tt.contents)
+		values, err := loadConfigFile(path)
+		cleanup()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(values, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, values, want)
+		}
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	var tests = []struct {
+		name     string
+		contents string
+	}{
+		{"micromdm.json", `{"tls": false}`},
+		{"micromdm.toml", "[dep]\nconsumer_key = \"CK\""},
+		{"micromdm.toml", "tls false"},
+		{"micromdm.toml", `push-pass = "unterminated`},
+		{"micromdm.toml", `push-pass = 'unterminated`},
+		{"micromdm.yaml", "tls = false"},
+	}
+	for _, tt := range tests {
+		path, cleanup := writeConfig(t, tt.name, tt.contents)
+		_, err := loadConfigFile(path)
+		cleanup()
+		if err == nil {
+			t.Errorf("%s %q: expected an error", tt.name, tt.contents)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	fs := flag.NewFlagSet("micromdm", flag.ContinueOnError)
+	serverURL := fs.String("server-url", "from-env", "")
+	pushPass := fs.String("push-pass", "", "")
+	tls := fs.Bool("tls", true, "")
+	fs.String("config", "", "")
+	if err := fs.Parse([]string{"-push-pass", "from-flag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]string{"server-url": "from-file", "push-pass": "from-file", "tls": "false"}
+	if err := applyConfig(fs, values); err != nil {
+		t.Fatal(err)
+	}
+	if *serverURL != "from-file" {
+		t.Errorf("server-url = %q, expected the config file to replace the env default", *serverURL)
+	}
+	if *pushPass != "from-flag" {
+		t.Errorf("push-pass = %q, expected the command line flag to win", *pushPass)
+	}
+	if *tls {
+		t.Error("expected tls to be disabled by the config file")
+	}
+
+	for _, values := range []map[string]string{
+		{"unknown": "value"},
+		{"config": "other.toml"},
+		{"tls": "maybe"},
+	} {
+		fs := flag.NewFlagSet("micromdm", flag.ContinueOnError)
+		fs.Bool("tls", true, "")
+		fs.String("config", "", "")
+		if err := applyConfig(fs, values); err == nil {
+			t.Errorf("%v: expected an error", values)
+		}
+	}
+}
+
+func TestMissingKeys(t *testing.T) {
+	missing := missingKeys(map[string]string{
+		"tls-cert":  "",
+		"push-cert": "/path/to/push.p12",
+		"api-key":   "",
+	})
+	if want := []string{"api-key", "tls-cert"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("got %v, want %v", missing, want)
+	}
+	if missing := missingKeys(map[string]string{"api-key": "secret"}); len(missing) != 0 {
+		t.Errorf("expected no missing keys, got %v", missing)
+	}
+}
+
+func TestSplitDEPAccounts(t *testing.T) {
+	values := map[string]string{
+		"dep-account.school.consumer-key":    "CK_school",
+		"dep-account.school.consumer-secret": "CS_school",
+		"dep-account.school.access-token":    "AT_school",
+		"dep-account.school.access-secret":   "AS_school",
+		"dep-account.school.server-url":      "https://mdmenrollment.apple.com",
+		"dep-consumer-key":                   "CK_default",
+	}
+	accounts, err := splitDEPAccounts(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]*depAccountConfig{"school": {
+		ConsumerKey:    "CK_school",
+		ConsumerSecret: "CS_school",
+		AccessToken:    "AT_school",
+		AccessSecret:   "AS_school",
+		ServerURL:      "https://mdmenrollment.apple.com",
+	}}
+	if !reflect.DeepEqual(accounts, want) {
+		t.Errorf("got %+v, want %+v", accounts["school"], want["school"])
+	}
+	// the account keys are removed, so applyConfig does not reject them as unknown flags.
+	if want := map[string]string{"dep-consumer-key": "CK_default"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("remaining values = %v, want %v", values, want)
+	}
+
+	complete := func(name string) map[string]string {
+		return map[string]string{
+			"dep-account." + name + ".consumer-key":    "CK",
+			"dep-account." + name + ".consumer-secret": "CS",
+			"dep-account." + name + ".access-token":    "AT",
+			"dep-account." + name + ".access-secret":   "AS",
+		}
+	}
+	missingSecret := complete("school")
+	delete(missingSecret, "dep-account.school.access-secret")
+	unknownOption := complete("school")
+	unknownOption["dep-account.school.region"] = "eu"
+	for name, values := range map[string]map[string]string{
+		"default account": complete("default"),
+		"missing secret":  missingSecret,
+		"unknown option":  unknownOption,
+		"no option":       {"dep-account.school": "CK"},
+		"no name":         {"dep-account..consumer-key": "CK"},
+	} {
+		if _, err := splitDEPAccounts(values); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSplitEnrollments(t *testing.T) {
+	values := map[string]string{
+		"enrollment.byod.scep-challenge": "secret",
+		"enrollment.byod.organization":   "Example Inc.",
+		"enrollment.byod.display-name":   "BYOD",
+		"enrollment.byod.description":    "Personal devices",
+		"enrollment.kiosk.profile":       "/path/to/kiosk.mobileconfig",
+		"scep-challenge":                 "default",
+	}
+	enrollments, err := splitEnrollments(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]*enrollmentConfig{
+		"byod": {
+			SCEPChallenge: "secret",
+			Organization:  "Example Inc.",
+			DisplayName:   "BYOD",
+			Description:   "Personal devices",
+		},
+		"kiosk": {Profile: "/path/to/kiosk.mobileconfig"},
+	}
+	if !reflect.DeepEqual(enrollments, want) {
+		t.Errorf("got %+v, want %+v", enrollments, want)
+	}
+	if want := map[string]string{"scep-challenge": "default"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("remaining values = %v, want %v", values, want)
+	}
+
+	for name, values := range map[string]map[string]string{
+		"unknown option": {"enrollment.byod.topic": "com.apple.mgmt"},
+		"invalid name":   {"enrollment.by od.profile": "byod.mobileconfig"},
+		"path in name":   {"enrollment.../profile": "byod.mobileconfig"},
+		"no option":      {"enrollment.byod": "byod.mobileconfig"},
+	} {
+		if _, err := splitEnrollments(values); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestValidEnrollmentName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"byod":     true,
+		"Kiosk-2":  true,
+		"":         false,
+		"by_od":    false,
+		"byod/":    false,
+		"..":       false,
+		"kiosk?id": false,
+	} {
+		if got := validEnrollmentName(name); got != valid {
+			t.Errorf("validEnrollmentName(%q) = %v, want %v", name, got, valid)
+		}
+	}
+}
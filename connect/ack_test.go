@@ -0,0 +1,124 @@
+package connect
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/groob/plist"
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/device"
+	"github.com/micromdm/micromdm/identity"
+	"golang.org/x/net/context"
+)
+
+func TestAcknowledgeSecurityInfo(t *testing.T) {
+	queued, err := mdm.NewPayload(&mdm.CommandRequest{UDID: testUDID, RequestType: "SecurityInfo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key>
+	<string>` + queued.CommandUUID + `</string>
+	<key>SecurityInfo</key>
+	<dict>
+		<key>FDE_Enabled</key>
+		<true/>
+		<key>FirewallSettings</key>
+		<dict>
+			<key>BlockAllIncoming</key>
+			<false/>
+			<key>FirewallEnabled</key>
+			<true/>
+			<key>StealthMode</key>
+			<true/>
+		</dict>
+		<key>HardwareEncryptionCaps</key>
+		<integer>3</integer>
+		<key>PasscodeCompliant</key>
+		<true/>
+		<key>PasscodePresent</key>
+		<true/>
+	</dict>
+	<key>Status</key>
+	<string>Acknowledged</string>
+	<key>UDID</key>
+	<string>` + testUDID + `</string>
+</dict>
+</plist>`
+	var resp mdm.Response
+	if err := plist.NewDecoder(strings.NewReader(body)).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	devices := &fakeDevices{dev: &device.Device{
+		UUID: "device-uuid",
+		UDID: device.JsonNullString{NullString: sql.NullString{String: testUDID, Valid: true}},
+	}}
+	commands := &fakeCommands{payload: queued}
+	svc := NewService(devices, nil, nil, nil, nil, nil, fakeResults{}, &fakeWorkflows{}, commands, nil, nil, nil, log.NewNopLogger())
+	if _, err := svc.Acknowledge(context.Background(), resp); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := devices.saved
+	if saved == nil {
+		t.Fatal("expected the device to be saved")
+	}
+	if saved.UUID != "device-uuid" {
+		t.Errorf("expected the device record device-uuid to be saved, got %q", saved.UUID)
+	}
+	if !saved.FDEEnabled || !saved.PasscodePresent || !saved.PasscodeCompliant || saved.HardwareEncryptionCaps != 3 {
+		t.Errorf("expected FDE enabled, a compliant passcode and encryption caps 3, got %v, %v, %v, %d",
+			saved.FDEEnabled, saved.PasscodePresent, saved.PasscodeCompliant, saved.HardwareEncryptionCaps)
+	}
+	firewall, err := json.Marshal(resp.SecurityInfo.FirewallSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved.FirewallSettings, firewall) || bytes.Equal(firewall, []byte("null")) {
+		t.Errorf("expected firewall settings %s, got %s", firewall, saved.FirewallSettings)
+	}
+	if len(commands.acknowledged) != 1 {
+		t.Errorf("expected the command to be acknowledged, got %v", commands.acknowledged)
+	}
+}
+
+// fakeIdentities records the devices whose identity certificate was forgotten.
+type fakeIdentities struct {
+	identity.Datastore
+	deleted []string
+}
+
+func (f *fakeIdentities) DeleteFingerprint(udid string) error {
+	f.deleted = append(f.deleted, udid)
+	return nil
+}
+
+func TestAcknowledgeEraseDevice(t *testing.T) {
+	queued, err := mdm.NewPayload(&mdm.CommandRequest{UDID: testUDID, RequestType: "EraseDevice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := &fakeCommands{payload: queued}
+	identities := &fakeIdentities{}
+	svc := NewService(&fakeDevices{}, nil, nil, nil, nil, nil, fakeResults{}, &fakeWorkflows{}, commands, identities, nil, nil, log.NewNopLogger())
+	resp := mdm.Response{UDID: testUDID, CommandUUID: queued.CommandUUID, Status: "Acknowledged"}
+	if _, err := svc.Acknowledge(context.Background(), resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// the erased device enrolls again with a new identity certificate.
+	if len(identities.deleted) != 1 || identities.deleted[0] != testUDID {
+		t.Errorf("expected the identity certificate of %s to be forgotten, got %v", testUDID, identities.deleted)
+	}
+	if len(commands.acknowledged) != 1 {
+		t.Errorf("expected the command to be acknowledged, got %v", commands.acknowledged)
+	}
+}
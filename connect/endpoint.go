@@ -25,10 +25,9 @@ func (r mdmConnectResponse) error() error { return r.Err }
 func makeConnectEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(mdmConnectRequest)
-		if req.UserID != nil {
-			// don't handle user
-			return mdmConnectResponse{}, nil
-		}
+		// every request counts as a checkin, including Idle requests without a command.
+		// The checkin time is best-effort and never blocks the command delivery.
+		svc.Checkin(ctx, req.Response)
 		var err error
 		switch req.Status {
 		case "Acknowledged":
@@ -36,9 +35,6 @@ func makeConnectEndpoint(svc Service) endpoint.Endpoint {
 			if err != nil {
 				return mdmConnectResponse{Err: err}, nil
 			}
-			if req.RequestType == "DeviceConfigured" {
-				return mdmConnectResponse{}, nil
-			}
 			if total != 0 {
 				next, _, err := svc.NextCommand(ctx, req.Response)
 				if err != nil {
@@ -55,7 +51,7 @@ func makeConnectEndpoint(svc Service) endpoint.Endpoint {
 				return mdmConnectResponse{}, nil
 			}
 			return mdmConnectResponse{payload: next}, nil
-		case "Error":
+		case "Error", "CommandFormatError":
 			total, err := svc.FailCommand(ctx, req.Response)
 			if err != nil {
 				return mdmConnectResponse{Err: err}, nil
@@ -4,11 +4,21 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"github.com/go-kit/kit/log"
+	level "github.com/go-kit/kit/log/experimental_level"
+	"github.com/go-kit/kit/metrics"
 	"github.com/micromdm/mdm"
 	"github.com/micromdm/micromdm/application"
 	"github.com/micromdm/micromdm/certificate"
 	"github.com/micromdm/micromdm/command"
+	"github.com/micromdm/micromdm/commandresult"
 	"github.com/micromdm/micromdm/device"
+	"github.com/micromdm/micromdm/escrow"
+	"github.com/micromdm/micromdm/identity"
+	"github.com/micromdm/micromdm/osupdate"
+	"github.com/micromdm/micromdm/profile"
+	"github.com/micromdm/micromdm/user"
+	"github.com/micromdm/micromdm/workflow"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 	"time"
@@ -19,15 +29,26 @@ type Service interface {
 	Acknowledge(ctx context.Context, req mdm.Response) (int, error)
 	NextCommand(ctx context.Context, req mdm.Response) ([]byte, int, error)
 	FailCommand(ctx context.Context, req mdm.Response) (int, error)
+	// Checkin records that the device connected, whatever the status of the request.
+	Checkin(ctx context.Context, req mdm.Response) error
 }
 
 // NewService creates a mdm service
-func NewService(devices device.Datastore, apps application.Datastore, certs certificate.Datastore, cs command.Service) Service {
+func NewService(devices device.Datastore, apps application.Datastore, certs certificate.Datastore, profiles profile.Datastore, updates osupdate.Datastore, users user.Datastore, results commandresult.Datastore, workflows workflow.Datastore, cs command.Service, identities identity.Datastore, escrowKey *escrow.Key, unhandled metrics.Counter, logger log.Logger) Service {
 	return &service{
-		commands: cs,
-		devices:  devices,
-		apps:     apps,
-		certs:    certs,
+		escrowKey:  escrowKey,
+		identities: identities,
+		unhandled:  unhandled,
+		logger:     logger,
+		commands:   cs,
+		results:    results,
+		workflows:  workflows,
+		devices:    devices,
+		apps:       apps,
+		certs:      certs,
+		profiles:   profiles,
+		updates:    updates,
+		users:      users,
 	}
 }
 
@@ -36,13 +57,52 @@ type service struct {
 	apps     application.Datastore
 	commands command.Service
 	certs    certificate.Datastore
+	profiles profile.Datastore
+	updates  osupdate.Datastore
+	users    user.Datastore
+	results  commandresult.Datastore
+	// workflows runs the workflow steps of a device after DeviceConfigured.
+	workflows workflow.Datastore
+	// identities holds the identity certificate each device is bound to, see identity.Datastore.
+	identities identity.Datastore
+	// escrowKey encrypts the Activation Lock bypass code. If nil, the code is not stored.
+	escrowKey *escrow.Key
+	// unhandled counts the responses which were not recorded, by RequestType.
+	unhandled metrics.Counter
+	logger    log.Logger
 }
 
 // Acknowledge a response from a device.
 // NOTE: IOS devices do not always include the key `RequestType` in their response. Only the presence of the
 // result key can be used to identify the response (or the command UUID)
+// A repeated Acknowledge for the same command is ignored.
 func (svc service) Acknowledge(ctx context.Context, req mdm.Response) (int, error) {
+	acked, err := svc.commands.Acknowledged(req.CommandUUID)
+	if err != nil {
+		return 0, errors.Wrap(err, "checking for previous acknowledgement")
+	}
+	if acked {
+		return svc.commands.QueueLength(queueID(req))
+	}
 	requestPayload, err := svc.commands.Find(req.CommandUUID)
+	if err == command.ErrNoKey || (err == nil && requestPayload.Command == nil) {
+		// The payload expired or was never queued, so there is nothing to record.
+		// Drop the command and carry on with the rest of the queue.
+		svc.logger.Log("msg", "acknowledged unknown command", "udid", req.UDID, "command_uuid", req.CommandUUID)
+		return svc.commands.DeleteCommand(queueID(req), req.CommandUUID)
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "finding acknowledged command")
+	}
+
+	// Responses on a user channel don't describe the device,
+	// so only the result is recorded.
+	if req.UserID != nil {
+		if err := svc.saveResult(req, requestPayload.Command.RequestType); err != nil {
+			return 0, err
+		}
+		return svc.commands.AcknowledgeCommand(*req.UserID, req.CommandUUID)
+	}
 
 	switch requestPayload.Command.RequestType {
 	case "DeviceInformation":
@@ -57,11 +117,96 @@ func (svc service) Acknowledge(ctx context.Context, req mdm.Response) (int, erro
 		if err := svc.ackCertificateList(req); err != nil {
 			return 0, err
 		}
+	case "SecurityInfo":
+		if err := svc.ackSecurityInfo(req); err != nil {
+			return 0, err
+		}
+	case "ProfileList":
+		if err := svc.ackProfileList(req); err != nil {
+			return 0, err
+		}
+	case "ProvisioningProfileList":
+		if err := svc.ackProvisioningProfileList(req); err != nil {
+			return 0, err
+		}
+	case "AvailableOSUpdates":
+		if err := svc.ackAvailableOSUpdates(req); err != nil {
+			return 0, err
+		}
+	case "UserList":
+		if err := svc.ackUserList(req); err != nil {
+			return 0, err
+		}
+	case "ManagedApplicationList":
+		if err := svc.ackManagedApplicationList(req, requestPayload.Command.ManagedApplicationList); err != nil {
+			return 0, err
+		}
+	case "InstallApplication":
+		if err := svc.ackInstallApplication(req, requestPayload.Command.InstallApplication); err != nil {
+			return 0, err
+		}
+	case "ApplyRedemptionCode":
+		if err := svc.ackApplyRedemptionCode(req, requestPayload.Command.ApplyRedemptionCode); err != nil {
+			return 0, err
+		}
+	case "RemoveApplication":
+		if err := svc.ackRemoveApplication(req, requestPayload.Command.RemoveApplication); err != nil {
+			return 0, err
+		}
+	case "InstallProfile":
+		if err := svc.ackInstallProfile(req, requestPayload.Command.InstallProfile); err != nil {
+			return 0, err
+		}
+	case "RemoveProfile":
+		if err := svc.ackRemoveProfile(req, requestPayload.Command.RemoveProfile); err != nil {
+			return 0, err
+		}
+	case "EnableLostMode", "DisableLostMode":
+		if err := svc.ackLostMode(req, requestPayload.Command.RequestType == "EnableLostMode"); err != nil {
+			return 0, err
+		}
+	case "Settings":
+		if err := svc.ackSettings(req, requestPayload.Command.Settings); err != nil {
+			return 0, err
+		}
+	case "ActivationLockBypassCode":
+		if err := svc.ackActivationLockBypassCode(req); err != nil {
+			return 0, err
+		}
+	case "DeviceLocation":
+		if err := svc.ackDeviceLocation(req); err != nil {
+			return 0, err
+		}
+	case "DeviceLock":
+		// Nothing to record, but the command must be removed from the queue below.
+		// NextCommand rotates unacknowledged commands to the back of the queue,
+		// so leaving it in place would lock the device again on the next connect.
+	case "DeviceConfigured":
+		if err := svc.ackDeviceConfigured(req.UDID); err != nil {
+			return 0, err
+		}
+	case "EraseDevice":
+		if err := svc.ackEraseDevice(req.UDID); err != nil {
+			return 0, err
+		}
 	default:
-		// Unhandled MDM client response
+		// Unhandled MDM client response, only the result is recorded.
+		level.Debug(svc.logger).Log(
+			"msg", "unhandled command response",
+			"request_type", requestPayload.Command.RequestType,
+			"command_uuid", req.CommandUUID,
+		)
+		svc.unhandled.With("request_type", requestPayload.Command.RequestType).Add(1)
 	}
 
-	total, err := svc.commands.DeleteCommand(req.UDID, req.CommandUUID)
+	if err := svc.saveResult(req, requestPayload.Command.RequestType); err != nil {
+		return 0, err
+	}
+	// a workflow step is queued once the previous one was acknowledged.
+	if err := svc.continueWorkflow(req.CommandUUID); err != nil {
+		return 0, err
+	}
+	total, err := svc.commands.AcknowledgeCommand(req.UDID, req.CommandUUID)
 	if err != nil {
 		return total, err
 	}
@@ -75,12 +220,60 @@ func (svc service) Acknowledge(ctx context.Context, req mdm.Response) (int, erro
 	return total, nil
 }
 
+// Checkin records the last checkin time of the device. It is best-effort:
+// a failed update is logged and must not stop the command delivery.
+func (svc service) Checkin(ctx context.Context, req mdm.Response) error {
+	if err := svc.devices.UpdateLastCheckin(req.UDID, time.Now()); err != nil {
+		level.Warn(svc.logger).Log(
+			"msg", "updating last checkin",
+			"udid", req.UDID,
+			"err", err,
+		)
+	}
+	return nil
+}
+
 func (svc service) NextCommand(ctx context.Context, req mdm.Response) ([]byte, int, error) {
-	return svc.commands.NextCommand(req.UDID)
+	return svc.commands.NextCommand(queueID(req))
 }
 
+// FailCommand removes a command the device could not execute from the queue.
+// Supervision-only commands like RestartDevice and ShutDownDevice will fail this way
+// on unsupervised devices, so the command is kept as failed along with the ErrorChain.
+// A command the device could not parse is answered with CommandFormatError and is kept the same way.
 func (svc service) FailCommand(ctx context.Context, req mdm.Response) (int, error) {
-	return svc.commands.DeleteCommand(req.UDID, req.CommandUUID)
+	requestType := req.RequestType
+	if payload, err := svc.commands.Find(req.CommandUUID); err == nil && payload.Command != nil {
+		requestType = payload.Command.RequestType
+	}
+	if err := svc.saveResult(req, requestType); err != nil {
+		return 0, err
+	}
+	if err := svc.failWorkflow(req.CommandUUID); err != nil {
+		return 0, err
+	}
+	return svc.commands.FailCommand(queueID(req), req.CommandUUID, req.ErrorChain)
+}
+
+// queueID returns the command queue of the channel the request was sent on.
+// Commands for a user channel are queued under the UserID.
+func queueID(req mdm.Response) string {
+	if req.UserID != nil {
+		return *req.UserID
+	}
+	return req.UDID
+}
+
+// saveResult records the response in the command history before the command leaves the queue.
+func (svc service) saveResult(req mdm.Response, requestType string) error {
+	err := svc.results.Save(&commandresult.Result{
+		UDID:        req.UDID,
+		CommandUUID: req.CommandUUID,
+		RequestType: requestType,
+		Status:      req.Status,
+		ErrorChain:  req.ErrorChain,
+	})
+	return errors.Wrap(err, "saving command result")
 }
 
 func (svc service) checkRequeue(deviceUDID string) (int, error) {
@@ -105,7 +298,7 @@ func (svc service) checkRequeue(deviceUDID string) (int, error) {
 // Acknowledge Queries sent with DeviceInformation command
 func (svc service) ackQueryResponses(req mdm.Response) error {
 	devices, err := svc.devices.Devices(
-		device.SerialNumber{SerialNumber: req.QueryResponses.SerialNumber},
+		device.SerialNumber{SerialNumber: device.NormalizeSerial(req.QueryResponses.SerialNumber)},
 		device.UDID{UDID: req.UDID},
 	)
 
@@ -118,7 +311,21 @@ func (svc service) ackQueryResponses(req mdm.Response) error {
 	}
 
 	if len(devices) > 1 {
-		return fmt.Errorf("expected a single device for udid: %s, serial number: %s, but got more than one.", req.UDID, req.QueryResponses.SerialNumber)
+		// Keep the response on the UDID record, so the duplicate can be found and merged
+		// with the record of the serial number.
+		for _, dev := range devices {
+			if dev.UDID.String != req.UDID {
+				continue
+			}
+			dev.LastQueryResponse, err = json.Marshal(req.QueryResponses)
+			if err != nil {
+				return err
+			}
+			if err := svc.devices.Save("lastQueryResponse", &dev); err != nil {
+				return err
+			}
+		}
+		return fmt.Errorf("expected a single device for udid: %s, serial number: %s, but got more than one. Reconcile duplicate devices to merge them.", req.UDID, req.QueryResponses.SerialNumber)
 	}
 
 	existing := devices[0]
@@ -131,9 +338,10 @@ func (svc service) ackQueryResponses(req mdm.Response) error {
 	}
 
 	var serialNumber device.JsonNullString
-	serialNumber.Scan(req.QueryResponses.SerialNumber)
+	serialNumber.Scan(device.NormalizeSerial(req.QueryResponses.SerialNumber))
 
 	existing.ProductName = req.QueryResponses.ProductName
+	existing.Platform = device.PlatformFromProductName(req.QueryResponses.ProductName)
 	existing.BuildVersion = req.QueryResponses.BuildVersion
 	existing.DeviceName = req.QueryResponses.DeviceName
 	existing.IMEI = req.QueryResponses.IMEI
@@ -145,24 +353,167 @@ func (svc service) ackQueryResponses(req mdm.Response) error {
 	return svc.devices.Save("queryResponses", &existing)
 }
 
-// Acknowledge a response to `InstalledApplicationList`.
-func (svc service) ackInstalledApplicationList(req mdm.Response) error {
+// Acknowledge a response to `SecurityInfo`.
+func (svc service) ackSecurityInfo(req mdm.Response) error {
+	existing, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
+	}
+
+	info := req.SecurityInfo
+	existing.HardwareEncryptionCaps = info.HardwareEncryptionCaps
+	existing.PasscodePresent = info.PasscodePresent
+	existing.PasscodeCompliant = info.PasscodeCompliant
+	existing.FDEEnabled = info.FDEEnabled
+	existing.FirewallSettings, err = json.Marshal(info.FirewallSettings)
+	if err != nil {
+		return err
+	}
+
+	return svc.devices.Save("securityInfo", existing)
+}
+
+// ackInstallProfile records the profile as assigned to the device.
+func (svc service) ackInstallProfile(req mdm.Response, cmd mdm.InstallProfile) error {
+	config, err := profile.ParseConfiguration(cmd.Payload)
+	if err != nil {
+		return errors.Wrap(err, "parsing installed profile")
+	}
+	restrictions, err := profile.ParseRestrictions(cmd.Payload)
+	if err != nil {
+		return errors.Wrap(err, "parsing installed profile restrictions")
+	}
+	dev, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
+	}
+	return svc.profiles.SaveAssignedProfile(&profile.AssignedProfile{
+		DeviceUUID:   dev.UUID,
+		Identifier:   config.PayloadIdentifier,
+		PayloadUUID:  config.PayloadUUID,
+		DisplayName:  config.PayloadDisplayName,
+		AssignedAt:   time.Now().UTC(),
+		Restrictions: restrictions,
+	})
+}
+
+// ackRemoveProfile clears the assigned profile record of the device.
+func (svc service) ackRemoveProfile(req mdm.Response, cmd mdm.RemoveProfile) error {
 	dev, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
 	if err != nil {
 		return errors.Wrap(err, "getting a device record by udid")
 	}
+	return svc.profiles.DeleteAssignedProfile(dev.UUID, cmd.Identifier)
+}
+
+// ackLostMode records whether the device is in Lost Mode.
+func (svc service) ackLostMode(req mdm.Response, enabled bool) error {
+	existing, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
+	}
+	existing.LostMode = enabled
+	return svc.devices.Save("lostMode", existing)
+}
+
+// ackEraseDevice forgets the identity certificate of the device. The erased device
+// enrolls again with a new certificate and does not check out first.
+func (svc service) ackEraseDevice(udid string) error {
+	if svc.identities == nil {
+		return nil
+	}
+	return errors.Wrap(svc.identities.DeleteFingerprint(udid), "forgetting identity certificate")
+}
+
+// ackSettings records the settings which change the device record.
+// The device does not report its new name until the next DeviceInformation,
+// so the name which was sent is saved. The command is acknowledged even if
+// single items failed, so only the items the device applied are saved.
+func (svc service) ackSettings(req mdm.Response, settings mdm.Settings) error {
+	applied := make(map[string]bool, len(req.Settings))
+	for _, status := range req.Settings {
+		applied[status.Item] = status.Status == "Acknowledged"
+	}
+	for _, setting := range settings.Settings {
+		if setting.Item != "DeviceName" || setting.DeviceName == nil {
+			continue
+		}
+		// older devices do not report the status of every item.
+		if ok, reported := applied[setting.Item]; reported && !ok {
+			level.Warn(svc.logger).Log(
+				"msg", "device did not apply setting",
+				"udid", req.UDID,
+				"item", setting.Item,
+			)
+			continue
+		}
+		existing, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+		if err != nil {
+			return errors.Wrap(err, "getting a device record by udid")
+		}
+		existing.DeviceName = *setting.DeviceName
+		if err := svc.devices.Save("deviceName", existing); err != nil {
+			return errors.Wrap(err, "saving device name")
+		}
+	}
+	return nil
+}
+
+// ackActivationLockBypassCode escrows the Activation Lock bypass code of a supervised device.
+func (svc service) ackActivationLockBypassCode(req mdm.Response) error {
+	if req.ActivationLockBypassCode == "" {
+		// the device has no code, Activation Lock was never enabled or the code was already used.
+		return nil
+	}
+	if svc.escrowKey == nil {
+		level.Warn(svc.logger).Log(
+			"msg", "escrow-key not set, not storing the Activation Lock bypass code",
+			"udid", req.UDID,
+		)
+		return nil
+	}
+	existing, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
+	}
+	if existing.ActivationLockBypassCode, err = svc.escrowKey.Seal(req.ActivationLockBypassCode); err != nil {
+		return errors.Wrap(err, "encrypting Activation Lock bypass code")
+	}
+	return svc.devices.Save("activationLockBypassCode", existing)
+}
+
+// ackDeviceLocation stores the location reported by a device in Lost Mode.
+func (svc service) ackDeviceLocation(req mdm.Response) error {
+	existing, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
+	}
+	// the device reports when the location was determined,
+	// which can be well before the response is sent
+	updatedAt, err := time.Parse(time.RFC3339, req.Timestamp)
+	if err != nil {
+		updatedAt = time.Now()
+	}
+	updatedAt = updatedAt.UTC()
+	latitude, longitude := req.Latitude, req.Longitude
+	existing.Latitude = &latitude
+	existing.Longitude = &longitude
+	existing.LocationUpdatedAt = &updatedAt
+	return svc.devices.Save("location", existing)
+}
 
-	if err := svc.apps.DeleteDeviceApplications(dev.UUID); err != nil {
-		return fmt.Errorf("clearing applications for device: %s", err)
+// Acknowledge a response to `InstalledApplicationList`.
+func (svc service) ackInstalledApplicationList(req mdm.Response) error {
+	dev, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
 	}
 
-	var requestApps []application.DeviceApplication = make([]application.DeviceApplication, len(req.InstalledApplicationList))
-	// Update or insert application records that do not exist, returning the UUID so that it can be inserted for
-	// the device sending the response.
+	apps := make([]application.DeviceApplication, len(req.InstalledApplicationList))
 	for i, reqApp := range req.InstalledApplicationList {
-		identifier := sql.NullString{reqApp.Identifier, reqApp.Identifier != ""}
-		shortVersion := sql.NullString{reqApp.ShortVersion, reqApp.ShortVersion != ""}
-		version := sql.NullString{reqApp.Version, reqApp.Version != ""}
+		identifier := sql.NullString{String: reqApp.Identifier, Valid: reqApp.Identifier != ""}
+		shortVersion := sql.NullString{String: reqApp.ShortVersion, Valid: reqApp.ShortVersion != ""}
+		version := sql.NullString{String: reqApp.Version, Valid: reqApp.Version != ""}
 
 		bundleSize := sql.NullInt64{}
 		bundleSize.Scan(reqApp.BundleSize)
@@ -170,7 +521,7 @@ func (svc service) ackInstalledApplicationList(req mdm.Response) error {
 		dynamicSize := sql.NullInt64{}
 		dynamicSize.Scan(reqApp.DynamicSize)
 
-		newApp := application.DeviceApplication{
+		apps[i] = application.DeviceApplication{
 			DeviceUUID:   dev.UUID,
 			Name:         reqApp.Name,
 			Identifier:   identifier,
@@ -179,20 +530,105 @@ func (svc service) ackInstalledApplicationList(req mdm.Response) error {
 			BundleSize:   bundleSize,
 			DynamicSize:  dynamicSize,
 		}
+	}
 
-		if err := svc.apps.NewDeviceApp(&newApp); err != nil {
-			fmt.Println(err)
-			fmt.Printf("%v\n", newApp)
-			return fmt.Errorf("inserting an application for device: %s", err)
+	// the list replaces the previous one in a single transaction,
+	// so a failed insert leaves the previous inventory in place.
+	if err := svc.apps.ReplaceDeviceApps(dev.UUID, apps); err != nil {
+		return errors.Wrap(err, "replacing applications for device")
+	}
+	return nil
+}
+
+// Acknowledge a response to `InstallApplication`.
+// The device acknowledges the command before the installation completes, reporting
+// an intermediate State such as NeedsRedemption, Prompting or Installing, or Managed
+// if the app was already installed.
+func (svc service) ackInstallApplication(req mdm.Response, cmd mdm.InstallApplication) error {
+	identifier := req.Identifier
+	if identifier == "" {
+		identifier = cmd.Identifier
+	}
+	vpp := cmd.Options != nil && cmd.Options.PurchaseMethod == application.PurchaseMethodVPP
+	if !vpp && (identifier == "" || req.State == "") {
+		// store apps requested without an Identifier cannot be tracked
+		// until they show up in the InstalledApplicationList.
+		return nil
+	}
+
+	dev, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
+	}
+
+	// device based VPP apps consume a license as soon as the device accepts the command.
+	if vpp {
+		err := svc.apps.AssignVPPLicense(&application.VPPLicense{
+			ITunesStoreID: cmd.ITunesStoreID,
+			Identifier:    identifier,
+			DeviceUUID:    dev.UUID,
+			AssignedAt:    time.Now().UTC(),
+		})
+		if err != nil {
+			return err
 		}
+	}
+	if identifier == "" || req.State == "" {
+		return nil
+	}
+
+	return svc.apps.SaveDeviceAppInstallState(dev.UUID, identifier, req.State)
+}
 
-		requestApps[i] = newApp
-		//fmt.Printf("%v\n", newApp)
+// Acknowledge a response to `ApplyRedemptionCode`.
+// Legacy VPP apps consume a license once the device redeems the code.
+func (svc service) ackApplyRedemptionCode(req mdm.Response, cmd mdm.ApplyRedemptionCode) error {
+	dev, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
 	}
 
-	//fmt.Printf("%v\n", requestApps)
+	return svc.apps.AssignVPPLicense(&application.VPPLicense{
+		Identifier:     cmd.Identifier,
+		DeviceUUID:     dev.UUID,
+		RedemptionCode: cmd.RedemptionCode,
+		AssignedAt:     time.Now().UTC(),
+	})
+}
 
-	return nil
+// Acknowledge a response to `ManagedApplicationList`.
+// A request for specific Identifiers only reports those apps, so apps are only
+// marked as unmanaged after a response to a request for the full list.
+func (svc service) ackManagedApplicationList(req mdm.Response, cmd mdm.ManagedApplicationList) error {
+	dev, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
+	}
+
+	var apps []application.ManagedApplication
+	for identifier, app := range req.ManagedApplicationList {
+		apps = append(apps, application.ManagedApplication{
+			Identifier:      identifier,
+			Status:          app.Status,
+			ManagementFlags: app.ManagementFlags,
+		})
+	}
+
+	complete := len(cmd.Identifiers) == 0
+	return svc.apps.ReconcileManagedApps(dev.UUID, apps, complete)
+}
+
+// Acknowledge a response to `RemoveApplication`.
+func (svc service) ackRemoveApplication(req mdm.Response, cmd mdm.RemoveApplication) error {
+	dev, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
+	}
+
+	if err := svc.apps.DeleteDeviceApp(dev.UUID, cmd.Identifier); err != nil {
+		return err
+	}
+	return svc.apps.ReleaseVPPLicense(dev.UUID, cmd.Identifier)
 }
 
 // Acknowledge a response to `CertificateList`.
@@ -215,8 +651,135 @@ func (svc service) ackCertificateList(req mdm.Response) error {
 	}
 
 	if err := svc.certs.ReplaceCertificatesByDeviceUUID(device.UUID, certs); err != nil {
+		return errors.Wrap(err, "replacing certificates for device")
+	}
+
+	return nil
+}
+
+// Acknowledge a response to `ProfileList`.
+func (svc service) ackProfileList(req mdm.Response) error {
+	device, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
+	}
+
+	// the restrictions of a reported profile are known if it was installed
+	// with an InstallProfile command and not replaced since.
+	assigned, err := svc.profiles.GetAssignedProfilesByDeviceUUID(device.UUID)
+	if err != nil {
+		return errors.Wrap(err, "getting the profiles installed by the server")
+	}
+	installed := make(map[string]profile.AssignedProfile, len(assigned))
+	for _, a := range assigned {
+		installed[a.Identifier] = a
+	}
+
+	var profiles []profile.Profile = []profile.Profile{}
+	for _, p := range req.ProfileList {
+		newProfile := profile.Profile{
+			DeviceUUID:   device.UUID,
+			Identifier:   p.PayloadIdentifier,
+			PayloadUUID:  p.PayloadUUID,
+			DisplayName:  p.PayloadDisplayName,
+			Organization: p.PayloadOrganization,
+			IsSigned:     len(p.SignerCertificates) > 0,
+		}
+		if a, ok := installed[p.PayloadIdentifier]; ok && a.PayloadUUID == p.PayloadUUID && hasRestrictions(p) {
+			newProfile.Restrictions = a.Restrictions
+		}
+
+		profiles = append(profiles, newProfile)
+	}
+
+	if err := svc.profiles.ReplaceProfilesByDeviceUUID(device.UUID, profiles); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// hasRestrictions reports whether a profile listed by the device has a Restrictions payload.
+func hasRestrictions(p mdm.ProfileListItem) bool {
+	for _, payload := range p.PayloadContent {
+		if payload.PayloadType == profile.RestrictionsPayloadType {
+			return true
+		}
+	}
+	return false
+}
+
+// Acknowledge a response to `ProvisioningProfileList`.
+func (svc service) ackProvisioningProfileList(req mdm.Response) error {
+	device, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
+	}
+
+	var profiles []profile.ProvisioningProfile = []profile.ProvisioningProfile{}
+	for _, p := range req.ProvisioningProfileList {
+		newProfile := profile.ProvisioningProfile{
+			DeviceUUID: device.UUID,
+			UUID:       p.UUID,
+			Name:       p.Name,
+			// plist <date> values are ISO 8601 in UTC and decode directly into time.Time.
+			ExpiryDate: p.ExpiryDate.UTC(),
+		}
+
+		profiles = append(profiles, newProfile)
+	}
+
+	return svc.profiles.ReplaceProvisioningProfilesByDeviceUUID(device.UUID, profiles)
+}
+
+// Acknowledge a response to `AvailableOSUpdates`.
+func (svc service) ackAvailableOSUpdates(req mdm.Response) error {
+	device, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
+	}
+
+	var updates []osupdate.Update = []osupdate.Update{}
+	for _, u := range req.AvailableOSUpdates {
+		update := osupdate.Update{
+			DeviceUUID:        device.UUID,
+			ProductKey:        u.ProductKey,
+			HumanReadableName: u.HumanReadableName,
+			Version:           u.Version,
+			Build:             u.Build,
+			IsCritical:        u.IsCritical,
+			RestartRequired:   u.RestartRequired,
+			DownloadSize:      u.DownloadSize,
+			InstallSize:       u.InstallSize,
+		}
+
+		updates = append(updates, update)
+	}
+
+	return svc.updates.ReplaceUpdatesByDeviceUUID(device.UUID, updates)
+}
+
+// ackUserList stores the users of a shared iPad.
+func (svc service) ackUserList(req mdm.Response) error {
+	device, err := svc.devices.GetDeviceByUDID(req.UDID, "device_uuid")
+	if err != nil {
+		return errors.Wrap(err, "getting a device record by udid")
+	}
+
+	users := []user.User{}
+	for _, u := range req.Users {
+		users = append(users, user.User{
+			DeviceUUID:    device.UUID,
+			UserName:      u.UserName,
+			FullName:      u.FullName,
+			UID:           u.UID,
+			UserGUID:      u.UserGUID,
+			IsLoggedIn:    u.IsLoggedIn,
+			HasDataToSync: u.HasDataToSync,
+			DataQuota:     u.DataQuota,
+			DataUsed:      u.DataUsed,
+		})
+	}
+
+	return svc.users.ReplaceUsersByDeviceUUID(device.UUID, users)
+}
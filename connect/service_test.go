@@ -1,116 +1,90 @@
 package connect
 
 import (
-	"os"
+	"database/sql"
 	"testing"
 
 	"github.com/go-kit/kit/log"
-	"github.com/jmoiron/sqlx"
 	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/application"
 	"github.com/micromdm/micromdm/device"
 	"golang.org/x/net/context"
 )
 
-type MockDevices struct{}
-
-func (md MockDevices) New(src string, d *device.Device) (string, error) {
-	return "", nil
-}
-func (md MockDevices) GetDeviceByUDID(udid string, fields ...string) (*device.Device, error) {
-	return &device.Device{
-		UUID: "00000000-1111-2222-3333-444455556666",
-	}, nil
-}
-func (md MockDevices) GetDeviceByUUID(uuid string, fields ...string) (*device.Device, error) {
-	return &device.Device{}, nil
+// fakeApps keeps the last application list a device replaced.
+type fakeApps struct {
+	application.Datastore
+	deviceUUID string
+	apps       []application.DeviceApplication
 }
-func (md MockDevices) Devices(params ...interface{}) ([]device.Device, error) {
-	return []device.Device{
-		{
-			UUID: "00000000-1111-2222-3333-444455556666",
-		},
-	}, nil
-}
-func (md MockDevices) Save(msg string, dev *device.Device) error {
+
+func (f *fakeApps) ReplaceDeviceApps(deviceUUID string, apps []application.DeviceApplication) error {
+	f.deviceUUID = deviceUUID
+	f.apps = apps
 	return nil
 }
 
-type MockCmd struct{}
-
-func (mc MockCmd) NewCommand(*mdm.CommandRequest) (*mdm.Payload, error) {
-	return &mdm.Payload{}, nil
-}
-func (mc MockCmd) NextCommand(udid string) ([]byte, int, error) {
-	return []byte{}, 0, nil
-}
-func (mc MockCmd) DeleteCommand(deviceUDID, commandUUID string) (int, error) {
-	return 0, nil
+// queryDevices also lists the device of fakeDevices, as DeviceInformation looks it up by serial number and UDID.
+type queryDevices struct {
+	*fakeDevices
 }
 
-type serviceFixtures struct {
-	dbx    sqlx.DB
-	appsDB applications.Datastore
-	mock   sqlmock.Sqlmock
+func (f queryDevices) Devices(params ...interface{}) ([]device.Device, error) {
+	return []device.Device{*f.dev}, nil
 }
 
-func setup() (serviceFixtures, error) {
-	db, mock, err := sqlmock.New()
+func testService(requestType string, apps application.Datastore) (Service, *fakeDevices, *fakeCommands, error) {
+	queued, err := mdm.NewPayload(&mdm.CommandRequest{UDID: testUDID, RequestType: requestType})
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
-	dbx := sqlx.NewDb(db, "mock")
-	logger := log.NewLogfmtLogger(os.Stdout)
-	appsDB, err := applications.NewDB("postgres", "host=localhost", logger)
-	if err != nil {
-		return nil, err
-	}
-
-	return serviceFixtures{dbx, appsDB, mock}, nil
-}
-
-func teardown(fixtures serviceFixtures) {
-	fixtures.dbx.Close()
+	devices := &fakeDevices{dev: &device.Device{
+		UUID: "00000000-1111-2222-3333-444455556666",
+		UDID: device.JsonNullString{NullString: sql.NullString{String: testUDID, Valid: true}},
+	}}
+	commands := &fakeCommands{payload: queued}
+	svc := NewService(queryDevices{devices}, apps, nil, nil, nil, nil, fakeResults{}, &fakeWorkflows{}, commands, nil, nil, nil, log.NewNopLogger())
+	return svc, devices, commands, nil
 }
 
 func TestAckQueryResponses(t *testing.T) {
-	fixtures, err := setup()
+	svc, devices, commands, err := testService("DeviceInformation", nil)
 	if err != nil {
 		t.Fatalf("could not set up fixtures: %s", err)
 	}
-	defer teardown(fixtures.dbx)
 	ctx := context.Background()
 
 	response := mdm.Response{
-		UDID:           "00000000-1111-2222-3333-444455556666",
+		UDID:           testUDID,
 		Status:         "Acknowledged",
-		CommandUUID:    "10000000-1111-2222-3333-444455556666",
+		CommandUUID:    commands.payload.CommandUUID,
 		RequestType:    "DeviceInformation",
 		QueryResponses: mdm.QueryResponses{},
 	}
 
-	mockDevices := MockDevices{}
-	mockCmd := MockCmd{}
-
-	svc := NewService(mockDevices, fixtures.appsDB, mockCmd)
-	svc.Acknowledge(ctx, response)
-
-	if err := fixtures.mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+	if _, err := svc.Acknowledge(ctx, response); err != nil {
+		t.Fatal(err)
+	}
+	if devices.saved == nil {
+		t.Error("expected the device to be saved")
+	}
+	if len(commands.acknowledged) != 1 {
+		t.Errorf("expected the command to be acknowledged, got %v", commands.acknowledged)
 	}
 }
 
 func TestAckInstalledApplicationList(t *testing.T) {
-	fixtures, err := setup()
+	apps := &fakeApps{}
+	svc, _, commands, err := testService("InstalledApplicationList", apps)
 	if err != nil {
 		t.Fatalf("could not set up fixtures: %s", err)
 	}
-	defer teardown(fixtures.dbx)
 	ctx := context.Background()
 
 	response := mdm.Response{
-		UDID:        "00000000-1111-2222-3333-444455556666",
+		UDID:        testUDID,
 		Status:      "Acknowledged",
-		CommandUUID: "10000000-1111-2222-3333-444455556666",
+		CommandUUID: commands.payload.CommandUUID,
 		RequestType: "InstalledApplicationList",
 		InstalledApplicationList: []mdm.InstalledApplicationListItem{
 			{
@@ -131,31 +105,34 @@ func TestAckInstalledApplicationList(t *testing.T) {
 		},
 	}
 
-	mockDevices := MockDevices{}
-	mockCmd := MockCmd{}
-	mock := fixtures.mock
-
-	// Expect insert applications as new
-	wifiAppUuidRow := sqlmock.NewRows([]string{"application_uuid"}).AddRow("90000000-1111-2222-3333-444455556666")
-	mock.ExpectQuery("INSERT INTO applications").WithArgs("Wireless Network Utility", nil, nil, nil, 2416111, 0, nil).WillReturnRows(wifiAppUuidRow)
-	kcAppUuidRow := sqlmock.NewRows([]string{"application_uuid"}).AddRow("A0000000-1111-2222-3333-444455556666")
-	mock.ExpectQuery("INSERT INTO applications").WithArgs("Keychain Access", "com.apple.keychainaccess", "9.0", "9.0", 14166172, 0, nil).WillReturnRows(kcAppUuidRow)
-	sizeAppUuidRow := sqlmock.NewRows([]string{"application_uuid"}).AddRow("B0000000-1111-2222-3333-444455556666")
-	mock.ExpectQuery("INSERT INTO applications").WithArgs("Bundle Size Regression", nil, nil, nil, 2463209237, 0, nil).WillReturnRows(sizeAppUuidRow)
-
-	// Expect query for device installed apps
-	deviceAppsRow := sqlmock.NewRows([]string{"application_uuid", "name"}).AddRow("APP00000-1111-2222-3333-444455556666", "Mock Application")
-	mock.ExpectQuery("RIGHT JOIN devices_applications").WithArgs("00000000-1111-2222-3333-444455556666").WillReturnRows(deviceAppsRow)
-
-	mock.ExpectExec("INSERT INTO devices_applications").WithArgs("00000000-1111-2222-3333-444455556666", "90000000-1111-2222-3333-444455556666").WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec("INSERT INTO devices_applications").WithArgs("00000000-1111-2222-3333-444455556666", "A0000000-1111-2222-3333-444455556666").WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec("INSERT INTO devices_applications").WithArgs("00000000-1111-2222-3333-444455556666", "B0000000-1111-2222-3333-444455556666").WillReturnResult(sqlmock.NewResult(1, 1))
-
-	svc := NewService(mockDevices, fixtures.appsDB, mockCmd)
-	svc.Acknowledge(ctx, response)
+	if _, err := svc.Acknowledge(ctx, response); err != nil {
+		t.Fatal(err)
+	}
 
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+	if apps.deviceUUID != "00000000-1111-2222-3333-444455556666" {
+		t.Errorf("expected the applications of device 00000000-1111-2222-3333-444455556666 to be replaced, got %q", apps.deviceUUID)
+	}
+	var tests = []struct {
+		name       string
+		identifier sql.NullString
+		version    sql.NullString
+		bundleSize int64
+	}{
+		{"Wireless Network Utility", sql.NullString{}, sql.NullString{}, 2416111},
+		{"Keychain Access", sql.NullString{String: "com.apple.keychainaccess", Valid: true}, sql.NullString{String: "9.0", Valid: true}, 14166172},
+		{"Bundle Size Regression", sql.NullString{}, sql.NullString{}, 2463209237},
+	}
+	if len(apps.apps) != len(tests) {
+		t.Fatalf("expected %d applications, got %d", len(tests), len(apps.apps))
+	}
+	for i, tt := range tests {
+		app := apps.apps[i]
+		if app.Name != tt.name || app.Identifier != tt.identifier || app.Version != tt.version {
+			t.Errorf("expected %s %v %v, got %s %v %v", tt.name, tt.identifier, tt.version, app.Name, app.Identifier, app.Version)
+		}
+		if !app.BundleSize.Valid || app.BundleSize.Int64 != tt.bundleSize {
+			t.Errorf("%s: expected bundle size %d, got %v", tt.name, tt.bundleSize, app.BundleSize)
+		}
 	}
 }
 
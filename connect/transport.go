@@ -1,14 +1,12 @@
 package connect
 
 import (
-	"bytes"
-	"fmt"
-	"io/ioutil"
 	"net/http"
 
 	"golang.org/x/net/context"
 
 	kitlog "github.com/go-kit/kit/log"
+	level "github.com/go-kit/kit/log/experimental_level"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 	"github.com/groob/plist"
@@ -24,7 +22,7 @@ func ServiceHandler(ctx context.Context, svc Service, logger kitlog.Logger) http
 	connectHandler := kithttp.NewServer(
 		ctx,
 		makeConnectEndpoint(svc),
-		decodeMDMConnectRequest,
+		decodeMDMConnectRequest(level.Debug(logger)),
 		encodeResponse,
 		opts...,
 	)
@@ -34,15 +32,21 @@ func ServiceHandler(ctx context.Context, svc Service, logger kitlog.Logger) http
 	return r
 }
 
-func decodeMDMConnectRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	body, _ := ioutil.ReadAll(r.Body)
-	fmt.Println(string(body))
-	reader := bytes.NewReader(body)
-	var request mdmConnectRequest
-	if err := plist.NewDecoder(reader).Decode(&request); err != nil {
-		return nil, err
+// decodeMDMConnectRequest decodes the plist and logs the device, status and command to debug.
+// The body is not logged, responses can hold secrets like an Activation Lock bypass code.
+func decodeMDMConnectRequest(debug kitlog.Logger) kithttp.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		var request mdmConnectRequest
+		if err := plist.NewDecoder(r.Body).Decode(&request); err != nil {
+			return nil, err
+		}
+		debug.Log("msg", "connect request",
+			"udid", request.UDID,
+			"status", request.Status,
+			"command_uuid", request.CommandUUID,
+		)
+		return request, nil
 	}
-	return request, nil
 }
 
 type errorer interface {
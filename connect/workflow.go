@@ -0,0 +1,158 @@
+package connect
+
+import (
+	"time"
+
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/command"
+	"github.com/micromdm/micromdm/device"
+	"github.com/micromdm/micromdm/workflow"
+	"github.com/pkg/errors"
+)
+
+// ackDeviceConfigured records that the device left the Setup Assistant,
+// so that checkRequeue stops queueing DeviceConfigured, and starts its workflow.
+func (svc service) ackDeviceConfigured(udid string) error {
+	dev, err := svc.devices.GetDeviceByUDID(udid,
+		"device_uuid", "workflow_uuid", "COALESCE(dep_profile_uuid, '') AS dep_profile_uuid", "enrollment")
+	if err != nil {
+		return errors.Wrap(err, "acknowledging DeviceConfigured")
+	}
+	if err := svc.devices.Save("deviceConfigured", dev); err != nil {
+		return errors.Wrap(err, "acknowledging DeviceConfigured")
+	}
+	return svc.startWorkflow(udid, dev)
+}
+
+// startWorkflow queues the first step of the workflow of a configured device,
+// unless the device already ran or is running a workflow.
+// The workflow assigned to the device itself is preferred over the one assigned
+// to its DEP profile or enrollment profile.
+func (svc service) startWorkflow(udid string, dev *device.Device) error {
+	_, err := svc.workflows.RunByDevice(udid)
+	if err == nil {
+		return nil
+	}
+	if err != workflow.ErrNoRun {
+		return errors.Wrap(err, "starting workflow")
+	}
+	wfUUID := dev.Workflow
+	if wfUUID == "" {
+		wfUUID, err = svc.workflows.AssignedWorkflow(dev.DEPProfileUUID, dev.Enrollment)
+		if err != nil {
+			return errors.Wrap(err, "starting workflow")
+		}
+	}
+	if wfUUID == "" {
+		return nil
+	}
+	steps, err := svc.workflowSteps(wfUUID)
+	if err != nil {
+		return err
+	}
+	run := &workflow.Run{
+		DeviceUDID:   udid,
+		WorkflowUUID: wfUUID,
+		Status:       workflow.RunRunning,
+		StartedAt:    time.Now().UTC(),
+	}
+	return svc.queueNextStep(run, steps)
+}
+
+// continueWorkflow queues the next step of the workflow which waited for the command.
+func (svc service) continueWorkflow(commandUUID string) error {
+	run, err := svc.workflows.RunByCommand(commandUUID)
+	if err == workflow.ErrNoRun {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	steps, err := svc.workflowSteps(run.WorkflowUUID)
+	if err != nil {
+		return err
+	}
+	return svc.queueNextStep(run, steps)
+}
+
+// failWorkflow stops the workflow which waited for a failed command.
+func (svc service) failWorkflow(commandUUID string) error {
+	run, err := svc.workflows.RunByCommand(commandUUID)
+	if err == workflow.ErrNoRun {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	run.Status = workflow.RunFailed
+	return svc.workflows.SaveRun(run)
+}
+
+func (svc service) workflowSteps(wfUUID string) ([]workflow.Step, error) {
+	workflows, err := svc.workflows.Workflows(workflow.WrkflowUUID{UUID: wfUUID})
+	if err != nil {
+		return nil, errors.Wrap(err, "finding workflow steps")
+	}
+	if len(workflows) == 0 {
+		return nil, nil
+	}
+	return workflows[0].Steps, nil
+}
+
+// queueNextStep queues the next step of the run which the device matches,
+// or marks the run completed when there are no steps left.
+// A step which cannot be queued fails the run, so that the acknowledgement
+// of the previous command does not fail with it on every connect.
+func (svc service) queueNextStep(run *workflow.Run, steps []workflow.Step) error {
+	// conditions are evaluated against the device as it is when the step would run.
+	dev, err := svc.devices.GetDeviceByUDID(run.DeviceUDID,
+		"udid", "COALESCE(asset_tag, '') AS asset_tag",
+		"COALESCE(model, '') AS model",
+		"COALESCE(product_name, '') AS product_name",
+		"COALESCE(os_version, '') AS os_version",
+		"COALESCE(build_version, '') AS build_version",
+		"serial_number", "device_name", "enrollment",
+		"COALESCE(dep_profile_uuid, '') AS dep_profile_uuid",
+	)
+	if err != nil {
+		return errors.Wrap(err, "evaluating workflow step conditions")
+	}
+	step, ok := run.Next(steps, dev)
+	if !ok {
+		run.Status = workflow.RunCompleted
+		run.CommandUUID = ""
+		return svc.workflows.SaveRun(run)
+	}
+	payload, err := svc.queueStep(step, dev)
+	if err != nil {
+		svc.logger.Log("msg", "failing workflow run", "udid", run.DeviceUDID,
+			"workflow_uuid", run.WorkflowUUID, "position", step.Position, "err", err)
+		run.Status = workflow.RunFailed
+		run.CommandUUID = ""
+		return svc.workflows.SaveRun(run)
+	}
+	run.Position = step.Position
+	run.CommandUUID = payload.CommandUUID
+	if err := svc.workflows.SaveRun(run); err != nil {
+		// the run does not wait for the command, so a retry must not find it queued.
+		if _, delErr := svc.commands.DeleteQueuedCommand(run.DeviceUDID, payload.CommandUUID); delErr != nil {
+			svc.logger.Log("msg", "removing workflow step command", "udid", run.DeviceUDID,
+				"command_uuid", payload.CommandUUID, "err", delErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (svc service) queueStep(step workflow.Step, dev *device.Device) (*mdm.Payload, error) {
+	request, err := step.Request(dev)
+	if err != nil {
+		return nil, errors.Wrapf(err, "queueing workflow step %d", step.Position)
+	}
+	var opts []command.Option
+	if step.LibraryProfile != "" {
+		opts = append(opts, command.FromLibrary(step.LibraryProfile, 0))
+	}
+	payload, err := svc.commands.NewCommand(&request, opts...)
+	return payload, errors.Wrapf(err, "queueing workflow step %d", step.Position)
+}
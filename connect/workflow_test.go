@@ -0,0 +1,150 @@
+package connect
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/command"
+	"github.com/micromdm/micromdm/commandresult"
+	"github.com/micromdm/micromdm/device"
+	"github.com/micromdm/micromdm/workflow"
+	"golang.org/x/net/context"
+)
+
+const testUDID = "00000000-1111-2222-3333-444455556666"
+
+// fakeDevices returns dev for every UDID and keeps the last saved device.
+type fakeDevices struct {
+	device.Datastore
+	dev   *device.Device
+	saved *device.Device
+}
+
+func (f *fakeDevices) GetDeviceByUDID(udid string, fields ...string) (*device.Device, error) {
+	dev := *f.dev
+	return &dev, nil
+}
+
+func (f *fakeDevices) Save(msg string, dev *device.Device) error {
+	f.saved = dev
+	return nil
+}
+
+// fakeCommands holds a single queued payload.
+type fakeCommands struct {
+	command.Service
+	payload      *mdm.Payload
+	newErr       error
+	queued       []mdm.CommandRequest
+	acknowledged []string
+}
+
+func (f *fakeCommands) Acknowledged(commandUUID string) (bool, error) {
+	return false, nil
+}
+
+func (f *fakeCommands) Find(commandUUID string) (*mdm.Payload, error) {
+	return f.payload, nil
+}
+
+func (f *fakeCommands) NewCommand(request *mdm.CommandRequest, opts ...command.Option) (*mdm.Payload, error) {
+	if f.newErr != nil {
+		return nil, f.newErr
+	}
+	f.queued = append(f.queued, *request)
+	return mdm.NewPayload(request)
+}
+
+func (f *fakeCommands) AcknowledgeCommand(deviceUDID, commandUUID string) (int, error) {
+	f.acknowledged = append(f.acknowledged, commandUUID)
+	return 1, nil
+}
+
+type fakeResults struct{}
+
+func (fakeResults) Save(r *commandresult.Result) error { return nil }
+
+func (fakeResults) GetResultsByUDID(udid string, limit int) ([]commandresult.Result, error) {
+	return nil, nil
+}
+
+// fakeWorkflows runs a single workflow and keeps the saved run.
+type fakeWorkflows struct {
+	workflow.Datastore
+	wf  workflow.Workflow
+	run *workflow.Run
+}
+
+func (f *fakeWorkflows) RunByCommand(commandUUID string) (*workflow.Run, error) {
+	if f.run == nil || f.run.CommandUUID != commandUUID || f.run.Status != workflow.RunRunning {
+		return nil, workflow.ErrNoRun
+	}
+	run := *f.run
+	return &run, nil
+}
+
+func (f *fakeWorkflows) Workflows(params ...interface{}) ([]workflow.Workflow, error) {
+	return []workflow.Workflow{f.wf}, nil
+}
+
+func (f *fakeWorkflows) SaveRun(r *workflow.Run) error {
+	run := *r
+	f.run = &run
+	return nil
+}
+
+func TestAcknowledgeInvalidWorkflowStep(t *testing.T) {
+	template := "{{.Unknown}}"
+	var tests = []struct {
+		name   string
+		step   workflow.Step
+		newErr error
+	}{
+		{
+			name: "device name template",
+			step: workflow.Step{Position: 2, Command: mdm.CommandRequest{
+				RequestType: "Settings",
+				Settings:    mdm.Settings{Settings: []mdm.Setting{{Item: "DeviceName", DeviceName: &template}}},
+			}},
+		},
+		{
+			name:   "rejected command",
+			step:   workflow.Step{Position: 2, Command: mdm.CommandRequest{RequestType: "EnableRemoteDesktop"}},
+			newErr: command.PlatformError{RequestType: "EnableRemoteDesktop", Platform: device.PlatformIOS, Supported: []string{device.PlatformMacOS}},
+		},
+	}
+
+	for _, tt := range tests {
+		acked, err := mdm.NewPayload(&mdm.CommandRequest{UDID: testUDID, RequestType: "DeviceLock"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		devices := &fakeDevices{dev: &device.Device{UDID: device.JsonNullString{NullString: sql.NullString{String: testUDID, Valid: true}}}}
+		commands := &fakeCommands{payload: acked, newErr: tt.newErr}
+		workflows := &fakeWorkflows{
+			wf: workflow.Workflow{UUID: "wf", Steps: []workflow.Step{
+				{Position: 1, Command: mdm.CommandRequest{RequestType: "DeviceLock"}},
+				tt.step,
+			}},
+			run: &workflow.Run{DeviceUDID: testUDID, WorkflowUUID: "wf", Position: 1, CommandUUID: acked.CommandUUID, Status: workflow.RunRunning},
+		}
+		svc := NewService(devices, nil, nil, nil, nil, nil, fakeResults{}, workflows, commands, nil, nil, nil, log.NewNopLogger())
+
+		_, err = svc.Acknowledge(context.Background(), mdm.Response{UDID: testUDID, CommandUUID: acked.CommandUUID, Status: "Acknowledged"})
+		if err != nil {
+			t.Errorf("%s: acknowledging: %s", tt.name, err)
+			continue
+		}
+		if len(commands.acknowledged) != 1 || commands.acknowledged[0] != acked.CommandUUID {
+			t.Errorf("%s: expected the command to be acknowledged, got %v", tt.name, commands.acknowledged)
+		}
+		if len(commands.queued) != 0 {
+			t.Errorf("%s: expected no step to be queued, got %d", tt.name, len(commands.queued))
+		}
+		if workflows.run.Status != workflow.RunFailed {
+			t.Errorf("%s: expected run status %s, got %s", tt.name, workflow.RunFailed, workflows.run.Status)
+		}
+	}
+}
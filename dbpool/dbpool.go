@@ -0,0 +1,48 @@
+// Package dbpool configures the connection pools of the postgres datastores.
+package dbpool
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Config limits the connections of one datastore. Each datastore has its own pool,
+// so the server opens up to MaxOpenConns times the number of datastores.
+type Config struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// Default keeps the total number of connections of all datastores
+// well below the default max_connections of postgres.
+var Default = Config{
+	MaxOpenConns:    5,
+	MaxIdleConns:    2,
+	ConnMaxLifetime: 30 * time.Minute,
+}
+
+// Option changes the pool of a datastore after it is opened with the Default limits.
+type Option func(*sql.DB)
+
+// Limits applies c to the pool.
+func Limits(c Config) Option {
+	return func(db *sql.DB) {
+		c.Apply(db)
+	}
+}
+
+// Apply sets the pool limits on db.
+func (c Config) Apply(db *sql.DB) {
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+}
+
+// Configure applies the Default limits and then opts to db.
+func Configure(db *sql.DB, opts ...Option) {
+	Default.Apply(db)
+	for _, opt := range opts {
+		opt(db)
+	}
+}
@@ -0,0 +1,28 @@
+package dbpool
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq" // postgres driver
+)
+
+func TestConfigure(t *testing.T) {
+	// sql.Open does not connect, so no database is needed.
+	db, err := sql.Open("postgres", "host=localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	Configure(db)
+	if have := db.Stats().MaxOpenConnections; have != Default.MaxOpenConns {
+		t.Errorf("expected default of %d open connections, got %d", Default.MaxOpenConns, have)
+	}
+
+	Configure(db, Limits(Config{MaxOpenConns: 20, MaxIdleConns: 5, ConnMaxLifetime: time.Minute}))
+	if have := db.Stats().MaxOpenConnections; have != 20 {
+		t.Errorf("expected 20 open connections, got %d", have)
+	}
+}
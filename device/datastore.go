@@ -1,13 +1,16 @@
 package device
 
 import (
+	"database/sql"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq" // postgres driver
+	"github.com/micromdm/micromdm/dbpool"
 	"github.com/pkg/errors"
 )
 
@@ -25,9 +28,10 @@ var (
 	dep_profile_assigned_date,
 	dep_profile_assigned_by,
 	dep_device,
-	last_checkin
+	last_checkin,
+	dep_account
 	) 
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 	ON CONFLICT (serial_number)
 	DO UPDATE SET
 	model = $2,
@@ -41,7 +45,8 @@ var (
 	dep_profile_assigned_date = $10,
 	dep_profile_assigned_by = $11,
 	dep_device = $12,
-	last_checkin = $13
+	last_checkin = $13,
+	dep_account = $14
 	RETURNING device_uuid;`
 
 	authenticateMDM = `INSERT INTO devices (
@@ -54,9 +59,11 @@ var (
 	imei,
 	meid,
 	model,
-	last_checkin
+	last_checkin,
+	enrollment,
+	platform
 	)
-    VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+    VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
     ON CONFLICT (serial_number)
     DO UPDATE SET
     	udid=$1,
@@ -68,7 +75,10 @@ var (
     imei=$7,
     meid=$8,
     model=$9,
-    last_checkin=$10
+    last_checkin=$10,
+    enrollment=$11,
+    platform=COALESCE(NULLIF($12, ''), devices.platform),
+    deleted_at=NULL
 	RETURNING device_uuid;`
 
 	selectDevicesStmt = `SELECT
@@ -77,21 +87,75 @@ var (
 	serial_number,
 	os_version,
 	product_name,
-	last_checkin,
+	platform,
+	COALESCE(last_checkin, '0001-01-01 00:00:00') AS last_checkin,
 	dep_profile_status,
+	dep_account,
+	enrollment,
 	model,
 	workflow_uuid,
-	device_name
+	device_name,
+	lost_mode,
+	latitude,
+	longitude,
+	location_updated_at,
+	deleted_at
 	FROM devices`
 )
 
 // Datastore manages devices in a database
 type Datastore interface {
 	New(src string, d *Device) (string, error)
+	// GetDeviceByUDID returns sql.ErrNoRows for a deleted device, until it enrolls again.
 	GetDeviceByUDID(udid string, fields ...string) (*Device, error)
 	GetDeviceByUUID(uuid string, fields ...string) (*Device, error)
+	// Devices returns the devices matching any of the filters which select a device,
+	// like UDID or SerialNumber, and all of the conditions, like LastCheckinBefore.
 	Devices(params ...interface{}) ([]Device, error)
+	DeviceCount(params ...interface{}) (int, error)
+	Search(query string) ([]Device, error)
 	Save(msg string, dev *Device) error
+
+	// DEPCursor returns the cursor of the last device fetch or sync of a DEP account,
+	// or an empty string if devices were never fetched. fetching is true if the cursor
+	// belongs to a full fetch which did not finish.
+	DEPCursor(account string) (cursor string, fetching bool, err error)
+	SaveDEPCursor(account, cursor string, fetching bool) error
+
+	// MarkPushPending records that a push to the device with the token could not be
+	// delivered. ClearPushPending removes the marker once a push was sent.
+	MarkPushPending(token string) error
+	ClearPushPending(token string) error
+
+	// Delete removes a device from management. The record is kept with a deleted_at time
+	// and no longer returned by Devices, unless IncludeDeleted is passed.
+	Delete(uuid string) error
+
+	// UpdateLastCheckin sets the last checkin time of the device with the UDID
+	// and clears an undelivered push, the device connected.
+	UpdateLastCheckin(udid string, at time.Time) error
+
+	// duplicates
+	Duplicates() ([]Duplicate, error)
+	MergeDuplicate(d Duplicate) error
+
+	// groups
+	CreateGroup(g *Group) (*Group, error)
+	Groups(params ...interface{}) ([]Group, error)
+	UpdateGroup(g *Group) (*Group, error)
+	DeleteGroup(uuid string) error
+	AddGroupDevice(groupUUID, deviceUUID string) error
+	RemoveGroupDevice(groupUUID, deviceUUID string) error
+
+	// users
+	SaveUser(u *User) error
+	User(deviceUDID, userID string) (*User, error)
+
+	// SaveDeclarationStatus stores the declaration status reported by a device,
+	// replacing the earlier status of the same declarations.
+	SaveDeclarationStatus(statuses []DeclarationStatus) error
+	// DeleteDeclarationStatus deletes the declaration status of a device.
+	DeleteDeclarationStatus(deviceUDID string) error
 }
 
 // UUID is a filter that can be added as a parameter to narrow down the list of returned results
@@ -99,8 +163,8 @@ type UUID struct {
 	UUID string
 }
 
-func (p UUID) where() string {
-	return fmt.Sprintf("device_uuid = '%s'", p.UUID)
+func (p UUID) where() (string, []interface{}) {
+	return "device_uuid = ?", []interface{}{p.UUID}
 }
 
 // SerialNumber is a filter
@@ -108,8 +172,8 @@ type SerialNumber struct {
 	SerialNumber string
 }
 
-func (p SerialNumber) where() string {
-	return fmt.Sprintf("serial_number = '%s'", p.SerialNumber)
+func (p SerialNumber) where() (string, []interface{}) {
+	return "serial_number = ?", []interface{}{NormalizeSerial(p.SerialNumber)}
 }
 
 // UDID is a filter
@@ -117,29 +181,124 @@ type UDID struct {
 	UDID string
 }
 
-func (p UDID) where() string {
-	return fmt.Sprintf("udid = '%s'", p.UDID)
+func (p UDID) where() (string, []interface{}) {
+	return "udid = ?", []interface{}{p.UDID}
+}
+
+// PushToken is a filter which matches the hex encoded APNs token of a device
+type PushToken struct {
+	Token string
+}
+
+func (p PushToken) where() (string, []interface{}) {
+	return "apple_mdm_token = ?", []interface{}{p.Token}
+}
+
+// Enrolled is a filter which matches devices currently enrolled in MDM
+type Enrolled struct{}
+
+func (p Enrolled) where() (string, []interface{}) {
+	return "mdm_enrolled = true", nil
+}
+
+// PushPending is a filter which matches devices with an undelivered push notification
+type PushPending struct{}
+
+func (p PushPending) where() (string, []interface{}) {
+	return "push_pending_at IS NOT NULL", nil
+}
+
+// OSVersionLessThan is a filter which matches devices reporting an OS version
+// older than Version. Versions are compared component-wise, so "9.3.5" is
+// older than "10.0" and "10.0" is older than "10.0.1". Missing components count
+// as zero, so "10" is not older than "10.0".
+// Devices without a numeric os_version never match.
+// It narrows the devices matched by the other filters.
+type OSVersionLessThan struct {
+	Version string
+}
+
+func (p OSVersionLessThan) condition() (string, []interface{}) {
+	components, ok := versionComponents(p.Version)
+	if !ok {
+		return "FALSE", nil
+	}
+	// CASE guarantees the int[] cast only runs on well formed versions.
+	// Trailing zero components are dropped on both sides, an int[] with fewer
+	// components is otherwise smaller.
+	return fmt.Sprintf(
+		`CASE WHEN os_version ~ '^[0-9]+(\.[0-9]+)*$' THEN string_to_array(regexp_replace(os_version, '(\.0+)+$', ''), '.')::int[] < ARRAY[%s]::int[] ELSE FALSE END`,
+		strings.Join(components, ","),
+	), nil
+}
+
+// versionComponents splits a dotted version string into its numeric components,
+// without trailing zero components.
+func versionComponents(version string) ([]string, bool) {
+	parts := strings.Split(strings.TrimSpace(version), ".")
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		parts[i] = strconv.Itoa(n)
+	}
+	for len(parts) > 1 && parts[len(parts)-1] == "0" {
+		parts = parts[:len(parts)-1]
+	}
+	return parts, true
 }
 
+// LastCheckinBefore is a filter which matches devices that have not checked in
+// since the given time, including devices which never checked in: their last_checkin
+// is NULL, or the zero time which is the column default.
+// Results are ordered by last_checkin, oldest first.
+// It narrows the devices matched by the other filters.
+type LastCheckinBefore time.Time
+
+func (p LastCheckinBefore) condition() (string, []interface{}) {
+	return "(last_checkin IS NULL OR last_checkin < ?)", []interface{}{time.Time(p).UTC()}
+}
+
+func (p LastCheckinBefore) orderBy() string {
+	return "last_checkin ASC NULLS FIRST"
+}
+
+// Limit restricts the number of devices returned.
+// Results are ordered by device_uuid so that pages are stable.
+type Limit int
+
+func (p Limit) orderBy() string {
+	return "device_uuid"
+}
+
+// Offset skips the given number of devices, used with Limit to paginate results.
+type Offset int
+
+// IncludeDeleted returns deleted devices along with the others.
+type IncludeDeleted struct{}
+
 type pgStore struct {
 	*sqlx.DB
+	// stmts caches the prepared statements of GetDeviceByUDID and GetDeviceByUUID,
+	// which run on every checkin and connect request.
+	stmts *stmtCache
 }
 
 func (store pgStore) GetDeviceByUDID(udid string, fields ...string) (*Device, error) {
-	var device Device
 	s := strings.Join(fields, ", ")
-	query := `SELECT ` + s + ` FROM devices WHERE udid=$1 LIMIT 1`
-	return &device, sqlx.Get(store, &device, query, udid)
+	query := `SELECT ` + s + ` FROM devices WHERE udid=$1 AND deleted_at IS NULL LIMIT 1`
+	return store.getDevice(query, udid)
 }
 
 func (store pgStore) GetDeviceByUUID(uuid string, fields ...string) (*Device, error) {
-	var device Device
 	s := strings.Join(fields, ", ")
 	query := `SELECT ` + s + ` FROM devices WHERE device_uuid=$1 LIMIT 1`
-	return &device, sqlx.Get(store, &device, query, uuid)
+	return store.getDevice(query, uuid)
 }
 
 func (store pgStore) New(src string, d *Device) (string, error) {
+	d.SerialNumber.String = NormalizeSerial(d.SerialNumber.String)
 	switch src {
 	case "fetch":
 		err := store.QueryRow(
@@ -157,6 +316,7 @@ func (store pgStore) New(src string, d *Device) (string, error) {
 			d.DEPProfileAssignedBy,
 			true,
 			time.Time{},
+			d.DEPAccount,
 		).Scan(&d.UUID)
 		if err != nil {
 			return "", err
@@ -175,6 +335,8 @@ func (store pgStore) New(src string, d *Device) (string, error) {
 			d.MEID,
 			d.Model,
 			d.LastCheckin,
+			d.Enrollment,
+			d.Platform,
 		).Scan(&d.UUID)
 		if err != nil {
 			return "", err
@@ -186,17 +348,118 @@ func (store pgStore) New(src string, d *Device) (string, error) {
 }
 
 func (store pgStore) Devices(params ...interface{}) ([]Device, error) {
-	stmt := selectDevicesStmt
-	stmt = addWhereFilters(stmt, "OR", params...)
+	stmt, args := addDeviceFilters(selectDevicesStmt, params...)
+	stmt = addOrderBy(stmt, params...)
+	stmt = addLimitOffset(stmt, params...)
 	var devices []Device
-	err := store.Select(&devices, stmt)
+	err := store.Select(&devices, store.Rebind(stmt), args...)
 	if err != nil {
 		return nil, errors.Wrap(err, "pgStore Devices")
 	}
 	return devices, nil
 }
 
+// searchLimit caps the number of devices returned by Search.
+const searchLimit = 100
+
+// Search returns devices with a serial number, device name, UDID, product name or model
+// containing query, ignoring case. An exact serial number match is returned first,
+// followed by serial numbers starting with query.
+func (store pgStore) Search(query string) ([]Device, error) {
+	escaped := likeEscaper.Replace(strings.TrimSpace(query))
+	stmt := selectDevicesStmt + `
+	WHERE deleted_at IS NULL
+	AND (serial_number ILIKE $1
+	OR device_name ILIKE $1
+	OR udid ILIKE $1
+	OR product_name ILIKE $1
+	OR model ILIKE $1)
+	ORDER BY
+	CASE
+		WHEN serial_number ILIKE $2 THEN 0
+		WHEN serial_number ILIKE $3 THEN 1
+		ELSE 2
+	END,
+	serial_number
+	LIMIT $4`
+	var devices []Device
+	err := store.Select(&devices, stmt, "%"+escaped+"%", escaped, escaped+"%", searchLimit)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore Search")
+	}
+	return devices, nil
+}
+
+// likeEscaper escapes the LIKE pattern characters in user input.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// DeviceCount returns the number of devices matching the filters, ignoring Limit and Offset.
+func (store pgStore) DeviceCount(params ...interface{}) (int, error) {
+	stmt, args := addDeviceFilters("SELECT COUNT(*) FROM devices", params...)
+	var count int
+	if err := store.Get(&count, store.Rebind(stmt), args...); err != nil {
+		return 0, errors.Wrap(err, "pgStore DeviceCount")
+	}
+	return count, nil
+}
+
+func (store pgStore) DEPCursor(account string) (string, bool, error) {
+	var row struct {
+		Cursor   string `db:"cursor"`
+		Fetching bool   `db:"fetching"`
+	}
+	err := store.Get(&row, `SELECT cursor, fetching FROM dep_sync_cursor WHERE account = $1`, account)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.Wrap(err, "pgStore DEPCursor")
+	}
+	return row.Cursor, row.Fetching, nil
+}
+
+func (store pgStore) SaveDEPCursor(account, cursor string, fetching bool) error {
+	_, err := store.Exec(`INSERT INTO dep_sync_cursor (account, cursor, fetching, updated_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (account)
+	DO UPDATE SET cursor = $2, fetching = $3, updated_at = $4`, account, cursor, fetching, time.Now().UTC())
+	return errors.Wrap(err, "pgStore SaveDEPCursor")
+}
+
+func (store pgStore) MarkPushPending(token string) error {
+	_, err := store.Exec(`UPDATE devices SET push_pending_at = $2
+	WHERE apple_mdm_token = $1 AND push_pending_at IS NULL`, token, time.Now().UTC())
+	return errors.Wrap(err, "pgStore MarkPushPending")
+}
+
+func (store pgStore) ClearPushPending(token string) error {
+	_, err := store.Exec(`UPDATE devices SET push_pending_at = NULL
+	WHERE apple_mdm_token = $1 AND push_pending_at IS NOT NULL`, token)
+	return errors.Wrap(err, "pgStore ClearPushPending")
+}
+
+func (store pgStore) Delete(uuid string) error {
+	res, err := store.Exec(`UPDATE devices SET deleted_at = $2 WHERE device_uuid = $1 AND deleted_at IS NULL`,
+		uuid, time.Now().UTC())
+	if err != nil {
+		return errors.Wrap(err, "pgStore Delete")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	_, err = store.Exec(`DELETE FROM device_declarations WHERE device_udid = (
+		SELECT udid FROM devices WHERE device_uuid = $1
+	)`, uuid)
+	return errors.Wrap(err, "pgStore Delete")
+}
+
+func (store pgStore) UpdateLastCheckin(udid string, at time.Time) error {
+	_, err := store.Exec(`UPDATE devices SET last_checkin = $2, push_pending_at = NULL WHERE udid = $1`, udid, at.UTC())
+	return errors.Wrap(err, "pgStore UpdateLastCheckin")
+}
+
 func (store pgStore) Save(msg string, dev *Device) error {
+	dev.SerialNumber.String = NormalizeSerial(dev.SerialNumber.String)
 	var stmt string
 	switch msg {
 	case "assignWorkflow":
@@ -209,12 +472,23 @@ func (store pgStore) Save(msg string, dev *Device) error {
 		apple_push_magic=:apple_push_magic,
 		apple_mdm_token=:apple_mdm_token,
 		mdm_enrolled=:mdm_enrolled,
-		unlock_token=:unlock_token,
 		last_checkin=:last_checkin
 		WHERE device_uuid=:device_uuid`
+	case "deviceConfigured":
+		stmt = `UPDATE devices SET
+		awaiting_configuration=false
+		WHERE device_uuid=:device_uuid`
+	case "unlockToken":
+		stmt = `UPDATE devices SET
+		unlock_token=:unlock_token
+		WHERE device_uuid=:device_uuid`
 	case "checkout":
+		// clear the push token so that the device is no longer sent notifications
 		stmt = `UPDATE devices SET
-		mdm_enrolled=:mdm_enrolled
+		mdm_enrolled=:mdm_enrolled,
+		checked_out_at=:checked_out_at,
+		apple_mdm_token='',
+		apple_push_magic=''
 		WHERE device_uuid=:device_uuid`
 	case "queryResponses":
 		stmt = `UPDATE devices SET
@@ -223,12 +497,56 @@ func (store pgStore) Save(msg string, dev *Device) error {
 		serial_number=:serial_number,
 		model=:model,
 		product_name=:product_name,
+		platform=:platform,
 		imei=:imei,
 		meid=:meid,
 		os_version=:os_version,
 		build_version=:build_version,
 		last_checkin=:last_checkin
 		WHERE device_uuid=:device_uuid`
+	case "securityInfo":
+		stmt = `UPDATE devices SET
+		hardware_encryption_caps=:hardware_encryption_caps,
+		passcode_present=:passcode_present,
+		passcode_compliant=:passcode_compliant,
+		fde_enabled=:fde_enabled,
+		firewall_settings=:firewall_settings
+		WHERE device_uuid=:device_uuid`
+	case "lastQueryResponse":
+		stmt = `UPDATE devices SET
+		last_query_response=:last_query_response
+		WHERE device_uuid=:device_uuid`
+	case "activationLockBypassCode":
+		stmt = `UPDATE devices SET
+		activation_lock_bypass_code=:activation_lock_bypass_code
+		WHERE device_uuid=:device_uuid`
+	case "deviceName":
+		stmt = `UPDATE devices SET
+		device_name=:device_name
+		WHERE device_uuid=:device_uuid`
+	case "lostMode":
+		stmt = `UPDATE devices SET
+		lost_mode=:lost_mode
+		WHERE device_uuid=:device_uuid`
+	case "location":
+		stmt = `UPDATE devices SET
+		latitude=:latitude,
+		longitude=:longitude,
+		location_updated_at=:location_updated_at
+		WHERE device_uuid=:device_uuid`
+	case "depProfile":
+		// DEP devices are known by serial number before they enroll
+		stmt = `UPDATE devices SET
+		dep_profile_status=:dep_profile_status,
+		dep_profile_uuid=:dep_profile_uuid,
+		dep_profile_assign_time=:dep_profile_assign_time
+		WHERE serial_number=:serial_number`
+	case "depRemoved":
+		// the device was removed from the DEP account
+		stmt = `UPDATE devices SET
+		dep_device=false,
+		dep_profile_status=:dep_profile_status
+		WHERE serial_number=:serial_number`
 	default:
 		return errors.New("device: unsupported update msg")
 	}
@@ -236,17 +554,21 @@ func (store pgStore) Save(msg string, dev *Device) error {
 	return err
 }
 
-// whereer is for building args passed into a method which finds resources
+// whereer is for building args passed into a method which finds resources.
+// Values are passed as bind parameters, written as ? in the clause.
 type whereer interface {
-	where() string
+	where() (string, []interface{})
 }
 
-// add WHERE clause from params
-func addWhereFilters(stmt string, separator string, params ...interface{}) string {
+// add WHERE clause from params, and return the bind parameters of the clause
+func addWhereFilters(stmt string, separator string, params ...interface{}) (string, []interface{}) {
 	var where []string
+	var args []interface{}
 	for _, param := range params {
 		if f, ok := param.(whereer); ok {
-			where = append(where, f.where())
+			clause, clauseArgs := f.where()
+			where = append(where, clause)
+			args = append(args, clauseArgs...)
 		}
 	}
 
@@ -254,17 +576,99 @@ func addWhereFilters(stmt string, separator string, params ...interface{}) strin
 		whereFilter := strings.Join(where, " "+separator+" ")
 		stmt = fmt.Sprintf("%s WHERE %s", stmt, whereFilter)
 	}
+	return stmt, args
+}
+
+// conditioner is implemented by device filters which every returned device must match.
+// Values are passed as bind parameters, written as ? in the clause.
+type conditioner interface {
+	condition() (string, []interface{})
+}
+
+// addDeviceFilters adds a WHERE clause matching any of the where filters in params,
+// which select devices by identity, and all of the condition filters, like LastCheckinBefore.
+// Deleted devices are left out unless IncludeDeleted is one of the params.
+func addDeviceFilters(stmt string, params ...interface{}) (string, []interface{}) {
+	var where, conditions []string
+	var args, conditionArgs []interface{}
+	includeDeleted := false
+	for _, param := range params {
+		if _, ok := param.(IncludeDeleted); ok {
+			includeDeleted = true
+		}
+		if f, ok := param.(whereer); ok {
+			clause, clauseArgs := f.where()
+			where = append(where, clause)
+			args = append(args, clauseArgs...)
+		}
+		if f, ok := param.(conditioner); ok {
+			clause, clauseArgs := f.condition()
+			conditions = append(conditions, "("+clause+")")
+			conditionArgs = append(conditionArgs, clauseArgs...)
+		}
+	}
+
+	var clauses []string
+	if len(where) != 0 {
+		clauses = append(clauses, "("+strings.Join(where, " OR ")+")")
+	}
+	clauses = append(clauses, conditions...)
+	args = append(args, conditionArgs...)
+	if !includeDeleted {
+		clauses = append(clauses, "deleted_at IS NULL")
+	}
+	if len(clauses) != 0 {
+		stmt = fmt.Sprintf("%s WHERE %s", stmt, strings.Join(clauses, " AND "))
+	}
+	return stmt, args
+}
+
+// orderer is implemented by filters which also dictate the sort order of results
+type orderer interface {
+	orderBy() string
+}
+
+// add ORDER BY clause from params
+func addOrderBy(stmt string, params ...interface{}) string {
+	var order []string
+	for _, param := range params {
+		if f, ok := param.(orderer); ok {
+			order = append(order, f.orderBy())
+		}
+	}
+
+	if len(order) != 0 {
+		stmt = fmt.Sprintf("%s ORDER BY %s", stmt, strings.Join(order, ", "))
+	}
+	return stmt
+}
+
+// add LIMIT and OFFSET clauses from params
+func addLimitOffset(stmt string, params ...interface{}) string {
+	for _, param := range params {
+		switch p := param.(type) {
+		case Limit:
+			if p >= 0 {
+				stmt = fmt.Sprintf("%s LIMIT %d", stmt, p)
+			}
+		case Offset:
+			if p > 0 {
+				stmt = fmt.Sprintf("%s OFFSET %d", stmt, p)
+			}
+		}
+	}
 	return stmt
 }
 
 //NewDB creates a Datastore
-func NewDB(driver, conn string, logger kitlog.Logger) (Datastore, error) {
+func NewDB(driver, conn string, logger kitlog.Logger, opts ...dbpool.Option) (Datastore, error) {
 	switch driver {
 	case "postgres":
 		db, err := sqlx.Open(driver, conn)
 		if err != nil {
 			return nil, errors.Wrap(err, "device datastore")
 		}
+		dbpool.Configure(db.DB, opts...)
 		var dbError error
 		maxAttempts := 20
 		for attempts := 1; attempts <= maxAttempts; attempts++ {
@@ -278,7 +682,7 @@ func NewDB(driver, conn string, logger kitlog.Logger) (Datastore, error) {
 		if dbError != nil {
 			return nil, errors.Wrap(dbError, "device datastore")
 		}
-		return pgStore{DB: db}, nil
+		return pgStore{DB: db, stmts: newStmtCache()}, nil
 	default:
 		return nil, errors.New("unknown driver")
 	}
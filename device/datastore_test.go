@@ -1,6 +1,7 @@
 package device
 
 import (
+	"database/sql"
 	"os"
 	"testing"
 	"time"
@@ -30,6 +31,9 @@ func TestSaveTokenUpdate(t *testing.T) {
 }
 
 func TestNewDB(t *testing.T) {
+	if testConn == "" {
+		t.Skip("set MICROMDM_TEST_DB to a migrated postgres database to run the test")
+	}
 	defer teardown()
 	logger := log.NewLogfmtLogger(os.Stderr)
 	_, err := NewDB("postgres", testConn, logger)
@@ -65,7 +69,7 @@ func addTestDevices(t *testing.T, ds Datastore) []Device {
 	}{
 		{
 			Device{
-				SerialNumber: "DEADBEEF123A",
+				SerialNumber: nullString("DEADBEEF123A"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "red",
@@ -73,7 +77,7 @@ func addTestDevices(t *testing.T, ds Datastore) []Device {
 		},
 		{
 			Device{
-				SerialNumber: "DEADBEEF123A",
+				SerialNumber: nullString("DEADBEEF123A"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "red",
@@ -81,7 +85,7 @@ func addTestDevices(t *testing.T, ds Datastore) []Device {
 		},
 		{
 			Device{
-				SerialNumber: "DEADBEEF123B",
+				SerialNumber: nullString("DEADBEEF123B"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "blue",
@@ -89,12 +93,12 @@ func addTestDevices(t *testing.T, ds Datastore) []Device {
 		},
 		{
 			Device{
-				SerialNumber:         "DEADBEEF123C",
+				SerialNumber:         nullString("DEADBEEF123C"),
 				Model:                "iPad",
 				Description:          "It's a tablet",
 				Color:                "pink",
 				AssetTag:             "foo",
-				DEPProfileAssignTime: &now,
+				DEPProfileAssignTime: now,
 			},
 		},
 	}
@@ -127,7 +131,7 @@ func TestInsertFetch(t *testing.T) {
 	}{
 		{
 			Device{
-				SerialNumber: "DEADBEEF123A",
+				SerialNumber: nullString("DEADBEEF123A"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "red",
@@ -135,7 +139,7 @@ func TestInsertFetch(t *testing.T) {
 		},
 		{
 			Device{
-				SerialNumber: "DEADBEEF123A",
+				SerialNumber: nullString("DEADBEEF123A"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "red",
@@ -143,7 +147,7 @@ func TestInsertFetch(t *testing.T) {
 		},
 		{
 			Device{
-				SerialNumber: "DEADBEEF123B",
+				SerialNumber: nullString("DEADBEEF123B"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "blue",
@@ -151,12 +155,12 @@ func TestInsertFetch(t *testing.T) {
 		},
 		{
 			Device{
-				SerialNumber:         "DEADBEEF123C",
+				SerialNumber:         nullString("DEADBEEF123C"),
 				Model:                "iPad",
 				Description:          "It's a tablet",
 				Color:                "pink",
 				AssetTag:             "foo",
-				DEPProfileAssignTime: &now,
+				DEPProfileAssignTime: now,
 			},
 		},
 	}
@@ -183,7 +187,7 @@ func TestInsertAuthenticate(t *testing.T) {
 	}{
 		{
 			Device{
-				SerialNumber: "DEADBEEF123A",
+				SerialNumber: nullString("DEADBEEF123A"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "red",
@@ -191,7 +195,7 @@ func TestInsertAuthenticate(t *testing.T) {
 		},
 		{
 			Device{
-				SerialNumber: "DEADBEEF123A",
+				SerialNumber: nullString("DEADBEEF123A"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "red",
@@ -199,7 +203,7 @@ func TestInsertAuthenticate(t *testing.T) {
 		},
 		{
 			Device{
-				SerialNumber: "DEADBEEF123B",
+				SerialNumber: nullString("DEADBEEF123B"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "blue",
@@ -207,8 +211,8 @@ func TestInsertAuthenticate(t *testing.T) {
 		},
 		{
 			Device{
-				UDID:         "581ddbee-7742-4472-aadd-6d2ad35c4470",
-				SerialNumber: "DEADBEEF123B",
+				UDID:         nullString("581ddbee-7742-4472-aadd-6d2ad35c4470"),
+				SerialNumber: nullString("DEADBEEF123B"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "blue",
@@ -216,8 +220,8 @@ func TestInsertAuthenticate(t *testing.T) {
 		},
 		{
 			Device{
-				UDID:         "581ddbee-7742-4472-aadd-6d2ad35c4470",
-				SerialNumber: "DEADBEEF123B",
+				UDID:         nullString("581ddbee-7742-4472-aadd-6d2ad35c4470"),
+				SerialNumber: nullString("DEADBEEF123B"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "blue",
@@ -225,12 +229,12 @@ func TestInsertAuthenticate(t *testing.T) {
 		},
 		{
 			Device{
-				SerialNumber:         "DEADBEEF123C",
+				SerialNumber:         nullString("DEADBEEF123C"),
 				Model:                "iPad",
 				Description:          "It's a tablet",
 				Color:                "pink",
 				AssetTag:             "foo",
-				DEPProfileAssignTime: &now,
+				DEPProfileAssignTime: now,
 			},
 		},
 	}
@@ -256,8 +260,8 @@ func TestGetDeviceByUDID(t *testing.T) {
 	}{
 		{
 			Device{
-				UDID:         "581ddbee-7742-4472-aadd-6d2ad35c4471",
-				SerialNumber: "DEADBEEF123A",
+				UDID:         nullString("581ddbee-7742-4472-aadd-6d2ad35c4471"),
+				SerialNumber: nullString("DEADBEEF123A"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "red",
@@ -265,8 +269,8 @@ func TestGetDeviceByUDID(t *testing.T) {
 		},
 		{
 			Device{
-				UDID:         "581ddbee-7742-4472-aadd-6d2ad35c4471",
-				SerialNumber: "DEADBEEF123A",
+				UDID:         nullString("581ddbee-7742-4472-aadd-6d2ad35c4471"),
+				SerialNumber: nullString("DEADBEEF123A"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "red",
@@ -274,8 +278,8 @@ func TestGetDeviceByUDID(t *testing.T) {
 		},
 		{
 			Device{
-				UDID:         "581ddbee-7742-4472-aadd-6d2ad35c4470",
-				SerialNumber: "DEADBEEF123B",
+				UDID:         nullString("581ddbee-7742-4472-aadd-6d2ad35c4470"),
+				SerialNumber: nullString("DEADBEEF123B"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "blue",
@@ -283,8 +287,8 @@ func TestGetDeviceByUDID(t *testing.T) {
 		},
 		{
 			Device{
-				UDID:         "581ddbee-7742-4472-aadd-6d2ad35c4470",
-				SerialNumber: "DEADBEEF123B",
+				UDID:         nullString("581ddbee-7742-4472-aadd-6d2ad35c4470"),
+				SerialNumber: nullString("DEADBEEF123B"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "blue",
@@ -292,8 +296,8 @@ func TestGetDeviceByUDID(t *testing.T) {
 		},
 		{
 			Device{
-				UDID:         "581ddbee-7742-4472-aadd-6d2ad35c4470",
-				SerialNumber: "DEADBEEF123B",
+				UDID:         nullString("581ddbee-7742-4472-aadd-6d2ad35c4470"),
+				SerialNumber: nullString("DEADBEEF123B"),
 				Model:        "Macbook",
 				Description:  "It's a laptop",
 				Color:        "blue",
@@ -301,13 +305,13 @@ func TestGetDeviceByUDID(t *testing.T) {
 		},
 		{
 			Device{
-				UDID:                 "581ddbee-7742-4472-aadd-6d2ad35c4472",
-				SerialNumber:         "DEADBEEF123C",
+				UDID:                 nullString("581ddbee-7742-4472-aadd-6d2ad35c4472"),
+				SerialNumber:         nullString("DEADBEEF123C"),
 				Model:                "iPad",
 				Description:          "It's a tablet",
 				Color:                "pink",
 				AssetTag:             "foo",
-				DEPProfileAssignTime: &now,
+				DEPProfileAssignTime: now,
 			},
 		},
 	}
@@ -321,24 +325,31 @@ func TestGetDeviceByUDID(t *testing.T) {
 		if len(uuid) != 36 {
 			t.Errorf("newdevice get device by udid: expected uuid got %q", uuid)
 		}
-		d, err := ds.GetDeviceByUDID(tt.in.UDID, "device_uuid", "udid", "serial_number")
+		d, err := ds.GetDeviceByUDID(tt.in.UDID.String, "device_uuid", "udid", "serial_number")
 		if err != nil {
 			t.Log("get failed at", tt.in.SerialNumber)
 			t.Fatal(err)
 		}
 		if d.SerialNumber != tt.in.SerialNumber {
-			t.Errorf("get device by udid: expected %q got %q", tt.in.SerialNumber, d.SerialNumber)
+			t.Errorf("get device by udid: expected %q got %q", tt.in.SerialNumber.String, d.SerialNumber.String)
 		}
 	}
 
 }
 
 var (
-	testConn = "user=micromdm password=micromdm dbname=micromdm sslmode=disable"
+	// testConn is the migrated postgres database the tests run against, see migratedStore.
+	testConn = os.Getenv("MICROMDM_TEST_DB")
 )
 
+func nullString(s string) JsonNullString {
+	return JsonNullString{sql.NullString{String: s, Valid: true}}
+}
+
 func datastore(t *testing.T) Datastore {
-	//setup()
+	if testConn == "" {
+		t.Skip("set MICROMDM_TEST_DB to a migrated postgres database to run the test")
+	}
 	logger := log.NewLogfmtLogger(os.Stderr)
 	ds, err := NewDB("postgres", testConn, logger)
 	if err != nil {
@@ -347,27 +358,15 @@ func datastore(t *testing.T) Datastore {
 	return ds
 }
 
-func setup() {
-	db, err := sqlx.Open("postgres", testConn)
-	if err != nil {
-		panic(err)
-	}
-	migrate(db)
-	defer db.Close()
-}
-
+// teardown deletes the devices. The tables belong to the migrations, so they are kept.
 func teardown() {
+	if testConn == "" {
+		return
+	}
 	db, err := sqlx.Open("postgres", testConn)
 	if err != nil {
 		panic(err)
 	}
-
-	drop := `
-	DROP TABLE IF EXISTS device_workflow;
-	DROP TABLE IF EXISTS devices;
-	DROP INDEX IF EXISTS devices.serial_idx;
-	DROP INDEX IF EXISTS devices.udid_idx;
-	`
-	db.MustExec(drop)
+	db.MustExec(`DELETE FROM devices`)
 	defer db.Close()
 }
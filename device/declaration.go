@@ -0,0 +1,26 @@
+package device
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Kinds of declarations in a declarative management status report.
+const (
+	DeclarationActivation    = "activation"
+	DeclarationConfiguration = "configuration"
+	DeclarationAsset         = "asset"
+	DeclarationManagement    = "management"
+)
+
+// DeclarationStatus is the status of a declaration as last reported by a device.
+type DeclarationStatus struct {
+	DeviceUDID  string          `json:"device_udid" db:"device_udid"`
+	Identifier  string          `json:"identifier" db:"identifier"`
+	Kind        string          `json:"kind" db:"kind"`
+	Active      bool            `json:"active" db:"active"`
+	Valid       string          `json:"valid" db:"valid"`
+	ServerToken string          `json:"server_token" db:"server_token"`
+	Reasons     json.RawMessage `json:"reasons,omitempty" db:"reasons"`
+	UpdatedAt   time.Time       `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,37 @@
+package device
+
+import (
+	"github.com/pkg/errors"
+)
+
+var saveDeclarationStatusStmt = `INSERT INTO device_declarations (device_udid, identifier, kind,
+				 active, valid, server_token, reasons, updated_at)
+				 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+				 ON CONFLICT (device_udid, identifier) DO UPDATE SET
+				 kind = $3, active = $4, valid = $5, server_token = $6, reasons = $7, updated_at = $8;`
+
+func (store pgStore) SaveDeclarationStatus(statuses []DeclarationStatus) error {
+	tx, err := store.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "pgStore save declaration status")
+	}
+	for _, s := range statuses {
+		// reasons is a jsonb column, store NULL rather than an empty document
+		var reasons interface{}
+		if len(s.Reasons) > 0 {
+			reasons = []byte(s.Reasons)
+		}
+		_, err := tx.Exec(saveDeclarationStatusStmt, s.DeviceUDID, s.Identifier, s.Kind,
+			s.Active, s.Valid, s.ServerToken, reasons, s.UpdatedAt)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "pgStore save declaration status")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "pgStore save declaration status")
+}
+
+func (store pgStore) DeleteDeclarationStatus(deviceUDID string) error {
+	_, err := store.Exec(`DELETE FROM device_declarations WHERE device_udid = $1`, deviceUDID)
+	return errors.Wrap(err, "pgStore delete declaration status")
+}
@@ -0,0 +1,36 @@
+package device
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestGetDeviceByUDIDDeleted(t *testing.T) {
+	store, cleanup := migratedStore(t)
+	defer cleanup()
+
+	const udid = "581ddbee-7742-4472-aadd-6d2ad35c4470"
+	var uuid string
+	err := store.QueryRow(`INSERT INTO devices (udid) VALUES ($1) RETURNING device_uuid`, udid).Scan(&uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetDeviceByUDID(udid, "device_uuid"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(uuid); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetDeviceByUDID(udid, "device_uuid"); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for a deleted device, got %v", err)
+	}
+	// the record is kept and can still be looked up by its UUID.
+	dev, err := store.GetDeviceByUUID(uuid, "device_uuid", "deleted_at")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.DeletedAt == nil {
+		t.Error("expected deleted_at to be set")
+	}
+}
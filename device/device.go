@@ -3,6 +3,7 @@ package device
 import (
 	"database/sql/driver"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/micromdm/dep"
@@ -22,6 +23,15 @@ func (ns *JsonNullString) MarshalJSON() ([]byte, error) {
 	return []byte("null"), nil
 }
 
+// ErrNotFound is returned when a device does not exist or was already deleted.
+var ErrNotFound = errors.New("device not found")
+
+// NormalizeSerial returns a serial number in upper case, without surrounding spaces.
+// DEP and the devices themselves don't agree on the casing of serial numbers.
+func NormalizeSerial(serial string) string {
+	return strings.ToUpper(strings.TrimSpace(serial))
+}
+
 // Device represents an iOS or OS X Computer
 type Device struct {
 	// Primary key is UUID
@@ -31,6 +41,7 @@ type Device struct {
 	OSVersion    string         `json:"os_version,omitempty" db:"os_version,omitempty"`
 	BuildVersion string         `json:"build_version,omitempty" db:"build_version,omitempty"`
 	ProductName  string         `json:"product_name,omitempty" db:"product_name,omitempty"`
+	Platform     string         `json:"platform,omitempty" db:"platform"` // ios, macos or tvos, from the ProductName
 	IMEI         string         `json:"imei,omitempty" db:"imei,omitempty"`
 	MEID         string         `json:"meid,omitempty" db:"meid,omitempty"`
 	//Apple MDM Protocol Topic
@@ -40,8 +51,11 @@ type Device struct {
 	Token                  string           `json:"token,omitempty" db:"apple_mdm_token,omitempty"`
 	UnlockToken            string           `json:"unlock_token,omitempty" db:"unlock_token,omitempty"`
 	Enrolled               bool             `json:"enrolled,omitempty" db:"mdm_enrolled,omitempty"`
+	CheckedOutAt           *time.Time       `json:"checked_out_at,omitempty" db:"checked_out_at"`
 	Workflow               string           `json:"workflow_uuid,omitempty" db:"workflow_uuid,omitempty"`
 	DEPDevice              bool             `json:"dep_device,omitempty" db:"dep_device,omitempty"`
+	DEPAccount             string           `json:"dep_account,omitempty" db:"dep_account"`
+	Enrollment             string           `json:"enrollment,omitempty" db:"enrollment"`
 	Description            string           `json:"description,omitempty" db:"description"`
 	Model                  string           `json:"model,omitempty" db:"model"`
 	Color                  string           `json:"color,omitempty" db:"color"`
@@ -55,6 +69,23 @@ type Device struct {
 	LastCheckin            time.Time        `json:"last_checkin" db:"last_checkin"`
 	DeviceName             string           `json:"device_name" db:"device_name"`
 	LastQueryResponse      []byte           `json:"last_query_response" db:"last_query_response"`
+	// SecurityInfo
+	HardwareEncryptionCaps int    `json:"hardware_encryption_caps,omitempty" db:"hardware_encryption_caps"`
+	PasscodePresent        bool   `json:"passcode_present" db:"passcode_present"`
+	PasscodeCompliant      bool   `json:"passcode_compliant" db:"passcode_compliant"`
+	FDEEnabled             bool   `json:"fde_enabled" db:"fde_enabled"`
+	FirewallSettings       []byte `json:"firewall_settings,omitempty" db:"firewall_settings"`
+	// Lost Mode
+	LostMode          bool       `json:"lost_mode" db:"lost_mode"`
+	Latitude          *float64   `json:"latitude,omitempty" db:"latitude"`
+	Longitude         *float64   `json:"longitude,omitempty" db:"longitude"`
+	LocationUpdatedAt *time.Time `json:"location_updated_at,omitempty" db:"location_updated_at"`
+	// DeletedAt is set once the device is removed from management.
+	// The record is kept along with its command and inventory history.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	// ActivationLockBypassCode is escrowed by supervised devices, encrypted with the escrow key.
+	// It is never returned with the device.
+	ActivationLockBypassCode []byte `json:"-" db:"activation_lock_bypass_code"`
 }
 
 // DEPProfileStatus is the status of the DEP Profile
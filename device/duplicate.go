@@ -0,0 +1,13 @@
+package device
+
+// Duplicate is a device with two records: one with the UDID it enrolled with and one
+// with the serial number it reported in DeviceInformation, usually created by a DEP sync
+// or an earlier enrollment. The record with the most recent checkin is kept.
+type Duplicate struct {
+	UDID         string `json:"udid" db:"udid"`
+	SerialNumber string `json:"serial_number" db:"serial_number"`
+	// Keep is the device_uuid of the record which remains after the merge.
+	Keep string `json:"keep" db:"keep_uuid"`
+	// Remove is the device_uuid of the record merged into Keep.
+	Remove string `json:"remove" db:"remove_uuid"`
+}
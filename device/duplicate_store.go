@@ -0,0 +1,98 @@
+package device
+
+import "github.com/pkg/errors"
+
+// sql statements
+var (
+	// the UDID record only knows the serial number from the last DeviceInformation response,
+	// because the serial number column of the other record is unique.
+	selectDuplicatesStmt = `SELECT a.udid, b.serial_number,
+		CASE WHEN b.last_checkin > a.last_checkin THEN b.device_uuid ELSE a.device_uuid END AS keep_uuid,
+		CASE WHEN b.last_checkin > a.last_checkin THEN a.device_uuid ELSE b.device_uuid END AS remove_uuid
+		FROM devices a
+		JOIN devices b ON b.serial_number = upper(trim(a.last_query_response->>'SerialNumber'))
+			AND b.device_uuid <> a.device_uuid
+		WHERE a.udid IS NOT NULL
+		ORDER BY a.udid`
+
+	mergeApplicationsStmt = `UPDATE devices_applications SET device_uuid = $1
+		WHERE device_uuid = $2 AND NOT EXISTS (
+			SELECT 1 FROM devices_applications kept
+			WHERE kept.device_uuid = $1 AND kept.identifier = devices_applications.identifier
+		)`
+
+	mergeCertificatesStmt = `UPDATE devices_certificates SET device_uuid = $1
+		WHERE device_uuid = $2 AND NOT EXISTS (
+			SELECT 1 FROM devices_certificates kept
+			WHERE kept.device_uuid = $1 AND kept.sha256_fingerprint = devices_certificates.sha256_fingerprint
+		)`
+
+	mergeGroupsStmt = `UPDATE devices_groups SET device_uuid = $1
+		WHERE device_uuid = $2 AND NOT EXISTS (
+			SELECT 1 FROM devices_groups kept
+			WHERE kept.device_uuid = $1 AND kept.group_uuid = devices_groups.group_uuid
+		)`
+
+	// a license both records hold is counted once, the one of the removed record is deleted with it.
+	mergeVPPLicensesStmt = `UPDATE vpp_licenses SET device_uuid = $1
+		WHERE device_uuid = $2 AND NOT EXISTS (
+			SELECT 1 FROM vpp_licenses kept
+			WHERE kept.device_uuid = $1 AND kept.itunes_store_id = vpp_licenses.itunes_store_id
+		)`
+
+	mergeAssignedProfilesStmt = `UPDATE devices_assigned_profiles SET device_uuid = $1
+		WHERE device_uuid = $2 AND NOT EXISTS (
+			SELECT 1 FROM devices_assigned_profiles kept
+			WHERE kept.device_uuid = $1 AND kept.payload_identifier = devices_assigned_profiles.payload_identifier
+		)`
+
+	// the record of the serial number may be kept, but only the UDID record knows how to push the device.
+	mergeEnrollmentStmt = `UPDATE devices SET
+		apple_mdm_token = COALESCE(NULLIF(devices.apple_mdm_token, ''), removed.apple_mdm_token),
+		apple_mdm_topic = COALESCE(NULLIF(devices.apple_mdm_topic, ''), removed.apple_mdm_topic),
+		apple_push_magic = COALESCE(NULLIF(devices.apple_push_magic, ''), removed.apple_push_magic),
+		unlock_token = COALESCE(NULLIF(devices.unlock_token, ''::bytea), removed.unlock_token),
+		mdm_enrolled = COALESCE(devices.mdm_enrolled, false) OR COALESCE(removed.mdm_enrolled, false)
+		FROM devices removed
+		WHERE devices.device_uuid = $1 AND removed.device_uuid = $2`
+)
+
+// Duplicates returns the devices which have a record for their UDID and another
+// record for their serial number.
+func (store pgStore) Duplicates() ([]Duplicate, error) {
+	var duplicates []Duplicate
+	err := store.Select(&duplicates, selectDuplicatesStmt)
+	return duplicates, errors.Wrap(err, "pgStore Duplicates")
+}
+
+// MergeDuplicate moves the applications, certificates, group memberships, VPP licenses and
+// assigned profiles of d.Remove to d.Keep, copies the push token, push magic, unlock token and
+// enrollment state of d.Remove where d.Keep has none, deletes d.Remove and sets the UDID and
+// serial number of d.Keep in a single transaction. Other records of the removed device, like the installed
+// profiles, are deleted with it and are reported again by the device on its next inventory.
+func (store pgStore) MergeDuplicate(d Duplicate) error {
+	tx, err := store.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "pgStore MergeDuplicate")
+	}
+	stmts := []struct {
+		query string
+		args  []interface{}
+	}{
+		{mergeApplicationsStmt, []interface{}{d.Keep, d.Remove}},
+		{mergeCertificatesStmt, []interface{}{d.Keep, d.Remove}},
+		{mergeGroupsStmt, []interface{}{d.Keep, d.Remove}},
+		{mergeVPPLicensesStmt, []interface{}{d.Keep, d.Remove}},
+		{mergeAssignedProfilesStmt, []interface{}{d.Keep, d.Remove}},
+		{mergeEnrollmentStmt, []interface{}{d.Keep, d.Remove}},
+		{`DELETE FROM devices WHERE device_uuid = $1`, []interface{}{d.Remove}},
+		{`UPDATE devices SET udid = $2, serial_number = $3 WHERE device_uuid = $1`, []interface{}{d.Keep, d.UDID, d.SerialNumber}},
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "pgStore MergeDuplicate")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "pgStore MergeDuplicate")
+}
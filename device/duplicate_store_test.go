@@ -0,0 +1,103 @@
+package device
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// migratedStore connects to the postgres database in MICROMDM_TEST_DB,
+// which must have the migrations applied. The devices are deleted by cleanup.
+func migratedStore(t *testing.T) (store pgStore, cleanup func()) {
+	conn := os.Getenv("MICROMDM_TEST_DB")
+	if conn == "" {
+		t.Skip("set MICROMDM_TEST_DB to a migrated postgres database to run the test")
+	}
+	db, err := sqlx.Connect("postgres", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup = func() {
+		db.Exec(`DELETE FROM devices`)
+		db.Close()
+	}
+	return pgStore{DB: db, stmts: newStmtCache()}, cleanup
+}
+
+func TestMergeDuplicateKeepsSerialRecord(t *testing.T) {
+	store, cleanup := migratedStore(t)
+	defer cleanup()
+
+	const (
+		udid   = "581ddbee-7742-4472-aadd-6d2ad35c4470"
+		serial = "DEADBEEF123A"
+	)
+	now := time.Now().UTC()
+	var udidRecord, serialRecord string
+	// the device enrolled with its UDID, the serial number record checked in more recently.
+	err := store.QueryRow(`INSERT INTO devices (udid, apple_mdm_token, apple_mdm_topic, apple_push_magic,
+		unlock_token, mdm_enrolled, last_checkin, last_query_response)
+		VALUES ($1, 'token', 'topic', 'magic', 'unlock', true, $2, $3) RETURNING device_uuid`,
+		udid, now.Add(-time.Hour), `{"SerialNumber": "deadbeef123a "}`).Scan(&udidRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.QueryRow(`INSERT INTO devices (serial_number, last_checkin) VALUES ($1, $2) RETURNING device_uuid`,
+		serial, now).Scan(&serialRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	duplicates, err := store.Duplicates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 1 {
+		t.Fatalf("expected 1 duplicate, got %d", len(duplicates))
+	}
+	d := duplicates[0]
+	if d.Keep != serialRecord || d.Remove != udidRecord {
+		t.Fatalf("expected to keep the serial number record %s, got keep %s remove %s", serialRecord, d.Keep, d.Remove)
+	}
+	if err := store.MergeDuplicate(d); err != nil {
+		t.Fatal(err)
+	}
+
+	var merged struct {
+		UDID        string `db:"udid"`
+		Serial      string `db:"serial_number"`
+		Token       string `db:"apple_mdm_token"`
+		Topic       string `db:"apple_mdm_topic"`
+		PushMagic   string `db:"apple_push_magic"`
+		UnlockToken []byte `db:"unlock_token"`
+		Enrolled    bool   `db:"mdm_enrolled"`
+	}
+	err = store.Get(&merged, `SELECT udid, serial_number, apple_mdm_token, apple_mdm_topic, apple_push_magic,
+		unlock_token, mdm_enrolled FROM devices WHERE device_uuid = $1`, serialRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.UDID != udid || merged.Serial != serial {
+		t.Errorf("expected udid %s and serial number %s, got %s and %s", udid, serial, merged.UDID, merged.Serial)
+	}
+	if merged.Token != "token" || merged.Topic != "topic" || merged.PushMagic != "magic" {
+		t.Errorf("expected the push token, topic and magic of the removed record, got %q, %q, %q",
+			merged.Token, merged.Topic, merged.PushMagic)
+	}
+	if string(merged.UnlockToken) != "unlock" {
+		t.Errorf("expected the unlock token of the removed record, got %q", merged.UnlockToken)
+	}
+	if !merged.Enrolled {
+		t.Error("expected the merged device to be enrolled")
+	}
+
+	var count int
+	if err := store.Get(&count, `SELECT count(*) FROM devices`); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected the removed record to be deleted, got %d devices", count)
+	}
+}
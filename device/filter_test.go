@@ -0,0 +1,170 @@
+package device
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVersionComponents(t *testing.T) {
+	var tests = []struct {
+		version    string
+		components []string
+		ok         bool
+	}{
+		{"9.3.5", []string{"9", "3", "5"}, true},
+		{"10.0", []string{"10"}, true},
+		{"10", []string{"10"}, true},
+		{"10.0.1", []string{"10", "0", "1"}, true},
+		{" 10.01 ", []string{"10", "1"}, true},
+		{"0.0", []string{"0"}, true},
+		{"10.0b1", nil, false},
+		{"10..1", nil, false},
+		{"-1.0", nil, false},
+		{"", nil, false},
+	}
+
+	for _, tt := range tests {
+		components, ok := versionComponents(tt.version)
+		if ok != tt.ok || !reflect.DeepEqual(components, tt.components) {
+			t.Errorf("%q: expected %v %v, got %v %v", tt.version, tt.components, tt.ok, components, ok)
+		}
+	}
+}
+
+func TestOSVersionLessThanCondition(t *testing.T) {
+	var tests = []struct {
+		version string
+		array   string
+	}{
+		{"10.0", "ARRAY[10]::int[]"},
+		{"10", "ARRAY[10]::int[]"},
+		{"9.3.5", "ARRAY[9,3,5]::int[]"},
+		{"10.0b1", ""},
+	}
+
+	for _, tt := range tests {
+		clause, args := OSVersionLessThan{Version: tt.version}.condition()
+		if len(args) != 0 {
+			t.Errorf("%q: expected no arguments, got %v", tt.version, args)
+		}
+		if tt.array == "" {
+			if clause != "FALSE" {
+				t.Errorf("%q: expected FALSE for a non-numeric version, got %s", tt.version, clause)
+			}
+			continue
+		}
+		if !strings.HasSuffix(clause, "< "+tt.array+" ELSE FALSE END") {
+			t.Errorf("%q: expected the clause to compare with %s, got %s", tt.version, tt.array, clause)
+		}
+	}
+}
+
+func TestDevicesOSVersionLessThan(t *testing.T) {
+	store, cleanup := migratedStore(t)
+	defer cleanup()
+
+	for _, version := range []string{"9.3.5", "10", "10.0", "10.0.0", "10.0.1", "10.1", "10.0b1", ""} {
+		_, err := store.Exec(`INSERT INTO devices (udid, os_version) VALUES (uuid_generate_v4()::text, $1)`, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		version string
+		older   []string
+	}{
+		{"10.0", []string{"9.3.5"}},
+		{"10", []string{"9.3.5"}},
+		{"10.0.1", []string{"10", "10.0", "10.0.0", "9.3.5"}},
+		{"10.1", []string{"10", "10.0", "10.0.0", "10.0.1", "9.3.5"}},
+		{"9.3.5", nil},
+		{"10.0b1", nil},
+	}
+
+	for _, tt := range tests {
+		devices, err := store.Devices(OSVersionLessThan{Version: tt.version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var older []string
+		for _, dev := range devices {
+			older = append(older, dev.OSVersion)
+		}
+		sort.Strings(older)
+		if !reflect.DeepEqual(older, tt.older) {
+			t.Errorf("older than %q: expected %v, got %v", tt.version, tt.older, older)
+		}
+	}
+}
+
+func TestAddDeviceFilters(t *testing.T) {
+	before := time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC)
+	stmt, args := addDeviceFilters("SELECT udid FROM devices",
+		LastCheckinBefore(before),
+		UDID{UDID: "udid-1"},
+		SerialNumber{SerialNumber: "c02abc"},
+	)
+	want := "SELECT udid FROM devices WHERE (udid = ? OR serial_number = ?) AND ((last_checkin IS NULL OR last_checkin < ?)) AND deleted_at IS NULL"
+	if stmt != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, stmt)
+	}
+	wantArgs := []interface{}{"udid-1", "C02ABC", before}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("expected arguments %v, got %v", wantArgs, args)
+	}
+}
+
+func TestDevicesLastCheckinBefore(t *testing.T) {
+	store, cleanup := migratedStore(t)
+	defer cleanup()
+
+	now := time.Now().UTC()
+	var tests = []struct {
+		udid        string
+		osVersion   string
+		lastCheckin *time.Time
+	}{
+		{"checked-in-now", "9.3.5", &now},
+		{"never-checked-in", "10.0", nil},
+		{"checked-in-a-week-ago", "9.3.5", timePtr(now.Add(-7 * 24 * time.Hour))},
+		{"checked-in-two-days-ago", "10.1", timePtr(now.Add(-48 * time.Hour))},
+	}
+	for _, tt := range tests {
+		_, err := store.Exec(`INSERT INTO devices (udid, os_version, last_checkin) VALUES ($1, $2, $3)`,
+			tt.udid, tt.osVersion, tt.lastCheckin)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	udids := func(params ...interface{}) []string {
+		devices, err := store.Devices(params...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var udids []string
+		for _, dev := range devices {
+			udids = append(udids, dev.UDID.String)
+		}
+		return udids
+	}
+
+	stale := LastCheckinBefore(now.Add(-24 * time.Hour))
+	want := []string{"never-checked-in", "checked-in-a-week-ago", "checked-in-two-days-ago"}
+	if have := udids(stale); !reflect.DeepEqual(have, want) {
+		t.Errorf("expected stale devices oldest first %v, got %v", want, have)
+	}
+	// the filters narrow each other.
+	want = []string{"checked-in-a-week-ago"}
+	if have := udids(stale, OSVersionLessThan{Version: "10.0"}); !reflect.DeepEqual(have, want) {
+		t.Errorf("expected stale devices older than 10.0 %v, got %v", want, have)
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
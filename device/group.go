@@ -0,0 +1,12 @@
+package device
+
+import "errors"
+
+// ErrGroupExists is returned when creating or renaming a group with a name that is already in use.
+var ErrGroupExists = errors.New("group already exists")
+
+// Group is a named collection of devices
+type Group struct {
+	UUID string `json:"uuid" db:"group_uuid"`
+	Name string `json:"name" db:"name"`
+}
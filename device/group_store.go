@@ -0,0 +1,110 @@
+package device
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// sql statements
+var (
+	addGroupStmt = `INSERT INTO groups (name) VALUES ($1)
+					ON CONFLICT ON CONSTRAINT groups_name_key DO NOTHING
+					RETURNING group_uuid;`
+
+	selectGroupsStmt = `SELECT group_uuid, name FROM groups`
+
+	addGroupDeviceStmt = `INSERT INTO devices_groups (group_uuid, device_uuid) VALUES ($1, $2)
+						  ON CONFLICT DO NOTHING;`
+)
+
+// GroupUUID is a filter we can add as a parameter to narrow down the list of returned groups
+type GroupUUID struct {
+	UUID string
+}
+
+func (p GroupUUID) where() (string, []interface{}) {
+	return "group_uuid = ?", []interface{}{p.UUID}
+}
+
+// GroupName is a filter we can add as a parameter to narrow down the list of returned groups
+type GroupName struct {
+	Name string
+}
+
+func (p GroupName) where() (string, []interface{}) {
+	return "name = ?", []interface{}{p.Name}
+}
+
+// InGroup is a filter which matches the devices which are members of the named group
+type InGroup struct {
+	Name string
+}
+
+func (p InGroup) where() (string, []interface{}) {
+	return `device_uuid IN (SELECT devices_groups.device_uuid FROM devices_groups
+		JOIN groups ON groups.group_uuid = devices_groups.group_uuid
+		WHERE groups.name = ?)`, []interface{}{p.Name}
+}
+
+func (store pgStore) CreateGroup(g *Group) (*Group, error) {
+	err := store.QueryRow(addGroupStmt, g.Name).Scan(&g.UUID)
+	if err == sql.ErrNoRows {
+		return nil, ErrGroupExists
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore add group")
+	}
+	return g, nil
+}
+
+func (store pgStore) Groups(params ...interface{}) ([]Group, error) {
+	stmt, args := addWhereFilters(selectGroupsStmt, "OR", params...)
+
+	var groups []Group
+	err := store.Select(&groups, store.Rebind(stmt), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore Groups")
+	}
+	return groups, nil
+}
+
+func (store pgStore) UpdateGroup(g *Group) (*Group, error) {
+	_, err := store.Exec(`UPDATE groups SET name = $2 WHERE group_uuid = $1`, g.UUID, g.Name)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+		return nil, ErrGroupExists
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore update group")
+	}
+	return g, nil
+}
+
+// DeleteGroup removes a group. Device membership is removed with it.
+func (store pgStore) DeleteGroup(uuid string) error {
+	_, err := store.Exec(`DELETE FROM groups WHERE group_uuid = $1`, uuid)
+	if err != nil {
+		return errors.Wrap(err, "pgStore delete group")
+	}
+	return nil
+}
+
+func (store pgStore) AddGroupDevice(groupUUID, deviceUUID string) error {
+	_, err := store.Exec(addGroupDeviceStmt, groupUUID, deviceUUID)
+	if err != nil {
+		return errors.Wrap(err, "pgStore add group device")
+	}
+	return nil
+}
+
+func (store pgStore) RemoveGroupDevice(groupUUID, deviceUUID string) error {
+	_, err := store.Exec(
+		`DELETE FROM devices_groups WHERE group_uuid = $1 AND device_uuid = $2`,
+		groupUUID, deviceUUID,
+	)
+	if err != nil {
+		return errors.Wrap(err, "pgStore remove group device")
+	}
+	return nil
+}
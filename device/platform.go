@@ -0,0 +1,26 @@
+package device
+
+import "strings"
+
+// Platforms of a device, derived from the ProductName it reports in DeviceInformation.
+const (
+	PlatformIOS   = "ios"
+	PlatformMacOS = "macos"
+	PlatformTVOS  = "tvos"
+)
+
+// PlatformFromProductName returns the platform of a product name like iPhone9,3,
+// MacBookPro14,1 or AppleTV5,3. It returns an empty string if the product is not known.
+func PlatformFromProductName(productName string) string {
+	name := strings.ToLower(productName)
+	switch {
+	case strings.Contains(name, "mac"):
+		// iMac, MacBook, Macmini, MacPro and VirtualMac
+		return PlatformMacOS
+	case strings.HasPrefix(name, "appletv"):
+		return PlatformTVOS
+	case strings.HasPrefix(name, "iphone"), strings.HasPrefix(name, "ipad"), strings.HasPrefix(name, "ipod"):
+		return PlatformIOS
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+package device
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// maxCachedStmts bounds the cache. Callers select a handful of column sets,
+// queries beyond the limit are run without preparing them.
+const maxCachedStmts = 64
+
+// stmtCache holds prepared statements keyed by their query.
+// The selected columns are part of the query, so a different column set
+// is prepared as a new statement.
+type stmtCache struct {
+	mu    sync.Mutex
+	stmts map[string]*cachedStmt
+}
+
+// cachedStmt counts the queries running the statement, so that a forgotten
+// statement is only closed once the last of them finished.
+type cachedStmt struct {
+	*sqlx.Stmt
+	users     int
+	forgotten bool
+}
+
+func newStmtCache() *stmtCache {
+	return &stmtCache{stmts: make(map[string]*cachedStmt)}
+}
+
+// get returns the prepared statement for query, preparing it on first use.
+// It returns nil if the cache is full. A statement returned by get must be
+// released when the query finished.
+func (c *stmtCache) get(db *sqlx.DB, query string) (*cachedStmt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stmt, ok := c.stmts[query]
+	if !ok {
+		if len(c.stmts) >= maxCachedStmts {
+			return nil, nil
+		}
+		prepared, err := db.Preparex(query)
+		if err != nil {
+			return nil, err
+		}
+		stmt = &cachedStmt{Stmt: prepared}
+		c.stmts[query] = stmt
+	}
+	stmt.users++
+	return stmt, nil
+}
+
+// release closes the statement if it was forgotten and this was its last query.
+func (c *stmtCache) release(stmt *cachedStmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stmt.users--
+	if stmt.forgotten && stmt.users == 0 {
+		stmt.Close()
+	}
+}
+
+// forget removes the failed statement for query, so that it is prepared again.
+// Statements must be forgotten when they fail, for example after a migration
+// changed the columns of the devices table. The statement is closed when it is
+// released by the queries still running it.
+func (c *stmtCache) forget(query string, stmt *cachedStmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// another query may have failed and replaced the statement already.
+	if c.stmts[query] == stmt {
+		delete(c.stmts, query)
+	}
+	stmt.forgotten = true
+}
+
+// getDevice runs a query which selects a single device with a prepared statement.
+func (store pgStore) getDevice(query string, arg interface{}) (*Device, error) {
+	var device Device
+	if store.stmts == nil {
+		return &device, sqlx.Get(store, &device, query, arg)
+	}
+	stmt, err := store.stmts.get(store.DB, query)
+	if err != nil {
+		return &device, err
+	}
+	if stmt == nil {
+		return &device, sqlx.Get(store, &device, query, arg)
+	}
+	defer store.stmts.release(stmt)
+	err = stmt.Get(&device, arg)
+	if err != nil && err != sql.ErrNoRows {
+		store.stmts.forget(query, stmt)
+	}
+	return &device, err
+}
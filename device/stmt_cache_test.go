@@ -0,0 +1,66 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+const stmtCacheQuery = `SELECT device_uuid FROM devices WHERE udid = \$1`
+
+func TestStmtCacheForgetInUse(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sdb := sqlx.NewDb(db, "mock")
+	cache := newStmtCache()
+	query := "SELECT device_uuid FROM devices WHERE udid = $1"
+
+	mock.ExpectPrepare(stmtCacheQuery).ExpectQuery().
+		WillReturnRows(sqlmock.NewRows([]string{"device_uuid"}).AddRow("uuid"))
+	failed, err := cache.get(sdb, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err := cache.get(sdb, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed != running {
+		t.Fatal("expected the cached statement")
+	}
+
+	// the failed query forgets the statement while another query still runs it.
+	cache.forget(query, failed)
+	cache.release(failed)
+	var uuid string
+	if err := running.Get(&uuid, "udid"); err != nil {
+		t.Fatalf("statement was closed while in use: %v", err)
+	}
+	cache.release(running)
+	if err := running.Get(&uuid, "udid"); err == nil {
+		t.Error("expected the forgotten statement to be closed after its last query")
+	}
+
+	// the next query prepares the statement again.
+	mock.ExpectPrepare(stmtCacheQuery)
+	prepared, err := cache.get(sdb, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prepared == running {
+		t.Error("expected the forgotten statement to be prepared again")
+	}
+
+	// forgetting the old statement again keeps the new one.
+	cache.forget(query, running)
+	if cache.stmts[query] != prepared {
+		t.Error("expected a stale statement not to replace the new statement")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,21 @@
+package device
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUserNotFound is returned when a device has no user channel for the user.
+var ErrUserNotFound = errors.New("user not found")
+
+// User is a user channel of a device.
+// Commands for a user are pushed with the user's token, not the device token.
+type User struct {
+	DeviceUDID string    `json:"device_udid" db:"device_udid"`
+	UserID     string    `json:"user_id" db:"user_id"`
+	ShortName  string    `json:"user_short_name" db:"user_short_name"`
+	LongName   string    `json:"user_long_name" db:"user_long_name"`
+	Token      string    `json:"-" db:"apple_mdm_token"`
+	PushMagic  string    `json:"-" db:"apple_push_magic"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,39 @@
+package device
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// sql statements
+var (
+	saveUserStmt = `INSERT INTO device_users (device_udid, user_id, user_short_name, user_long_name,
+					apple_mdm_token, apple_push_magic, updated_at)
+					VALUES ($1, $2, $3, $4, $5, $6, $7)
+					ON CONFLICT (device_udid, user_id) DO UPDATE SET
+					user_short_name = $3, user_long_name = $4,
+					apple_mdm_token = $5, apple_push_magic = $6, updated_at = $7;`
+
+	selectUserStmt = `SELECT device_udid, user_id, user_short_name, user_long_name,
+					  apple_mdm_token, apple_push_magic, updated_at
+					  FROM device_users WHERE device_udid = $1 AND user_id = $2`
+)
+
+func (store pgStore) SaveUser(u *User) error {
+	_, err := store.Exec(saveUserStmt, u.DeviceUDID, u.UserID, u.ShortName, u.LongName,
+		u.Token, u.PushMagic, u.UpdatedAt)
+	return errors.Wrap(err, "pgStore save user")
+}
+
+func (store pgStore) User(deviceUDID, userID string) (*User, error) {
+	var u User
+	err := store.Get(&u, selectUserStmt, deviceUDID, userID)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore get user")
+	}
+	return &u, nil
+}
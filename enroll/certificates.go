@@ -1,29 +1,42 @@
 package enroll
 
 import (
+	"crypto/x509"
 	"errors"
-	"golang.org/x/crypto/pkcs12"
-	"io/ioutil"
+	"strings"
+
+	"github.com/micromdm/micromdm/apns"
 )
 
 const PushTopicASN1 string = "0.9.2342.19200300.100.1.1"
 
-func GetPushTopicFromPKCS12(certPath string, certPass string) (string, error) {
-	certData, err := ioutil.ReadFile(certPath)
-	if err != nil {
-		return "", err
-	}
+// mdmTopicPrefix starts the UID of MDM push certificates.
+const mdmTopicPrefix = "com.apple.mgmt."
+
+// ErrNotMDMPushCert is returned if the UID of the push certificate is not an MDM push topic.
+var ErrNotMDMPushCert = errors.New("push certificate UID is not an MDM topic (com.apple.mgmt...), use an MDM push certificate")
 
-	_, cert, err := pkcs12.Decode(certData, certPass)
+// GetPushTopic returns the push topic of the push certificate at certPath,
+// a PKCS#12 file or a PEM bundle which apns.LoadCertificate can load.
+func GetPushTopic(certPath string, certPass string) (string, error) {
+	cert, _, _, err := apns.LoadCertificate(certPath, certPass)
 	if err != nil {
 		return "", err
 	}
+	return PushTopic(cert)
+}
 
+// PushTopic returns the push topic in the UID of an MDM push certificate.
+func PushTopic(cert *x509.Certificate) (string, error) {
 	for _, v := range cert.Subject.Names {
 		if v.Type.String() == PushTopicASN1 {
-			return v.Value.(string), nil
+			topic, _ := v.Value.(string)
+			if !strings.HasPrefix(topic, mdmTopicPrefix) {
+				return "", ErrNotMDMPushCert
+			}
+			return topic, nil
 		}
 	}
 
-	return "", errors.New("Could not find Push Topic in the provided pkcs12 bundle.")
+	return "", errors.New("Could not find Push Topic in the provided push certificate.")
 }
@@ -6,25 +6,56 @@ import (
 )
 
 type Endpoints struct {
-	GetEnrollEndpoint endpoint.Endpoint
+	GetEnrollEndpoint  endpoint.Endpoint
+	RegenerateEndpoint endpoint.Endpoint
 }
 
-type mdmEnrollRequest struct{}
+// mdmEnrollRequest holds the SCEP challenge the user entered on the landing page.
+type mdmEnrollRequest struct {
+	Challenge string
+	Submitted bool
+}
 
+// mdmEnrollResponse holds the encoded, and possibly signed, enrollment profile.
 type mdmEnrollResponse struct {
-	Profile
-	Err error `plist:"error,omitempty"`
+	Profile []byte
+	Err     error
+	// submitted is set if the user already submitted the landing page.
+	submitted bool
 }
 
 func MakeServerEndpoints(s Service) Endpoints {
 	return Endpoints{
-		GetEnrollEndpoint: MakeGetEnrollEndpoint(s),
+		GetEnrollEndpoint:  MakeGetEnrollEndpoint(s),
+		RegenerateEndpoint: MakeRegenerateEndpoint(s),
 	}
 }
 
 func MakeGetEnrollEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		profile, err := s.Enroll(ctx)
-		return mdmEnrollResponse{profile, err}, nil
+		req := request.(mdmEnrollRequest)
+		profile, err := s.Enroll(ctx, req.Challenge)
+		if err != nil {
+			return mdmEnrollResponse{Err: err, submitted: req.Submitted}, nil
+		}
+		data, err := s.Encode(profile)
+		return mdmEnrollResponse{Profile: data, Err: err}, nil
+	}
+}
+
+// regenerateRequest optionally rotates the SCEP challenge.
+type regenerateRequest struct {
+	SCEPChallenge string `json:"scep_challenge"`
+}
+
+func MakeRegenerateEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(regenerateRequest)
+		profile, err := s.Regenerate(ctx, req.SCEPChallenge)
+		if err != nil {
+			return mdmEnrollResponse{Err: err}, nil
+		}
+		data, err := s.Encode(profile)
+		return mdmEnrollResponse{Profile: data, Err: err}, nil
 	}
 }
@@ -0,0 +1,35 @@
+package enroll
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// landingPage prompts for the SCEP challenge when no static challenge is configured.
+// The form posts back to the same path, which then serves the profile.
+var landingPage = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Enroll with MicroMDM</title>
+</head>
+<body>
+<h1>Enroll your device</h1>
+<p>Enter the enrollment challenge you received from your administrator to download the enrollment profile.</p>
+{{if .Retry}}<p><strong>A challenge is required to enroll.</strong></p>{{end}}
+<form method="POST">
+<input type="password" name="challenge" autocomplete="off" autofocus required>
+<button type="submit">Download profile</button>
+</form>
+</body>
+</html>
+`))
+
+// renderLandingPage writes the challenge prompt. retry is set if the user submitted an empty challenge.
+func renderLandingPage(w http.ResponseWriter, retry bool) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// the page must not be cached, a submitted challenge is not stored by the browser
+	w.Header().Set("Cache-Control", "no-store")
+	return landingPage.Execute(w, struct{ Retry bool }{retry})
+}
@@ -1,19 +1,144 @@
 package enroll
 
 import (
-	"golang.org/x/net/context"
+	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/groob/plist"
+	"golang.org/x/net/context"
 )
 
+// ProfileIdentifier is the PayloadIdentifier of the enrollment profile.
+// Removing it from a device unenrolls the device.
+const ProfileIdentifier = "com.github.micromdm.micromdm.mdm"
+
+// ErrChallengeRequired is returned by Enroll if no SCEP challenge is configured
+// and the user did not provide one.
+var ErrChallengeRequired = errors.New("a SCEP challenge is required to enroll")
+
 type Service interface {
-	Enroll(ctx context.Context) (Profile, error)
+	// Enroll returns the enrollment profile. If no static SCEP challenge is configured,
+	// challenge is used in the SCEP payload and must not be empty.
+	Enroll(ctx context.Context, challenge string) (Profile, error)
+	// Regenerate reloads the push topic from the push certificate and, if challenge
+	// is not empty, replaces the SCEP challenge. It returns the new enrollment profile.
+	Regenerate(ctx context.Context, challenge string) (Profile, error)
+	// Encode returns the profile as a plist, signed if a signing certificate is configured.
+	Encode(p Profile) ([]byte, error)
+}
+
+// Option configures an enroll service.
+type Option func(*service)
+
+// DefaultAccessRights grants the server every right of the MDM payload.
+const DefaultAccessRights = 8191
+
+// AccessRights bits which depend on another bit.
+const (
+	accessInspectProfiles             = 1
+	accessInstallProfiles             = 2
+	accessInspectProvisioningProfiles = 64
+	accessInstallProvisioningProfiles = 128
+)
+
+// ErrInvalidAccessRights is returned by NewService if the AccessRights bitmask is not
+// between 1 and 8191, or grants installing (provisioning) profiles without inspecting them.
+var ErrInvalidAccessRights = errors.New("invalid MDM AccessRights")
+
+// WithAccessRights sets the AccessRights bitmask of the MDM payload.
+// It defaults to DefaultAccessRights.
+func WithAccessRights(rights int) Option {
+	return func(svc *service) {
+		svc.AccessRights = rights
+	}
+}
+
+// validAccessRights reports whether rights is a bitmask accepted by devices.
+func validAccessRights(rights int) bool {
+	if rights < 1 || rights > DefaultAccessRights {
+		return false
+	}
+	if rights&accessInstallProfiles != 0 && rights&accessInspectProfiles == 0 {
+		return false
+	}
+	if rights&accessInstallProvisioningProfiles != 0 && rights&accessInspectProvisioningProfiles == 0 {
+		return false
+	}
+	return true
+}
+
+// ErrInsecureURL is returned by NewService if the CheckInURL or ServerURL is not an https URL,
+// or if the server URL they default to is not.
+var ErrInsecureURL = errors.New("the MDM check-in and server URLs must be https URLs")
+
+// WithDisplayName sets the PayloadDisplayName of the enrollment profile.
+func WithDisplayName(name string) Option {
+	return func(svc *service) {
+		svc.DisplayName = name
+	}
 }
 
-func NewService(pushCertPath string, pushCertPass string, caCertPath string, scepURL string, scepChallenge string, url string, tlsCertPath string) (Service, error) {
-	pushTopic, err := GetPushTopicFromPKCS12(pushCertPath, pushCertPass)
+// WithOrganization sets the PayloadOrganization of the enrollment profile and its payloads.
+func WithOrganization(org string) Option {
+	return func(svc *service) {
+		svc.Organization = org
+	}
+}
+
+// WithDescription sets the PayloadDescription of the enrollment profile.
+func WithDescription(description string) Option {
+	return func(svc *service) {
+		svc.Description = description
+	}
+}
+
+// WithCheckInURL replaces the CheckInURL of the MDM payload,
+// which defaults to the /mdm/checkin path of the server URL.
+func WithCheckInURL(checkInURL string) Option {
+	return func(svc *service) {
+		svc.CheckInURL = checkInURL
+	}
+}
+
+// WithServerURL replaces the ServerURL of the MDM payload,
+// which defaults to the /mdm/connect path of the server URL.
+func WithServerURL(serverURL string) Option {
+	return func(svc *service) {
+		svc.ServerURL = serverURL
+	}
+}
+
+// OnChallengeChange registers a function which is called with the old and the new
+// SCEP challenge when Regenerate replaces the challenge, e.g. to update the embedded SCEP CA.
+func OnChallengeChange(f func(old, challenge string)) Option {
+	return func(svc *service) {
+		svc.onChallengeChange = f
+	}
+}
+
+// WithEnrollment names the enrollment profile. The name is added to the CheckInURL,
+// so that the checkin service records which profile a device enrolled with.
+func WithEnrollment(name string) Option {
+	return func(svc *service) {
+		svc.enrollment = name
+	}
+}
+
+// NewService creates an enroll service. The Topic of the MDM payload
+// is read from the UID of the push certificate.
+// If signer is nil, profiles are not signed.
+func NewService(pushCertPath string, pushCertPass string, caCertPath string, scepURL string, scepChallenge string, url string, tlsCertPath string, signer *Signer, logger log.Logger, opts ...Option) (Service, error) {
+	pushTopic, err := GetPushTopic(pushCertPath, pushCertPass)
 	if err != nil {
 		return nil, err
 	}
+	logger.Log("msg", "detected APNs topic from push certificate", "topic", pushTopic)
 
 	var caCert, tlsCert []byte
 
@@ -42,7 +167,11 @@ func NewService(pushCertPath string, pushCertPass string, caCertPath string, sce
 		},
 	}
 
-	return &service{
+	svc := &service{
+		logger:        logger,
+		signer:        signer,
+		pushCertPath:  pushCertPath,
+		pushCertPass:  pushCertPass,
 		URL:           url,
 		SCEPURL:       scepURL,
 		SCEPSubject:   scepSubject,
@@ -50,10 +179,78 @@ func NewService(pushCertPath string, pushCertPass string, caCertPath string, sce
 		Topic:         pushTopic,
 		CACert:        caCert,
 		TLSCert:       tlsCert,
-	}, nil
+		tlsCertPath:   tlsCertPath,
+		DisplayName:   "Enrollment Profile",
+		Organization:  "MicroMDM",
+		Description:   "The server may alter your settings",
+		AccessRights:  DefaultAccessRights,
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	if !validAccessRights(svc.AccessRights) {
+		return nil, ErrInvalidAccessRights
+	}
+	if err := svc.checkURLs(); err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+// checkURLs returns ErrInsecureURL if the CheckInURL or ServerURL of the MDM payload
+// is not an https URL. The server URL is checked if either of them defaults to it.
+func (svc *service) checkURLs() error {
+	urls := []string{svc.CheckInURL, svc.ServerURL}
+	if svc.CheckInURL == "" || svc.ServerURL == "" {
+		if svc.URL == "" {
+			return ErrInsecureURL
+		}
+		urls = append(urls, svc.URL)
+	}
+	for _, u := range urls {
+		if err := checkHTTPS(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkHTTPS returns ErrInsecureURL if rawurl is set and is not an absolute https URL.
+func checkHTTPS(rawurl string) error {
+	if rawurl == "" {
+		return nil
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return fmt.Errorf("enroll: invalid URL %q: %s", rawurl, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return ErrInsecureURL
+	}
+	return nil
 }
 
 type service struct {
+	logger       log.Logger
+	signer       *Signer
+	enrollment   string
+	pushCertPath string
+	pushCertPass string
+	tlsCertPath  string
+
+	// onChallengeChange is called when Regenerate replaces the SCEP challenge
+	onChallengeChange func(old, challenge string)
+
+	// enrollment profile text and MDM URLs, set with options
+	DisplayName  string
+	Organization string
+	Description  string
+	CheckInURL   string
+	ServerURL    string
+	AccessRights int
+
+	// mu guards the fields which can change with Regenerate
+	mu            sync.RWMutex
 	URL           string
 	SCEPURL       string
 	SCEPChallenge string
@@ -63,26 +260,109 @@ type service struct {
 	TLSCert       []byte
 }
 
-func (svc service) Enroll(ctx context.Context) (Profile, error) {
+// reloadTLSCert reads the TLS certificate again, so that the profile embeds
+// a rotated certificate. If it cannot be read, the last certificate is used.
+func (svc *service) reloadTLSCert() {
+	if svc.tlsCertPath == "" {
+		return
+	}
+	tlsCert, err := ioutil.ReadFile(svc.tlsCertPath)
+	if err != nil {
+		svc.logger.Log("msg", "reloading TLS certificate for the enrollment profile", "err", err)
+		return
+	}
+	svc.mu.Lock()
+	svc.TLSCert = tlsCert
+	svc.mu.Unlock()
+}
+
+func (svc *service) Regenerate(ctx context.Context, challenge string) (Profile, error) {
+	topic, err := GetPushTopic(svc.pushCertPath, svc.pushCertPass)
+	if err != nil {
+		return Profile{}, err
+	}
+	svc.reloadTLSCert()
+	svc.mu.Lock()
+	if topic != svc.Topic {
+		svc.logger.Log("msg", "APNs topic changed", "old", svc.Topic, "topic", topic)
+	}
+	svc.Topic = topic
+	if challenge != "" && challenge != svc.SCEPChallenge && svc.onChallengeChange != nil {
+		svc.onChallengeChange(svc.SCEPChallenge, challenge)
+	}
+	if challenge != "" {
+		svc.SCEPChallenge = challenge
+	}
+	svc.mu.Unlock()
+
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+	return svc.profile(svc.SCEPChallenge), nil
+}
+
+func (svc *service) Enroll(ctx context.Context, challenge string) (Profile, error) {
+	svc.reloadTLSCert()
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+
+	if svc.SCEPChallenge != "" {
+		challenge = svc.SCEPChallenge
+	}
+	if svc.SCEPURL != "" && challenge == "" {
+		return Profile{}, ErrChallengeRequired
+	}
+	return svc.profile(challenge), nil
+}
+
+func (svc *service) Encode(p Profile) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := plist.NewEncoder(&buf).Encode(p); err != nil {
+		return nil, err
+	}
+	if svc.signer == nil {
+		return buf.Bytes(), nil
+	}
+	return svc.signer.Sign(buf.Bytes())
+}
+
+// profile builds the enrollment profile with the given SCEP challenge.
+// svc.mu must be held.
+func (svc *service) profile(challenge string) Profile {
 	profile := NewProfile()
-	profile.PayloadIdentifier = "com.github.micromdm.micromdm.mdm"
-	profile.PayloadOrganization = "MicroMDM"
-	profile.PayloadDisplayName = "Enrollment Profile"
-	profile.PayloadDescription = "The server may alter your settings"
+	profile.PayloadIdentifier = ProfileIdentifier
+	profile.PayloadOrganization = svc.Organization
+	profile.PayloadDisplayName = svc.DisplayName
+	profile.PayloadDescription = svc.Description
 	profile.PayloadScope = "System"
 
 	mdmPayload := NewPayload("com.apple.mdm")
 	mdmPayload.PayloadDescription = "Enrolls with the MDM server"
-	mdmPayload.PayloadOrganization = "MicroMDM"
+	mdmPayload.PayloadOrganization = svc.Organization
 	mdmPayload.PayloadIdentifier = "com.github.micromdm.mdm"
 	mdmPayload.PayloadScope = "System"
 
+	checkInURL := svc.CheckInURL
+	if checkInURL == "" {
+		checkInURL = svc.URL + "/mdm/checkin"
+	}
+	if svc.enrollment != "" {
+		sep := "?"
+		if strings.Contains(checkInURL, "?") {
+			sep = "&"
+		}
+		checkInURL += sep + "enrollment=" + url.QueryEscape(svc.enrollment)
+	}
+	serverURL := svc.ServerURL
+	if serverURL == "" {
+		serverURL = svc.URL + "/mdm/connect"
+	}
+
 	mdmPayloadContent := MDMPayloadContent{
 		Payload:             *mdmPayload,
-		AccessRights:        8191,
-		CheckInURL:          svc.URL + "/mdm/checkin",
+		AccessRights:        svc.AccessRights,
+		CheckInURL:          checkInURL,
 		CheckOutWhenRemoved: true,
-		ServerURL:           svc.URL + "/mdm/connect",
+		ServerURL:           serverURL,
 		Topic:               svc.Topic,
 	}
 
@@ -98,15 +378,15 @@ func (svc service) Enroll(ctx context.Context) (Profile, error) {
 			Subject:  svc.SCEPSubject,
 		}
 
-		if svc.SCEPChallenge != "" {
-			scepContent.Challenge = svc.SCEPChallenge
+		if challenge != "" {
+			scepContent.Challenge = challenge
 		}
 
 		scepPayload := NewPayload("com.apple.security.scep")
 		scepPayload.PayloadDescription = "Configures SCEP"
 		scepPayload.PayloadDisplayName = "SCEP"
 		scepPayload.PayloadIdentifier = "com.github.micromdm.scep"
-		scepPayload.PayloadOrganization = "MicroMDM"
+		scepPayload.PayloadOrganization = svc.Organization
 		scepPayload.PayloadContent = scepContent
 		scepPayload.PayloadScope = "System"
 
@@ -139,5 +419,5 @@ func (svc service) Enroll(ctx context.Context) (Profile, error) {
 
 	profile.PayloadContent = payloadContent
 
-	return *profile, nil
+	return *profile
 }
@@ -0,0 +1,113 @@
+package enroll
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"golang.org/x/net/context"
+)
+
+// tlsPayloadContent returns the content of the TLS certificate payload of p.
+func tlsPayloadContent(p Profile) []byte {
+	for _, content := range p.PayloadContent {
+		if payload, ok := content.(Payload); ok && payload.PayloadType == "com.apple.security.pkcs1" {
+			cert, _ := payload.PayloadContent.([]byte)
+			return cert
+		}
+	}
+	return nil
+}
+
+func TestEnrollReloadsTLSCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "enroll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, "server.crt")
+	if err := ioutil.WriteFile(certPath, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	svc := &service{
+		logger:       log.NewNopLogger(),
+		URL:          "https://mdm.example.com",
+		AccessRights: DefaultAccessRights,
+		TLSCert:      []byte("first"),
+		tlsCertPath:  certPath,
+	}
+
+	if err := ioutil.WriteFile(certPath, []byte("rotated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	profile, err := svc.Enroll(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tlsPayloadContent(profile); !bytes.Equal(got, []byte("rotated")) {
+		t.Errorf("expected the rotated TLS certificate, got %q", got)
+	}
+
+	// the last certificate is used if the file cannot be read.
+	os.Remove(certPath)
+	profile, err = svc.Enroll(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tlsPayloadContent(profile); !bytes.Equal(got, []byte("rotated")) {
+		t.Errorf("expected the last TLS certificate, got %q", got)
+	}
+}
+
+func TestCheckURLs(t *testing.T) {
+	var tests = []struct {
+		name       string
+		url        string
+		checkInURL string
+		serverURL  string
+		err        error
+	}{
+		{"server url", "https://mdm.example.com", "", "", nil},
+		{"insecure server url", "http://mdm.example.com", "", "", ErrInsecureURL},
+		{"missing server url", "", "", "", ErrInsecureURL},
+		{"relative server url", "/mdm", "", "", ErrInsecureURL},
+		{"overrides", "", "https://checkin.example.com/checkin", "https://connect.example.com/connect", nil},
+		{"overrides with unused insecure server url", "http://localhost:8080", "https://checkin.example.com/checkin", "https://connect.example.com/connect", nil},
+		{"insecure checkin url", "https://mdm.example.com", "http://checkin.example.com/checkin", "", ErrInsecureURL},
+		{"insecure server url with checkin url", "http://mdm.example.com", "https://checkin.example.com/checkin", "", ErrInsecureURL},
+		{"insecure connect url", "https://mdm.example.com", "", "http://connect.example.com/connect", ErrInsecureURL},
+	}
+	for _, tt := range tests {
+		svc := &service{URL: tt.url, CheckInURL: tt.checkInURL, ServerURL: tt.serverURL}
+		if err := svc.checkURLs(); err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestValidAccessRights(t *testing.T) {
+	var tests = []struct {
+		rights int
+		valid  bool
+	}{
+		{DefaultAccessRights, true},
+		{accessInspectProfiles, true},
+		{accessInspectProfiles | accessInstallProfiles, true},
+		{accessInspectProvisioningProfiles | accessInstallProvisioningProfiles, true},
+		{0, false},
+		{-1, false},
+		{DefaultAccessRights + 1, false},
+		{accessInstallProfiles, false},
+		{accessInspectProfiles | accessInstallProvisioningProfiles, false},
+		{DefaultAccessRights &^ accessInspectProfiles, false},
+		{DefaultAccessRights &^ accessInspectProvisioningProfiles, false},
+	}
+	for _, tt := range tests {
+		if valid := validAccessRights(tt.rights); valid != tt.valid {
+			t.Errorf("validAccessRights(%d) = %v, want %v", tt.rights, valid, tt.valid)
+		}
+	}
+}
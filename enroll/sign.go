@@ -0,0 +1,90 @@
+package enroll
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/fullsailor/pkcs7"
+)
+
+// ErrUnsupportedSigningKey is returned if the signing key is not an RSA key.
+var ErrUnsupportedSigningKey = errors.New("profile signing key must be an RSA private key")
+
+// Signer signs enrollment profiles, so that devices show the profile as verified.
+type Signer struct {
+	cert         *x509.Certificate
+	key          *rsa.PrivateKey
+	intermediate []*x509.Certificate
+}
+
+// NewSigner loads a PEM encoded signing certificate and RSA key.
+// Certificates following the first one in certPath are included as intermediates.
+func NewSigner(certPath, keyPath string) (*Signer, error) {
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing signing certificate: %s", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate found in %s", certPath)
+	}
+
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key found in %s", keyPath)
+	}
+	key, err := parseRSAKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{cert: certs[0], key: key, intermediate: certs[1:]}, nil
+}
+
+// parseRSAKey parses a PKCS#1 or PKCS#8 RSA private key.
+func parseRSAKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing signing key: %s", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrUnsupportedSigningKey
+	}
+	return rsaKey, nil
+}
+
+// Sign wraps data in a PKCS#7 SignedData container.
+func (s *Signer) Sign(data []byte) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := sd.AddSigner(s.cert, s.key, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	for _, cert := range s.intermediate {
+		sd.AddCertificate(cert)
+	}
+	return sd.Finish()
+}
@@ -1,6 +1,8 @@
 package enroll
 
 import (
+	"encoding/json"
+	"io"
 	"net/http"
 
 	"golang.org/x/net/context"
@@ -8,7 +10,6 @@ import (
 	"github.com/go-kit/kit/log"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
-	"github.com/groob/plist"
 )
 
 // ServiceHandler returns an HTTP Handler for the enroll service
@@ -19,10 +20,25 @@ func MakeHTTPHandler(ctx context.Context, svc Service, logger log.Logger) http.H
 		httptransport.ServerErrorLogger(logger),
 	}
 
-	r.Methods("GET").Path("/mdm/enroll").Handler(httptransport.NewServer(
+	// without a static SCEP challenge, GET renders a landing page
+	// which prompts for the challenge and POSTs it back
+	enrollHandler := httptransport.NewServer(
 		ctx,
 		e.GetEnrollEndpoint,
 		decodeMDMEnrollRequest,
+		encodeEnrollResponse,
+		opts...,
+	)
+	r.Methods("GET", "POST").Path("/mdm/enroll").Handler(enrollHandler)
+	// named enrollment profiles are mounted at /mdm/enroll/<name>
+	r.Methods("GET", "POST").Path("/mdm/enroll/{name}").Handler(enrollHandler)
+
+	// the profile is regenerated through the management API,
+	// so that it is served behind the same access controls
+	r.Methods("POST").Path("/management/v1/enroll/regenerate").Handler(httptransport.NewServer(
+		ctx,
+		e.RegenerateEndpoint,
+		decodeRegenerateRequest,
 		encodeResponse,
 		opts...,
 	))
@@ -31,17 +47,64 @@ func MakeHTTPHandler(ctx context.Context, svc Service, logger log.Logger) http.H
 }
 
 func decodeMDMEnrollRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	return r, nil
+	var request mdmEnrollRequest
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		request.Challenge = r.PostForm.Get("challenge")
+		request.Submitted = true
+	}
+	return request, nil
+}
+
+func decodeRegenerateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request regenerateRequest
+	// an empty body keeps the current challenge
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return request, nil
+}
+
+// encodeEnrollResponse renders the landing page if a challenge is required.
+func encodeEnrollResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(mdmEnrollResponse)
+	if resp.Err == ErrChallengeRequired {
+		return renderLandingPage(w, resp.submitted)
+	}
+	return encodeResponse(ctx, w, response)
 }
 
 func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	resp := response.(mdmEnrollResponse)
+	if resp.Err != nil {
+		http.Error(w, resp.Err.Error(), http.StatusInternalServerError)
+		return nil
+	}
 
 	w.Header().Set("Content-Type", "application/x-apple-aspen-config")
+	_, err := w.Write(resp.Profile)
+	return err
+}
 
-	if err := plist.NewEncoder(w).Encode(resp); err != nil {
-		return err
+// StaticProfileHandler serves an enrollment profile file, signed with signer if it is not nil.
+// To record the enrollment on checkin, the CheckInURL of the profile must end in ?enrollment=<name>.
+func StaticProfileHandler(profile []byte, signer *Signer) (http.Handler, error) {
+	// profiles which are already signed start with a DER sequence
+	if signer != nil && len(profile) > 0 && profile[0] != 0x30 {
+		signed, err := signer.Sign(profile)
+		if err != nil {
+			return nil, err
+		}
+		profile = signed
 	}
-
-	return nil
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-apple-aspen-config")
+		w.Write(profile)
+	}), nil
 }
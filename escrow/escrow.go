@@ -0,0 +1,62 @@
+// Package escrow encrypts secrets which devices escrow with the server,
+// like the Activation Lock bypass code, before they are stored.
+package escrow
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const nonceSize = 24
+
+var (
+	// ErrInvalidKey is returned if an escrow key is not 32 hex encoded bytes.
+	ErrInvalidKey = errors.New("escrow key must be 64 hex characters")
+
+	// ErrDecrypt is returned if a sealed secret was not sealed with the key, or was modified.
+	ErrDecrypt = errors.New("escrowed secret cannot be decrypted with the escrow key")
+)
+
+// Key seals and opens escrowed secrets with NaCl secretbox.
+type Key struct {
+	key [32]byte
+}
+
+// ParseKey parses a hex encoded 32 byte key, as generated by
+// openssl rand -hex 32.
+func ParseKey(s string) (*Key, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		return nil, ErrInvalidKey
+	}
+	var k Key
+	copy(k.key[:], b)
+	return &k, nil
+}
+
+// Seal encrypts a secret. The random nonce is prepended to the result.
+func (k *Key) Seal(secret string) ([]byte, error) {
+	var nonce [nonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	return secretbox.Seal(nonce[:], []byte(secret), &nonce, &k.key), nil
+}
+
+// Open decrypts a secret sealed with Seal.
+func (k *Key) Open(sealed []byte) (string, error) {
+	if len(sealed) < nonceSize {
+		return "", ErrDecrypt
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed[:nonceSize])
+	secret, ok := secretbox.Open(nil, sealed[nonceSize:], &nonce, &k.key)
+	if !ok {
+		return "", ErrDecrypt
+	}
+	return string(secret), nil
+}
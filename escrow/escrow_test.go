@@ -0,0 +1,41 @@
+package escrow
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := ParseKey(strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := "MFQ2-G4P8-0D3H-0T2F-7K4N-8P1C"
+	sealed, err := key.Seal(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(sealed), code) {
+		t.Fatal("expected the sealed secret to be encrypted")
+	}
+	opened, err := key.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened != code {
+		t.Errorf("have %q, want %q", opened, code)
+	}
+
+	other, _ := ParseKey(strings.Repeat("cd", 32))
+	if _, err := other.Open(sealed); err != ErrDecrypt {
+		t.Errorf("have %v, want %v", err, ErrDecrypt)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	for _, s := range []string{"", "abcd", strings.Repeat("zz", 32)} {
+		if _, err := ParseKey(s); err != ErrInvalidKey {
+			t.Errorf("%q: have %v, want %v", s, err, ErrInvalidKey)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package health provides liveness and readiness HTTP handlers.
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check reports whether a dependency is reachable.
+type Check func() error
+
+var errTimeout = errors.New("check timed out")
+
+type checkResult struct {
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// expiryResult reports when a certificate or credential stops working.
+type expiryResult struct {
+	NotAfter time.Time `json:"not_after"`
+	DaysLeft int       `json:"days_left"`
+}
+
+type readinessResponse struct {
+	Status string                  `json:"status"`
+	Checks map[string]checkResult  `json:"checks"`
+	Expiry map[string]expiryResult `json:"expiry,omitempty"`
+}
+
+// Option configures the readiness handler.
+type Option func(*readyConfig)
+
+type readyConfig struct {
+	expiry map[string]time.Time
+}
+
+// WithExpiry adds the expiry of a certificate or credential to the readiness response,
+// so that monitoring can alert before it expires. It does not affect the status.
+func WithExpiry(name string, notAfter time.Time) Option {
+	return func(c *readyConfig) {
+		c.expiry[name] = notAfter
+	}
+}
+
+// ReadyHandler runs every check concurrently and responds with 200 only if all of them pass.
+// Checks which do not complete within timeout are reported as failed.
+func ReadyHandler(checks map[string]Check, timeout time.Duration, opts ...Option) http.Handler {
+	config := readyConfig{expiry: make(map[string]time.Time)}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := readinessResponse{Status: "ok", Checks: make(map[string]checkResult)}
+		if len(config.expiry) != 0 {
+			resp.Expiry = make(map[string]expiryResult)
+			for name, notAfter := range config.expiry {
+				resp.Expiry[name] = expiryResult{
+					NotAfter: notAfter,
+					DaysLeft: int(notAfter.Sub(time.Now()) / (24 * time.Hour)),
+				}
+			}
+		}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for name, check := range checks {
+			wg.Add(1)
+			go func(name string, check Check) {
+				defer wg.Done()
+				result := run(check, timeout)
+				mu.Lock()
+				resp.Checks[name] = result
+				if result.Status != "ok" {
+					resp.Status = "unavailable"
+				}
+				mu.Unlock()
+			}(name, check)
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if resp.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+func run(check Check, timeout time.Duration) checkResult {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check() }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		err = errTimeout
+	}
+	result := checkResult{Status: "ok", LatencyMS: int64(time.Since(start) / time.Millisecond)}
+	if err != nil {
+		result.Status = "unavailable"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// LiveHandler responds with 200 as long as the process is able to serve requests.
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(`{"status":"ok"}` + "\n"))
+	})
+}
@@ -0,0 +1,65 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyHandler(t *testing.T) {
+	ok := func() error { return nil }
+	down := func() error { return errors.New("connection refused") }
+	slow := func() error { time.Sleep(time.Second); return nil }
+
+	var tests = []struct {
+		checks map[string]Check
+		status int
+	}{
+		{map[string]Check{"postgres": ok, "redis": ok}, http.StatusOK},
+		{map[string]Check{"postgres": ok, "redis": down}, http.StatusServiceUnavailable},
+		{map[string]Check{"apns": slow}, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		ReadyHandler(tt.checks, 50*time.Millisecond).ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		if rec.Code != tt.status {
+			t.Errorf("expected status %d, got %d", tt.status, rec.Code)
+		}
+
+		var resp readinessResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Checks) != len(tt.checks) {
+			t.Errorf("expected %d checks in response, got %d", len(tt.checks), len(resp.Checks))
+		}
+	}
+}
+
+func TestReadyHandlerExpiry(t *testing.T) {
+	notAfter := time.Now().Add(10*24*time.Hour + time.Hour)
+	checks := map[string]Check{"postgres": func() error { return nil }}
+
+	rec := httptest.NewRecorder()
+	handler := ReadyHandler(checks, 50*time.Millisecond, WithExpiry("push_certificate", notAfter))
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var resp readinessResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	expiry, ok := resp.Expiry["push_certificate"]
+	if !ok {
+		t.Fatal("expected push_certificate expiry in response")
+	}
+	if expiry.DaysLeft != 10 {
+		t.Errorf("expected 10 days left, got %d", expiry.DaysLeft)
+	}
+}
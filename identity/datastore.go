@@ -0,0 +1,89 @@
+package identity
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/micromdm/micromdm/dbpool"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoFingerprint is returned if no identity certificate is known for a device.
+	ErrNoFingerprint = errors.New("identity: no certificate fingerprint for device")
+	// ErrFingerprintInUse is returned by SaveFingerprint if the certificate belongs to another device.
+	ErrFingerprintInUse = errors.New("identity: certificate belongs to another device")
+)
+
+// Datastore remembers the identity certificate each device signs its requests with.
+type Datastore interface {
+	Fingerprint(udid string) (string, error)
+	// SaveFingerprint returns ErrFingerprintInUse if the certificate is known for another device.
+	SaveFingerprint(udid, fingerprint string) error
+	// DeleteFingerprint forgets the certificate of a device which checked out.
+	DeleteFingerprint(udid string) error
+}
+
+type pgStore struct {
+	*sqlx.DB
+}
+
+func NewDB(driver, conn string, logger kitlog.Logger, opts ...dbpool.Option) (Datastore, error) {
+	switch driver {
+	case "postgres":
+		db, err := sqlx.Open(driver, conn)
+		if err != nil {
+			return nil, errors.Wrap(err, "identity datastore")
+		}
+		dbpool.Configure(db.DB, opts...)
+		var dbError error
+		maxAttempts := 20
+		for attempts := 1; attempts <= maxAttempts; attempts++ {
+			dbError = db.Ping()
+			if dbError == nil {
+				break
+			}
+			logger.Log("msg", fmt.Sprintf("could not connect to postgres: %v", dbError))
+			time.Sleep(time.Duration(attempts) * time.Second)
+		}
+		if dbError != nil {
+			return nil, errors.Wrap(dbError, "identity datastore")
+		}
+		return pgStore{DB: db}, nil
+	default:
+		return nil, errors.New("unknown driver")
+	}
+}
+
+func (store pgStore) Fingerprint(udid string) (string, error) {
+	var fingerprint string
+	err := store.Get(&fingerprint, `SELECT sha256_fingerprint FROM device_identity_certificates WHERE udid = $1`, udid)
+	if err == sql.ErrNoRows {
+		return "", ErrNoFingerprint
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "pgStore Fingerprint")
+	}
+	return fingerprint, nil
+}
+
+func (store pgStore) SaveFingerprint(udid, fingerprint string) error {
+	_, err := store.Exec(`INSERT INTO device_identity_certificates (udid, sha256_fingerprint, updated_at)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (udid) DO UPDATE SET
+	sha256_fingerprint = EXCLUDED.sha256_fingerprint,
+	updated_at = EXCLUDED.updated_at`, udid, fingerprint, time.Now().UTC())
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+		return ErrFingerprintInUse
+	}
+	return errors.Wrap(err, "pgStore SaveFingerprint")
+}
+
+func (store pgStore) DeleteFingerprint(udid string) error {
+	_, err := store.Exec(`DELETE FROM device_identity_certificates WHERE udid = $1`, udid)
+	return errors.Wrap(err, "pgStore DeleteFingerprint")
+}
@@ -0,0 +1,152 @@
+// Package identity verifies that MDM requests come from the device they claim to be.
+package identity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/groob/plist"
+)
+
+var (
+	// ErrNoCertificate is returned if the request has no verified client certificate.
+	ErrNoCertificate = errors.New("identity: no client certificate")
+	// ErrUDIDMismatch is returned if the certificate was issued to a different device.
+	ErrUDIDMismatch = errors.New("identity: certificate does not match device UDID")
+)
+
+// ProfileCommonName is the common name the generated enrollment profile requests from
+// the SCEP server. The profile is shared by every device, so the name cannot contain the UDID.
+// A certificate with this name is bound to the device which authenticates with it.
+const ProfileCommonName = "MDM Identity Certificate UDID"
+
+// MatchesUDID reports whether the certificate was issued to the device with udid.
+// The SCEP server has to put the UDID in the common name,
+// either on its own or at the end, as in "MDM Identity Certificate <UDID>".
+func MatchesUDID(cert *x509.Certificate, udid string) bool {
+	if udid == "" {
+		return false
+	}
+	cn := cert.Subject.CommonName
+	return strings.EqualFold(cn, udid) || strings.HasSuffix(strings.ToUpper(cn), " "+strings.ToUpper(udid))
+}
+
+// Fingerprint returns the hex encoded SHA-256 fingerprint of the certificate.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkBinding checks a certificate which does not name the UDID of the request.
+// Only certificates issued for the generated enrollment profile are bound to a device:
+// the certificate which presents the Authenticate message of a device without
+// a certificate on record is remembered, all later requests must use the same certificate,
+// see bindCertificate.
+func checkBinding(cert *x509.Certificate, req mdmRequest, certs Datastore) error {
+	if cert.Subject.CommonName != ProfileCommonName {
+		return ErrUDIDMismatch
+	}
+	return bindCertificate(cert, req, certs, ErrUDIDMismatch)
+}
+
+// bindCertificate checks cert against the identity certificate on record for the device
+// and returns errMismatch if the device uses a different certificate.
+// A device without a certificate on record is bound to cert, a certificate issued for the
+// generated enrollment profile only by the Authenticate message, because it does not name
+// the device. A device which authenticates with a new certificate which names its UDID,
+// after it was erased or enrolled again without checking out, is bound to the new certificate.
+// A profile certificate does not replace the one on record, the device has to check out
+// or acknowledge an EraseDevice command first. A certificate is only bound to one device.
+func bindCertificate(cert *x509.Certificate, req mdmRequest, certs Datastore, errMismatch error) error {
+	fingerprint := Fingerprint(cert)
+	bound, err := certs.Fingerprint(req.UDID)
+	switch {
+	case err == ErrNoFingerprint:
+		if cert.Subject.CommonName == ProfileCommonName && req.MessageType != "Authenticate" {
+			return errMismatch
+		}
+	case err != nil:
+		return err
+	case bound == fingerprint:
+		return nil
+	case req.MessageType != "Authenticate" || !MatchesUDID(cert, req.UDID):
+		return errMismatch
+	}
+	err = certs.SaveFingerprint(req.UDID, fingerprint)
+	if err == ErrFingerprintInUse {
+		return errMismatch
+	}
+	return err
+}
+
+// VerifyClientCert checks the device identity certificate presented in the TLS handshake.
+// The certificate chain is verified by the TLS server, so the server must be configured
+// with the SCEP CA as client CA. Requests without a certificate, or with a certificate
+// issued to a different device, are rejected with 403.
+// Certificates issued for the generated enrollment profile are bound to the device
+// at Authenticate and forgotten at CheckOut or EraseDevice, see ProfileCommonName.
+func VerifyClientCert(next http.Handler, certs Datastore, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _, ok := readRequest(w, r)
+		if !ok {
+			return
+		}
+		if err := verify(r, req, certs); err != nil {
+			logger.Log("msg", "rejected MDM request", "udid", req.UDID, "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+		if req.MessageType == "CheckOut" {
+			// the device re-enrolls with a new identity certificate.
+			if err := certs.DeleteFingerprint(req.UDID); err != nil {
+				logger.Log("msg", "forgetting identity certificate", "udid", req.UDID, "err", err)
+			}
+		}
+	})
+}
+
+func verify(r *http.Request, req mdmRequest, certs Datastore) error {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ErrNoCertificate
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if MatchesUDID(cert, req.UDID) {
+		return nil
+	}
+	return checkBinding(cert, req, certs)
+}
+
+// mdmRequest holds the fields of checkin and connect requests needed to identify the device.
+type mdmRequest struct {
+	UDID        string
+	MessageType string
+}
+
+// maxRequestSize is the largest checkin or connect body read before the device is verified.
+// Connect responses carry the application, certificate and profile lists of the device,
+// so it is larger than a checkin message.
+const maxRequestSize = 8 << 20
+
+// readRequest reads the body, decodes the plist and restores the body for the next handler.
+// If the body is too large or not a plist, the error is written to w and ok is false.
+func readRequest(w http.ResponseWriter, r *http.Request) (req mdmRequest, body []byte, ok bool) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return req, nil, false
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err := plist.NewDecoder(bytes.NewReader(body)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, nil, false
+	}
+	return req, body, true
+}
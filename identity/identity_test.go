@@ -0,0 +1,116 @@
+package identity
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+const udid = "00000000-1111-2222-3333-444455556666"
+
+func TestMatchesUDID(t *testing.T) {
+	var tests = []struct {
+		cn    string
+		match bool
+	}{
+		{udid, true},
+		{"MDM Identity Certificate " + udid, true},
+		{"MDM Identity Certificate " + strings.ToLower(udid), true},
+		{"MDM Identity Certificate UDID", false},
+		{"MDM Identity Certificate 99999999-1111-2222-3333-444455556666", false},
+	}
+
+	for _, tt := range tests {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: tt.cn}}
+		if have := MatchesUDID(cert, udid); have != tt.match {
+			t.Errorf("%q: expected match %v, got %v", tt.cn, tt.match, have)
+		}
+	}
+}
+
+func TestVerifyClientCert(t *testing.T) {
+	body := func(messageType, udid string) string {
+		return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>` + messageType + `</string>
+	<key>UDID</key>
+	<string>` + udid + `</string>
+</dict>
+</plist>`
+	}
+	const otherUDID = "99999999-1111-2222-3333-444455556666"
+
+	chain := func(cn string, raw string) *tls.ConnectionState {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}, Raw: []byte(raw)}
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	profileCert := chain(ProfileCommonName, "device")
+	reenrolledCert := chain(ProfileCommonName, "reenrolled")
+
+	// the tests run in order against the same store.
+	var tests = []struct {
+		name   string
+		body   string
+		tls    *tls.ConnectionState
+		status int
+	}{
+		{"no tls", body("TokenUpdate", udid), nil, http.StatusForbidden},
+		{"no certificate", body("TokenUpdate", udid), &tls.ConnectionState{}, http.StatusForbidden},
+		{"other device", body("TokenUpdate", udid), chain(otherUDID, "other"), http.StatusForbidden},
+		{"same device", body("TokenUpdate", udid), chain(udid, "udid"), http.StatusOK},
+		{"profile certificate before authenticate", body("TokenUpdate", udid), profileCert, http.StatusForbidden},
+		{"profile certificate authenticate", body("Authenticate", udid), profileCert, http.StatusOK},
+		{"profile certificate bound", body("TokenUpdate", udid), profileCert, http.StatusOK},
+		{"profile certificate of other device", body("Authenticate", otherUDID), profileCert, http.StatusForbidden},
+		{"other profile certificate", body("Authenticate", udid), reenrolledCert, http.StatusForbidden},
+		{"checkout", body("CheckOut", udid), profileCert, http.StatusOK},
+		{"reenrolled authenticate", body("Authenticate", udid), reenrolledCert, http.StatusOK},
+		{"old profile certificate", body("TokenUpdate", udid), profileCert, http.StatusForbidden},
+	}
+
+	certs := memStore{}
+	for _, tt := range tests {
+		var gotBody string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := ioutil.ReadAll(r.Body)
+			gotBody = string(data)
+		})
+		req := httptest.NewRequest("PUT", "/mdm/checkin", strings.NewReader(tt.body))
+		req.TLS = tt.tls
+		rec := httptest.NewRecorder()
+		VerifyClientCert(next, certs, log.NewNopLogger()).ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, rec.Code)
+		}
+		if tt.status == http.StatusOK && gotBody != tt.body {
+			t.Errorf("%s: expected the request body to be passed on", tt.name)
+		}
+	}
+}
+
+func TestVerifyClientCertBodyTooLarge(t *testing.T) {
+	var served bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	})
+	req := httptest.NewRequest("PUT", "/mdm/connect", strings.NewReader(strings.Repeat("a", maxRequestSize+1)))
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	VerifyClientCert(next, memStore{}, log.NewNopLogger()).ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+	if served {
+		t.Error("expected the request not to be passed on")
+	}
+}
@@ -0,0 +1,111 @@
+package identity
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/fullsailor/pkcs7"
+	"github.com/go-kit/kit/log"
+)
+
+var (
+	// ErrMissingSignature is returned if signatures are required and the request has no Mdm-Signature header.
+	ErrMissingSignature = errors.New("identity: missing Mdm-Signature header")
+	// ErrSignerMismatch is returned if the request was signed with a different certificate
+	// than the one the device enrolled with.
+	ErrSignerMismatch = errors.New("identity: request not signed by the device identity certificate")
+	// ErrUntrustedSigner is returned if the signing certificate was not issued by the device CA.
+	ErrUntrustedSigner = errors.New("identity: signing certificate not issued by the device CA")
+)
+
+// VerifySignature checks the Mdm-Signature header which devices send when SignMessage
+// is set in the enrollment profile. The header holds a detached CMS signature of the body.
+// The signing certificate must chain to roots, the CA of the SCEP server,
+// and be issued to the UDID of the request, see MatchesUDID,
+// or for the generated enrollment profile, see ProfileCommonName.
+//
+// The certificate which signs the Authenticate message is remembered for the device,
+// all later requests must be signed with the same certificate. An Authenticate signed with
+// another certificate which names the UDID replaces it, so an erased device can enroll again,
+// see bindCertificate. Devices which enrolled before signatures were checked are trusted
+// on their first signed request, unless the certificate was issued for the generated
+// enrollment profile: it does not name the device, so it is only remembered for the
+// Authenticate message.
+// If required is false, requests without a signature are passed on,
+// but a signature which is present is still verified.
+func VerifySignature(next http.Handler, certs Datastore, roots *x509.CertPool, required bool, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, body, ok := readRequest(w, r)
+		if !ok {
+			return
+		}
+		if err := verifySignature(r.Header.Get("Mdm-Signature"), body, req, certs, roots, required); err != nil {
+			logger.Log("msg", "rejected MDM request", "udid", req.UDID, "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func verifySignature(header string, body []byte, req mdmRequest, certs Datastore, roots *x509.CertPool, required bool) error {
+	if header == "" {
+		// the device has no identity certificate on record before it authenticates.
+		if !required || req.MessageType == "Authenticate" {
+			return nil
+		}
+		return ErrMissingSignature
+	}
+
+	signer, err := verifySigner(header, body, req.UDID, roots)
+	if err != nil {
+		return err
+	}
+	if err := bindCertificate(signer, req, certs, ErrSignerMismatch); err != nil {
+		return err
+	}
+	if req.MessageType == "CheckOut" {
+		// the device re-enrolls with a new identity certificate.
+		return certs.DeleteFingerprint(req.UDID)
+	}
+	return nil
+}
+
+// verifySigner verifies the signature of body and the certificate which made it,
+// and returns the signing certificate.
+func verifySigner(header string, body []byte, udid string, roots *x509.CertPool) (*x509.Certificate, error) {
+	sig, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return nil, err
+	}
+	p7, err := pkcs7.Parse(sig)
+	if err != nil {
+		return nil, err
+	}
+	p7.Content = body
+	if err := p7.Verify(); err != nil {
+		return nil, err
+	}
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		return nil, errors.New("identity: signature must have exactly one signer")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range p7.Certificates {
+		intermediates.AddCert(cert)
+	}
+	_, err = signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, ErrUntrustedSigner
+	}
+	if !MatchesUDID(signer, udid) && signer.Subject.CommonName != ProfileCommonName {
+		return nil, ErrUDIDMismatch
+	}
+	return signer, nil
+}
@@ -0,0 +1,176 @@
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fullsailor/pkcs7"
+	"github.com/go-kit/kit/log"
+)
+
+type memStore map[string]string
+
+func (m memStore) Fingerprint(udid string) (string, error) {
+	fingerprint, ok := m[udid]
+	if !ok {
+		return "", ErrNoFingerprint
+	}
+	return fingerprint, nil
+}
+
+func (m memStore) SaveFingerprint(udid, fingerprint string) error {
+	for other, saved := range m {
+		if other != udid && saved == fingerprint {
+			return ErrFingerprintInUse
+		}
+	}
+	m[udid] = fingerprint
+	return nil
+}
+
+func (m memStore) DeleteFingerprint(udid string) error {
+	delete(m, udid)
+	return nil
+}
+
+func TestVerifySignature(t *testing.T) {
+	authenticate := checkinBody("Authenticate")
+	tokenUpdate := checkinBody("TokenUpdate")
+	checkOut := checkinBody("CheckOut")
+	ca := newSigner(t, "Device CA", nil)
+	device := newSigner(t, "MDM Identity Certificate "+udid, &ca)
+	reenrolled := newSigner(t, "MDM Identity Certificate "+udid, &ca)
+	erased := newSigner(t, "MDM Identity Certificate "+udid, &ca)
+	otherDevice := newSigner(t, "MDM Identity Certificate 99999999-1111-2222-3333-444455556666", &ca)
+	selfSigned := newSigner(t, "MDM Identity Certificate "+udid, nil)
+	profileDevice := newSigner(t, ProfileCommonName, &ca)
+	profileOtherDevice := newSigner(t, ProfileCommonName, &ca)
+	profileErased := newSigner(t, ProfileCommonName, &ca)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	// the tests run in order against the same store.
+	var tests = []struct {
+		name     string
+		body     string
+		sig      string
+		required bool
+		status   int
+	}{
+		{"unsigned authenticate", authenticate, "", true, http.StatusOK},
+		{"self-signed authenticate", authenticate, selfSigned.sign(t, authenticate), true, http.StatusForbidden},
+		{"authenticate by other device", authenticate, otherDevice.sign(t, authenticate), true, http.StatusForbidden},
+		{"signed authenticate", authenticate, device.sign(t, authenticate), true, http.StatusOK},
+		{"signed by device", tokenUpdate, device.sign(t, tokenUpdate), true, http.StatusOK},
+		{"unsigned, not required", tokenUpdate, "", false, http.StatusOK},
+		{"unsigned, required", tokenUpdate, "", true, http.StatusForbidden},
+		{"forged body", tokenUpdate, device.sign(t, authenticate), false, http.StatusForbidden},
+		{"other certificate", tokenUpdate, reenrolled.sign(t, tokenUpdate), false, http.StatusForbidden},
+		{"checkout by other certificate", checkOut, reenrolled.sign(t, checkOut), true, http.StatusForbidden},
+		{"checkout by device", checkOut, device.sign(t, checkOut), true, http.StatusOK},
+		{"authenticate after checkout", authenticate, reenrolled.sign(t, authenticate), true, http.StatusOK},
+		{"old certificate after re-enrollment", tokenUpdate, device.sign(t, tokenUpdate), true, http.StatusForbidden},
+		{"token update by the certificate of the erased device", tokenUpdate, erased.sign(t, tokenUpdate), true, http.StatusForbidden},
+		{"authenticate after erase replaces certificate", authenticate, erased.sign(t, authenticate), true, http.StatusOK},
+		{"signed after erase", tokenUpdate, erased.sign(t, tokenUpdate), true, http.StatusOK},
+		{"old certificate after erase", tokenUpdate, reenrolled.sign(t, tokenUpdate), true, http.StatusForbidden},
+		{"checkout before profile enrollment", checkOut, erased.sign(t, checkOut), true, http.StatusOK},
+		{"profile certificate of other device", authenticate, profileOtherDevice.sign(t, authenticate), true, http.StatusForbidden},
+		{"token update signed by unbound profile certificate", tokenUpdate, profileDevice.sign(t, tokenUpdate), true, http.StatusForbidden},
+		{"authenticate with profile certificate", authenticate, profileDevice.sign(t, authenticate), true, http.StatusOK},
+		{"signed by profile certificate", tokenUpdate, profileDevice.sign(t, tokenUpdate), true, http.StatusOK},
+		{"authenticate does not replace profile certificate", authenticate, profileErased.sign(t, authenticate), true, http.StatusForbidden},
+	}
+
+	// the other device enrolled with the generated profile as well.
+	store := memStore{"99999999-1111-2222-3333-444455556666": Fingerprint(profileOtherDevice.cert)}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		req := httptest.NewRequest("PUT", "/mdm/checkin", strings.NewReader(tt.body))
+		if tt.sig != "" {
+			req.Header.Set("Mdm-Signature", tt.sig)
+		}
+		rec := httptest.NewRecorder()
+		VerifySignature(next, store, roots, tt.required, log.NewNopLogger()).ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, rec.Code)
+		}
+	}
+}
+
+func checkinBody(messageType string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>` + messageType + `</string>
+	<key>UDID</key>
+	<string>` + udid + `</string>
+</dict>
+</plist>`
+}
+
+type signer struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+// newSigner creates a certificate with the common name cn, issued by parent,
+// or a self-signed CA certificate if parent is nil.
+func newSigner(t *testing.T, cn string, parent *signer) signer {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	issuer, issuerKey := template, key
+	if parent != nil {
+		issuer, issuerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer{cert: cert, key: key}
+}
+
+func (s signer) sign(t *testing.T, body string) string {
+	sd, err := pkcs7.NewSignedData([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sd.AddSigner(s.cert, s.key, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	sig, err := sd.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
@@ -0,0 +1,103 @@
+// Package inventory periodically refreshes the inventory of enrolled devices.
+package inventory
+
+import (
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/command"
+	"github.com/micromdm/micromdm/device"
+	"golang.org/x/net/context"
+)
+
+// RequestTypes are the commands queued for every device on each poll.
+var RequestTypes = []string{"DeviceInformation", "InstalledApplicationList"}
+
+// maxSpread caps the time over which the pushes of a single poll are spread out.
+const maxSpread = 30 * time.Minute
+
+// Poller queues inventory commands for all enrolled devices.
+type Poller struct {
+	Devices  device.Datastore
+	Commands command.Service
+	// Push sends a push notification to the device with the UDID
+	Push   func(udid string) (string, error)
+	Logger kitlog.Logger
+}
+
+// Run polls every interval until ctx is done.
+// Pushes are spread over half the interval, up to maxSpread,
+// so that devices don't all check in at once.
+func (p Poller) Run(ctx context.Context, interval time.Duration) {
+	spread := interval / 2
+	if spread > maxSpread {
+		spread = maxSpread
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.Poll(ctx, spread); err != nil {
+				p.Logger.Log("msg", "polling inventory", "err", err)
+			}
+		}
+	}
+}
+
+// Poll queues the inventory commands for every enrolled device, then pushes
+// to each device, waiting spread/len(devices) between pushes.
+func (p Poller) Poll(ctx context.Context, spread time.Duration) error {
+	devices, err := p.Devices.Devices(device.Enrolled{})
+	if err != nil {
+		return err
+	}
+
+	var udids []string
+	for _, dev := range devices {
+		if !dev.UDID.Valid || dev.UDID.String == "" {
+			continue
+		}
+		udid := dev.UDID.String
+		if err := p.queue(udid); err != nil {
+			p.Logger.Log("msg", "queueing inventory commands", "udid", udid, "err", err)
+			continue
+		}
+		udids = append(udids, udid)
+	}
+	if len(udids) == 0 {
+		return nil
+	}
+
+	delay := spread / time.Duration(len(udids))
+	for i, udid := range udids {
+		if i > 0 && delay > 0 {
+			select {
+			case <-ctx.Done():
+				// the commands are queued and will be sent on the next checkin
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		if _, err := p.Push(udid); err != nil {
+			p.Logger.Log("msg", "push for inventory", "udid", udid, "err", err)
+		}
+	}
+	return nil
+}
+
+func (p Poller) queue(udid string) error {
+	for _, requestType := range RequestTypes {
+		_, err := p.Commands.NewCommand(&mdm.CommandRequest{
+			UDID:        udid,
+			RequestType: requestType,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package inventory
+
+import (
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/command"
+	"github.com/micromdm/micromdm/device"
+	"golang.org/x/net/context"
+)
+
+type mockDevices struct {
+	device.Datastore
+	devices []device.Device
+}
+
+func (md mockDevices) Devices(params ...interface{}) ([]device.Device, error) {
+	var devices []device.Device
+	for _, p := range params {
+		if _, ok := p.(device.Enrolled); !ok {
+			continue
+		}
+		for _, dev := range md.devices {
+			if dev.Enrolled {
+				devices = append(devices, dev)
+			}
+		}
+	}
+	return devices, nil
+}
+
+type mockCommands struct {
+	command.Service
+	queued map[string][]string
+}
+
+func (mc mockCommands) NewCommand(request *mdm.CommandRequest, opts ...command.Option) (*mdm.Payload, error) {
+	mc.queued[request.UDID] = append(mc.queued[request.UDID], request.RequestType)
+	return &mdm.Payload{}, nil
+}
+
+func newDevice(udid string, enrolled bool) device.Device {
+	dev := device.Device{Enrolled: enrolled}
+	dev.UDID.Scan(udid)
+	return dev
+}
+
+func TestPollSkipsCheckedOutDevices(t *testing.T) {
+	commands := mockCommands{queued: make(map[string][]string)}
+	var pushed []string
+	p := Poller{
+		Devices: mockDevices{devices: []device.Device{
+			newDevice("UDID-ENROLLED", true),
+			newDevice("UDID-CHECKED-OUT", false),
+		}},
+		Commands: commands,
+		Push: func(udid string) (string, error) {
+			pushed = append(pushed, udid)
+			return "", nil
+		},
+		Logger: kitlog.NewNopLogger(),
+	}
+
+	if err := p.Poll(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if have := commands.queued["UDID-ENROLLED"]; len(have) != len(RequestTypes) {
+		t.Errorf("have queued %v, want %v", have, RequestTypes)
+	}
+	if _, ok := commands.queued["UDID-CHECKED-OUT"]; ok {
+		t.Error("expected no commands for a checked out device")
+	}
+	if len(pushed) != 1 || pushed[0] != "UDID-ENROLLED" {
+		t.Errorf("have pushed %v, want [UDID-ENROLLED]", pushed)
+	}
+}
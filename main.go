@@ -3,27 +3,50 @@ package main
 import (
 	"crypto/tls"
 	"crypto/x509"
-	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 
 	"database/sql"
 	"github.com/DavidHuie/gomigrate"
 	"github.com/RobotsAndPencils/buford/certificate"
 	"github.com/RobotsAndPencils/buford/push"
 	"github.com/go-kit/kit/log"
+	level "github.com/go-kit/kit/log/experimental_level"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	"github.com/micromdm/dep"
+	"github.com/micromdm/micromdm/apikey"
+	"github.com/micromdm/micromdm/apns"
 	"github.com/micromdm/micromdm/application"
+	"github.com/micromdm/micromdm/audit"
 	mdmCert "github.com/micromdm/micromdm/certificate"
 	"github.com/micromdm/micromdm/checkin"
 	"github.com/micromdm/micromdm/command"
+	"github.com/micromdm/micromdm/commandresult"
 	"github.com/micromdm/micromdm/connect"
+	"github.com/micromdm/micromdm/dbpool"
 	"github.com/micromdm/micromdm/device"
 	"github.com/micromdm/micromdm/enroll"
+	"github.com/micromdm/micromdm/escrow"
+	"github.com/micromdm/micromdm/health"
+	"github.com/micromdm/micromdm/identity"
+	"github.com/micromdm/micromdm/inventory"
 	"github.com/micromdm/micromdm/management"
+	"github.com/micromdm/micromdm/osupdate"
+	"github.com/micromdm/micromdm/profile"
+	"github.com/micromdm/micromdm/ratelimit"
+	"github.com/micromdm/micromdm/scep"
+	"github.com/micromdm/micromdm/user"
+	"github.com/micromdm/micromdm/webhook"
 	"github.com/micromdm/micromdm/workflow"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/cors"
@@ -37,8 +60,13 @@ var (
 	gitHash = "unknown"
 )
 
+// shutdownTimeout is how long in-flight requests are given to complete
+// after a SIGTERM or SIGINT.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	logger := log.NewLogfmtLogger(os.Stderr)
 
 	//flags
@@ -46,17 +74,31 @@ func main() {
 		flURL           = flag.String("url", envString("MICROMDM_URL", ""), "public facing url")
 		flPort          = flag.String("port", envString("MICROMDM_HTTP_LISTEN_PORT", ""), "port to listen on")
 		flTLS           = flag.Bool("tls", envBool("MICROMDM_USE_TLS"), "use https")
-		flTLSCert       = flag.String("tls-cert", envString("MICROMDM_TLS_CERT", ""), "path to TLS certificate")
+		flTLSCert       = flag.String("tls-cert", envString("MICROMDM_TLS_CERT", ""), "path to TLS certificate. The certificate and key are read again every 30s, a rotated certificate is used without a restart.")
 		flTLSKey        = flag.String("tls-key", envString("MICROMDM_TLS_KEY", ""), "path to TLS private key")
 		flTLSCACert     = flag.String("tls-ca-cert", envString("MICROMDM_TLS_CA_CERT", ""), "path to CA certificate")
 		flSCEPURL       = flag.String("scep-url", envString("MICROMDM_SCEP_URL", ""), "scep server url. If blank, enroll profile will not use a scep payload.")
 		flSCEPChallenge = flag.String("scep-challenge", envString("MICROMDM_SCEP_CHALLENGE", ""), "scep server challenge")
+		flSCEPCACert    = flag.String("scep-ca-cert", envString("MICROMDM_SCEP_CA_CERT", ""), "path to the PEM CA certificate of the embedded SCEP server at /scep. If set, micromdm issues device identity certificates itself and scep-url is ignored.")
+		flSCEPCAKey     = flag.String("scep-ca-key", envString("MICROMDM_SCEP_CA_KEY", ""), "path to the PEM RSA private key of the embedded SCEP CA")
+		flSCEPValidity  = flag.Duration("scep-cert-validity", envDurationDefault("MICROMDM_SCEP_CERT_VALIDITY", scep.DefaultValidity), "how long device identity certificates issued by the embedded SCEP server are valid")
 		flPGconn        = flag.String("postgres", envString("MICROMDM_POSTGRES_CONN_URL", ""), "postgres connection url")
-		flRedisconn     = flag.String("redis", envString("MICROMDM_REDIS_CONN_URL", ""), "redis connection url")
+		flDBMaxOpen     = flag.Int("db-max-open-conns", envInt("MICROMDM_DB_MAX_OPEN_CONNS", dbpool.Default.MaxOpenConns), "maximum number of open postgres connections of each datastore")
+		flDBMaxIdle     = flag.Int("db-max-idle-conns", envInt("MICROMDM_DB_MAX_IDLE_CONNS", dbpool.Default.MaxIdleConns), "maximum number of idle postgres connections of each datastore")
+		flDBMaxLifetime = flag.Duration("db-conn-max-lifetime", envDurationDefault("MICROMDM_DB_CONN_MAX_LIFETIME", dbpool.Default.ConnMaxLifetime), "maximum time a postgres connection is reused, e.g. 30m. If 0, connections are reused forever.")
+		flStorage       = flag.String("storage", envString("MICROMDM_STORAGE", "default"), "storage mode. One of default or single-node. single-node only keeps the command queue in memory instead of redis, queued commands are lost on restart. It does not replace postgres: there is no SQLite datastore, so --postgres is still required.")
+		flRedisconn     = flag.String("redis", envString("MICROMDM_REDIS_CONN_URL", ""), "redis connection url. If blank, commands are queued in postgres.")
 		flVersion       = flag.Bool("version", false, "print version information")
-		flPushCert      = flag.String("push-cert", envString("MICROMDM_PUSH_CERT", ""), "path to push certificate")
+		flPushCert      = flag.String("push-cert", envString("MICROMDM_PUSH_CERT", ""), "path to push certificate, a .p12 file or a PEM bundle with the certificate and its private key")
 		flPushPass      = flag.String("push-pass", envString("MICROMDM_PUSH_PASS", ""), "push certificate password")
+		flAPNsEnv       = flag.String("apns-env", envString("MICROMDM_APNS_ENV", apns.Production), "APNs environment to send push notifications to. One of production or development")
 		flEnrollment    = flag.String("profile", envString("MICROMDM_ENROLL_PROFILE", ""), "path to enrollment profile")
+		flProfileName   = flag.String("profile-display-name", envString("MICROMDM_PROFILE_DISPLAY_NAME", "Enrollment Profile"), "PayloadDisplayName of the generated enrollment profile")
+		flProfileOrg    = flag.String("profile-organization", envString("MICROMDM_PROFILE_ORGANIZATION", "MicroMDM"), "PayloadOrganization of the generated enrollment profile")
+		flProfileDesc   = flag.String("profile-description", envString("MICROMDM_PROFILE_DESCRIPTION", "The server may alter your settings"), "PayloadDescription of the generated enrollment profile")
+		flCheckInURL    = flag.String("checkin-url", envString("MICROMDM_CHECKIN_URL", ""), "https CheckInURL of the generated enrollment profile. If blank, url with the /mdm/checkin path is used.")
+		flServerURL     = flag.String("server-url", envString("MICROMDM_SERVER_URL", ""), "https ServerURL of the generated enrollment profile. If blank, url with the /mdm/connect path is used.")
+		flAccessRights  = flag.Int("access-rights", envInt("MICROMDM_ACCESS_RIGHTS", enroll.DefaultAccessRights), "AccessRights bitmask of the generated enrollment profile, between 1 and 8191. Installing profiles (2) requires inspecting them (1), installing provisioning profiles (128) requires inspecting them (64).")
 		flDEPCK         = flag.String("dep-consumer-key", envString("DEP_CONSUMER_KEY", ""), "dep consumer key")
 		flDEPCS         = flag.String("dep-consumer-secret", envString("DEP_CONSUMER_SECRET", ""), "dep consumer secret")
 		flDEPAT         = flag.String("dep-access-token", envString("DEP_ACCESS_TOKEN", ""), "dep access token")
@@ -65,12 +107,81 @@ func main() {
 		flDEPServerURL  = flag.String("dep-server-url", envString("DEP_SERVER_URL", ""), "dep server url. for testing. Use blank if not running against depsim")
 		flPkgRepo       = flag.String("pkg-repo", envString("MICROMDM_PKG_REPO", ""), "path to pkg repo")
 		flCORSOrigin    = flag.String("cors-origin", envString("MICROMDM_CORS_ORIGIN", ""), "allowed domain for cross origin resource sharing")
+		flWebhookURL    = flag.String("webhook-url", envString("MICROMDM_WEBHOOK_URL", ""), "url to POST device events to. If blank, webhooks are disabled.")
+		flWebhookSecret = flag.String("webhook-secret", envString("MICROMDM_WEBHOOK_SECRET", ""), "shared secret used to sign webhook requests")
+		flLogFormat     = flag.String("log-format", envString("MICROMDM_LOG_FORMAT", "logfmt"), "log output format. One of logfmt or json")
+		flLogLevel      = flag.String("log-level", envString("MICROMDM_LOG_LEVEL", "info"), "minimum level of leveled log lines. One of debug, info, warn or error")
+		flInventory     = flag.Duration("inventory-interval", envDuration("MICROMDM_INVENTORY_INTERVAL"), "how often to request DeviceInformation and InstalledApplicationList from enrolled devices, e.g. 6h. If 0, inventory is not polled.")
+		flPushRetries   = flag.Int("push-retries", envInt("MICROMDM_PUSH_RETRIES", 3), "number of times a push is sent when APNs cannot be reached. Undelivered pushes are resent at startup.")
+		flPushWorkers   = flag.Int("push-concurrency", envInt("MICROMDM_PUSH_CONCURRENCY", management.DefaultPushConcurrency), "number of push notifications sent at the same time for bulk commands")
+		flDEPSync       = flag.Duration("dep-sync-interval", envDuration("MICROMDM_DEP_SYNC_INTERVAL"), "how often to sync devices from DEP, e.g. 30m. If 0, devices are only fetched with the management API.")
+		flConnectLimit  = flag.Int("connect-rate-limit", envInt("MICROMDM_CONNECT_RATE_LIMIT", 0), "number of connect requests a single device may send per connect-rate-window. If 0, connect requests are not throttled.")
+		flConnectWindow = flag.Duration("connect-rate-window", envDurationDefault("MICROMDM_CONNECT_RATE_WINDOW", time.Minute), "time window of the connect rate limit")
+		flClientCert    = flag.Bool("mdm-client-cert", envBool("MICROMDM_MDM_CLIENT_CERT"), "require MDM requests to present the device identity certificate issued to the requesting UDID, or the certificate of the enrollment profile bound to it at Authenticate. Requires tls and tls-client-ca or scep-ca-cert.")
+		flTLSClientCA   = flag.String("tls-client-ca", envString("MICROMDM_TLS_CLIENT_CA", ""), "path to the PEM CA certificate of the SCEP server which issues device identity certificates. Defaults to scep-ca-cert with the embedded SCEP server.")
+		flMDMSignature  = flag.Bool("mdm-signature", envBool("MICROMDM_MDM_SIGNATURE"), "reject MDM requests without an Mdm-Signature header. Requires SignMessage in the enrollment profile and tls-client-ca or scep-ca-cert. Signatures which are sent are verified if a device CA is configured.")
+		flAPIKey        = flag.String("api-key", envString("MICROMDM_API_KEY", ""), "API key required to use the management API, sent as bearer token or X-API-Key header. It has every scope and can create scoped keys at /management/v1/apikeys. If blank, the management API is not authenticated.")
+		flSignCert      = flag.String("sign-cert", envString("MICROMDM_SIGN_CERT", ""), "path to a PEM certificate used to sign the enrollment profile. If blank, the profile is not signed.")
+		flSignKey       = flag.String("sign-key", envString("MICROMDM_SIGN_KEY", ""), "path to the PEM RSA private key of the signing certificate")
+		flEscrowKey     = flag.String("escrow-key", envString("MICROMDM_ESCROW_KEY", ""), "hex encoded 32 byte key which encrypts the Activation Lock bypass codes escrowed by devices, e.g. from openssl rand -hex 32. If blank, bypass codes are not stored.")
+		flDebugCheckin  = flag.Bool("debug-checkin-messages", envBool("MICROMDM_DEBUG_CHECKIN_MESSAGES"), "store the Authenticate, TokenUpdate and CheckOut messages sent by devices in the checkin_messages table, with tokens and challenges redacted")
+		flCheckinKeep   = flag.Duration("checkin-message-retention", envDurationDefault("MICROMDM_CHECKIN_MESSAGE_RETENTION", 7*24*time.Hour), "how long stored checkin messages are kept")
+		flConfig        = flag.String("config", envString("MICROMDM_CONFIG", ""), "path to a .toml or .yaml file with flag values. Flags take precedence.")
 	)
 
 	// set tls to true by default. let user set it to false
 	*flTLS = true
 	flag.Parse()
 
+	var depAccounts map[string]*depAccountConfig
+	var enrollments map[string]*enrollmentConfig
+	if *flConfig != "" {
+		values, err := loadConfigFile(*flConfig)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		depAccounts, err = splitDEPAccounts(values)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		enrollments, err = splitEnrollments(values)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		if err := applyConfig(flag.CommandLine, values); err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+	}
+
+	switch *flLogFormat {
+	case "logfmt":
+	case "json":
+		logger = log.NewJSONLogger(os.Stderr)
+	default:
+		logger.Log("err", fmt.Sprintf("unknown log format %q, must be logfmt or json", *flLogFormat))
+		os.Exit(1)
+	}
+
+	var allowedLevels []string
+	switch *flLogLevel {
+	case "debug":
+		allowedLevels = level.AllowDebugAndAbove()
+	case "info":
+		allowedLevels = level.AllowInfoAndAbove()
+	case "warn":
+		allowedLevels = level.AllowWarnAndAbove()
+	case "error":
+		allowedLevels = level.AllowErrorOnly()
+	default:
+		logger.Log("err", fmt.Sprintf("unknown log level %q, must be debug, info, warn or error", *flLogLevel))
+		os.Exit(1)
+	}
+	logger = level.New(logger, level.Config{Allowed: allowedLevels})
+
 	// -version flag
 	if *flVersion {
 		fmt.Printf("micromdm - Version %s\n", Version)
@@ -86,40 +197,82 @@ func main() {
 		*flPort = port
 	}
 
-	if *flEnrollment == "" {
-		logger.Log("err", "must set path to enrollment profile")
+	pgHostAddr := os.Getenv("POSTGRES_PORT_5432_TCP_ADDR")
+	if *flPGconn == "" && pgHostAddr != "" {
+		*flPGconn = getPGConnFromENV(logger, pgHostAddr)
+	}
+
+	redisHostAddr := os.Getenv("REDIS_PORT_6379_TCP_ADDR")
+	if *flRedisconn == "" && redisHostAddr != "" {
+		*flRedisconn = getRedisConnFromENV(redisHostAddr)
+	}
+
+	// check required options, reporting all of the missing ones at once
+	required := map[string]string{
+		"profile":   *flEnrollment,
+		"postgres":  *flPGconn,
+		"push-cert": *flPushCert,
+		"push-pass": *flPushPass,
+	}
+	switch *flStorage {
+	case "default", "single-node":
+	default:
+		logger.Log("err", fmt.Sprintf("unknown storage mode %q, use default or single-node", *flStorage))
 		os.Exit(1)
 	}
+	// check cert and key if -tls=true
+	if *flTLS {
+		required["tls-cert"] = *flTLSCert
+		required["tls-key"] = *flTLSKey
+	}
+	if *flSignCert != "" {
+		required["sign-key"] = *flSignKey
+	}
+	if *flSCEPCACert != "" {
+		required["scep-ca-key"] = *flSCEPCAKey
+		required["url"] = *flURL
+	}
+	if (*flClientCert || *flMDMSignature) && *flSCEPCACert == "" {
+		required["tls-client-ca"] = *flTLSClientCA
+	}
+	if missing := missingKeys(required); len(missing) != 0 {
+		logger.Log("err", "missing required flags or config keys", "keys", strings.Join(missing, ", "))
+		os.Exit(1)
+	}
+	if *flClientCert && !*flTLS {
+		logger.Log("err", "mdm-client-cert requires tls, the client certificate is verified in the TLS handshake")
+		os.Exit(1)
+	}
+
 	enrollmentProfile, err := ioutil.ReadFile(*flEnrollment)
 	if err != nil {
 		logger.Log("err", err)
 		os.Exit(1)
 	}
-
-	// check cert and key if -tls=true
-	if *flTLS {
-		if err := checkTLSFlags(*flTLSKey, *flTLSCert); err != nil {
+	if err := checkEnrollmentTopic(enrollmentProfile, *flPushCert, *flPushPass); err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+	// the static profiles of the enrollment paths are read once, RemoveProfile must not remove any of them.
+	staticProfiles := make(map[string][]byte)
+	for name, enrollment := range enrollments {
+		if enrollment.Profile == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(enrollment.Profile)
+		if err != nil {
 			logger.Log("err", err)
 			os.Exit(1)
 		}
+		staticProfiles[name] = data
 	}
-
-	pgHostAddr := os.Getenv("POSTGRES_PORT_5432_TCP_ADDR")
-	if *flPGconn == "" && pgHostAddr != "" {
-		*flPGconn = getPGConnFromENV(logger, pgHostAddr)
-	}
-
-	// check database connection
-	if *flPGconn == "" {
-		logger.Log("err", "database connection url not specified")
-		os.Exit(1)
-	}
-	if checkEmptyArgs(*flPushCert, *flPushPass) {
-		logger.Log("err", "must specify push cert path and password")
+	enrollmentIdentifiers, err := profileIdentifiers(enrollmentProfile, staticProfiles)
+	if err != nil {
+		logger.Log("err", err)
 		os.Exit(1)
 	}
 
-	pushSvc, err := pushService(*flPushCert, *flPushPass)
+	pushSvc, pushCert, err := pushService(*flPushCert, *flPushPass, *flAPNsEnv, logger)
 	if err != nil {
 		logger.Log("err", err)
 		os.Exit(1)
@@ -131,6 +284,19 @@ func main() {
 		logger.Log("err", err)
 		os.Exit(1)
 	}
+	poolConfig := dbpool.Config{
+		MaxOpenConns:    *flDBMaxOpen,
+		MaxIdleConns:    *flDBMaxIdle,
+		ConnMaxLifetime: *flDBMaxLifetime,
+	}
+	// pool applies the limits and counts the pools, every postgres datastore has its own.
+	var pools int
+	limits := dbpool.Limits(poolConfig)
+	pool := dbpool.Option(func(db *sql.DB) {
+		limits(db)
+		pools++
+	})
+	dbpool.Configure(db, pool)
 	var dbError error
 	maxAttempts := 20
 	for attempts := 1; attempts <= maxAttempts; attempts++ {
@@ -158,6 +324,7 @@ func main() {
 		"postgres",
 		*flPGconn,
 		logger,
+		pool,
 	)
 	if err != nil {
 		logger.Log("err", err)
@@ -168,82 +335,391 @@ func main() {
 		"postgres",
 		*flPGconn,
 		logger,
+		pool,
 	)
 	if err != nil {
 		logger.Log("err", err)
 		os.Exit(1)
 	}
 
-	redisHostAddr := os.Getenv("REDIS_PORT_6379_TCP_ADDR")
-	if *flRedisconn == "" && redisHostAddr != "" {
-		*flRedisconn = getRedisConnFromENV(redisHostAddr)
+	// commands are queued in redis, or in postgres if no redis connection is configured.
+	commandDriver, commandConn := "redis", *flRedisconn
+	switch {
+	case *flStorage == "single-node":
+		logger.Log("warn", "single-node storage keeps the command queue in memory, queued commands are lost on restart")
+		commandDriver = "memory"
+	case *flRedisconn == "":
+		logger.Log("warn", "no redis connection configured, queueing commands in postgres")
+		commandDriver, commandConn = "postgres", *flPGconn
+	}
+	commandDB, err := command.NewDB(commandDriver, commandConn, logger, pool)
+	if err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
 	}
 
-	// check database connection
-	if *flRedisconn == "" {
-		logger.Log("err", "database connection url not specified")
+	appsDB, err := application.NewDB(
+		"postgres",
+		*flPGconn,
+		logger,
+		pool,
+	)
+	if err != nil {
+		logger.Log("err", err)
 		os.Exit(1)
 	}
 
-	commandDB, err := command.NewDB("redis", *flRedisconn, logger)
+	certsDB, err := mdmCert.NewDB(
+		"postgres",
+		*flPGconn,
+		logger,
+		pool,
+	)
 	if err != nil {
 		logger.Log("err", err)
 		os.Exit(1)
 	}
 
-	appsDB, err := application.NewDB(
+	profilesDB, err := profile.NewDB(
 		"postgres",
 		*flPGconn,
 		logger,
+		pool,
 	)
 	if err != nil {
 		logger.Log("err", err)
 		os.Exit(1)
 	}
 
-	certsDB, err := mdmCert.NewDB(
+	updatesDB, err := osupdate.NewDB(
+		"postgres",
+		*flPGconn,
+		logger,
+		pool,
+	)
+	if err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+
+	usersDB, err := user.NewDB(
+		"postgres",
+		*flPGconn,
+		logger,
+		pool,
+	)
+	if err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+
+	identityDB, err := identity.NewDB(
 		"postgres",
 		*flPGconn,
 		logger,
+		pool,
 	)
 	if err != nil {
 		logger.Log("err", err)
 		os.Exit(1)
 	}
 
-	dc := depClient(logger, *flDEPCK, *flDEPCS, *flDEPAT, *flDEPAS, *flDEPServerURL, *flDEPsim)
-	mgmtSvc := management.NewService(deviceDB, workflowDB, dc, pushSvc, appsDB, certsDB)
-	commandSvc := command.NewService(commandDB)
+	apiKeysDB, err := apikey.NewDB(
+		"postgres",
+		*flPGconn,
+		logger,
+		pool,
+	)
+	if err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+
+	auditDB, err := audit.NewDB(
+		"postgres",
+		*flPGconn,
+		logger,
+		pool,
+	)
+	if err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+
+	resultsDB, err := commandresult.NewDB(
+		"postgres",
+		*flPGconn,
+		logger,
+		pool,
+	)
+	if err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+
+	dc := depClients(logger, depAccounts, *flDEPCK, *flDEPCS, *flDEPAT, *flDEPAS, *flDEPServerURL, *flDEPsim)
+	var commandSvc command.Service
+	{
+		queued := kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "micromdm",
+			Subsystem: "command",
+			Name:      "queued_commands",
+			Help:      "Number of MDM commands waiting to be delivered.",
+		}, []string{})
+		created := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "micromdm",
+			Subsystem: "command",
+			Name:      "commands_total",
+			Help:      "Number of MDM commands created, by RequestType.",
+		}, []string{"request_type"})
+		commandSvc = command.NewService(commandDB, deviceDB, profilesDB, enrollmentIdentifiers)
+		commandSvc = command.InstrumentingMiddleware(commandDB, queued, created)(commandSvc)
+	}
+	var pusher apns.Pusher
+	{
+		pushes := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "micromdm",
+			Subsystem: "apns",
+			Name:      "pushes_total",
+			Help:      "Number of push notifications sent, by status and APNs reason.",
+		}, []string{"status", "reason"})
+		latency := kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "micromdm",
+			Subsystem: "apns",
+			Name:      "push_duration_seconds",
+			Help:      "Time taken to send a push notification to APNs.",
+		}, []string{})
+		apnsLogger := level.Warn(log.NewContext(logger).With("component", "apns"))
+		pusher = apns.Retry(*flPushRetries, time.Second, deviceDB, apnsLogger)(pushSvc)
+		pusher = apns.UnenrollUnregistered(deviceDB, apnsLogger)(pusher)
+		pusher = apns.Instrument(pusher, pushes, latency, apnsLogger)
+	}
+	var escrowKey *escrow.Key
+	if *flEscrowKey != "" {
+		escrowKey, err = escrow.ParseKey(*flEscrowKey)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+	}
+	mgmtSvc := management.NewService(deviceDB, workflowDB, dc, pusher, appsDB, certsDB, profilesDB, updatesDB, usersDB, resultsDB, apiKeysDB, auditDB, commandSvc,
+		management.WithPushConcurrency(*flPushWorkers), management.WithEscrowKey(escrowKey))
 	checkinSvc := checkin.NewService(deviceDB, mgmtSvc, commandSvc, enrollmentProfile)
-	connectSvc := connect.NewService(deviceDB, appsDB, certsDB, commandSvc)
+	if *flInventory > 0 {
+		poller := inventory.Poller{
+			Devices:  deviceDB,
+			Commands: commandSvc,
+			Push:     mgmtSvc.Push,
+			Logger:   log.NewContext(logger).With("component", "inventory"),
+		}
+		go poller.Run(ctx, *flInventory)
+	}
+	if *flDEPSync > 0 {
+		go management.SyncDEPDevices(ctx, mgmtSvc, *flDEPSync, log.NewContext(logger).With("component", "dep"))
+	}
+	// resend the pushes which could not be delivered before the last shutdown
+	go management.ResendPendingPushes(mgmtSvc, deviceDB, log.NewContext(logger).With("component", "apns"))
+	// wake up devices once their deferred commands can be sent
+	go command.PushDeferred(ctx, commandSvc, mgmtSvc.Push, time.Minute, log.NewContext(logger).With("component", "command"))
+	// delete the expired command data, postgres does not expire rows on its own
+	go command.PruneExpired(ctx, commandDB, time.Hour, log.NewContext(logger).With("component", "command"))
+	unhandledResponses := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "micromdm",
+		Subsystem: "connect",
+		Name:      "unhandled_responses_total",
+		Help:      "Number of command responses which were acknowledged but not recorded, by RequestType.",
+	}, []string{"request_type"})
+	connectSvc := connect.NewService(deviceDB, appsDB, certsDB, profilesDB, updatesDB, usersDB, resultsDB, workflowDB, commandSvc, identityDB, escrowKey,
+		unhandledResponses, log.NewContext(logger).With("component", "connect"))
+	if *flWebhookURL != "" {
+		if *flWebhookSecret == "" {
+			logger.Log("warn", "webhook-secret not set, webhook requests can not be verified")
+		}
+		hook := webhook.New(*flWebhookURL, *flWebhookSecret, log.NewContext(logger).With("component", "webhook"))
+		checkinSvc = webhook.CheckinMiddleware(hook)(checkinSvc)
+		connectSvc = webhook.ConnectMiddleware(hook, commandSvc)(connectSvc)
+	}
 
 	httpLogger := log.NewContext(logger).With("component", "http")
-	managementHandler := management.ServiceHandler(ctx, mgmtSvc, httpLogger)
+	// protect puts a handler of management API routes behind the API key and the audit log.
+	protect := func(h http.Handler) http.Handler {
+		if *flAPIKey != "" {
+			h = management.Authenticate(h, apiKeysDB, *flAPIKey)
+		}
+		// audit outside of Authenticate, so that rejected requests are recorded
+		return management.Audit(h, auditDB, deviceDB, httpLogger)
+	}
+	if *flAPIKey == "" {
+		logger.Log("warn", "the management API is not authenticated, set an API key with --api-key or MICROMDM_API_KEY")
+	}
+	managementHandler := protect(management.ServiceHandler(ctx, mgmtSvc, httpLogger))
 	commandHandler := command.ServiceHandler(ctx, commandSvc, httpLogger)
 	checkinHandler := checkin.ServiceHandler(ctx, checkinSvc, httpLogger)
 	connectHandler := connect.ServiceHandler(ctx, connectSvc, httpLogger)
+	if *flConnectLimit > 0 {
+		limiter := ratelimit.New(*flConnectLimit, *flConnectWindow)
+		limiter.Exempt = func(udid string) bool {
+			dev, err := deviceDB.GetDeviceByUDID(udid, "awaiting_configuration")
+			return err == nil && dev.AwaitingConfiguration
+		}
+		connectHandler = ratelimit.Handler(connectHandler, limiter)
+		// devices which just enrolled are not throttled either
+		checkinHandler = ratelimit.TrackEnrollment(checkinHandler, limiter)
+	}
 
 	mux := http.NewServeMux()
 
 	mux.Handle("/management/v1/", managementHandler)
+	// the command API queues any command, including EraseDevice,
+	// it is protected like the management API.
+	commandHandler = protect(commandHandler)
 	mux.Handle("/mdm/commands", commandHandler)
 	mux.Handle("/mdm/commands/", commandHandler)
+
+	scepURL := *flSCEPURL
+	var scepCA *scep.CA
+	if *flSCEPCACert != "" {
+		if *flSCEPURL != "" {
+			logger.Log("warn", "--scep-url is ignored because the embedded SCEP server is enabled with --scep-ca-cert")
+		}
+		challenges := []string{*flSCEPChallenge}
+		for _, enrollment := range enrollments {
+			challenges = append(challenges, enrollment.SCEPChallenge)
+		}
+		scepCA, err = scep.NewCA(*flSCEPCACert, *flSCEPCAKey, scep.WithValidity(*flSCEPValidity), scep.WithChallenges(challenges...))
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		mux.Handle("/scep", scep.Handler(scepCA, log.NewContext(logger).With("component", "scep")))
+		scepURL = strings.TrimRight(*flURL, "/") + "/scep"
+	}
+
+	// checkin messages are recorded inside of the signature and client certificate checks,
+	// so that only verified messages are stored.
+	if *flDebugCheckin {
+		messageDB, err := checkin.NewMessageDB("postgres", *flPGconn, logger, pool)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		checkinLogger := log.NewContext(logger).With("component", "checkin")
+		checkinHandler = checkin.RecordMessages(checkinHandler, messageDB, checkinLogger)
+		go checkin.PruneMessages(ctx, messageDB, *flCheckinKeep, time.Hour, checkinLogger)
+		logger.Log("warn", "checkin messages are stored in the checkin_messages table, disable --debug-checkin-messages once enrollment is debugged")
+	}
+
+	// deviceCAs issue the device identity certificates.
+	var deviceCAs *x509.CertPool
+	if *flTLSClientCA != "" {
+		deviceCAs, err = loadCertPool(*flTLSClientCA)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+	} else if scepCA != nil {
+		deviceCAs = x509.NewCertPool()
+		deviceCAs.AddCert(scepCA.Certificate())
+	}
+
+	if deviceCAs != nil {
+		checkinHandler = identity.VerifySignature(checkinHandler, identityDB, deviceCAs, *flMDMSignature, httpLogger)
+		connectHandler = identity.VerifySignature(connectHandler, identityDB, deviceCAs, *flMDMSignature, httpLogger)
+	} else {
+		logger.Log("warn", "Mdm-Signature headers are not verified, set the device CA with --tls-client-ca or --scep-ca-cert")
+	}
+
+	var clientCAs *x509.CertPool
+	if *flClientCert {
+		clientCAs = deviceCAs
+		checkinHandler = identity.VerifyClientCert(checkinHandler, identityDB, httpLogger)
+		connectHandler = identity.VerifyClientCert(connectHandler, identityDB, httpLogger)
+	}
+
+	// all postgres datastores are open
+	logger.Log("msg", "postgres connection pools",
+		"pools", pools,
+		"max_open_conns", poolConfig.MaxOpenConns,
+		"max_idle_conns", poolConfig.MaxIdleConns,
+		"conn_max_lifetime", poolConfig.ConnMaxLifetime,
+		"max_open_total", pools*poolConfig.MaxOpenConns,
+	)
+
 	mux.Handle("/mdm/checkin", checkinHandler)
 	mux.Handle("/mdm/connect", connectHandler)
 
-	if checkEmptyArgs(*flURL, *flSCEPURL) {
-		logger.Log("warn", "Enrollment endpoint /mdm/enroll will be disabled because you did not specify flags/environment vars for the external URL (--url MICROMDM_URL) or SCEP URL (--scep-url/MICROMDM_SCEP_URL)")
+	if checkEmptyArgs(*flURL, scepURL) {
+		logger.Log("warn", "Enrollment endpoint /mdm/enroll will be disabled because you did not specify flags/environment vars for the external URL (--url MICROMDM_URL) or SCEP URL (--scep-url/MICROMDM_SCEP_URL or --scep-ca-cert/MICROMDM_SCEP_CA_CERT)")
 	} else {
 		if *flSCEPChallenge == "" {
-			logger.Log("warn", "You did not specify a SCEP challenge via --scep-challenge or MICROMDM_SCEP_CHALLENGE (this may not be what you intended, the user will be prompted for a challenge).")
+			logger.Log("warn", "You did not specify a SCEP challenge via --scep-challenge or MICROMDM_SCEP_CHALLENGE (users enrolling at /mdm/enroll will be prompted for a challenge on a landing page).")
 		}
 
 		if *flTLSCACert == "" {
 			logger.Log("warn", "You did not specify a CA Certificate to trust via --tls-ca-cert or MICROMDM_TLS_CA_CERT. If your certificates are self signed, devices may not be able to enroll.")
 		}
-		enrollSvc, _ := enroll.NewService(*flPushCert, *flPushPass, *flTLSCACert, *flSCEPURL, *flSCEPChallenge, *flURL, *flTLSCert)
+		var signer *enroll.Signer
+		if *flSignCert != "" {
+			signer, err = enroll.NewSigner(*flSignCert, *flSignKey)
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+		}
+		enrollOpts := []enroll.Option{
+			enroll.WithDisplayName(*flProfileName),
+			enroll.WithOrganization(*flProfileOrg),
+			enroll.WithDescription(*flProfileDesc),
+			enroll.WithCheckInURL(*flCheckInURL),
+			enroll.WithServerURL(*flServerURL),
+			enroll.WithAccessRights(*flAccessRights),
+		}
+		if scepCA != nil {
+			// the embedded SCEP CA accepts the challenge of a regenerated profile.
+			enrollOpts = append(enrollOpts, enroll.OnChallengeChange(scepCA.ReplaceChallenge))
+		}
+		enrollSvc, err := enroll.NewService(*flPushCert, *flPushPass, *flTLSCACert, scepURL, *flSCEPChallenge, *flURL, *flTLSCert, signer, log.NewContext(logger).With("component", "enroll"), enrollOpts...)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
 		enrollHandler := enroll.MakeHTTPHandler(ctx, enrollSvc, httpLogger)
 		mux.Handle("/mdm/enroll", enrollHandler)
+		// /management/v1/enroll/ is a longer pattern than /management/v1/,
+		// it needs its own protection.
+		mux.Handle("/management/v1/enroll/", protect(enrollHandler))
+
+		for name, enrollment := range enrollments {
+			var handler http.Handler
+			if data, ok := staticProfiles[name]; ok {
+				var err error
+				handler, err = enroll.StaticProfileHandler(data, signer)
+				if err != nil {
+					logger.Log("err", err)
+					os.Exit(1)
+				}
+			} else {
+				opts := append([]enroll.Option{}, enrollOpts...)
+				opts = append(opts, enroll.WithEnrollment(name))
+				if enrollment.DisplayName != "" {
+					opts = append(opts, enroll.WithDisplayName(enrollment.DisplayName))
+				}
+				if enrollment.Organization != "" {
+					opts = append(opts, enroll.WithOrganization(enrollment.Organization))
+				}
+				if enrollment.Description != "" {
+					opts = append(opts, enroll.WithDescription(enrollment.Description))
+				}
+				svc, err := enroll.NewService(*flPushCert, *flPushPass, *flTLSCACert, scepURL, enrollment.SCEPChallenge, *flURL, *flTLSCert, signer,
+					log.NewContext(logger).With("component", "enroll", "enrollment", name), opts...)
+				if err != nil {
+					logger.Log("err", err)
+					os.Exit(1)
+				}
+				handler = enroll.MakeHTTPHandler(ctx, svc, httpLogger)
+			}
+			mux.Handle("/mdm/enroll/"+name, handler)
+		}
 	}
 
 	if *flPkgRepo != "" {
@@ -265,21 +741,36 @@ func main() {
 	}
 
 	http.Handle("/metrics", stdprometheus.Handler())
+	http.Handle("/livez", health.LiveHandler())
+	checks := map[string]health.Check{
+		"postgres": db.Ping,
+		"apns":     dialCheck(pushSvc.Host),
+	}
+	if commandDriver == "redis" {
+		checks["redis"] = commandDB.Ping
+	}
+	http.Handle("/healthz", health.ReadyHandler(checks, 5*time.Second, health.WithExpiry("push_certificate", pushCert.NotAfter)))
 
-	serve(logger, *flTLS, *flPort, *flTLSKey, *flTLSCert)
+	serve(logger, cancel, *flTLS, *flPort, *flTLSKey, *flTLSCert, clientCAs)
 }
 
-func depClient(logger log.Logger, consumerKey, consumerSecret, accessToken, accessSecret, serverURL string, depsim bool) dep.Client {
+// depClients returns a DEP client for each configured DEP account.
+// The dep-* flags configure the default account, additional accounts are read from the config file.
+func depClients(logger log.Logger, accounts map[string]*depAccountConfig, consumerKey, consumerSecret, accessToken, accessSecret, serverURL string, depsim bool) management.DEPAccounts {
 	depsimDefault := &dep.Config{
 		ConsumerKey:    "CK_48dd68d198350f51258e885ce9a5c37ab7f98543c4a697323d75682a6c10a32501cb247e3db08105db868f73f2c972bdb6ae77112aea803b9219eb52689d42e6",
 		ConsumerSecret: "CS_34c7b2b531a600d99a0e4edcf4a78ded79b86ef318118c2f5bcfee1b011108c32d5302df801adbe29d446eb78f02b13144e323eb9aad51c79f01e50cb45c3a68",
 		AccessToken:    "AT_927696831c59ba510cfe4ec1a69e5267c19881257d4bca2906a99d0785b785a6f6fdeb09774954fdd5e2d0ad952e3af52c6d8d2f21c924ba0caf4a031c158b89",
 		AccessSecret:   "AS_c31afd7a09691d83548489336e8ff1cb11b82b6bca13f793344496a556b1f4972eaff4dde6deb5ac9cf076fdfa97ec97699c34d515947b9cf9ed31c99dded6ba",
 	}
+	clients := make(management.DEPAccounts)
 	var config *dep.Config
-	if depsim {
+	switch {
+	case depsim:
 		config = depsimDefault
-	} else {
+	case len(accounts) > 0 && consumerKey == "" && consumerSecret == "" && accessToken == "" && accessSecret == "":
+		// only the accounts from the config file are used
+	default:
 		if checkEmptyArgs(consumerKey, consumerSecret, accessToken, accessSecret) {
 			logger.Log("err", "must specify DEP server credentials")
 			logger.Log("ConsumerKey", consumerKey, "ConsumerSecret", consumerSecret, "AccessToken", accessToken, "AccessSecret", accessSecret)
@@ -292,6 +783,21 @@ func depClient(logger log.Logger, consumerKey, consumerSecret, accessToken, acce
 			AccessSecret:   accessSecret,
 		}
 	}
+	if config != nil {
+		clients[management.DefaultDEPAccount] = depClient(logger, config, serverURL)
+	}
+	for name, account := range accounts {
+		clients[name] = depClient(logger, &dep.Config{
+			ConsumerKey:    account.ConsumerKey,
+			ConsumerSecret: account.ConsumerSecret,
+			AccessToken:    account.AccessToken,
+			AccessSecret:   account.AccessSecret,
+		}, account.ServerURL)
+	}
+	return clients
+}
+
+func depClient(logger log.Logger, config *dep.Config, serverURL string) dep.Client {
 	var client dep.Client
 	var err error
 	if serverURL != "" {
@@ -308,21 +814,98 @@ func depClient(logger log.Logger, consumerKey, consumerSecret, accessToken, acce
 	return client
 }
 
-func pushService(certPath, password string) (*push.Service, error) {
-	cert, key, err := certificate.Load(certPath, password)
+// checkEnrollmentTopic returns an error if the Topic of the MDM payload in the
+// enrollment profile does not match the UID of the push certificate.
+// Devices enrolled with a mismatched topic never receive push notifications.
+func checkEnrollmentTopic(enrollmentProfile []byte, certPath, password string) error {
+	topic, err := enroll.GetPushTopic(certPath, password)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	config, err := profile.ParseConfiguration(enrollmentProfile)
+	if err != nil {
+		return fmt.Errorf("parsing enrollment profile: %s", err)
+	}
+	for _, payload := range config.PayloadContent {
+		if payload.PayloadType == "com.apple.mdm" && payload.Topic != topic {
+			return fmt.Errorf("enrollment profile Topic %q does not match the push certificate topic %q", payload.Topic, topic)
+		}
+	}
+	return nil
+}
+
+// profileIdentifiers returns the PayloadIdentifier of the enrollment profile
+// and of the static profiles of the enrollment paths.
+func profileIdentifiers(enrollmentProfile []byte, staticProfiles map[string][]byte) ([]string, error) {
+	config, err := profile.ParseConfiguration(enrollmentProfile)
+	if err != nil {
+		return nil, fmt.Errorf("parsing enrollment profile: %s", err)
+	}
+	identifiers := []string{config.PayloadIdentifier}
+	for name, data := range staticProfiles {
+		config, err := profile.ParseConfiguration(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing profile of enrollment %s: %s", name, err)
+		}
+		identifiers = append(identifiers, config.PayloadIdentifier)
+	}
+	return identifiers, nil
+}
+
+// pushCertWarning is how long before the push certificate expires a warning is logged.
+const pushCertWarning = 30 * 24 * time.Hour
+
+// pushService creates a push service for the APNs environment env.
+// A certificate which is not valid for env is only logged, APNs rejects the pushes.
+// An expired certificate is an error, no command could be delivered.
+func pushService(certPath, password, env string, logger log.Logger) (*push.Service, *x509.Certificate, error) {
+	host, err := apns.Host(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, key, strategy, err := apns.LoadCertificate(certPath, password)
+	if err == certificate.ErrExpired {
+		return nil, nil, fmt.Errorf("push certificate %s has expired, renew it before starting micromdm", certPath)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	logger.Log("msg", "loaded push certificate", "format", strategy)
+	if !apns.ValidForEnvironment(cert, env) {
+		level.Warn(logger).Log("msg", "push certificate is not valid for the APNs environment", "apns_env", env)
+	}
+	if cert.NotAfter.Sub(time.Now()) < pushCertWarning {
+		level.Warn(logger).Log("msg", "push certificate expires soon, renew it to keep sending commands", "not_after", cert.NotAfter)
 	}
 	client, err := push.NewClient(certificate.TLS(cert, key))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	service := &push.Service{
 		Client: client,
-		Host:   push.Production,
+		Host:   host,
 	}
 
-	return service, nil
+	return service, cert, nil
+}
+
+// dialCheck returns a health check which opens a TCP connection to the host of rawurl.
+func dialCheck(rawurl string) health.Check {
+	return func() error {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return err
+		}
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+		conn, err := net.DialTimeout("tcp", host, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
 }
 
 func checkEmptyArgs(args ...string) bool {
@@ -334,15 +917,30 @@ func checkEmptyArgs(args ...string) bool {
 	return false
 }
 
-// choose http or https
-func serve(logger log.Logger, tlsEnabled bool, port, key, certPath string) {
+// serve runs the HTTP server until the process receives SIGTERM or SIGINT,
+// then waits for in-flight requests before cancelling the root context.
+// If clientCAs is not nil, client certificates issued by them are verified in the TLS handshake.
+func serve(logger log.Logger, cancel context.CancelFunc, tlsEnabled bool, port, key, certPath string, clientCAs *x509.CertPool) {
 	portStr := fmt.Sprintf(":%v", port)
+	var inFlight int64
+	srv := &http.Server{
+		Addr:    portStr,
+		Handler: countInFlight(logRequests(http.DefaultServeMux, logger), &inFlight),
+	}
+	if clientCAs != nil {
+		// only MDM requests have to present a certificate, see identity.VerifyClientCert.
+		srv.TLSConfig = &tls.Config{
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			ClientCAs:  clientCAs,
+		}
+	}
 	if tlsEnabled {
-		chain, err := tls.LoadX509KeyPair(certPath, key)
+		reloader, err := newCertReloader(certPath, key, logger)
 		if err != nil {
 			logger.Log("err", "failed to load TLS certificate or private key")
 			os.Exit(1)
 		}
+		chain, _ := reloader.GetCertificate(nil)
 
 		cert, err := x509.ParseCertificate(chain.Certificate[0]) // Leaf is always the first entry
 		if err != nil {
@@ -363,12 +961,93 @@ func serve(logger log.Logger, tlsEnabled bool, port, key, certPath string) {
 			os.Exit(1)
 		}
 
+		// the certificate is read from disk again for new connections, see certReloader.
+		if srv.TLSConfig == nil {
+			srv.TLSConfig = &tls.Config{}
+		}
+		srv.TLSConfig.GetCertificate = reloader.GetCertificate
+
 		logger.Log("msg", "HTTPs", "addr", port)
-		logger.Log("err", http.ListenAndServeTLS(portStr, certPath, key, nil))
+		go func() {
+			if err := srv.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+		}()
 	} else {
 		logger.Log("msg", "HTTP", "addr", port)
-		logger.Log("err", http.ListenAndServe(portStr, nil))
+		go func() {
+			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	logger.Log("msg", "shutting down", "signal", <-sig)
+
+	draining := atomic.LoadInt64(&inFlight)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Log("err", err, "in_flight", atomic.LoadInt64(&inFlight))
+	} else {
+		logger.Log("msg", "drained in-flight requests", "count", draining)
+	}
+	cancel()
+}
+
+// loadCertPool reads the PEM certificates in path into a new pool.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
 	}
+	return pool, nil
+}
+
+// logRequests logs every request with the same set of keys,
+// regardless of the log format.
+func logRequests(next http.Handler, logger log.Logger) http.Handler {
+	logger = log.NewContext(logger).With("component", "http")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		level.Info(logger).Log(
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"remote_addr", r.RemoteAddr,
+			"took", time.Since(begin).String(),
+		)
+	})
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// countInFlight keeps track of the number of requests being served.
+func countInFlight(next http.Handler, inFlight *int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(inFlight, 1)
+		defer atomic.AddInt64(inFlight, -1)
+		next.ServeHTTP(w, r)
+	})
 }
 
 func envString(key, def string) string {
@@ -385,11 +1064,23 @@ func envBool(key string) bool {
 	return false
 }
 
-func checkTLSFlags(key, cert string) error {
-	if key == "" || cert == "" {
-		return errors.New("You must provide a valid path to a TLS cert and key")
+func envInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
 	}
-	return nil
+	return def
+}
+
+func envDuration(key string) time.Duration {
+	d, _ := time.ParseDuration(os.Getenv(key))
+	return d
+}
+
+func envDurationDefault(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return d
+	}
+	return def
 }
 
 func defaultPort(tls bool) string {
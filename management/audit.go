@@ -0,0 +1,126 @@
+package management
+
+import (
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/micromdm/micromdm/apikey"
+	"github.com/micromdm/micromdm/audit"
+	"github.com/micromdm/micromdm/device"
+	"golang.org/x/net/context"
+)
+
+// statusRecorder remembers the status code written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+type auditContextKey struct{}
+
+// auditActor is set by Authenticate to the API key which made the request,
+// so that Audit knows the key of requests which Authenticate rejected.
+type auditActor struct {
+	key *apikey.Key
+}
+
+// setAuditActor records the API key of the request for the audit log, if it is audited.
+func setAuditActor(ctx context.Context, key *apikey.Key) {
+	if actor, ok := ctx.Value(auditContextKey{}).(*auditActor); ok {
+		actor.key = key
+	}
+}
+
+// Audit records every request which changes state in the audit log,
+// with the API key which made it and the status of the response.
+// Retrieving an Activation Lock bypass code is recorded as well.
+// Audit must wrap Authenticate, so that rejected requests are recorded too.
+// A request to more than one device, like a bulk or group command,
+// is recorded once for every device. Devices are recorded by their UUID.
+func Audit(next http.Handler, entries audit.Datastore, devices device.Datastore, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readOnly := r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS"
+		if readOnly && !isBypassCodePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// the devices are looked up before the request can change them.
+		targets := auditDevices(r, devices)
+		if len(targets) == 0 {
+			targets = []string{""}
+		}
+		actor := &auditActor{}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), auditContextKey{}, actor)))
+
+		for _, target := range targets {
+			entry := &audit.Entry{
+				Method: r.Method,
+				Path:   r.URL.Path,
+				Device: target,
+				Status: rec.status,
+			}
+			if actor.key != nil {
+				entry.Actor = actor.key.Name
+				entry.ActorUUID = actor.key.UUID
+			}
+			if err := entries.Record(entry); err != nil {
+				logger.Log("msg", "recording audit log entry", "method", r.Method, "path", r.URL.Path, "err", err)
+			}
+		}
+	})
+}
+
+// auditDevices returns the UUIDs of the devices a request targets.
+func auditDevices(r *http.Request, devices device.Datastore) []string {
+	switch r.URL.Path {
+	case "/management/v1/devices/commands":
+		var req bulkCommandRequest
+		if err := peekJSON(r, &req); err != nil {
+			return nil
+		}
+		if req.Group != "" {
+			members, err := devices.Devices(device.InGroup{Name: req.Group})
+			if err != nil {
+				return nil
+			}
+			uuids := make([]string, len(members))
+			for i, dev := range members {
+				uuids[i] = dev.UUID
+			}
+			return uuids
+		}
+		uuids := make([]string, len(req.UDIDs))
+		for i, udid := range req.UDIDs {
+			uuids[i] = auditDeviceUUID(devices, udid)
+		}
+		return uuids
+	case "/mdm/commands":
+		var req struct {
+			UDID string `json:"udid"`
+		}
+		if err := peekJSON(r, &req); err != nil || req.UDID == "" {
+			return nil
+		}
+		return []string{auditDeviceUUID(devices, req.UDID)}
+	}
+	if id := audit.DeviceFromPath(r.URL.Path); id != "" {
+		return []string{auditDeviceUUID(devices, id)}
+	}
+	return nil
+}
+
+// auditDeviceUUID returns the UUID of the device with the UDID or UUID id.
+// Some routes take a UDID and others a UUID, a macOS UDID looks like a UUID,
+// so the id is looked up as a UDID first.
+func auditDeviceUUID(devices device.Datastore, id string) string {
+	if dev, err := devices.GetDeviceByUDID(id, "device_uuid"); err == nil && dev.UUID != "" {
+		return dev.UUID
+	}
+	return id
+}
@@ -0,0 +1,142 @@
+package management
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/micromdm/micromdm/apikey"
+	"golang.org/x/net/context"
+)
+
+type apiKeyContextKey struct{}
+
+// masterKey is the key configured with --api-key. It has every scope.
+var masterKey = apikey.Key{
+	Name:   "api-key",
+	Scopes: []string{apikey.ScopeRead, apikey.ScopeCommand, apikey.ScopeErase, apikey.ScopeAdmin},
+}
+
+// Authenticate rejects management requests which do not carry a valid API key
+// with 401, and requests which the key has no scope for with 403.
+// The key is sent as a bearer token in the Authorization header or in the X-API-Key header.
+// master is the key configured on the command line, it is granted every scope.
+// Other keys are looked up in keys.
+func Authenticate(next http.Handler, keys apikey.Datastore, master string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := lookupAPIKey(requestAPIKey(r), keys, master)
+		if err == apikey.ErrNotFound {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="micromdm"`)
+			http.Error(w, "missing or invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		setAuditActor(r.Context(), key)
+		scope, err := requiredScope(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !key.HasScope(scope) {
+			http.Error(w, "API key is missing the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+		ctx := context.WithValue(r.Context(), apiKeyContextKey{}, key)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// APIKeyFromContext returns the API key which authenticated the request.
+func APIKeyFromContext(ctx context.Context) (*apikey.Key, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(*apikey.Key)
+	return key, ok
+}
+
+func lookupAPIKey(token string, keys apikey.Datastore, master string) (*apikey.Key, error) {
+	if token == "" {
+		return nil, apikey.ErrNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(master)) == 1 {
+		key := masterKey
+		return &key, nil
+	}
+	return keys.ByToken(token)
+}
+
+// requestAPIKey returns the API key sent with the request, or an empty string.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return auth[len("Bearer "):]
+	}
+	return ""
+}
+
+// requiredScope returns the scope an API key needs for the request.
+// Bulk commands and commands sent to /mdm/commands are inspected,
+// so that EraseDevice cannot be sent with the command scope.
+func requiredScope(r *http.Request) (string, error) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/management/v1/apikeys"),
+		strings.HasPrefix(r.URL.Path, "/management/v1/enroll/"),
+		r.URL.Path == "/management/v1/devices/reconcile":
+		return apikey.ScopeAdmin, nil
+	case isBypassCodePath(r.URL.Path):
+		// the bypass code unlocks a device which is erased.
+		return apikey.ScopeErase, nil
+	case r.Method == "GET" || r.Method == "HEAD":
+		return apikey.ScopeRead, nil
+	case strings.HasSuffix(r.URL.Path, "/erase"):
+		return apikey.ScopeErase, nil
+	case r.URL.Path == "/management/v1/devices/commands":
+		var req struct {
+			Command struct {
+				RequestType string `json:"request_type"`
+			} `json:"command"`
+		}
+		if err := peekJSON(r, &req); err != nil {
+			return "", err
+		}
+		if req.Command.RequestType == "EraseDevice" {
+			return apikey.ScopeErase, nil
+		}
+	case r.URL.Path == "/mdm/commands":
+		var req struct {
+			RequestType string `json:"request_type"`
+		}
+		if err := peekJSON(r, &req); err != nil {
+			return "", err
+		}
+		if req.RequestType == "EraseDevice" {
+			return apikey.ScopeErase, nil
+		}
+	}
+	return apikey.ScopeCommand, nil
+}
+
+// peekJSON decodes the JSON body of r into v and puts the body back for the handler.
+// A malformed body is left to the handler to reject.
+func peekJSON(r *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	json.Unmarshal(body, v)
+	return nil
+}
+
+// isBypassCodePath reports whether the request retrieves an Activation Lock bypass code.
+func isBypassCodePath(path string) bool {
+	return strings.HasPrefix(path, "/management/v1/devices/") &&
+		strings.HasSuffix(path, "/activation_lock_bypass_code")
+}
@@ -0,0 +1,315 @@
+package management
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/micromdm/dep"
+	"github.com/micromdm/micromdm/device"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// DefaultDEPAccount is the name of the DEP account configured with the dep-* flags.
+const DefaultDEPAccount = "default"
+
+// ErrUnknownDEPAccount is returned if an operation targets a DEP account which is not configured.
+var ErrUnknownDEPAccount = errors.New("unknown DEP account")
+
+// errMixedDEPAccounts is returned if a profile is assigned to devices from more than one DEP account.
+var errMixedDEPAccounts = errors.New("devices belong to more than one DEP account, assign the profile to each account separately")
+
+// DEPAccounts holds a DEP client for each configured DEP account, keyed by account name.
+type DEPAccounts map[string]dep.Client
+
+// client returns the client of the named account.
+func (a DEPAccounts) client(name string) (dep.Client, error) {
+	if name == "" {
+		name = DefaultDEPAccount
+	}
+	client, ok := a[name]
+	if !ok {
+		return nil, ErrUnknownDEPAccount
+	}
+	return client, nil
+}
+
+// names returns the sorted account names.
+func (a DEPAccounts) names() []string {
+	names := make([]string, 0, len(a))
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DEP requests which are throttled are retried with an exponential backoff
+// starting at depRetryWait.
+const (
+	depRetryAttempts = 5
+	depRetryWait     = 2 * time.Second
+)
+
+// statusCoder is implemented by errors which carry the HTTP status code of a response.
+type statusCoder interface {
+	StatusCode() int
+}
+
+// isDEPRateLimited returns true if the DEP API rejected a request
+// with 429 Too Many Requests.
+// The dep client does not export a typed error, it reports the status line of the
+// response in the message. The full status line is matched so that a serial number
+// or UUID containing 429 is not mistaken for the status code.
+func isDEPRateLimited(err error) bool {
+	if sc, ok := errors.Cause(err).(statusCoder); ok {
+		return sc.StatusCode() == http.StatusTooManyRequests
+	}
+	status := fmt.Sprintf("%d %s", http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
+	return strings.Contains(err.Error(), status)
+}
+
+// retryDEP calls fn until it succeeds, returns an error other than a
+// rate limit response, or depRetryAttempts is reached.
+func retryDEP(fn func() error) error {
+	wait := depRetryWait
+	var err error
+	for attempt := 1; attempt <= depRetryAttempts; attempt++ {
+		err = fn()
+		if err == nil || !isDEPRateLimited(err) {
+			return err
+		}
+		if attempt < depRetryAttempts {
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+	return err
+}
+
+func (svc service) AssignDEPProfile(account string, p *dep.Profile, serials []string) (*dep.ProfileResponse, error) {
+	if len(serials) == 0 {
+		return nil, errEmptyRequest
+	}
+	if account == "" {
+		var err error
+		if account, err = svc.depAccountOf(serials); err != nil {
+			return nil, err
+		}
+	}
+	client, err := svc.depClients.client(account)
+	if err != nil {
+		return nil, err
+	}
+	// devices are assigned below, so the profile is defined without any
+	p.Devices = nil
+	var defined *dep.ProfileResponse
+	err = retryDEP(func() error {
+		var err error
+		defined, err = client.DefineProfile(p)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "management: define dep profile")
+	}
+
+	var assigned *dep.ProfileResponse
+	err = retryDEP(func() error {
+		var err error
+		assigned, err = client.AssignProfile(defined.ProfileUUID, serials...)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "management: assign dep profile")
+	}
+	if assigned.ProfileUUID == "" {
+		assigned.ProfileUUID = defined.ProfileUUID
+	}
+
+	now := time.Now().UTC()
+	for serial, status := range assigned.Devices {
+		if status != "SUCCESS" {
+			continue
+		}
+		dev := &device.Device{
+			DEPProfileStatus:     device.ASSIGNED,
+			DEPProfileUUID:       assigned.ProfileUUID,
+			DEPProfileAssignTime: now,
+		}
+		dev.SerialNumber.Scan(serial)
+		if err := svc.devices.Save("depProfile", dev); err != nil {
+			return assigned, errors.Wrap(err, "management: save dep profile assignment")
+		}
+	}
+	return assigned, nil
+}
+
+// depAccountOf returns the DEP account the devices were fetched from.
+// Devices which were never fetched are assumed to belong to the default account.
+func (svc service) depAccountOf(serials []string) (string, error) {
+	var account string
+	for _, serial := range serials {
+		devs, err := svc.devices.Devices(device.SerialNumber{SerialNumber: serial})
+		if err != nil {
+			return "", errors.Wrap(err, "management: dep account of device")
+		}
+		if len(devs) == 0 || devs[0].DEPAccount == "" {
+			continue
+		}
+		if account != "" && account != devs[0].DEPAccount {
+			return "", errMixedDEPAccounts
+		}
+		account = devs[0].DEPAccount
+	}
+	return account, nil
+}
+
+// depPageSize is the number of devices requested from DEP at once.
+const depPageSize = 1000
+
+// isDEPCursorExpired returns true if DEP rejected a sync cursor.
+// Cursors expire after seven days, the devices must be fetched again.
+func isDEPCursorExpired(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "EXPIRED_CURSOR") || strings.Contains(msg, "INVALID_CURSOR")
+}
+
+func (svc service) FetchDEPDevices(account string) error {
+	if account != "" {
+		return svc.fetchDEPDevices(account)
+	}
+	for _, name := range svc.depClients.names() {
+		if err := svc.fetchDEPDevices(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (svc service) fetchDEPDevices(account string) error {
+	client, err := svc.depClients.client(account)
+	if err != nil {
+		return err
+	}
+	// the background sync and a manual fetch share the cursor
+	svc.depFetch.Lock()
+	defer svc.depFetch.Unlock()
+	cursor, fetching, err := svc.devices.DEPCursor(account)
+	if err != nil {
+		return errors.Wrap(err, "management: dep fetch")
+	}
+	// a full fetch until it finished once, then only the changes
+	sync := cursor != "" && !fetching
+	for {
+		var resp *dep.DeviceResponse
+		err := retryDEP(func() error {
+			var err error
+			if sync {
+				resp, err = client.SyncDevices(cursor, dep.Limit(depPageSize))
+			} else {
+				resp, err = client.FetchDevices(dep.Limit(depPageSize), dep.Cursor(cursor))
+			}
+			return err
+		})
+		if err != nil && cursor != "" && isDEPCursorExpired(err) {
+			// forget the cursor before fetching again, so that an interrupted
+			// fetch does not resume with the expired cursor
+			cursor, sync = "", false
+			if err := svc.devices.SaveDEPCursor(account, cursor, true); err != nil {
+				return errors.Wrap(err, "management: dep fetch")
+			}
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "management: dep fetch for account %s", account)
+		}
+		for _, d := range resp.Devices {
+			if err := svc.saveDEPDevice(account, d); err != nil {
+				return errors.Wrap(err, "management: dep fetch")
+			}
+		}
+		// save the cursor after each page, so that an interrupted fetch resumes
+		// with FetchDevices until its last page
+		cursor = resp.Cursor
+		fetching = !sync && resp.MoreToFollow
+		if err := svc.devices.SaveDEPCursor(account, cursor, fetching); err != nil {
+			return errors.Wrap(err, "management: dep fetch")
+		}
+		if !resp.MoreToFollow {
+			return nil
+		}
+	}
+}
+
+// saveDEPDevice upserts a device from a DEP fetch or sync response.
+func (svc service) saveDEPDevice(account string, d dep.Device) error {
+	dev := device.NewFromDEP(d)
+	dev.DEPAccount = account
+	if d.OpType == "deleted" {
+		dev.DEPProfileStatus = device.REMOVED
+		return svc.devices.Save("depRemoved", dev)
+	}
+	_, err := svc.devices.New("fetch", dev)
+	return err
+}
+
+// SyncDEPDevices calls FetchDEPDevices for all accounts every interval until ctx is done.
+func SyncDEPDevices(ctx context.Context, svc Service, interval time.Duration, logger kitlog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := svc.FetchDEPDevices(""); err != nil {
+				logger.Log("msg", "syncing DEP devices", "err", err)
+			}
+		}
+	}
+}
+
+// depAccountTTL is how long the DEP account details are cached.
+const depAccountTTL = 5 * time.Minute
+
+// depAccountCache holds the last account details returned by each DEP account.
+type depAccountCache struct {
+	mu       sync.Mutex
+	accounts map[string]cachedDEPAccount
+}
+
+type cachedDEPAccount struct {
+	account   *dep.Account
+	fetchedAt time.Time
+}
+
+func (svc service) DEPAccount(name string) (*dep.Account, error) {
+	if name == "" {
+		name = DefaultDEPAccount
+	}
+	client, err := svc.depClients.client(name)
+	if err != nil {
+		return nil, err
+	}
+	svc.depAccount.mu.Lock()
+	defer svc.depAccount.mu.Unlock()
+	if cached, ok := svc.depAccount.accounts[name]; ok && time.Since(cached.fetchedAt) < depAccountTTL {
+		return cached.account, nil
+	}
+	var account *dep.Account
+	err = retryDEP(func() error {
+		var err error
+		account, err = client.Account()
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "management: dep account")
+	}
+	svc.depAccount.accounts[name] = cachedDEPAccount{account: account, fetchedAt: time.Now()}
+	return account, nil
+}
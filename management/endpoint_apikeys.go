@@ -0,0 +1,80 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/micromdm/apikey"
+	"golang.org/x/net/context"
+)
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// createAPIKeyResponse holds the secret token. It is not shown again.
+type createAPIKeyResponse struct {
+	*apikey.Key
+	Token string `json:"token,omitempty"`
+	Err   error  `json:"error,omitempty"`
+}
+
+func (r createAPIKeyResponse) status() int { return http.StatusCreated }
+
+func (r createAPIKeyResponse) error() error { return r.Err }
+
+func makeCreateAPIKeyEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(createAPIKeyRequest)
+		key, token, err := svc.CreateAPIKey(req.Name, req.Scopes)
+		return createAPIKeyResponse{Key: key, Token: token, Err: err}, nil
+	}
+}
+
+type listAPIKeysRequest struct{}
+
+type listAPIKeysResponse struct {
+	keys []apikey.Key
+	Err  error `json:"error,omitempty"`
+}
+
+func (r listAPIKeysResponse) error() error { return r.Err }
+
+func (r listAPIKeysResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeListAPIKeysEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		keys, err := svc.APIKeys()
+		return listAPIKeysResponse{Err: err, keys: keys}, nil
+	}
+}
+
+type revokeAPIKeyRequest struct {
+	UUID string
+}
+
+type revokeAPIKeyResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r revokeAPIKeyResponse) status() int { return http.StatusNoContent }
+
+func (r revokeAPIKeyResponse) error() error { return r.Err }
+
+func makeRevokeAPIKeyEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(revokeAPIKeyRequest)
+		err := svc.RevokeAPIKey(req.UUID)
+		return revokeAPIKeyResponse{Err: err}, nil
+	}
+}
@@ -0,0 +1,97 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/micromdm/application"
+	"golang.org/x/net/context"
+)
+
+type listApplicationsRequest struct {
+	Query string
+}
+
+type listApplicationsResponse struct {
+	apps []application.AppCount
+	Err  error `json:"error,omitempty"`
+}
+
+func (r listApplicationsResponse) error() error { return r.Err }
+
+func (r listApplicationsResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.apps, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeListApplicationsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(listApplicationsRequest)
+		apps, err := svc.Applications(req.Query)
+		return listApplicationsResponse{Err: err, apps: apps}, nil
+	}
+}
+
+type applicationDevicesRequest struct {
+	Identifier string
+}
+
+type applicationDevicesResponse struct {
+	installs []application.AppInstall
+	Err      error `json:"error,omitempty"`
+}
+
+func (r applicationDevicesResponse) error() error { return r.Err }
+
+func (r applicationDevicesResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.installs, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeApplicationDevicesEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(applicationDevicesRequest)
+		installs, err := svc.ApplicationDevices(req.Identifier)
+		return applicationDevicesResponse{Err: err, installs: installs}, nil
+	}
+}
+
+type applicationVersionsRequest struct {
+	Identifier string
+}
+
+type applicationVersionsResponse struct {
+	versions []application.AppVersion
+	Err      error `json:"error,omitempty"`
+}
+
+func (r applicationVersionsResponse) error() error { return r.Err }
+
+func (r applicationVersionsResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.versions, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeApplicationVersionsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(applicationVersionsRequest)
+		versions, err := svc.ApplicationVersions(req.Identifier)
+		return applicationVersionsResponse{Err: err, versions: versions}, nil
+	}
+}
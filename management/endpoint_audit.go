@@ -0,0 +1,46 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/micromdm/audit"
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type auditLogRequest struct {
+	Device string
+	Actor  string
+	Limit  int
+}
+
+type auditLogResponse struct {
+	entries []audit.Entry
+	Err     error `json:"error,omitempty"`
+}
+
+func (r auditLogResponse) error() error { return r.Err }
+
+func (r auditLogResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeAuditLogEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(auditLogRequest)
+		entries, err := svc.AuditLog(req.Device, req.Actor, req.Limit)
+		return auditLogResponse{Err: err, entries: entries}, nil
+	}
+}
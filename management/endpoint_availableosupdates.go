@@ -0,0 +1,42 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/micromdm/osupdate"
+	"golang.org/x/net/context"
+)
+
+type availableOSUpdatesRequest struct {
+	UUID string
+}
+
+type availableOSUpdatesResponse struct {
+	updates []osupdate.Update
+	Err     error `json:"error,omitempty"`
+}
+
+func (r availableOSUpdatesResponse) error() error { return r.Err }
+
+func (r availableOSUpdatesResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.updates, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeAvailableOSUpdatesEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(availableOSUpdatesRequest)
+		updates, err := svc.AvailableOSUpdates(req.UUID)
+		if err != nil {
+			return availableOSUpdatesResponse{Err: err}, nil
+		}
+		return availableOSUpdatesResponse{updates: updates}, nil
+	}
+}
@@ -0,0 +1,40 @@
+package management
+
+import (
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+type bulkCommandRequest struct {
+	UDIDs []string `json:"udids,omitempty"`
+	// Group targets every member of the named group instead of a list of UDIDs.
+	Group   string             `json:"group,omitempty"`
+	Command mdm.CommandRequest `json:"command"`
+}
+
+type bulkCommandResponse struct {
+	Summary BulkCommandSummary  `json:"summary"`
+	Results []BulkCommandResult `json:"results"`
+	Err     error               `json:"error,omitempty"`
+}
+
+func (r bulkCommandResponse) status() int  { return http.StatusAccepted }
+func (r bulkCommandResponse) error() error { return r.Err }
+
+func makeBulkCommandEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(bulkCommandRequest)
+		if req.Group != "" {
+			results, err := svc.GroupCommand(req.Group, req.Command)
+			if err != nil {
+				return bulkCommandResponse{Err: err}, nil
+			}
+			return bulkCommandResponse{Summary: Summarize(results), Results: results}, nil
+		}
+		results := svc.BulkCommand(req.UDIDs, req.Command)
+		return bulkCommandResponse{Summary: Summarize(results), Results: results}, nil
+	}
+}
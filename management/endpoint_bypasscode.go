@@ -0,0 +1,31 @@
+package management
+
+import (
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/net/context"
+)
+
+type activationLockBypassCodeRequest struct {
+	UUID string
+}
+
+type activationLockBypassCodeResponse struct {
+	Code string `json:"activation_lock_bypass_code,omitempty"`
+	Err  error  `json:"error,omitempty"`
+}
+
+func (r activationLockBypassCodeResponse) status() int  { return http.StatusOK }
+func (r activationLockBypassCodeResponse) error() error { return r.Err }
+
+func makeActivationLockBypassCodeEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(activationLockBypassCodeRequest)
+		code, err := svc.ActivationLockBypassCode(req.UUID)
+		if err != nil {
+			return activationLockBypassCodeResponse{Err: err}, nil
+		}
+		return activationLockBypassCodeResponse{Code: code}, nil
+	}
+}
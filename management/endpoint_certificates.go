@@ -6,6 +6,7 @@ import (
 	"github.com/micromdm/micromdm/certificate"
 	"golang.org/x/net/context"
 	"net/http"
+	"time"
 )
 
 type listCertificatesRequest struct {
@@ -39,3 +40,36 @@ func makeCertificatesEndpoint(svc Service) endpoint.Endpoint {
 		return listCertificatesResponse{certificates: certs}, nil
 	}
 }
+
+// defaultExpiryWindow is used when an expiring certificates request does not set a window.
+const defaultExpiryWindow = 30 * 24 * time.Hour
+
+type expiringCertificatesRequest struct {
+	Within time.Duration
+}
+
+func makeExpiringCertificatesEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(expiringCertificatesRequest)
+		certs, err := svc.ExpiringCertificates(req.Within)
+		if err != nil {
+			return listCertificatesResponse{Err: err}, nil
+		}
+		return listCertificatesResponse{certificates: certs}, nil
+	}
+}
+
+type issuedCertificatesRequest struct {
+	Issuer string
+}
+
+func makeIssuedCertificatesEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(issuedCertificatesRequest)
+		certs, err := svc.CertificatesIssuedBy(req.Issuer)
+		if err != nil {
+			return listCertificatesResponse{Err: err}, nil
+		}
+		return listCertificatesResponse{certificates: certs}, nil
+	}
+}
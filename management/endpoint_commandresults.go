@@ -0,0 +1,48 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/micromdm/commandresult"
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultCommandResultsLimit = 50
+	maxCommandResultsLimit     = 500
+)
+
+type commandResultsRequest struct {
+	UUID  string
+	Limit int
+}
+
+type commandResultsResponse struct {
+	results []commandresult.Result
+	Err     error `json:"error,omitempty"`
+}
+
+func (r commandResultsResponse) error() error { return r.Err }
+
+func (r commandResultsResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.results, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeCommandResultsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(commandResultsRequest)
+		results, err := svc.CommandResults(req.UUID, req.Limit)
+		if err != nil {
+			return commandResultsResponse{Err: err}, nil
+		}
+		return commandResultsResponse{results: results}, nil
+	}
+}
@@ -2,10 +2,13 @@ package management
 
 import (
 	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/dep"
 	"golang.org/x/net/context"
 )
 
-type fetchDEPDevicesRequest struct{}
+type fetchDEPDevicesRequest struct {
+	Account string
+}
 
 type fetchDEPDevicesResponse struct {
 	Err error `json:"error,omitempty"`
@@ -15,7 +18,64 @@ func (r fetchDEPDevicesResponse) error() error { return r.Err }
 
 func makeFetchDevicesEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		err := svc.FetchDEPDevices()
+		req := request.(fetchDEPDevicesRequest)
+		err := svc.FetchDEPDevices(req.Account)
 		return fetchDEPDevicesResponse{Err: err}, nil
 	}
 }
+
+type assignDEPProfileRequest struct {
+	// Account is the DEP account to assign the profile in.
+	// If empty, the account the devices were fetched from is used.
+	Account       string       `json:"account,omitempty"`
+	Profile       *dep.Profile `json:"profile"`
+	SerialNumbers []string     `json:"serial_numbers"`
+}
+
+type assignDEPProfileResponse struct {
+	*dep.ProfileResponse
+	Err error `json:"error,omitempty"`
+}
+
+func (r assignDEPProfileResponse) error() error { return r.Err }
+
+func makeAssignDEPProfileEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(assignDEPProfileRequest)
+		resp, err := svc.AssignDEPProfile(req.Account, req.Profile, req.SerialNumbers)
+		return assignDEPProfileResponse{Err: err, ProfileResponse: resp}, nil
+	}
+}
+
+type depAccountRequest struct {
+	Account string
+}
+
+// depAccountResponse holds the details operators need to identify the DEP account.
+type depAccountResponse struct {
+	ServerName string `json:"server_name,omitempty"`
+	OrgName    string `json:"org_name,omitempty"`
+	OrgPhone   string `json:"org_phone,omitempty"`
+	OrgEmail   string `json:"org_email,omitempty"`
+	AdminID    string `json:"admin_id,omitempty"`
+	Err        error  `json:"error,omitempty"`
+}
+
+func (r depAccountResponse) error() error { return r.Err }
+
+func makeDEPAccountEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(depAccountRequest)
+		account, err := svc.DEPAccount(req.Account)
+		if err != nil {
+			return depAccountResponse{Err: err}, nil
+		}
+		return depAccountResponse{
+			ServerName: account.ServerName,
+			OrgName:    account.OrgName,
+			OrgPhone:   account.OrgPhone,
+			OrgEmail:   account.OrgEmail,
+			AdminID:    account.AdminID,
+		}, nil
+	}
+}
@@ -3,6 +3,7 @@ package management
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"golang.org/x/net/context"
 
@@ -10,10 +11,19 @@ import (
 	"github.com/micromdm/micromdm/device"
 )
 
-type listDevicesRequest struct{}
+const (
+	defaultDevicesPageSize = 100
+	maxDevicesPageSize     = 1000
+)
+
+type listDevicesRequest struct {
+	Limit  int
+	Offset int
+}
 
 type listDevicesResponse struct {
 	devices []device.Device
+	total   int
 	Err     error `json:"error,omitempty"`
 }
 
@@ -25,23 +35,38 @@ func (r listDevicesResponse) encodeList(w http.ResponseWriter) error {
 		return err
 	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Total-Count", strconv.Itoa(r.total))
 	w.Write(jsn)
 	return nil
 }
 
 func makeListDevicesEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		ds, err := svc.Devices()
-		return listDevicesResponse{Err: err, devices: ds}, nil
+		req := request.(listDevicesRequest)
+		ds, total, err := svc.Devices(req.Limit, req.Offset)
+		return listDevicesResponse{Err: err, devices: ds, total: total}, nil
+	}
+}
+
+type searchDevicesRequest struct {
+	Query string
+}
+
+func makeSearchDevicesEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(searchDevicesRequest)
+		ds, err := svc.SearchDevices(req.Query)
+		return listDevicesResponse{Err: err, devices: ds, total: len(ds)}, nil
 	}
 }
 
 type showDeviceRequest struct {
-	UUID string
+	// ID is the UDID or the UUID of the device.
+	ID string
 }
 
 type showDeviceResponse struct {
-	*device.Device
+	*DeviceDetail
 	Err error `json:"error,omitempty"`
 }
 
@@ -50,11 +75,11 @@ func (r showDeviceResponse) error() error { return r.Err }
 func makeShowDeviceEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(showDeviceRequest)
-		dev, err := svc.Device(req.UUID)
+		detail, err := svc.DeviceDetail(req.ID)
 		if err != nil {
 			return showDeviceResponse{Err: err}, nil
 		}
-		return showDeviceResponse{Device: dev}, nil
+		return showDeviceResponse{DeviceDetail: detail}, nil
 	}
 }
 
@@ -80,3 +105,22 @@ func makeUpdateDeviceEndpoint(svc Service) endpoint.Endpoint {
 		return updateDeviceResponse{Err: err}, nil
 	}
 }
+
+type deleteDeviceRequest struct {
+	UUID string
+}
+
+type deleteDeviceResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r deleteDeviceResponse) error() error { return r.Err }
+func (r deleteDeviceResponse) status() int  { return http.StatusNoContent }
+
+func makeDeleteDeviceEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(deleteDeviceRequest)
+		err := svc.DeleteDevice(req.UUID)
+		return deleteDeviceResponse{Err: err}, nil
+	}
+}
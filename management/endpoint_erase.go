@@ -0,0 +1,35 @@
+package management
+
+import (
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+type eraseDeviceRequest struct {
+	UDID string `json:"-"`
+	mdm.EraseDevice
+	// Confirmation must equal the serial number of the device.
+	Confirmation string `json:"confirmation"`
+}
+
+type eraseDeviceResponse struct {
+	*mdm.Payload
+	Err error `json:"error,omitempty"`
+}
+
+func (r eraseDeviceResponse) status() int  { return http.StatusCreated }
+func (r eraseDeviceResponse) error() error { return r.Err }
+
+func makeEraseDeviceEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(eraseDeviceRequest)
+		payload, err := svc.EraseDevice(req.UDID, req.EraseDevice, req.Confirmation)
+		if err != nil {
+			return eraseDeviceResponse{Err: err}, nil
+		}
+		return eraseDeviceResponse{Payload: payload}, nil
+	}
+}
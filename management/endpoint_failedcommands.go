@@ -0,0 +1,42 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/micromdm/command"
+	"golang.org/x/net/context"
+)
+
+type failedCommandsRequest struct {
+	UUID string
+}
+
+type failedCommandsResponse struct {
+	commands []command.FailedCommand
+	Err      error `json:"error,omitempty"`
+}
+
+func (r failedCommandsResponse) error() error { return r.Err }
+
+func (r failedCommandsResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.commands, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeFailedCommandsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(failedCommandsRequest)
+		commands, err := svc.FailedCommands(req.UUID)
+		if err != nil {
+			return failedCommandsResponse{Err: err}, nil
+		}
+		return failedCommandsResponse{commands: commands}, nil
+	}
+}
@@ -0,0 +1,126 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/micromdm/device"
+	"golang.org/x/net/context"
+)
+
+type addGroupRequest struct {
+	*device.Group
+}
+
+type addGroupResponse struct {
+	*device.Group
+	Err error `json:"error,omitempty"`
+}
+
+func (r addGroupResponse) status() int { return http.StatusCreated }
+
+func (r addGroupResponse) error() error { return r.Err }
+
+func makeAddGroupEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(addGroupRequest)
+		g, err := svc.AddGroup(req.Group)
+		return addGroupResponse{Err: err, Group: g}, nil
+	}
+}
+
+type listGroupsRequest struct{}
+
+type listGroupsResponse struct {
+	groups []device.Group
+	Err    error `json:"error,omitempty"`
+}
+
+func (r listGroupsResponse) error() error { return r.Err }
+
+func (r listGroupsResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.groups, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeListGroupsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		groups, err := svc.Groups()
+		return listGroupsResponse{Err: err, groups: groups}, nil
+	}
+}
+
+type renameGroupRequest struct {
+	UUID string `json:"-"`
+	Name string `json:"name"`
+}
+
+type renameGroupResponse struct {
+	*device.Group
+	Err error `json:"error,omitempty"`
+}
+
+func (r renameGroupResponse) error() error { return r.Err }
+
+func makeRenameGroupEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(renameGroupRequest)
+		g, err := svc.RenameGroup(req.UUID, req.Name)
+		return renameGroupResponse{Err: err, Group: g}, nil
+	}
+}
+
+type deleteGroupRequest struct {
+	UUID string
+}
+
+type deleteGroupResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r deleteGroupResponse) status() int { return http.StatusNoContent }
+
+func (r deleteGroupResponse) error() error { return r.Err }
+
+func makeDeleteGroupEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(deleteGroupRequest)
+		err := svc.DeleteGroup(req.UUID)
+		return deleteGroupResponse{Err: err}, nil
+	}
+}
+
+type groupDeviceRequest struct {
+	GroupUUID  string
+	DeviceUUID string
+}
+
+type groupDeviceResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r groupDeviceResponse) status() int { return http.StatusNoContent }
+
+func (r groupDeviceResponse) error() error { return r.Err }
+
+func makeAddGroupDeviceEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(groupDeviceRequest)
+		err := svc.AddGroupDevice(req.GroupUUID, req.DeviceUUID)
+		return groupDeviceResponse{Err: err}, nil
+	}
+}
+
+func makeRemoveGroupDeviceEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(groupDeviceRequest)
+		err := svc.RemoveGroupDevice(req.GroupUUID, req.DeviceUUID)
+		return groupDeviceResponse{Err: err}, nil
+	}
+}
@@ -0,0 +1,42 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/micromdm/profile"
+	"golang.org/x/net/context"
+)
+
+type installedProfilesRequest struct {
+	UUID string
+}
+
+type installedProfilesResponse struct {
+	profiles []profile.Profile
+	Err      error `json:"error,omitempty"`
+}
+
+func (r installedProfilesResponse) error() error { return r.Err }
+
+func (r installedProfilesResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeInstalledProfilesEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(installedProfilesRequest)
+		profiles, err := svc.InstalledProfiles(req.UUID)
+		if err != nil {
+			return installedProfilesResponse{Err: err}, nil
+		}
+		return installedProfilesResponse{profiles: profiles}, nil
+	}
+}
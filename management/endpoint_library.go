@@ -0,0 +1,102 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/micromdm/profile"
+	"golang.org/x/net/context"
+)
+
+// addLibraryProfileRequest uploads a profile. Uploading an existing name adds a new version.
+type addLibraryProfileRequest struct {
+	Name string `json:"name"`
+	// Data is the .mobileconfig, base64 encoded in JSON.
+	Data []byte `json:"data"`
+}
+
+type addLibraryProfileResponse struct {
+	*profile.LibraryProfile
+	Err error `json:"error,omitempty"`
+}
+
+func (r addLibraryProfileResponse) status() int { return http.StatusCreated }
+
+func (r addLibraryProfileResponse) error() error { return r.Err }
+
+func makeAddLibraryProfileEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(addLibraryProfileRequest)
+		p, err := svc.AddLibraryProfile(req.Name, req.Data)
+		return addLibraryProfileResponse{Err: err, LibraryProfile: p}, nil
+	}
+}
+
+type listLibraryProfilesRequest struct{}
+
+type listLibraryProfilesResponse struct {
+	profiles []profile.LibraryProfile
+	Err      error `json:"error,omitempty"`
+}
+
+func (r listLibraryProfilesResponse) error() error { return r.Err }
+
+func (r listLibraryProfilesResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeListLibraryProfilesEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		profiles, err := svc.LibraryProfiles()
+		return listLibraryProfilesResponse{Err: err, profiles: profiles}, nil
+	}
+}
+
+type showLibraryProfileRequest struct {
+	Name    string
+	Version int
+}
+
+type showLibraryProfileResponse struct {
+	*profile.LibraryProfile
+	Err error `json:"error,omitempty"`
+}
+
+func (r showLibraryProfileResponse) error() error { return r.Err }
+
+func makeShowLibraryProfileEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(showLibraryProfileRequest)
+		p, err := svc.LibraryProfile(req.Name, req.Version)
+		if err != nil {
+			return showLibraryProfileResponse{Err: err}, nil
+		}
+		return showLibraryProfileResponse{LibraryProfile: p}, nil
+	}
+}
+
+type deleteLibraryProfileRequest struct {
+	Name string
+}
+
+type deleteLibraryProfileResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r deleteLibraryProfileResponse) status() int  { return http.StatusNoContent }
+func (r deleteLibraryProfileResponse) error() error { return r.Err }
+
+func makeDeleteLibraryProfileEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(deleteLibraryProfileRequest)
+		err := svc.DeleteLibraryProfile(req.Name)
+		return deleteLibraryProfileResponse{Err: err}, nil
+	}
+}
@@ -7,6 +7,8 @@ import (
 
 type pushRequest struct {
 	UDID string
+	// UserID pushes to a user channel instead of the device channel.
+	UserID string
 }
 
 type pushResponse struct {
@@ -20,7 +22,13 @@ func (r pushResponse) error() error { return r.Err }
 func makePushEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(pushRequest)
-		id, err := svc.Push(req.UDID)
+		var id string
+		var err error
+		if req.UserID != "" {
+			id, err = svc.PushUser(req.UDID, req.UserID)
+		} else {
+			id, err = svc.Push(req.UDID)
+		}
 		if err != nil {
 			return pushResponse{Err: err, Status: "failure"}, nil
 		}
@@ -0,0 +1,36 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/micromdm/device"
+	"golang.org/x/net/context"
+)
+
+type reconcileDevicesRequest struct{}
+
+type reconcileDevicesResponse struct {
+	merged []device.Duplicate
+	Err    error `json:"error,omitempty"`
+}
+
+func (r reconcileDevicesResponse) error() error { return r.Err }
+
+func (r reconcileDevicesResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.merged, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeReconcileDevicesEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		merged, err := svc.ReconcileDuplicates()
+		return reconcileDevicesResponse{Err: err, merged: merged}, nil
+	}
+}
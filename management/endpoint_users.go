@@ -0,0 +1,42 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/micromdm/user"
+	"golang.org/x/net/context"
+)
+
+type usersRequest struct {
+	UUID string
+}
+
+type usersResponse struct {
+	users []user.User
+	Err   error `json:"error,omitempty"`
+}
+
+func (r usersResponse) error() error { return r.Err }
+
+func (r usersResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.users, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeUsersEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(usersRequest)
+		users, err := svc.Users(req.UUID)
+		if err != nil {
+			return usersResponse{Err: err}, nil
+		}
+		return usersResponse{users: users}, nil
+	}
+}
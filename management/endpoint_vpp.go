@@ -0,0 +1,65 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/micromdm/micromdm/application"
+	"golang.org/x/net/context"
+)
+
+// saveVPPAppRequest adds a VPP app or updates the number of licenses owned.
+type saveVPPAppRequest struct {
+	application.VPPApp
+}
+
+type saveVPPAppResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r saveVPPAppResponse) error() error { return r.Err }
+
+func makeSaveVPPAppEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(saveVPPAppRequest)
+		err := svc.SaveVPPApp(&req.VPPApp)
+		return saveVPPAppResponse{Err: err}, nil
+	}
+}
+
+type listVPPAppsRequest struct{}
+
+// vppAppLicenses shows how many licenses of a VPP app are left to assign.
+type vppAppLicenses struct {
+	application.VPPApp
+	AvailableLicenses int `json:"available_licenses"`
+}
+
+type listVPPAppsResponse struct {
+	apps []application.VPPApp
+	Err  error `json:"error,omitempty"`
+}
+
+func (r listVPPAppsResponse) error() error { return r.Err }
+
+func (r listVPPAppsResponse) encodeList(w http.ResponseWriter) error {
+	apps := make([]vppAppLicenses, len(r.apps))
+	for i, app := range r.apps {
+		apps[i] = vppAppLicenses{VPPApp: app, AvailableLicenses: app.AvailableLicenses()}
+	}
+	jsn, err := json.MarshalIndent(apps, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeListVPPAppsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		apps, err := svc.VPPApps()
+		return listVPPAppsResponse{Err: err, apps: apps}, nil
+	}
+}
@@ -55,3 +55,48 @@ func makeListWorkflowsEndpoint(svc Service) endpoint.Endpoint {
 		return listWorkflowsResponse{Err: err, workflows: workflows}, nil
 	}
 }
+
+type assignWorkflowRequest struct {
+	workflow.Assignment
+}
+
+type assignWorkflowResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r assignWorkflowResponse) status() int  { return http.StatusNoContent }
+func (r assignWorkflowResponse) error() error { return r.Err }
+
+func makeAssignWorkflowEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(assignWorkflowRequest)
+		err := svc.AssignWorkflowTo(req.Assignment)
+		return assignWorkflowResponse{Err: err}, nil
+	}
+}
+
+type listWorkflowAssignmentsRequest struct{}
+
+type listWorkflowAssignmentsResponse struct {
+	assignments []workflow.Assignment
+	Err         error `json:"error,omitempty"`
+}
+
+func (r listWorkflowAssignmentsResponse) error() error { return r.Err }
+
+func (r listWorkflowAssignmentsResponse) encodeList(w http.ResponseWriter) error {
+	jsn, err := json.MarshalIndent(r.assignments, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+	return nil
+}
+
+func makeListWorkflowAssignmentsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		assignments, err := svc.WorkflowAssignments()
+		return listWorkflowAssignmentsResponse{Err: err, assignments: assignments}, nil
+	}
+}
@@ -0,0 +1,23 @@
+package management
+
+import (
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/micromdm/micromdm/device"
+)
+
+// ResendPendingPushes sends a push to every device which was marked as push
+// pending because APNs could not be reached. The marker is cleared once the push
+// was sent, devices which still cannot be pushed to stay marked.
+func ResendPendingPushes(svc Service, devices device.Datastore, logger kitlog.Logger) {
+	pending, err := devices.Devices(device.PushPending{})
+	if err != nil {
+		logger.Log("msg", "listing devices with pending pushes", "err", err)
+		return
+	}
+	for _, dev := range pending {
+		udid := dev.UDID.String
+		if _, err := svc.Push(udid); err != nil {
+			logger.Log("msg", "resending pending push", "udid", udid, "err", err)
+		}
+	}
+}
@@ -1,13 +1,29 @@
 package management
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
 	"github.com/RobotsAndPencils/buford/payload"
 	"github.com/RobotsAndPencils/buford/push"
 	"github.com/micromdm/dep"
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/apikey"
+	"github.com/micromdm/micromdm/apns"
 	"github.com/micromdm/micromdm/application"
+	"github.com/micromdm/micromdm/audit"
 	"github.com/micromdm/micromdm/certificate"
+	"github.com/micromdm/micromdm/command"
+	"github.com/micromdm/micromdm/commandresult"
 	"github.com/micromdm/micromdm/device"
+	"github.com/micromdm/micromdm/escrow"
+	"github.com/micromdm/micromdm/osupdate"
+	"github.com/micromdm/micromdm/profile"
+	"github.com/micromdm/micromdm/user"
 	"github.com/micromdm/micromdm/workflow"
 	"github.com/pkg/errors"
 )
@@ -15,6 +31,10 @@ import (
 // ErrNotFound ...
 var ErrNotFound = errors.New("not found")
 
+// ErrNoBypassCode is returned if a device did not escrow an Activation Lock bypass code,
+// or the server has no escrow key to decrypt it.
+var ErrNoBypassCode = errors.New("no Activation Lock bypass code escrowed for device")
+
 // Service is the interface that provides methods for managing devices
 type Service interface {
 	// profiles
@@ -22,19 +42,97 @@ type Service interface {
 	Profiles() ([]workflow.Profile, error)
 	Profile(uuid string) (*workflow.Profile, error)
 	DeleteProfile(uuid string) error
+	// profile library
+	// AddLibraryProfile uploads a new version of the named profile.
+	AddLibraryProfile(name string, data []byte) (*profile.LibraryProfile, error)
+	// LibraryProfiles returns the latest version of each profile in the library.
+	LibraryProfiles() ([]profile.LibraryProfile, error)
+	// LibraryProfile returns a version of the named profile, or the latest if version is 0.
+	LibraryProfile(name string, version int) (*profile.LibraryProfile, error)
+	DeleteLibraryProfile(name string) error
+
+	// vpp
+	// SaveVPPApp tracks a VPP app and the number of licenses owned.
+	SaveVPPApp(app *application.VPPApp) error
+	// VPPApps returns the VPP apps with the number of licenses assigned to devices.
+	VPPApps() ([]application.VPPApp, error)
+
+	// api keys
+	// CreateAPIKey returns a new key for the management API and its secret token.
+	CreateAPIKey(name string, scopes []string) (*apikey.Key, string, error)
+	APIKeys() ([]apikey.Key, error)
+	RevokeAPIKey(uuid string) error
+
+	// AuditLog returns the most recent changes made with the management API,
+	// filtered by device UUID or UDID and API key if they are not empty.
+	AuditLog(device, actor string, limit int) ([]audit.Entry, error)
+
 	// workflows
 	AddWorkflow(wf *workflow.Workflow) (*workflow.Workflow, error)
 	Workflows() ([]workflow.Workflow, error)
+	// AssignWorkflowTo selects the workflow devices run after enrolling
+	// with a DEP profile or a named enrollment profile.
+	AssignWorkflowTo(a workflow.Assignment) error
+	WorkflowAssignments() ([]workflow.Assignment, error)
 
-	// Devices
-	Devices() ([]device.Device, error)
+	// Devices returns a page of devices and the total number of devices.
+	Devices(limit, offset int) ([]device.Device, int, error)
+	// Device returns a device by UUID, including devices which were deleted.
 	Device(uuid string) (*device.Device, error)
+	// DeviceDetail returns a device by UDID or UUID with its inventory and the number
+	// of commands waiting for it.
+	DeviceDetail(id string) (*DeviceDetail, error)
+	// DeleteDevice removes a device from management, keeping its history.
+	DeleteDevice(uuid string) error
+	SearchDevices(query string) ([]device.Device, error)
+	// ActivationLockBypassCode returns the decrypted Activation Lock bypass code
+	// the device escrowed, so that a locked device can be erased and reused.
+	ActivationLockBypassCode(uuid string) (string, error)
+	// ReconcileDuplicates merges the devices which have a record for their UDID
+	// and another one for their serial number, and returns the merged devices.
+	ReconcileDuplicates() ([]device.Duplicate, error)
+
+	// Groups
+	AddGroup(g *device.Group) (*device.Group, error)
+	Groups() ([]device.Group, error)
+	RenameGroup(uuid, name string) (*device.Group, error)
+	DeleteGroup(uuid string) error
+	AddGroupDevice(groupUUID, deviceUUID string) error
+	RemoveGroupDevice(groupUUID, deviceUUID string) error
 
 	// Installed Applications
 	InstalledApps(deviceUUID string) ([]application.Application, error)
 
+	// Applications returns the applications installed across all devices with
+	// their install counts, filtered by name or identifier if query is not empty.
+	Applications(query string) ([]application.AppCount, error)
+	// ApplicationDevices returns the devices which have the application installed.
+	ApplicationDevices(identifier string) ([]application.AppInstall, error)
+	// ApplicationVersions returns the number of devices on each version of the application.
+	ApplicationVersions(identifier string) ([]application.AppVersion, error)
+
 	// Installed Certificates
 	Certificates(deviceUUID string) ([]certificate.Certificate, error)
+	// ExpiringCertificates returns the device certificates which expire within the duration.
+	ExpiringCertificates(within time.Duration) ([]certificate.Certificate, error)
+	// CertificatesIssuedBy returns the device certificates issued by a CA, grouped by fingerprint.
+	CertificatesIssuedBy(issuer string) ([]certificate.Certificate, error)
+
+	// Installed Profiles
+	InstalledProfiles(deviceUUID string) ([]profile.Profile, error)
+
+	// Available OS Updates
+	AvailableOSUpdates(deviceUUID string) ([]osupdate.Update, error)
+
+	// Users of a shared iPad
+	Users(deviceUUID string) ([]user.User, error)
+
+	// CommandResults returns the last responses the device sent for MDM commands
+	CommandResults(deviceUUID string, limit int) ([]commandresult.Result, error)
+
+	// FailedCommands returns the commands the device responded to with an Error
+	// or CommandFormatError, most recent first
+	FailedCommands(deviceUUID string) ([]command.FailedCommand, error)
 
 	// AssignWorkflow assigns a workflow to a device
 	AssignWorkflow(deviceUUID, workflowUUID string) error
@@ -42,30 +140,140 @@ type Service interface {
 	// push sends a new push notification to the device
 	// returning the notification ID
 	Push(deviceUDID string) (string, error)
+	// PushUser sends a push notification to a user channel of the device.
+	PushUser(deviceUDID, userID string) (string, error)
+
+	// FetchDEPDevices updates the device datastore with devices from a DEP account,
+	// or from every account if account is empty.
+	// After the first fetch only devices which changed since the previous call are synced.
+	FetchDEPDevices(account string) error
+
+	// AssignDEPProfile defines a DEP enrollment profile and assigns it to the devices
+	// with the given serial numbers. The response holds the assignment status of each device.
+	// If account is empty, the account the devices were fetched from is used.
+	AssignDEPProfile(account string, p *dep.Profile, serials []string) (*dep.ProfileResponse, error)
+
+	// DEPAccount returns the details of a configured DEP account.
+	// If name is empty, the default account is returned.
+	DEPAccount(name string) (*dep.Account, error)
+
+	// EraseDevice queues an EraseDevice command and notifies the device.
+	// confirmation must match the serial number of the device.
+	EraseDevice(deviceUDID string, erase mdm.EraseDevice, confirmation string) (*mdm.Payload, error)
+
+	// BulkCommand queues a copy of the command template for each device
+	// and notifies the devices which had a command queued.
+	BulkCommand(deviceUDIDs []string, template mdm.CommandRequest) []BulkCommandResult
+
+	// GroupCommand is BulkCommand for every enrolled member of the named group.
+	GroupCommand(groupName string, template mdm.CommandRequest) ([]BulkCommandResult, error)
+}
+
+// BulkCommandResult is the outcome of queueing a bulk command for one device.
+type BulkCommandResult struct {
+	UDID        string `json:"udid"`
+	CommandUUID string `json:"command_uuid,omitempty"`
+	Queued      bool   `json:"queued"`
+	Error       string `json:"error,omitempty"`
+	PushError   string `json:"push_error,omitempty"`
+}
+
+// BulkCommandSummary counts the outcomes of a bulk command.
+type BulkCommandSummary struct {
+	Queued     int `json:"queued"`
+	Failed     int `json:"failed"`
+	Pushed     int `json:"pushed"`
+	PushFailed int `json:"push_failed"`
+}
+
+// Summarize counts the results of a bulk command.
+func Summarize(results []BulkCommandResult) BulkCommandSummary {
+	var summary BulkCommandSummary
+	for _, result := range results {
+		if !result.Queued {
+			summary.Failed++
+			continue
+		}
+		summary.Queued++
+		if result.PushError != "" {
+			summary.PushFailed++
+		} else {
+			summary.Pushed++
+		}
+	}
+	return summary
+}
+
+// DefaultPushConcurrency is the number of push notifications sent
+// at the same time for a bulk command.
+const DefaultPushConcurrency = 50
+
+// Option configures a management service.
+type Option func(*service)
+
+// WithPushConcurrency sets the number of push notifications sent at the same
+// time for a bulk command. The pushes share the HTTP/2 connection of the Pusher.
+func WithPushConcurrency(n int) Option {
+	return func(svc *service) {
+		if n > 0 {
+			svc.pushConcurrency = n
+		}
+	}
+}
 
-	// FetchDEPDevices updates the device datastore with devices from DEP
-	FetchDEPDevices() error
+// WithEscrowKey sets the key which decrypts the escrowed Activation Lock bypass codes.
+func WithEscrowKey(key *escrow.Key) Option {
+	return func(svc *service) {
+		svc.escrowKey = key
+	}
 }
 
 // NewService creates a management service
-func NewService(ds device.Datastore, ws workflow.Datastore, dc dep.Client, ps *push.Service, as application.Datastore, cs certificate.Datastore) Service {
-	return &service{
+func NewService(ds device.Datastore, ws workflow.Datastore, dc DEPAccounts, ps apns.Pusher, as application.Datastore, cs certificate.Datastore, prs profile.Datastore, us osupdate.Datastore, uds user.Datastore, rs commandresult.Datastore, ks apikey.Datastore, ads audit.Datastore, cmds command.Service, opts ...Option) Service {
+	svc := &service{
+		commands:     cmds,
 		devices:      ds,
-		depClient:    dc,
+		depClients:   dc,
 		workflows:    ws,
 		pushsvc:      ps,
 		applications: as,
 		certificates: cs,
+		profiles:     prs,
+		updates:      us,
+		users:        uds,
+		results:      rs,
+		apiKeys:      ks,
+		audit:        ads,
+		depAccount:   &depAccountCache{accounts: make(map[string]cachedDEPAccount)},
+		depFetch:     &sync.Mutex{},
+
+		pushConcurrency: DefaultPushConcurrency,
 	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	return svc
 }
 
 type service struct {
-	depClient    dep.Client
+	depClients   DEPAccounts
 	devices      device.Datastore
 	workflows    workflow.Datastore
-	pushsvc      *push.Service
+	pushsvc      apns.Pusher
 	applications application.Datastore
 	certificates certificate.Datastore
+	profiles     profile.Datastore
+	updates      osupdate.Datastore
+	users        user.Datastore
+	results      commandresult.Datastore
+	apiKeys      apikey.Datastore
+	audit        audit.Datastore
+	commands     command.Service
+	depAccount   *depAccountCache
+	depFetch     *sync.Mutex
+	escrowKey    *escrow.Key
+
+	pushConcurrency int
 }
 
 func (svc service) Push(deviceUDID string) (string, error) {
@@ -87,6 +295,22 @@ func (svc service) Push(deviceUDID string) (string, error) {
 	return svc.pushsvc.Push(dev.Token, nil, p)
 }
 
+func (svc service) PushUser(deviceUDID, userID string) (string, error) {
+	channel, err := svc.devices.User(deviceUDID, userID)
+	if err == device.ErrUserNotFound {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("retrieving user channel: %s", err)
+	}
+
+	p := payload.MDM{Token: channel.PushMagic}
+	if !push.IsDeviceTokenValid(channel.Token) {
+		return "", errors.New("invalid push token")
+	}
+	return svc.pushsvc.Push(channel.Token, nil, p)
+}
+
 func (svc service) AddProfile(prf *workflow.Profile) (*workflow.Profile, error) {
 	return svc.workflows.CreateProfile(prf)
 }
@@ -120,23 +344,11 @@ func (svc service) DeleteProfile(uuid string) error {
 	return nil
 }
 
-func (svc service) FetchDEPDevices() error {
-	fetched, err := svc.depClient.FetchDevices(dep.Limit(100))
-	if err != nil {
-		return errors.Wrap(err, "management: dep fetch")
-	}
-	for _, d := range fetched.Devices {
-		dev := device.NewFromDEP(d)
-		_, err := svc.devices.New("fetch", dev)
-		if err != nil {
-			return errors.Wrap(err, "management: dep fetch")
-		}
-	}
-	return nil
-}
-
 // workflows svc
 func (svc service) AddWorkflow(wf *workflow.Workflow) (*workflow.Workflow, error) {
+	if err := wf.Validate(svc.profiles); err != nil {
+		return nil, err
+	}
 	return svc.workflows.CreateWorkflow(wf)
 }
 
@@ -144,13 +356,43 @@ func (svc service) Workflows() ([]workflow.Workflow, error) {
 	return svc.workflows.Workflows()
 }
 
+func (svc service) AssignWorkflowTo(a workflow.Assignment) error {
+	if err := a.Validate(); err != nil {
+		return err
+	}
+	workflows, err := svc.workflows.Workflows(workflow.WrkflowUUID{UUID: a.WorkflowUUID})
+	if err != nil {
+		return err
+	}
+	if len(workflows) == 0 {
+		return ErrNotFound
+	}
+	return svc.workflows.AssignWorkflow(a)
+}
+
+func (svc service) WorkflowAssignments() ([]workflow.Assignment, error) {
+	return svc.workflows.Assignments()
+}
+
 // devices
-func (svc service) Devices() ([]device.Device, error) {
-	return svc.devices.Devices()
+func (svc service) Devices(limit, offset int) ([]device.Device, int, error) {
+	total, err := svc.devices.DeviceCount()
+	if err != nil {
+		return nil, 0, err
+	}
+	devices, err := svc.devices.Devices(device.Limit(limit), device.Offset(offset))
+	if err != nil {
+		return nil, 0, err
+	}
+	return devices, total, nil
+}
+
+func (svc service) SearchDevices(query string) ([]device.Device, error) {
+	return svc.devices.Search(query)
 }
 
 func (svc service) Device(uuid string) (*device.Device, error) {
-	devices, err := svc.devices.Devices(device.UUID{UUID: uuid})
+	devices, err := svc.devices.Devices(device.UUID{UUID: uuid}, device.IncludeDeleted{})
 	if err != nil {
 		return nil, err
 	}
@@ -161,6 +403,142 @@ func (svc service) Device(uuid string) (*device.Device, error) {
 	return &dev, nil
 }
 
+// DeviceDetail is a device with its inventory.
+type DeviceDetail struct {
+	*device.Device
+	LastQueryResponse json.RawMessage           `json:"last_query_response,omitempty"`
+	Applications      []application.Application `json:"applications"`
+	Certificates      []certificate.Certificate `json:"certificates"`
+	// Restrictions are the restrictions applied by the profiles the device
+	// reported in its last ProfileList response. Only the restrictions of profiles
+	// installed by the server are known, the restrictions of profiles installed
+	// by the user or another server are missing.
+	Restrictions   profile.Restrictions `json:"restrictions,omitempty"`
+	QueuedCommands int                  `json:"queued_commands"`
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func (svc service) ActivationLockBypassCode(uuid string) (string, error) {
+	dev, err := svc.devices.GetDeviceByUUID(uuid, "activation_lock_bypass_code")
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "management: activation lock bypass code")
+	}
+	if len(dev.ActivationLockBypassCode) == 0 || svc.escrowKey == nil {
+		return "", ErrNoBypassCode
+	}
+	code, err := svc.escrowKey.Open(dev.ActivationLockBypassCode)
+	return code, errors.Wrap(err, "management: activation lock bypass code")
+}
+
+func (svc service) DeviceDetail(id string) (*DeviceDetail, error) {
+	// the UDID of a Mac looks like a UUID, so the UDID is tried first.
+	fields := []string{"device_uuid", "last_query_response"}
+	found, err := svc.devices.GetDeviceByUDID(id, fields...)
+	if err == sql.ErrNoRows && uuidPattern.MatchString(id) {
+		found, err = svc.devices.GetDeviceByUUID(id, fields...)
+	}
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "management: device detail")
+	}
+
+	dev, err := svc.Device(found.UUID)
+	if err != nil {
+		return nil, err
+	}
+	detail := &DeviceDetail{
+		Device:            dev,
+		LastQueryResponse: json.RawMessage(found.LastQueryResponse),
+	}
+	if detail.Applications, err = svc.InstalledApps(dev.UUID); err != nil {
+		return nil, err
+	}
+	if detail.Certificates, err = svc.Certificates(dev.UUID); err != nil {
+		return nil, err
+	}
+	profiles, err := svc.InstalledProfiles(dev.UUID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range profiles {
+		detail.Restrictions = detail.Restrictions.Merge(p.Restrictions)
+	}
+	if dev.UDID.Valid {
+		if detail.QueuedCommands, err = svc.commands.QueueLength(dev.UDID.String); err != nil {
+			return nil, errors.Wrap(err, "management: device detail")
+		}
+	}
+	return detail, nil
+}
+
+func (svc service) DeleteDevice(uuid string) error {
+	err := svc.devices.Delete(uuid)
+	if err == device.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// groups
+func (svc service) AddGroup(g *device.Group) (*device.Group, error) {
+	return svc.devices.CreateGroup(g)
+}
+
+func (svc service) Groups() ([]device.Group, error) {
+	return svc.devices.Groups()
+}
+
+func (svc service) group(uuid string) (*device.Group, error) {
+	groups, err := svc.devices.Groups(device.GroupUUID{UUID: uuid})
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, ErrNotFound
+	}
+	g := groups[0]
+	return &g, nil
+}
+
+func (svc service) RenameGroup(uuid, name string) (*device.Group, error) {
+	g, err := svc.group(uuid)
+	if err != nil {
+		return nil, err
+	}
+	g.Name = name
+	return svc.devices.UpdateGroup(g)
+}
+
+func (svc service) DeleteGroup(uuid string) error {
+	if _, err := svc.group(uuid); err != nil {
+		return err
+	}
+	return svc.devices.DeleteGroup(uuid)
+}
+
+func (svc service) AddGroupDevice(groupUUID, deviceUUID string) error {
+	if _, err := svc.group(groupUUID); err != nil {
+		return err
+	}
+	if _, err := svc.Device(deviceUUID); err != nil {
+		return err
+	}
+	return svc.devices.AddGroupDevice(groupUUID, deviceUUID)
+}
+
+func (svc service) RemoveGroupDevice(groupUUID, deviceUUID string) error {
+	if _, err := svc.group(groupUUID); err != nil {
+		return err
+	}
+	return svc.devices.RemoveGroupDevice(groupUUID, deviceUUID)
+}
+
 func (svc service) AssignWorkflow(deviceUUID, workflowUUID string) error {
 	dev, err := svc.devices.GetDeviceByUUID(deviceUUID,
 		[]string{"device_uuid"}...,
@@ -181,6 +559,33 @@ func (svc service) InstalledApps(deviceUUID string) ([]application.Application,
 	return apps, nil
 }
 
+func (svc service) Applications(query string) ([]application.AppCount, error) {
+	apps, err := svc.applications.AppCounts(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "management: applications")
+	}
+
+	return apps, nil
+}
+
+func (svc service) ApplicationDevices(identifier string) ([]application.AppInstall, error) {
+	installs, err := svc.applications.AppInstalls(identifier)
+	if err != nil {
+		return nil, errors.Wrap(err, "management: application devices")
+	}
+
+	return installs, nil
+}
+
+func (svc service) ApplicationVersions(identifier string) ([]application.AppVersion, error) {
+	versions, err := svc.applications.AppVersions(identifier)
+	if err != nil {
+		return nil, errors.Wrap(err, "management: application versions")
+	}
+
+	return versions, nil
+}
+
 func (svc service) Certificates(deviceUUID string) ([]certificate.Certificate, error) {
 	certs, err := svc.certificates.GetCertificatesByDeviceUUID(deviceUUID)
 	if err != nil {
@@ -189,3 +594,270 @@ func (svc service) Certificates(deviceUUID string) ([]certificate.Certificate, e
 
 	return certs, nil
 }
+
+func (svc service) ExpiringCertificates(within time.Duration) ([]certificate.Certificate, error) {
+	certs, err := svc.certificates.Expiring(time.Now().UTC().Add(within))
+	if err != nil {
+		return nil, errors.Wrap(err, "management: expiring certificates")
+	}
+
+	return certs, nil
+}
+
+func (svc service) CertificatesIssuedBy(issuer string) ([]certificate.Certificate, error) {
+	certs, err := svc.certificates.IssuedBy(issuer)
+	if err != nil {
+		return nil, errors.Wrap(err, "management: certificates issued by")
+	}
+
+	return certs, nil
+}
+
+func (svc service) InstalledProfiles(deviceUUID string) ([]profile.Profile, error) {
+	profiles, err := svc.profiles.GetProfilesByDeviceUUID(deviceUUID)
+	if err != nil {
+		return nil, errors.Wrap(err, "management: installed profiles")
+	}
+
+	return profiles, nil
+}
+
+func (svc service) AddLibraryProfile(name string, data []byte) (*profile.LibraryProfile, error) {
+	config, err := profile.ParseConfiguration(data)
+	if err != nil {
+		return nil, err
+	}
+	p := &profile.LibraryProfile{
+		Name:        name,
+		Identifier:  config.PayloadIdentifier,
+		PayloadUUID: config.PayloadUUID,
+		DisplayName: config.PayloadDisplayName,
+		Data:        data,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if err := svc.profiles.AddLibraryProfile(p); err != nil {
+		return nil, errors.Wrap(err, "management: add library profile")
+	}
+	return p, nil
+}
+
+func (svc service) LibraryProfiles() ([]profile.LibraryProfile, error) {
+	profiles, err := svc.profiles.LibraryProfiles()
+	if err != nil {
+		return nil, errors.Wrap(err, "management: library profiles")
+	}
+	return profiles, nil
+}
+
+func (svc service) LibraryProfile(name string, version int) (*profile.LibraryProfile, error) {
+	p, err := svc.profiles.LibraryProfile(name, version)
+	if err == profile.ErrLibraryProfileNotFound {
+		return nil, ErrNotFound
+	}
+	return p, err
+}
+
+func (svc service) DeleteLibraryProfile(name string) error {
+	err := svc.profiles.DeleteLibraryProfile(name)
+	if err == profile.ErrLibraryProfileNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (svc service) SaveVPPApp(app *application.VPPApp) error {
+	return errors.Wrap(svc.applications.SaveVPPApp(app), "management: save vpp app")
+}
+
+func (svc service) VPPApps() ([]application.VPPApp, error) {
+	apps, err := svc.applications.VPPApps()
+	if err != nil {
+		return nil, errors.Wrap(err, "management: vpp apps")
+	}
+
+	return apps, nil
+}
+
+func (svc service) AvailableOSUpdates(deviceUUID string) ([]osupdate.Update, error) {
+	updates, err := svc.updates.GetUpdatesByDeviceUUID(deviceUUID)
+	if err != nil {
+		return nil, errors.Wrap(err, "management: available os updates")
+	}
+
+	return updates, nil
+}
+
+func (svc service) CreateAPIKey(name string, scopes []string) (*apikey.Key, string, error) {
+	if name == "" {
+		return nil, "", errEmptyRequest
+	}
+	key, token, err := svc.apiKeys.Create(name, scopes)
+	if err == apikey.ErrInvalidScope {
+		return nil, "", err
+	}
+	if err != nil {
+		return nil, "", errors.Wrap(err, "management: create api key")
+	}
+	return key, token, nil
+}
+
+func (svc service) APIKeys() ([]apikey.Key, error) {
+	keys, err := svc.apiKeys.Keys()
+	if err != nil {
+		return nil, errors.Wrap(err, "management: api keys")
+	}
+	return keys, nil
+}
+
+func (svc service) RevokeAPIKey(uuid string) error {
+	err := svc.apiKeys.Revoke(uuid)
+	if err == apikey.ErrNotFound {
+		return ErrNotFound
+	}
+	return errors.Wrap(err, "management: revoke api key")
+}
+
+func (svc service) AuditLog(device, actor string, limit int) ([]audit.Entry, error) {
+	if device != "" {
+		// entries record the device UUID
+		device = auditDeviceUUID(svc.devices, device)
+	}
+	entries, err := svc.audit.Entries(device, actor, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "management: audit log")
+	}
+	return entries, nil
+}
+
+func (svc service) ReconcileDuplicates() ([]device.Duplicate, error) {
+	duplicates, err := svc.devices.Duplicates()
+	if err != nil {
+		return nil, errors.Wrap(err, "management: finding duplicate devices")
+	}
+	merged := []device.Duplicate{}
+	for _, d := range duplicates {
+		if err := svc.devices.MergeDuplicate(d); err != nil {
+			return merged, errors.Wrapf(err, "management: merging device %s", d.UDID)
+		}
+		merged = append(merged, d)
+	}
+	return merged, nil
+}
+
+func (svc service) Users(deviceUUID string) ([]user.User, error) {
+	users, err := svc.users.GetUsersByDeviceUUID(deviceUUID)
+	if err != nil {
+		return nil, errors.Wrap(err, "management: users")
+	}
+
+	return users, nil
+}
+
+func (svc service) CommandResults(deviceUUID string, limit int) ([]commandresult.Result, error) {
+	dev, err := svc.devices.GetDeviceByUUID(deviceUUID, "udid")
+	if err != nil {
+		return nil, errors.Wrap(err, "management: command results")
+	}
+	results, err := svc.results.GetResultsByUDID(dev.UDID.String, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "management: command results")
+	}
+
+	return results, nil
+}
+
+func (svc service) FailedCommands(deviceUUID string) ([]command.FailedCommand, error) {
+	dev, err := svc.devices.GetDeviceByUUID(deviceUUID, "udid")
+	if err != nil {
+		return nil, errors.Wrap(err, "management: failed commands")
+	}
+	failed, err := svc.commands.FailedCommands(dev.UDID.String)
+	if err != nil {
+		return nil, errors.Wrap(err, "management: failed commands")
+	}
+
+	return failed, nil
+}
+
+func (svc service) EraseDevice(deviceUDID string, erase mdm.EraseDevice, confirmation string) (*mdm.Payload, error) {
+	request := &mdm.CommandRequest{
+		UDID:        deviceUDID,
+		RequestType: "EraseDevice",
+		EraseDevice: erase,
+	}
+	payload, err := svc.commands.EraseDevice(request, confirmation)
+	if err != nil {
+		return nil, err
+	}
+	// the command is queued, a failed push will be retried on the next checkin
+	svc.Push(deviceUDID)
+	return payload, nil
+}
+
+func (svc service) BulkCommand(deviceUDIDs []string, template mdm.CommandRequest) []BulkCommandResult {
+	results := make([]BulkCommandResult, len(deviceUDIDs))
+	var queued []int
+	for i, udid := range deviceUDIDs {
+		results[i].UDID = udid
+		request := template
+		request.UDID = udid
+		payload, err := svc.commands.NewCommand(&request)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Queued = true
+		results[i].CommandUUID = payload.CommandUUID
+		queued = append(queued, i)
+	}
+
+	// the commands are queued, a failed push will be retried on the next checkin
+	svc.pushAll(results, queued)
+	return results
+}
+
+// pushAll pushes to the devices of the results at the given indexes
+// with a pool of pushConcurrency workers.
+func (svc service) pushAll(results []BulkCommandResult, indexes []int) {
+	jobs := make(chan *BulkCommandResult)
+	var wg sync.WaitGroup
+	for w := 0; w < svc.pushConcurrency && w < len(indexes); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for result := range jobs {
+				if _, err := svc.Push(result.UDID); err != nil {
+					result.PushError = err.Error()
+				}
+			}
+		}()
+	}
+	for _, i := range indexes {
+		jobs <- &results[i]
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func (svc service) GroupCommand(groupName string, template mdm.CommandRequest) ([]BulkCommandResult, error) {
+	groups, err := svc.devices.Groups(device.GroupName{Name: groupName})
+	if err != nil {
+		return nil, errors.Wrap(err, "management: group command")
+	}
+	if len(groups) == 0 {
+		return nil, ErrNotFound
+	}
+
+	members, err := svc.devices.Devices(device.InGroup{Name: groupName})
+	if err != nil {
+		return nil, errors.Wrap(err, "management: group command")
+	}
+	var udids []string
+	for _, dev := range members {
+		// devices which have not enrolled yet have no UDID to queue commands for.
+		if dev.UDID.Valid && dev.UDID.String != "" {
+			udids = append(udids, dev.UDID.String)
+		}
+	}
+	return svc.BulkCommand(udids, template), nil
+}
@@ -1,6 +1,20 @@
 package management
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/micromdm/micromdm/application"
+)
+
+// fakeApps lists the same applications for every device.
+type fakeApps struct {
+	application.Datastore
+	apps []application.Application
+}
+
+func (f fakeApps) GetApplicationsByDeviceUUID(deviceUUID string) ([]application.Application, error) {
+	return f.apps, nil
+}
 
 func svcSetup() {
 
@@ -14,10 +28,14 @@ func TestService_InstalledApps(t *testing.T) {
 	svcSetup()
 	defer svcTearDown()
 
-	svc := NewService(nil, nil, nil, nil, nil)
-	_, err := svc.InstalledApps("00000000-1111-2222-3333-444455556666")
+	apps := fakeApps{apps: []application.Application{{Name: "Keychain Access"}}}
+	svc := NewService(nil, nil, nil, nil, apps, nil, nil, nil, nil, nil, nil, nil, nil)
+	installed, err := svc.InstalledApps("00000000-1111-2222-3333-444455556666")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(installed) != 1 || installed[0].Name != "Keychain Access" {
+		t.Errorf("expected Keychain Access to be installed, got %v", installed)
+	}
 
 }
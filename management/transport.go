@@ -5,15 +5,26 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	kitlog "github.com/go-kit/kit/log"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
+	"github.com/micromdm/micromdm/apikey"
+	"github.com/micromdm/micromdm/command"
+	"github.com/micromdm/micromdm/device"
+	"github.com/micromdm/micromdm/profile"
 	"github.com/micromdm/micromdm/workflow"
 	"golang.org/x/net/context"
 )
 
-var errBadUUID = errors.New("request must have a valid uuid")
+var (
+	errBadUUID       = errors.New("request must have a valid uuid")
+	errBadPagination = errors.New("limit must be a positive integer and offset must not be negative")
+	errBadVersion    = errors.New("version must be a positive integer")
+	errBadWithin     = errors.New("within must be a positive duration, ex: 720h")
+)
 
 // ServiceHandler returns an HTTP Handler for the management service
 func ServiceHandler(ctx context.Context, svc Service, logger kitlog.Logger) http.Handler {
@@ -29,6 +40,20 @@ func ServiceHandler(ctx context.Context, svc Service, logger kitlog.Logger) http
 		encodeResponse,
 		opts...,
 	)
+	depAccountHandler := kithttp.NewServer(
+		ctx,
+		makeDEPAccountEndpoint(svc),
+		decodeDEPAccountRequest,
+		encodeResponse,
+		opts...,
+	)
+	assignDEPProfileHandler := kithttp.NewServer(
+		ctx,
+		makeAssignDEPProfileEndpoint(svc),
+		decodeAssignDEPProfileRequest,
+		encodeResponse,
+		opts...,
+	)
 
 	addProfileHandler := kithttp.NewServer(
 		ctx,
@@ -58,6 +83,90 @@ func ServiceHandler(ctx context.Context, svc Service, logger kitlog.Logger) http
 		encodeResponse,
 		opts...,
 	)
+	addLibraryProfileHandler := kithttp.NewServer(
+		ctx,
+		makeAddLibraryProfileEndpoint(svc),
+		decodeAddLibraryProfileRequest,
+		encodeResponse,
+		opts...,
+	)
+	listLibraryProfilesHandler := kithttp.NewServer(
+		ctx,
+		makeListLibraryProfilesEndpoint(svc),
+		decodeListLibraryProfilesRequest,
+		encodeResponse,
+		opts...,
+	)
+	listApplicationsHandler := kithttp.NewServer(
+		ctx,
+		makeListApplicationsEndpoint(svc),
+		decodeListApplicationsRequest,
+		encodeResponse,
+		opts...,
+	)
+	applicationDevicesHandler := kithttp.NewServer(
+		ctx,
+		makeApplicationDevicesEndpoint(svc),
+		decodeApplicationDevicesRequest,
+		encodeResponse,
+		opts...,
+	)
+	applicationVersionsHandler := kithttp.NewServer(
+		ctx,
+		makeApplicationVersionsEndpoint(svc),
+		decodeApplicationVersionsRequest,
+		encodeResponse,
+		opts...,
+	)
+	saveVPPAppHandler := kithttp.NewServer(
+		ctx,
+		makeSaveVPPAppEndpoint(svc),
+		decodeSaveVPPAppRequest,
+		encodeResponse,
+		opts...,
+	)
+	createAPIKeyHandler := kithttp.NewServer(
+		ctx,
+		makeCreateAPIKeyEndpoint(svc),
+		decodeCreateAPIKeyRequest,
+		encodeResponse,
+		opts...,
+	)
+	listAPIKeysHandler := kithttp.NewServer(
+		ctx,
+		makeListAPIKeysEndpoint(svc),
+		decodeListAPIKeysRequest,
+		encodeResponse,
+		opts...,
+	)
+	revokeAPIKeyHandler := kithttp.NewServer(
+		ctx,
+		makeRevokeAPIKeyEndpoint(svc),
+		decodeRevokeAPIKeyRequest,
+		encodeResponse,
+		opts...,
+	)
+	listVPPAppsHandler := kithttp.NewServer(
+		ctx,
+		makeListVPPAppsEndpoint(svc),
+		decodeListVPPAppsRequest,
+		encodeResponse,
+		opts...,
+	)
+	showLibraryProfileHandler := kithttp.NewServer(
+		ctx,
+		makeShowLibraryProfileEndpoint(svc),
+		decodeShowLibraryProfileRequest,
+		encodeResponse,
+		opts...,
+	)
+	deleteLibraryProfileHandler := kithttp.NewServer(
+		ctx,
+		makeDeleteLibraryProfileEndpoint(svc),
+		decodeDeleteLibraryProfileRequest,
+		encodeResponse,
+		opts...,
+	)
 
 	addWorkflowHandler := kithttp.NewServer(
 		ctx,
@@ -66,6 +175,20 @@ func ServiceHandler(ctx context.Context, svc Service, logger kitlog.Logger) http
 		encodeResponse,
 		opts...,
 	)
+	assignWorkflowHandler := kithttp.NewServer(
+		ctx,
+		makeAssignWorkflowEndpoint(svc),
+		decodeAssignWorkflowRequest,
+		encodeResponse,
+		opts...,
+	)
+	listWorkflowAssignmentsHandler := kithttp.NewServer(
+		ctx,
+		makeListWorkflowAssignmentsEndpoint(svc),
+		decodeListWorkflowAssignmentsRequest,
+		encodeResponse,
+		opts...,
+	)
 	listWorkflowsHandler := kithttp.NewServer(
 		ctx,
 		makeListWorkflowsEndpoint(svc),
@@ -80,6 +203,13 @@ func ServiceHandler(ctx context.Context, svc Service, logger kitlog.Logger) http
 		encodeResponse,
 		opts...,
 	)
+	searchDevicesHandler := kithttp.NewServer(
+		ctx,
+		makeSearchDevicesEndpoint(svc),
+		decodeSearchDevicesRequest,
+		encodeResponse,
+		opts...,
+	)
 	showDeviceHandler := kithttp.NewServer(
 		ctx,
 		makeShowDeviceEndpoint(svc),
@@ -87,6 +217,13 @@ func ServiceHandler(ctx context.Context, svc Service, logger kitlog.Logger) http
 		encodeResponse,
 		opts...,
 	)
+	deleteDeviceHandler := kithttp.NewServer(
+		ctx,
+		makeDeleteDeviceEndpoint(svc),
+		decodeDeleteDeviceRequest,
+		encodeResponse,
+		opts...,
+	)
 	updateDeviceHandler := kithttp.NewServer(
 		ctx,
 		makeUpdateDeviceEndpoint(svc),
@@ -115,32 +252,225 @@ func ServiceHandler(ctx context.Context, svc Service, logger kitlog.Logger) http
 		encodeResponse,
 		opts...,
 	)
+	expiringCertificatesHandler := kithttp.NewServer(
+		ctx,
+		makeExpiringCertificatesEndpoint(svc),
+		decodeExpiringCertificatesRequest,
+		encodeResponse,
+		opts...,
+	)
+	issuedCertificatesHandler := kithttp.NewServer(
+		ctx,
+		makeIssuedCertificatesEndpoint(svc),
+		decodeIssuedCertificatesRequest,
+		encodeResponse,
+		opts...,
+	)
+	eraseDeviceHandler := kithttp.NewServer(
+		ctx,
+		makeEraseDeviceEndpoint(svc),
+		decodeEraseDeviceRequest,
+		encodeResponse,
+		opts...,
+	)
+	activationLockBypassCodeHandler := kithttp.NewServer(
+		ctx,
+		makeActivationLockBypassCodeEndpoint(svc),
+		decodeActivationLockBypassCodeRequest,
+		encodeResponse,
+		opts...,
+	)
+	installedProfilesHandler := kithttp.NewServer(
+		ctx,
+		makeInstalledProfilesEndpoint(svc),
+		decodeInstalledProfilesRequest,
+		encodeResponse,
+		opts...,
+	)
+	availableOSUpdatesHandler := kithttp.NewServer(
+		ctx,
+		makeAvailableOSUpdatesEndpoint(svc),
+		decodeAvailableOSUpdatesRequest,
+		encodeResponse,
+		opts...,
+	)
+	usersHandler := kithttp.NewServer(
+		ctx,
+		makeUsersEndpoint(svc),
+		decodeUsersRequest,
+		encodeResponse,
+		opts...,
+	)
+	reconcileDevicesHandler := kithttp.NewServer(
+		ctx,
+		makeReconcileDevicesEndpoint(svc),
+		decodeReconcileDevicesRequest,
+		encodeResponse,
+		opts...,
+	)
+	auditLogHandler := kithttp.NewServer(
+		ctx,
+		makeAuditLogEndpoint(svc),
+		decodeAuditLogRequest,
+		encodeResponse,
+		opts...,
+	)
+	commandResultsHandler := kithttp.NewServer(
+		ctx,
+		makeCommandResultsEndpoint(svc),
+		decodeCommandResultsRequest,
+		encodeResponse,
+		opts...,
+	)
+	failedCommandsHandler := kithttp.NewServer(
+		ctx,
+		makeFailedCommandsEndpoint(svc),
+		decodeFailedCommandsRequest,
+		encodeResponse,
+		opts...,
+	)
+	bulkCommandHandler := kithttp.NewServer(
+		ctx,
+		makeBulkCommandEndpoint(svc),
+		decodeBulkCommandRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	addGroupHandler := kithttp.NewServer(
+		ctx,
+		makeAddGroupEndpoint(svc),
+		decodeAddGroupRequest,
+		encodeResponse,
+		opts...,
+	)
+	listGroupsHandler := kithttp.NewServer(
+		ctx,
+		makeListGroupsEndpoint(svc),
+		decodeListGroupsRequest,
+		encodeResponse,
+		opts...,
+	)
+	renameGroupHandler := kithttp.NewServer(
+		ctx,
+		makeRenameGroupEndpoint(svc),
+		decodeRenameGroupRequest,
+		encodeResponse,
+		opts...,
+	)
+	deleteGroupHandler := kithttp.NewServer(
+		ctx,
+		makeDeleteGroupEndpoint(svc),
+		decodeDeleteGroupRequest,
+		encodeResponse,
+		opts...,
+	)
+	addGroupDeviceHandler := kithttp.NewServer(
+		ctx,
+		makeAddGroupDeviceEndpoint(svc),
+		decodeGroupDeviceRequest,
+		encodeResponse,
+		opts...,
+	)
+	removeGroupDeviceHandler := kithttp.NewServer(
+		ctx,
+		makeRemoveGroupDeviceEndpoint(svc),
+		decodeGroupDeviceRequest,
+		encodeResponse,
+		opts...,
+	)
 
 	r := mux.NewRouter()
 
 	// dep
 	r.Handle("/management/v1/devices/fetch", fetchDEPHandler).Methods("POST")
+	r.Handle("/management/v1/dep/account", depAccountHandler).Methods("GET")
+	r.Handle("/management/v1/dep/profiles", assignDEPProfileHandler).Methods("POST")
 	//devices
 	r.Handle("/management/v1/devices", listDevicesHandler).Methods("GET")
-	r.Handle("/management/v1/devices/{uuid}", showDeviceHandler).Methods("GET")
+	// registered before {uuid} so that "search" is not treated as a device uuid
+	r.Handle("/management/v1/devices/search", searchDevicesHandler).Methods("GET")
+	r.Handle("/management/v1/devices/reconcile", reconcileDevicesHandler).Methods("POST")
+	// the device with its inventory, by UDID or UUID
+	r.Handle("/management/v1/devices/{id}", showDeviceHandler).Methods("GET")
 	r.Handle("/management/v1/devices/{uuid}", updateDeviceHandler).Methods("PATCH")
+	r.Handle("/management/v1/devices/{uuid}", deleteDeviceHandler).Methods("DELETE")
 	r.Handle("/management/v1/devices/{udid}/push", pushHandler).Methods("POST")
+	r.Handle("/management/v1/devices/{udid}/erase", eraseDeviceHandler).Methods("POST")
+	r.Handle("/management/v1/devices/{uuid}/activation_lock_bypass_code", activationLockBypassCodeHandler).Methods("GET")
 	r.Handle("/management/v1/devices/{uuid}/applications", installedAppsHandler).Methods("GET")
 	r.Handle("/management/v1/devices/{uuid}/certificates", certificatesHandler).Methods("GET")
+	r.Handle("/management/v1/devices/{uuid}/profiles", installedProfilesHandler).Methods("GET")
+	r.Handle("/management/v1/devices/{uuid}/updates", availableOSUpdatesHandler).Methods("GET")
+	r.Handle("/management/v1/devices/{uuid}/users", usersHandler).Methods("GET")
+	r.Handle("/management/v1/devices/{uuid}/commands", commandResultsHandler).Methods("GET")
+	r.Handle("/management/v1/devices/{uuid}/commands/failed", failedCommandsHandler).Methods("GET")
+	r.Handle("/management/v1/devices/commands", bulkCommandHandler).Methods("POST")
 	// profiles
 	r.Handle("/management/v1/profiles", addProfileHandler).Methods("POST")
 	r.Handle("/management/v1/profiles", listProfilesHandler).Methods("GET")
 	r.Handle("/management/v1/profiles/{uuid}", showProfileHandler).Methods("GET")
 	r.Handle("/management/v1/profiles/{uuid}", deleteProfileHandler).Methods("DELETE")
+	// profile library
+	r.Handle("/management/v1/library/profiles", addLibraryProfileHandler).Methods("POST")
+	r.Handle("/management/v1/library/profiles", listLibraryProfilesHandler).Methods("GET")
+	r.Handle("/management/v1/library/profiles/{name}", showLibraryProfileHandler).Methods("GET")
+	r.Handle("/management/v1/library/profiles/{name}", deleteLibraryProfileHandler).Methods("DELETE")
+
+	r.Handle("/management/v1/certificates", issuedCertificatesHandler).Methods("GET")
+	r.Handle("/management/v1/certificates/expiring", expiringCertificatesHandler).Methods("GET")
+
+	// applications
+	r.Handle("/management/v1/applications", listApplicationsHandler).Methods("GET")
+	r.Handle("/management/v1/applications/{identifier}/devices", applicationDevicesHandler).Methods("GET")
+	r.Handle("/management/v1/applications/{identifier}/versions", applicationVersionsHandler).Methods("GET")
+
+	// vpp
+	r.Handle("/management/v1/vpp/apps", saveVPPAppHandler).Methods("POST")
+	r.Handle("/management/v1/vpp/apps", listVPPAppsHandler).Methods("GET")
+	// groups
+	r.Handle("/management/v1/apikeys", createAPIKeyHandler).Methods("POST")
+	r.Handle("/management/v1/apikeys", listAPIKeysHandler).Methods("GET")
+	r.Handle("/management/v1/apikeys/{uuid}", revokeAPIKeyHandler).Methods("DELETE")
+
+	r.Handle("/management/v1/audit", auditLogHandler).Methods("GET")
+
+	r.Handle("/management/v1/groups", addGroupHandler).Methods("POST")
+	r.Handle("/management/v1/groups", listGroupsHandler).Methods("GET")
+	r.Handle("/management/v1/groups/{uuid}", renameGroupHandler).Methods("PATCH")
+	r.Handle("/management/v1/groups/{uuid}", deleteGroupHandler).Methods("DELETE")
+	r.Handle("/management/v1/groups/{uuid}/devices/{device_uuid}", addGroupDeviceHandler).Methods("PUT")
+	r.Handle("/management/v1/groups/{uuid}/devices/{device_uuid}", removeGroupDeviceHandler).Methods("DELETE")
 	// workflows
 	r.Handle("/management/v1/workflows", addWorkflowHandler).Methods("POST")
 	r.Handle("/management/v1/workflows", listWorkflowsHandler).Methods("GET")
+	r.Handle("/management/v1/workflows/assignments", listWorkflowAssignmentsHandler).Methods("GET")
+	r.Handle("/management/v1/workflows/{uuid}/assignments", assignWorkflowHandler).Methods("POST")
 
 	return r
 }
 
 func decodeFetchDEPDevicesRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	return fetchDEPDevicesRequest{}, nil
+	return fetchDEPDevicesRequest{Account: r.URL.Query().Get("account")}, nil
+}
+
+func decodeDEPAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return depAccountRequest{Account: r.URL.Query().Get("account")}, nil
+}
+
+func decodeAssignDEPProfileRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request assignDEPProfileRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err == io.EOF {
+		return nil, errEmptyRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+	if request.Profile == nil || len(request.SerialNumbers) == 0 {
+		return nil, errEmptyRequest
+	}
+	return request, nil
 }
 
 func decodeAddProfileRequest(_ context.Context, r *http.Request) (interface{}, error) {
@@ -182,6 +512,116 @@ func decodeDeleteProfileRequest(_ context.Context, r *http.Request) (interface{}
 	return deleteProfileRequest{UUID: uuid}, nil
 }
 
+func decodeAddLibraryProfileRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request addLibraryProfileRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err == io.EOF {
+		return nil, errEmptyRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+	if request.Name == "" || len(request.Data) == 0 {
+		return nil, errEmptyRequest
+	}
+	return request, nil
+}
+
+func decodeListLibraryProfilesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return listLibraryProfilesRequest{}, nil
+}
+
+// decodeExpiringCertificatesRequest reads the window from ?within=,
+// defaulting to defaultExpiryWindow.
+func decodeExpiringCertificatesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	request := expiringCertificatesRequest{Within: defaultExpiryWindow}
+	if within := r.URL.Query().Get("within"); within != "" {
+		d, err := time.ParseDuration(within)
+		if err != nil || d <= 0 {
+			return nil, errBadWithin
+		}
+		request.Within = d
+	}
+	return request, nil
+}
+
+func decodeIssuedCertificatesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	issuer := r.URL.Query().Get("issuer")
+	if issuer == "" {
+		return nil, errEmptyRequest
+	}
+	return issuedCertificatesRequest{Issuer: issuer}, nil
+}
+
+func decodeListApplicationsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return listApplicationsRequest{Query: r.URL.Query().Get("q")}, nil
+}
+
+func decodeApplicationDevicesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	identifier, ok := vars["identifier"]
+	if !ok {
+		return nil, errBadRouting
+	}
+	return applicationDevicesRequest{Identifier: identifier}, nil
+}
+
+func decodeApplicationVersionsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	identifier, ok := vars["identifier"]
+	if !ok {
+		return nil, errBadRouting
+	}
+	return applicationVersionsRequest{Identifier: identifier}, nil
+}
+
+func decodeSaveVPPAppRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request saveVPPAppRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err == io.EOF {
+		return nil, errEmptyRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+	if request.ITunesStoreID == 0 || request.Name == "" {
+		return nil, errEmptyRequest
+	}
+	return request, nil
+}
+
+func decodeListVPPAppsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return listVPPAppsRequest{}, nil
+}
+
+// decodeShowLibraryProfileRequest returns the latest version
+// unless a version is requested with ?version=
+func decodeShowLibraryProfileRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		return nil, errBadRouting
+	}
+	request := showLibraryProfileRequest{Name: name}
+	if version := r.URL.Query().Get("version"); version != "" {
+		n, err := strconv.Atoi(version)
+		if err != nil || n < 1 {
+			return nil, errBadVersion
+		}
+		request.Version = n
+	}
+	return request, nil
+}
+
+func decodeDeleteLibraryProfileRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		return nil, errBadRouting
+	}
+	return deleteLibraryProfileRequest{Name: name}, nil
+}
+
 // workflow
 func decodeAddWorkflowRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var request addWorkflowRequest
@@ -199,19 +639,171 @@ func decodeListWorkflowsRequest(_ context.Context, r *http.Request) (interface{}
 	return listWorkflowsRequest{}, nil
 }
 
+func decodeAssignWorkflowRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	uuid, ok := vars["uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+	if len(uuid) != 36 {
+		return nil, errBadUUID
+	}
+	var request assignWorkflowRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err == io.EOF {
+		return nil, errEmptyRequest
+	}
+	request.WorkflowUUID = uuid
+	return request, err
+}
+
+func decodeListWorkflowAssignmentsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return listWorkflowAssignmentsRequest{}, nil
+}
+
+// groups
+func decodeAddGroupRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request addGroupRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err == io.EOF {
+		return nil, errEmptyRequest
+	}
+	if request.Group == nil || request.Name == "" {
+		return nil, errEmptyRequest
+	}
+	return request, err
+}
+
+func decodeListGroupsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return listGroupsRequest{}, nil
+}
+
+func decodeRenameGroupRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	uuid, ok := vars["uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+
+	var request = renameGroupRequest{UUID: uuid}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err == io.EOF {
+		return nil, errEmptyRequest
+	}
+	if request.Name == "" {
+		return nil, errEmptyRequest
+	}
+	return request, err
+}
+
+func decodeCreateAPIKeyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request createAPIKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err == io.EOF {
+		return nil, errEmptyRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeListAPIKeysRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return listAPIKeysRequest{}, nil
+}
+
+func decodeRevokeAPIKeyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	uuid, ok := vars["uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+	if len(uuid) != 36 {
+		return nil, errBadUUID
+	}
+	return revokeAPIKeyRequest{UUID: uuid}, nil
+}
+
+func decodeDeleteGroupRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	uuid, ok := vars["uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+	if len(uuid) != 36 {
+		return nil, errBadUUID
+	}
+	return deleteGroupRequest{UUID: uuid}, nil
+}
+
+func decodeGroupDeviceRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	groupUUID, ok := vars["uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+	deviceUUID, ok := vars["device_uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+	if len(groupUUID) != 36 || len(deviceUUID) != 36 {
+		return nil, errBadUUID
+	}
+	return groupDeviceRequest{GroupUUID: groupUUID, DeviceUUID: deviceUUID}, nil
+}
+
 // devices
 func decodeListDevicesRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	return listDevicesRequest{}, nil
+	request := listDevicesRequest{Limit: defaultDevicesPageSize}
+	query := r.URL.Query()
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, errBadPagination
+		}
+		request.Limit = n
+	}
+	if request.Limit > maxDevicesPageSize {
+		request.Limit = maxDevicesPageSize
+	}
+	if offset := query.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return nil, errBadPagination
+		}
+		request.Offset = n
+	}
+	return request, nil
+}
+
+func decodeSearchDevicesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		return nil, errEmptyRequest
+	}
+	return searchDevicesRequest{Query: query}, nil
 }
 
 func decodeShowDeviceRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	vars := mux.Vars(r)
-	uuid, ok := vars["uuid"]
+	id, ok := vars["id"]
 	if !ok {
 		return nil, errBadRouting
 	}
 
-	return showDeviceRequest{UUID: uuid}, nil
+	return showDeviceRequest{ID: id}, nil
+}
+
+func decodeDeleteDeviceRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	uuid, ok := vars["uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+	if len(uuid) != 36 {
+		return nil, errBadUUID
+	}
+	return deleteDeviceRequest{UUID: uuid}, nil
 }
 
 func decodePushRequest(_ context.Context, r *http.Request) (interface{}, error) {
@@ -221,7 +813,40 @@ func decodePushRequest(_ context.Context, r *http.Request) (interface{}, error)
 		return nil, errBadRouting
 	}
 
-	return pushRequest{UDID: udid}, nil
+	return pushRequest{UDID: udid, UserID: r.URL.Query().Get("user_id")}, nil
+}
+
+func decodeEraseDeviceRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	udid, ok := vars["udid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+
+	var request = eraseDeviceRequest{UDID: udid}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err == io.EOF {
+		return nil, errEmptyRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+	if request.Confirmation == "" {
+		return nil, errEmptyRequest
+	}
+	return request, nil
+}
+
+func decodeBulkCommandRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request bulkCommandRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err == io.EOF {
+		return nil, errEmptyRequest
+	}
+	if (len(request.UDIDs) == 0 && request.Group == "") || request.Command.RequestType == "" {
+		return nil, errEmptyRequest
+	}
+	return request, err
 }
 
 func decodeUpdateDeviceRequest(_ context.Context, r *http.Request) (interface{}, error) {
@@ -264,6 +889,95 @@ func decodeCertificatesRequest(_ context.Context, r *http.Request) (interface{},
 	return listCertificatesRequest{UUID: uuid}, nil
 }
 
+func decodeActivationLockBypassCodeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	uuid, ok := vars["uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+
+	return activationLockBypassCodeRequest{UUID: uuid}, nil
+}
+
+func decodeInstalledProfilesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	uuid, ok := vars["uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+
+	return installedProfilesRequest{UUID: uuid}, nil
+}
+
+func decodeAvailableOSUpdatesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	uuid, ok := vars["uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+
+	return availableOSUpdatesRequest{UUID: uuid}, nil
+}
+
+func decodeUsersRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	uuid, ok := vars["uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+
+	return usersRequest{UUID: uuid}, nil
+}
+
+func decodeCommandResultsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	uuid, ok := vars["uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+	request := commandResultsRequest{UUID: uuid, Limit: defaultCommandResultsLimit}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, errBadPagination
+		}
+		request.Limit = n
+	}
+	if request.Limit > maxCommandResultsLimit {
+		request.Limit = maxCommandResultsLimit
+	}
+	return request, nil
+}
+
+func decodeFailedCommandsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	uuid, ok := vars["uuid"]
+	if !ok {
+		return nil, errBadRouting
+	}
+	return failedCommandsRequest{UUID: uuid}, nil
+}
+
+func decodeReconcileDevicesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return reconcileDevicesRequest{}, nil
+}
+
+func decodeAuditLogRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	request := auditLogRequest{Device: q.Get("device"), Actor: q.Get("actor"), Limit: defaultAuditLimit}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, errBadPagination
+		}
+		request.Limit = n
+	}
+	if request.Limit > maxAuditLimit {
+		request.Limit = maxAuditLimit
+	}
+	return request, nil
+}
+
 func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	if e, ok := response.(errorer); ok && e.error() != nil {
 		encodeError(ctx, e.error(), w)
@@ -305,11 +1019,14 @@ func encodeError(_ context.Context, err error, w http.ResponseWriter) {
 		err = httperr.Err
 	}
 	switch err {
-	case ErrNotFound:
+	case ErrNotFound, ErrUnknownDEPAccount, ErrNoBypassCode:
 		w.WriteHeader(http.StatusNotFound)
-	case errEmptyRequest, errBadUUID:
+	case errEmptyRequest, errBadUUID, errBadPagination, errBadVersion, errBadWithin, errMixedDEPAccounts, command.ErrEraseNotConfirmed, command.ErrInvalidPIN,
+		profile.ErrInvalidProfile, profile.ErrNotConfiguration, apikey.ErrInvalidScope,
+		workflow.ErrInvalidStep, workflow.ErrInvalidAssignment, workflow.ErrInvalidCondition,
+		workflow.ErrInvalidDeviceName, workflow.ErrEraseStep, workflow.ErrUnknownLibraryProfile:
 		w.WriteHeader(http.StatusBadRequest)
-	case workflow.ErrExists:
+	case workflow.ErrExists, device.ErrGroupExists:
 		w.WriteHeader(http.StatusConflict)
 	default:
 		w.WriteHeader(http.StatusInternalServerError)
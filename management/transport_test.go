@@ -12,6 +12,7 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/jmoiron/sqlx"
 	"github.com/micromdm/dep"
+	"github.com/micromdm/micromdm/application"
 	"github.com/micromdm/micromdm/device"
 	"github.com/micromdm/micromdm/workflow"
 	"golang.org/x/net/context"
@@ -299,9 +300,17 @@ func TestAddProfile(t *testing.T) {
 	}
 }
 
-var testConn = "user=micromdm password=micromdm dbname=micromdm sslmode=disable"
+// testConn is a migrated postgres database, and the tests also expect a DEP simulator on localhost:9000.
+var testConn = os.Getenv("MICROMDM_TEST_DB")
+
+func skipWithoutDB(t *testing.T) {
+	if testConn == "" {
+		t.Skip("set MICROMDM_TEST_DB to a migrated postgres database to run the test")
+	}
+}
 
 func newServer(t *testing.T) (*httptest.Server, Service) {
+	skipWithoutDB(t)
 	ctx := context.Background()
 	l := log.NewLogfmtLogger(os.Stderr)
 	logger := log.NewContext(l).With("source", "testing")
@@ -327,12 +336,12 @@ func newServer(t *testing.T) (*httptest.Server, Service) {
 		t.Fatal(err)
 	}
 
-	as, err := applications.NewDB("postgres", testConn, logger)
+	as, err := application.NewDB("postgres", testConn, logger)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	svc := NewService(ds, ps, dc, nil, as)
+	svc := NewService(ds, ps, DEPAccounts{DefaultDEPAccount: dc}, nil, as, nil, nil, nil, nil, nil, nil, nil, nil)
 	handler := ServiceHandler(ctx, svc, logger)
 	server := httptest.NewServer(handler)
 	return server, svc
@@ -423,6 +432,7 @@ func testAddHTTP(endpoint string, t *testing.T, svc Service, server *httptest.Se
 }
 
 func TestFetchDEPDevices(t *testing.T) {
+	skipWithoutDB(t)
 	ctx := context.Background()
 	logger := log.NewLogfmtLogger(os.Stderr)
 	ds, err := device.NewDB("postgres", testConn, logger)
@@ -443,7 +453,7 @@ func TestFetchDEPDevices(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	svc := NewService(ds, nil, dc, nil, nil)
+	svc := NewService(ds, nil, DEPAccounts{DefaultDEPAccount: dc}, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 	handler := ServiceHandler(ctx, svc, logger)
 	server := httptest.NewServer(handler)
 	defer server.Close()
@@ -0,0 +1,14 @@
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+-- Configuration profiles reported as installed by the device in a ProfileList response.
+CREATE TABLE IF NOT EXISTS devices_profiles (
+  profile_uuid uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  device_uuid uuid REFERENCES devices(device_uuid) ON DELETE CASCADE,
+  payload_identifier text NOT NULL,
+  payload_uuid text NOT NULL DEFAULT '',
+  payload_display_name text NOT NULL DEFAULT '',
+  payload_organization text NOT NULL DEFAULT '',
+  is_signed BOOL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS idx_devices_profiles_device_uuid ON devices_profiles (device_uuid);
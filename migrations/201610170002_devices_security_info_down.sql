@@ -0,0 +1,6 @@
+ALTER TABLE devices
+  DROP COLUMN IF EXISTS hardware_encryption_caps,
+  DROP COLUMN IF EXISTS passcode_present,
+  DROP COLUMN IF EXISTS passcode_compliant,
+  DROP COLUMN IF EXISTS fde_enabled,
+  DROP COLUMN IF EXISTS firewall_settings;
@@ -0,0 +1,7 @@
+-- Security state reported by the device in response to a SecurityInfo command.
+ALTER TABLE devices
+  ADD COLUMN hardware_encryption_caps integer NOT NULL DEFAULT 0,
+  ADD COLUMN passcode_present boolean NOT NULL DEFAULT false,
+  ADD COLUMN passcode_compliant boolean NOT NULL DEFAULT false,
+  ADD COLUMN fde_enabled boolean NOT NULL DEFAULT false,
+  ADD COLUMN firewall_settings JSONB;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS devices_applications_device_identifier_idx;
+
+ALTER TABLE devices_applications
+  DROP COLUMN IF EXISTS install_state;
@@ -0,0 +1,7 @@
+-- Progress of an InstallApplication command, as reported by the device.
+-- NULL for applications which were only seen in an InstalledApplicationList.
+ALTER TABLE devices_applications
+  ADD COLUMN install_state text;
+
+CREATE INDEX IF NOT EXISTS devices_applications_device_identifier_idx
+  ON devices_applications (device_uuid, identifier);
@@ -0,0 +1,17 @@
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+-- OS updates reported as available by the device in an AvailableOSUpdates response.
+CREATE TABLE IF NOT EXISTS devices_os_updates (
+  update_uuid uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  device_uuid uuid REFERENCES devices(device_uuid) ON DELETE CASCADE,
+  product_key text NOT NULL,
+  human_readable_name text NOT NULL DEFAULT '',
+  version text NOT NULL DEFAULT '',
+  build text NOT NULL DEFAULT '',
+  is_critical BOOL NOT NULL DEFAULT false,
+  restart_required BOOL NOT NULL DEFAULT false,
+  download_size bigint NOT NULL DEFAULT 0,
+  install_size bigint NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_devices_os_updates_device_uuid ON devices_os_updates (device_uuid);
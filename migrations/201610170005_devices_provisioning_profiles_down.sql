@@ -0,0 +1 @@
+DROP TABLE IF EXISTS devices_provisioning_profiles;
@@ -0,0 +1,11 @@
+-- Provisioning profiles reported as installed by the device in a ProvisioningProfileList response.
+-- expiry_date is stored as UTC.
+CREATE TABLE IF NOT EXISTS devices_provisioning_profiles (
+  device_uuid uuid REFERENCES devices(device_uuid) ON DELETE CASCADE,
+  uuid text NOT NULL,
+  name text NOT NULL DEFAULT '',
+  expiry_date timestamp NOT NULL,
+  PRIMARY KEY (device_uuid, uuid)
+);
+
+CREATE INDEX IF NOT EXISTS idx_devices_provisioning_profiles_expiry_date ON devices_provisioning_profiles (expiry_date);
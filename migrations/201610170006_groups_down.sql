@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS devices_groups;
+DROP TABLE IF EXISTS groups;
@@ -0,0 +1,14 @@
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS groups (
+  group_uuid uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS devices_groups (
+  group_uuid uuid REFERENCES groups(group_uuid) ON DELETE CASCADE,
+  device_uuid uuid REFERENCES devices(device_uuid) ON DELETE CASCADE,
+  PRIMARY KEY (group_uuid, device_uuid)
+);
+
+CREATE INDEX IF NOT EXISTS idx_devices_groups_device_uuid ON devices_groups (device_uuid);
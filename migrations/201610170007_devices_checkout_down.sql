@@ -0,0 +1,2 @@
+ALTER TABLE devices
+  DROP COLUMN IF EXISTS checked_out_at;
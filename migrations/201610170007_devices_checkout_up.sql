@@ -0,0 +1,3 @@
+-- Time of the last CheckOut message sent by the device.
+ALTER TABLE devices
+  ADD COLUMN checked_out_at timestamp;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_command_results_udid_created_at;
+DROP TABLE IF EXISTS command_results;
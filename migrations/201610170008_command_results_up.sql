@@ -0,0 +1,14 @@
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+-- Responses devices sent for MDM commands, kept after the command leaves the queue.
+CREATE TABLE IF NOT EXISTS command_results (
+  result_uuid uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  udid text NOT NULL,
+  command_uuid text NOT NULL,
+  request_type text NOT NULL DEFAULT '',
+  status text NOT NULL,
+  error_chain jsonb,
+  created_at timestamp NOT NULL DEFAULT (now() at time zone 'utc')
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_results_udid_created_at ON command_results (udid, created_at DESC);
@@ -0,0 +1,5 @@
+ALTER TABLE devices
+  DROP COLUMN IF EXISTS location_updated_at,
+  DROP COLUMN IF EXISTS longitude,
+  DROP COLUMN IF EXISTS latitude,
+  DROP COLUMN IF EXISTS lost_mode;
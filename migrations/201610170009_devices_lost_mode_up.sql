@@ -0,0 +1,6 @@
+-- Lost Mode state and the last location reported in a DeviceLocation response.
+ALTER TABLE devices
+  ADD COLUMN lost_mode boolean NOT NULL DEFAULT false,
+  ADD COLUMN latitude double precision,
+  ADD COLUMN longitude double precision,
+  ADD COLUMN location_updated_at timestamp;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS devices_assigned_profiles;
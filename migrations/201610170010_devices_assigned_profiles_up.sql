@@ -0,0 +1,9 @@
+-- Configuration profiles installed on the device with an InstallProfile command.
+CREATE TABLE IF NOT EXISTS devices_assigned_profiles (
+  device_uuid uuid REFERENCES devices(device_uuid) ON DELETE CASCADE,
+  payload_identifier text NOT NULL,
+  payload_uuid text NOT NULL DEFAULT '',
+  payload_display_name text NOT NULL DEFAULT '',
+  assigned_at timestamp NOT NULL,
+  PRIMARY KEY (device_uuid, payload_identifier)
+);
@@ -0,0 +1,11 @@
+-- Named configuration profiles which can be installed by name and version.
+CREATE TABLE IF NOT EXISTS profile_library (
+  name text NOT NULL,
+  version integer NOT NULL,
+  payload_identifier text NOT NULL,
+  payload_uuid text NOT NULL DEFAULT '',
+  payload_display_name text NOT NULL DEFAULT '',
+  data bytea NOT NULL,
+  created_at timestamp NOT NULL,
+  PRIMARY KEY (name, version)
+);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS dep_sync_cursor;
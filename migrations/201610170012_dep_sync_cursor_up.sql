@@ -0,0 +1,6 @@
+-- The cursor of the last DEP device sync. There is at most one row.
+CREATE TABLE IF NOT EXISTS dep_sync_cursor (
+  id integer PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+  cursor text NOT NULL,
+  updated_at timestamp NOT NULL
+);
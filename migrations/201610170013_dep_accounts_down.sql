@@ -0,0 +1,6 @@
+DELETE FROM dep_sync_cursor WHERE account <> 'default';
+ALTER TABLE dep_sync_cursor DROP CONSTRAINT dep_sync_cursor_pkey;
+ALTER TABLE dep_sync_cursor DROP COLUMN account;
+ALTER TABLE dep_sync_cursor ADD COLUMN id integer PRIMARY KEY DEFAULT 1 CHECK (id = 1);
+
+ALTER TABLE devices DROP COLUMN dep_account;
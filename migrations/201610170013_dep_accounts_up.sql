@@ -0,0 +1,8 @@
+-- The DEP account a device was fetched from. Empty for devices which are not in DEP.
+ALTER TABLE devices ADD COLUMN dep_account text NOT NULL DEFAULT '';
+
+-- One sync cursor per DEP account.
+ALTER TABLE dep_sync_cursor ADD COLUMN account text NOT NULL DEFAULT 'default';
+ALTER TABLE dep_sync_cursor DROP CONSTRAINT dep_sync_cursor_pkey;
+ALTER TABLE dep_sync_cursor DROP COLUMN id;
+ALTER TABLE dep_sync_cursor ADD PRIMARY KEY (account);
@@ -0,0 +1,2 @@
+-- The name of the enrollment profile the device enrolled with. Empty for the default profile.
+ALTER TABLE devices ADD COLUMN enrollment text NOT NULL DEFAULT '';
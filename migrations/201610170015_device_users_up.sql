@@ -0,0 +1,12 @@
+-- The push tokens of the user channels of a device. A device sends a TokenUpdate
+-- with a UserID for every user that has a user channel, e.g. macOS network accounts.
+CREATE TABLE IF NOT EXISTS device_users (
+  device_udid text NOT NULL,
+  user_id text NOT NULL,
+  user_short_name text NOT NULL DEFAULT '',
+  user_long_name text NOT NULL DEFAULT '',
+  apple_mdm_token text NOT NULL DEFAULT '',
+  apple_push_magic text NOT NULL DEFAULT '',
+  updated_at timestamp NOT NULL,
+  PRIMARY KEY (device_udid, user_id)
+);
@@ -0,0 +1,13 @@
+-- Users of a shared iPad reported by the device in a UserList response.
+CREATE TABLE IF NOT EXISTS devices_shared_users (
+  device_uuid uuid REFERENCES devices(device_uuid) ON DELETE CASCADE,
+  user_name text NOT NULL,
+  full_name text NOT NULL DEFAULT '',
+  uid integer NOT NULL DEFAULT 0,
+  user_guid text NOT NULL DEFAULT '',
+  is_logged_in BOOL NOT NULL DEFAULT false,
+  has_data_to_sync BOOL NOT NULL DEFAULT false,
+  data_quota bigint NOT NULL DEFAULT 0,
+  data_used bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY (device_uuid, user_name)
+);
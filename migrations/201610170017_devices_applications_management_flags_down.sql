@@ -0,0 +1,2 @@
+ALTER TABLE devices_applications
+  DROP COLUMN IF EXISTS management_flags;
@@ -0,0 +1,3 @@
+-- ManagementFlags of an application reported in a ManagedApplicationList response.
+ALTER TABLE devices_applications
+  ADD COLUMN management_flags integer;
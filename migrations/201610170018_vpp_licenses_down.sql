@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS vpp_licenses;
+DROP TABLE IF EXISTS vpp_apps;
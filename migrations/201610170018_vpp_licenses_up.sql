@@ -0,0 +1,16 @@
+-- Apps purchased through the Volume Purchase Program and the number of licenses owned.
+CREATE TABLE IF NOT EXISTS vpp_apps (
+  itunes_store_id integer PRIMARY KEY,
+  name text NOT NULL,
+  identifier text NOT NULL DEFAULT '',
+  total_licenses integer NOT NULL DEFAULT 0
+);
+
+-- VPP licenses assigned to devices. redemption_code is empty for device based assignments.
+CREATE TABLE IF NOT EXISTS vpp_licenses (
+  itunes_store_id integer REFERENCES vpp_apps(itunes_store_id) ON DELETE CASCADE,
+  device_uuid uuid REFERENCES devices(device_uuid) ON DELETE CASCADE,
+  redemption_code text NOT NULL DEFAULT '',
+  assigned_at timestamp NOT NULL,
+  PRIMARY KEY (itunes_store_id, device_uuid)
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS devices_certificates_not_after_idx;
+
+ALTER TABLE devices_certificates
+  DROP COLUMN IF EXISTS not_after;
@@ -0,0 +1,6 @@
+-- Expiry parsed from the certificate data. NULL if the data is not a valid certificate.
+ALTER TABLE devices_certificates
+  ADD COLUMN not_after timestamp;
+
+CREATE INDEX IF NOT EXISTS devices_certificates_not_after_idx
+  ON devices_certificates (not_after);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS devices_certificates_sha256_fingerprint_idx;
+DROP INDEX IF EXISTS devices_certificates_issuer_idx;
+
+ALTER TABLE devices_certificates
+  DROP COLUMN IF EXISTS issuer,
+  DROP COLUMN IF EXISTS serial_number,
+  DROP COLUMN IF EXISTS key_usage,
+  DROP COLUMN IF EXISTS sha256_fingerprint;
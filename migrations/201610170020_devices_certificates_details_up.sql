@@ -0,0 +1,12 @@
+-- Details parsed from the certificate data.
+-- The SHA-256 fingerprint identifies the same certificate across devices.
+ALTER TABLE devices_certificates
+  ADD COLUMN issuer text NOT NULL DEFAULT '',
+  ADD COLUMN serial_number text NOT NULL DEFAULT '',
+  ADD COLUMN key_usage integer NOT NULL DEFAULT 0,
+  ADD COLUMN sha256_fingerprint text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS devices_certificates_issuer_idx
+  ON devices_certificates (issuer);
+CREATE INDEX IF NOT EXISTS devices_certificates_sha256_fingerprint_idx
+  ON devices_certificates (sha256_fingerprint);
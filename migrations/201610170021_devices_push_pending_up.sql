@@ -0,0 +1,2 @@
+-- Set when a push notification could not be delivered to APNs, so that it is resent after a restart.
+ALTER TABLE devices ADD COLUMN push_pending_at timestamp;
@@ -0,0 +1,6 @@
+-- Fingerprint of the identity certificate a device signs its MDM requests with.
+CREATE TABLE IF NOT EXISTS device_identity_certificates (
+  udid text PRIMARY KEY,
+  sha256_fingerprint text NOT NULL,
+  updated_at timestamp NOT NULL
+);
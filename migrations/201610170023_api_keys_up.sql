@@ -0,0 +1,9 @@
+-- API keys for the management API. Only the SHA-256 of the token is stored.
+CREATE TABLE IF NOT EXISTS api_keys (
+  key_uuid uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name text NOT NULL,
+  token_sha256 text NOT NULL UNIQUE,
+  scopes text[] NOT NULL,
+  created_at timestamp NOT NULL,
+  revoked_at timestamp
+);
@@ -0,0 +1,13 @@
+-- Mutating requests to the management API.
+CREATE TABLE IF NOT EXISTS audit_log (
+  entry_uuid uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  method text NOT NULL,
+  path text NOT NULL,
+  actor text NOT NULL DEFAULT '',
+  actor_uuid text NOT NULL DEFAULT '',
+  device text NOT NULL DEFAULT '',
+  status integer NOT NULL,
+  created_at timestamp NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_log_device_idx ON audit_log (device, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor, created_at);
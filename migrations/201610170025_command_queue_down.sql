@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS command_failures;
+DROP TABLE IF EXISTS command_acknowledged;
+DROP TABLE IF EXISTS command_queue;
+DROP SEQUENCE IF EXISTS command_queue_seq;
+DROP TABLE IF EXISTS command_payloads;
@@ -0,0 +1,34 @@
+-- Command queue for deployments which do not run redis.
+CREATE TABLE IF NOT EXISTS command_payloads (
+  command_uuid text PRIMARY KEY,
+  payload bytea NOT NULL,
+  expires_at timestamp
+);
+
+CREATE SEQUENCE IF NOT EXISTS command_queue_seq;
+
+CREATE TABLE IF NOT EXISTS command_queue (
+  udid text NOT NULL,
+  command_uuid text NOT NULL,
+  priority integer NOT NULL DEFAULT 0,
+  seq bigint NOT NULL DEFAULT nextval('command_queue_seq'),
+  queued_at timestamp NOT NULL,
+  not_before timestamp,
+  deferred BOOL NOT NULL DEFAULT false,
+  PRIMARY KEY (udid, command_uuid)
+);
+CREATE INDEX IF NOT EXISTS command_queue_order_idx ON command_queue (udid, priority DESC, seq);
+
+CREATE TABLE IF NOT EXISTS command_acknowledged (
+  command_uuid text PRIMARY KEY,
+  expires_at timestamp NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS command_failures (
+  udid text NOT NULL,
+  command_uuid text NOT NULL,
+  request_type text NOT NULL DEFAULT '',
+  error_chain text,
+  failed_at timestamp NOT NULL
+);
+CREATE INDEX IF NOT EXISTS command_failures_udid_idx ON command_failures (udid, failed_at DESC);
@@ -0,0 +1,2 @@
+-- The original casing of serial numbers is not kept, there is nothing to undo.
+SELECT 1;
@@ -0,0 +1,16 @@
+-- Serial numbers are stored in upper case, DEP and devices don't agree on the casing.
+-- Rows whose upper case serial number is taken are duplicates of another record
+-- and are left as they are.
+UPDATE devices SET serial_number = upper(trim(serial_number))
+WHERE serial_number <> upper(trim(serial_number))
+AND NOT EXISTS (
+  SELECT 1 FROM devices other
+  WHERE other.serial_number = upper(trim(devices.serial_number))
+)
+-- of devices which only differ in casing, only the most recent one is updated
+AND device_uuid = (
+  SELECT same.device_uuid FROM devices same
+  WHERE upper(trim(same.serial_number)) = upper(trim(devices.serial_number))
+  ORDER BY same.last_checkin DESC NULLS LAST
+  LIMIT 1
+);
@@ -0,0 +1,3 @@
+-- Devices removed from management are kept with their history.
+ALTER TABLE devices
+  ADD COLUMN deleted_at timestamp;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS workflow_assignments;
+DROP TABLE IF EXISTS workflow_steps;
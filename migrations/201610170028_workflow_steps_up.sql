@@ -0,0 +1,16 @@
+-- Commands queued in order for a device which runs the workflow after enrollment.
+CREATE TABLE IF NOT EXISTS workflow_steps (
+  workflow_uuid uuid REFERENCES workflows ON DELETE CASCADE,
+  position integer NOT NULL,
+  command jsonb NOT NULL,
+  library_profile text NOT NULL DEFAULT '',
+  PRIMARY KEY (workflow_uuid, position)
+);
+
+-- The workflow a device runs, selected by the DEP profile or the named enrollment profile it enrolled with.
+CREATE TABLE IF NOT EXISTS workflow_assignments (
+  workflow_uuid uuid NOT NULL REFERENCES workflows ON DELETE CASCADE,
+  type text NOT NULL,
+  value text NOT NULL,
+  PRIMARY KEY (type, value)
+);
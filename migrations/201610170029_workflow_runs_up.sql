@@ -0,0 +1,12 @@
+-- The progress of a device through the steps of its workflow.
+CREATE TABLE IF NOT EXISTS workflow_runs (
+  device_udid text PRIMARY KEY,
+  workflow_uuid uuid NOT NULL REFERENCES workflows ON DELETE CASCADE,
+  position integer NOT NULL,
+  command_uuid text NOT NULL DEFAULT '',
+  status text NOT NULL,
+  started_at timestamp NOT NULL,
+  updated_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS workflow_runs_command_uuid_idx ON workflow_runs (command_uuid);
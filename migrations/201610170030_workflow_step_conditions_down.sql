@@ -0,0 +1,2 @@
+ALTER TABLE workflow_steps
+  DROP COLUMN IF EXISTS condition;
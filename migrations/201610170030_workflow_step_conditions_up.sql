@@ -0,0 +1,3 @@
+-- A step is skipped when the device does not match its condition.
+ALTER TABLE workflow_steps
+  ADD COLUMN condition jsonb;
@@ -0,0 +1,4 @@
+ALTER TABLE devices_profiles
+  DROP COLUMN IF EXISTS restrictions;
+ALTER TABLE devices_assigned_profiles
+  DROP COLUMN IF EXISTS restrictions;
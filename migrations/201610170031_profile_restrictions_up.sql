@@ -0,0 +1,5 @@
+-- The keys of the Restrictions payloads in profiles installed with InstallProfile.
+ALTER TABLE devices_assigned_profiles
+  ADD COLUMN restrictions jsonb;
+ALTER TABLE devices_profiles
+  ADD COLUMN restrictions jsonb;
@@ -0,0 +1,2 @@
+ALTER TABLE devices
+  DROP COLUMN IF EXISTS activation_lock_bypass_code;
@@ -0,0 +1,3 @@
+-- The Activation Lock bypass code of a supervised device, encrypted with the escrow key.
+ALTER TABLE devices
+  ADD COLUMN activation_lock_bypass_code bytea;
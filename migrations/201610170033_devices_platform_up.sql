@@ -0,0 +1,11 @@
+-- The platform of a device is derived from the ProductName it reports in DeviceInformation.
+ALTER TABLE devices
+  ADD COLUMN platform text NOT NULL DEFAULT '';
+
+UPDATE devices SET platform = CASE
+    WHEN product_name ILIKE '%mac%' THEN 'macos'
+    WHEN product_name ILIKE 'appletv%' THEN 'tvos'
+    WHEN product_name ILIKE 'iphone%' OR product_name ILIKE 'ipad%' OR product_name ILIKE 'ipod%' THEN 'ios'
+    ELSE ''
+  END
+  WHERE product_name IS NOT NULL;
@@ -0,0 +1,13 @@
+-- The declaration status reported by devices with a DeclarativeManagement status checkin.
+-- kind is one of activation, configuration, asset or management.
+CREATE TABLE IF NOT EXISTS device_declarations (
+  device_udid text NOT NULL,
+  identifier text NOT NULL,
+  kind text NOT NULL,
+  active boolean NOT NULL DEFAULT false,
+  valid text NOT NULL DEFAULT '',
+  server_token text NOT NULL DEFAULT '',
+  reasons jsonb,
+  updated_at timestamp NOT NULL,
+  PRIMARY KEY (device_udid, identifier)
+);
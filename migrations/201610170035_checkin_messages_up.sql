@@ -0,0 +1,10 @@
+-- Raw Authenticate, TokenUpdate and CheckOut messages with redacted secrets,
+-- recorded for debugging enrollment with --debug-checkin-messages.
+CREATE TABLE IF NOT EXISTS checkin_messages (
+  message_uuid uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  udid text NOT NULL DEFAULT '',
+  message_type text NOT NULL,
+  body text NOT NULL,
+  created_at timestamp NOT NULL
+);
+CREATE INDEX IF NOT EXISTS checkin_messages_created_at_idx ON checkin_messages (created_at);
@@ -0,0 +1,2 @@
+ALTER TABLE dep_sync_cursor
+  DROP COLUMN IF EXISTS fetching;
@@ -0,0 +1,3 @@
+-- fetching is true while a full fetch is in progress, its cursor resumes the fetch instead of starting a sync.
+ALTER TABLE dep_sync_cursor
+  ADD COLUMN fetching boolean NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS device_identity_certificates_fingerprint_idx;
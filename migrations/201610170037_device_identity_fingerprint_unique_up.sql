@@ -0,0 +1,3 @@
+-- A certificate of the generated enrollment profile is bound to a single device.
+CREATE UNIQUE INDEX IF NOT EXISTS device_identity_certificates_fingerprint_idx
+  ON device_identity_certificates (sha256_fingerprint);
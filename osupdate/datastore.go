@@ -0,0 +1,122 @@
+package osupdate
+
+import (
+	"fmt"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq" // postgres driver
+	"github.com/micromdm/micromdm/dbpool"
+	"github.com/pkg/errors"
+)
+
+var (
+	insertUpdateStmt = `INSERT INTO devices_os_updates (
+		device_uuid,
+		product_key,
+		human_readable_name,
+		version,
+		build,
+		is_critical,
+		restart_required,
+		download_size,
+		install_size
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING update_uuid;`
+
+	selectUpdatesByDeviceUUIDStmt = `SELECT
+		update_uuid,
+		device_uuid,
+		product_key,
+		human_readable_name,
+		version,
+		build,
+		is_critical,
+		restart_required,
+		download_size,
+		install_size
+		FROM devices_os_updates
+		WHERE device_uuid = $1`
+)
+
+// This Datastore manages a list of OS updates available to devices.
+type Datastore interface {
+	GetUpdatesByDeviceUUID(uuid string) ([]Update, error)
+	ReplaceUpdatesByDeviceUUID(uuid string, updates []Update) error
+}
+
+type pgStore struct {
+	*sqlx.DB
+}
+
+func NewDB(driver, conn string, logger kitlog.Logger, opts ...dbpool.Option) (Datastore, error) {
+	switch driver {
+	case "postgres":
+		db, err := sqlx.Open(driver, conn)
+		if err != nil {
+			return nil, errors.Wrap(err, "os updates datastore")
+		}
+		dbpool.Configure(db.DB, opts...)
+		var dbError error
+		maxAttempts := 20
+		for attempts := 1; attempts <= maxAttempts; attempts++ {
+			dbError = db.Ping()
+			if dbError == nil {
+				break
+			}
+			logger.Log("msg", fmt.Sprintf("could not connect to postgres: %v", dbError))
+			time.Sleep(time.Duration(attempts) * time.Second)
+		}
+		if dbError != nil {
+			return nil, errors.Wrap(dbError, "os updates datastore")
+		}
+		return pgStore{DB: db}, nil
+	default:
+		return nil, errors.New("unknown driver")
+	}
+}
+
+func (store pgStore) GetUpdatesByDeviceUUID(uuid string) ([]Update, error) {
+	var updates []Update
+	err := store.Select(&updates, selectUpdatesByDeviceUUIDStmt, uuid)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore GetUpdatesByDeviceUUID")
+	}
+	return updates, nil
+}
+
+// ReplaceUpdatesByDeviceUUID replaces the available updates of a device with the list
+// reported in the latest AvailableOSUpdates response.
+func (store pgStore) ReplaceUpdatesByDeviceUUID(uuid string, updates []Update) error {
+	tx, err := store.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM devices_os_updates WHERE device_uuid = $1", uuid); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "pgStore ReplaceUpdatesByDeviceUUID")
+	}
+
+	for _, u := range updates {
+		err := tx.QueryRow(
+			insertUpdateStmt,
+			uuid,
+			u.ProductKey,
+			u.HumanReadableName,
+			u.Version,
+			u.Build,
+			u.IsCritical,
+			u.RestartRequired,
+			u.DownloadSize,
+			u.InstallSize,
+		).Scan(&u.UUID)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "pgStore ReplaceUpdatesByDeviceUUID")
+		}
+	}
+
+	return tx.Commit()
+}
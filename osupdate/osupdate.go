@@ -0,0 +1,16 @@
+package osupdate
+
+// Update is an OS update reported as available for a device
+// in response to an AvailableOSUpdates command.
+type Update struct {
+	UUID              string `db:"update_uuid" json:"uuid"`
+	DeviceUUID        string `db:"device_uuid" json:"device_uuid"`
+	ProductKey        string `db:"product_key" json:"product_key"`
+	HumanReadableName string `db:"human_readable_name" json:"human_readable_name,omitempty"`
+	Version           string `db:"version" json:"version"`
+	Build             string `db:"build" json:"build,omitempty"`
+	IsCritical        bool   `db:"is_critical" json:"is_critical"`
+	RestartRequired   bool   `db:"restart_required" json:"restart_required"`
+	DownloadSize      int64  `db:"download_size" json:"download_size"`
+	InstallSize       int64  `db:"install_size" json:"install_size"`
+}
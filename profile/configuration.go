@@ -0,0 +1,64 @@
+package profile
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/fullsailor/pkcs7"
+	"github.com/groob/plist"
+)
+
+var (
+	// ErrInvalidProfile is returned if a profile is neither a plist nor a signed plist.
+	ErrInvalidProfile = errors.New("profile is not a valid .mobileconfig")
+
+	// ErrNotConfiguration is returned if the top level PayloadType of a profile is not Configuration.
+	ErrNotConfiguration = errors.New("profile PayloadType must be Configuration")
+)
+
+// Configuration holds the top level keys of a configuration profile.
+type Configuration struct {
+	PayloadType        string
+	PayloadIdentifier  string
+	PayloadUUID        string
+	PayloadDisplayName string    `plist:",omitempty"`
+	PayloadContent     []Payload `plist:",omitempty"`
+}
+
+// Payload holds the keys of a payload in the PayloadContent of a configuration profile.
+type Payload struct {
+	PayloadType       string
+	PayloadIdentifier string
+	// Topic is the push topic of a com.apple.mdm payload.
+	Topic string `plist:",omitempty"`
+}
+
+// ParseConfiguration parses the top level keys of a .mobileconfig, which may be signed.
+// It returns ErrNotConfiguration if the profile is not a Configuration profile.
+func ParseConfiguration(data []byte) (*Configuration, error) {
+	data, err := unsign(data)
+	if err != nil {
+		return nil, err
+	}
+	var config Configuration
+	if err := plist.NewDecoder(bytes.NewReader(data)).Decode(&config); err != nil {
+		return nil, ErrInvalidProfile
+	}
+	if config.PayloadType != "Configuration" {
+		return nil, ErrNotConfiguration
+	}
+	return &config, nil
+}
+
+// unsign returns the content of a signed profile, or the profile if it is not signed.
+func unsign(data []byte) ([]byte, error) {
+	// signed profiles are DER encoded PKCS7 SignedData
+	if len(data) > 0 && data[0] == 0x30 {
+		p7, err := pkcs7.Parse(data)
+		if err != nil {
+			return nil, ErrInvalidProfile
+		}
+		return p7.Content, nil
+	}
+	return data, nil
+}
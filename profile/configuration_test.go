@@ -0,0 +1,82 @@
+package profile
+
+import (
+	"fmt"
+	"testing"
+)
+
+const configurationPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array/>
+	<key>PayloadDisplayName</key>
+	<string>Wi-Fi</string>
+	<key>PayloadIdentifier</key>
+	<string>com.example.wifi</string>
+	<key>PayloadType</key>
+	<string>%s</string>
+	<key>PayloadUUID</key>
+	<string>9A5F6E3C-6B3B-4B1F-9C33-6C1C7B8D1E52</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+</dict>
+</plist>`
+
+func TestParseConfiguration(t *testing.T) {
+	config, err := ParseConfiguration([]byte(fmt.Sprintf(configurationPlist, "Configuration")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.PayloadIdentifier != "com.example.wifi" {
+		t.Errorf("have identifier %q, want com.example.wifi", config.PayloadIdentifier)
+	}
+
+	_, err = ParseConfiguration([]byte(fmt.Sprintf(configurationPlist, "com.apple.wifi.managed")))
+	if err != ErrNotConfiguration {
+		t.Errorf("have %v, want %v", err, ErrNotConfiguration)
+	}
+
+	if _, err := ParseConfiguration([]byte("not a profile")); err != ErrInvalidProfile {
+		t.Errorf("have %v, want %v", err, ErrInvalidProfile)
+	}
+}
+
+func TestParseConfigurationPayloadContent(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>AccessRights</key>
+			<integer>8191</integer>
+			<key>PayloadIdentifier</key>
+			<string>com.github.micromdm.mdm</string>
+			<key>PayloadType</key>
+			<string>com.apple.mdm</string>
+			<key>Topic</key>
+			<string>com.apple.mgmt.External.example</string>
+		</dict>
+	</array>
+	<key>PayloadIdentifier</key>
+	<string>com.github.micromdm.micromdm.mdm</string>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadUUID</key>
+	<string>9A5F6E3C-6B3B-4B1F-9C33-6C1C7B8D1E52</string>
+</dict>
+</plist>`)
+	config, err := ParseConfiguration(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.PayloadContent) != 1 {
+		t.Fatalf("have %d payloads, want 1", len(config.PayloadContent))
+	}
+	if topic := config.PayloadContent[0].Topic; topic != "com.apple.mgmt.External.example" {
+		t.Errorf("have topic %q, want com.apple.mgmt.External.example", topic)
+	}
+}
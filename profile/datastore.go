@@ -0,0 +1,339 @@
+package profile
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq" // postgres driver
+	"github.com/micromdm/micromdm/dbpool"
+	"github.com/pkg/errors"
+)
+
+var (
+	insertProfileStmt = `INSERT INTO devices_profiles (
+		device_uuid,
+		payload_identifier,
+		payload_uuid,
+		payload_display_name,
+		payload_organization,
+		is_signed,
+		restrictions
+	) VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING profile_uuid;`
+
+	selectProfilesByDeviceUUIDStmt = `SELECT
+		profile_uuid,
+		device_uuid,
+		payload_identifier,
+		payload_uuid,
+		payload_display_name,
+		payload_organization,
+		is_signed,
+		restrictions
+		FROM devices_profiles
+		WHERE device_uuid = $1`
+
+	insertProvisioningProfileStmt = `INSERT INTO devices_provisioning_profiles (
+		device_uuid,
+		uuid,
+		name,
+		expiry_date
+	) VALUES ($1, $2, $3, $4);`
+
+	selectProvisioningProfilesByDeviceUUIDStmt = `SELECT
+		device_uuid,
+		uuid,
+		name,
+		expiry_date
+		FROM devices_provisioning_profiles
+		WHERE device_uuid = $1
+		ORDER BY expiry_date`
+
+	upsertAssignedProfileStmt = `INSERT INTO devices_assigned_profiles (
+		device_uuid,
+		payload_identifier,
+		payload_uuid,
+		payload_display_name,
+		assigned_at,
+		restrictions
+	) VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (device_uuid, payload_identifier)
+	DO UPDATE SET
+		payload_uuid = $3,
+		payload_display_name = $4,
+		assigned_at = $5,
+		restrictions = $6;`
+
+	selectAssignedProfilesByDeviceUUIDStmt = `SELECT
+		device_uuid,
+		payload_identifier,
+		payload_uuid,
+		payload_display_name,
+		assigned_at,
+		restrictions
+		FROM devices_assigned_profiles
+		WHERE device_uuid = $1
+		ORDER BY payload_identifier`
+
+	deleteAssignedProfileStmt = `DELETE FROM devices_assigned_profiles
+		WHERE device_uuid = $1 AND payload_identifier = $2`
+
+	// the next version of a name is one more than the latest, starting at 1
+	insertLibraryProfileStmt = `INSERT INTO profile_library (
+		name,
+		version,
+		payload_identifier,
+		payload_uuid,
+		payload_display_name,
+		data,
+		created_at
+	) SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5, $6
+		FROM profile_library
+		WHERE name = $1
+	RETURNING version;`
+
+	selectLibraryProfileStmt = `SELECT
+		name,
+		version,
+		payload_identifier,
+		payload_uuid,
+		payload_display_name,
+		data,
+		created_at
+		FROM profile_library
+		WHERE name = $1`
+
+	// the list omits the profile data
+	selectLatestLibraryProfilesStmt = `SELECT DISTINCT ON (name)
+		name,
+		version,
+		payload_identifier,
+		payload_uuid,
+		payload_display_name,
+		created_at
+		FROM profile_library
+		ORDER BY name, version DESC`
+
+	deleteLibraryProfileStmt = `DELETE FROM profile_library WHERE name = $1`
+)
+
+// ErrLibraryProfileNotFound is returned if no version of a named profile exists in the library.
+var ErrLibraryProfileNotFound = errors.New("profile not found in library")
+
+// This Datastore manages a list of configuration profiles installed on devices.
+type Datastore interface {
+	GetProfilesByDeviceUUID(uuid string) ([]Profile, error)
+	ReplaceProfilesByDeviceUUID(uuid string, profiles []Profile) error
+	GetProvisioningProfilesByDeviceUUID(uuid string) ([]ProvisioningProfile, error)
+	ReplaceProvisioningProfilesByDeviceUUID(uuid string, profiles []ProvisioningProfile) error
+	GetAssignedProfilesByDeviceUUID(uuid string) ([]AssignedProfile, error)
+	// SaveAssignedProfile records a profile installed by an InstallProfile command,
+	// replacing an earlier version with the same identifier.
+	SaveAssignedProfile(p *AssignedProfile) error
+	// DeleteAssignedProfile removes a profile the device removed with a RemoveProfile command.
+	DeleteAssignedProfile(deviceUUID, identifier string) error
+
+	// AddLibraryProfile stores p as the next version of the named profile,
+	// setting p.Version.
+	AddLibraryProfile(p *LibraryProfile) error
+	// LibraryProfile returns a version of the named profile.
+	// A version of 0 returns the latest version.
+	LibraryProfile(name string, version int) (*LibraryProfile, error)
+	// LibraryProfiles returns the latest version of each profile in the library, without its data.
+	LibraryProfiles() ([]LibraryProfile, error)
+	// DeleteLibraryProfile removes all versions of the named profile.
+	DeleteLibraryProfile(name string) error
+}
+
+type pgStore struct {
+	*sqlx.DB
+}
+
+func NewDB(driver, conn string, logger kitlog.Logger, opts ...dbpool.Option) (Datastore, error) {
+	switch driver {
+	case "postgres":
+		db, err := sqlx.Open(driver, conn)
+		if err != nil {
+			return nil, errors.Wrap(err, "profiles datastore")
+		}
+		dbpool.Configure(db.DB, opts...)
+		var dbError error
+		maxAttempts := 20
+		for attempts := 1; attempts <= maxAttempts; attempts++ {
+			dbError = db.Ping()
+			if dbError == nil {
+				break
+			}
+			logger.Log("msg", fmt.Sprintf("could not connect to postgres: %v", dbError))
+			time.Sleep(time.Duration(attempts) * time.Second)
+		}
+		if dbError != nil {
+			return nil, errors.Wrap(dbError, "profiles datastore")
+		}
+		return pgStore{DB: db}, nil
+	default:
+		return nil, errors.New("unknown driver")
+	}
+}
+
+func (store pgStore) GetProfilesByDeviceUUID(uuid string) ([]Profile, error) {
+	var profiles []Profile
+	err := store.Select(&profiles, selectProfilesByDeviceUUIDStmt, uuid)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore GetProfilesByDeviceUUID")
+	}
+	return profiles, nil
+}
+
+// ReplaceProfilesByDeviceUUID replaces the installed profiles of a device with the list
+// reported in the latest ProfileList response.
+func (store pgStore) ReplaceProfilesByDeviceUUID(uuid string, profiles []Profile) error {
+	tx, err := store.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM devices_profiles WHERE device_uuid = $1", uuid); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "pgStore ReplaceProfilesByDeviceUUID")
+	}
+
+	for _, p := range profiles {
+		err := tx.QueryRow(
+			insertProfileStmt,
+			uuid,
+			p.Identifier,
+			p.PayloadUUID,
+			p.DisplayName,
+			p.Organization,
+			p.IsSigned,
+			p.Restrictions,
+		).Scan(&p.UUID)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "pgStore ReplaceProfilesByDeviceUUID")
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (store pgStore) GetProvisioningProfilesByDeviceUUID(uuid string) ([]ProvisioningProfile, error) {
+	var profiles []ProvisioningProfile
+	err := store.Select(&profiles, selectProvisioningProfilesByDeviceUUIDStmt, uuid)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore GetProvisioningProfilesByDeviceUUID")
+	}
+	return profiles, nil
+}
+
+// ReplaceProvisioningProfilesByDeviceUUID replaces the provisioning profiles of a device with the list
+// reported in the latest ProvisioningProfileList response.
+func (store pgStore) ReplaceProvisioningProfilesByDeviceUUID(uuid string, profiles []ProvisioningProfile) error {
+	tx, err := store.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM devices_provisioning_profiles WHERE device_uuid = $1", uuid); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "pgStore ReplaceProvisioningProfilesByDeviceUUID")
+	}
+
+	for _, p := range profiles {
+		// expiry_date is a timestamp without time zone, stored as UTC.
+		_, err := tx.Exec(
+			insertProvisioningProfileStmt,
+			uuid,
+			p.UUID,
+			p.Name,
+			p.ExpiryDate.UTC(),
+		)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "pgStore ReplaceProvisioningProfilesByDeviceUUID")
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (store pgStore) GetAssignedProfilesByDeviceUUID(uuid string) ([]AssignedProfile, error) {
+	var profiles []AssignedProfile
+	err := store.Select(&profiles, selectAssignedProfilesByDeviceUUIDStmt, uuid)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore GetAssignedProfilesByDeviceUUID")
+	}
+	return profiles, nil
+}
+
+func (store pgStore) SaveAssignedProfile(p *AssignedProfile) error {
+	_, err := store.Exec(
+		upsertAssignedProfileStmt,
+		p.DeviceUUID,
+		p.Identifier,
+		p.PayloadUUID,
+		p.DisplayName,
+		p.AssignedAt.UTC(),
+		p.Restrictions,
+	)
+	return errors.Wrap(err, "pgStore SaveAssignedProfile")
+}
+
+func (store pgStore) DeleteAssignedProfile(deviceUUID, identifier string) error {
+	_, err := store.Exec(deleteAssignedProfileStmt, deviceUUID, identifier)
+	return errors.Wrap(err, "pgStore DeleteAssignedProfile")
+}
+
+func (store pgStore) AddLibraryProfile(p *LibraryProfile) error {
+	err := store.QueryRow(
+		insertLibraryProfileStmt,
+		p.Name,
+		p.Identifier,
+		p.PayloadUUID,
+		p.DisplayName,
+		p.Data,
+		p.CreatedAt.UTC(),
+	).Scan(&p.Version)
+	return errors.Wrap(err, "pgStore AddLibraryProfile")
+}
+
+func (store pgStore) LibraryProfile(name string, version int) (*LibraryProfile, error) {
+	var p LibraryProfile
+	var err error
+	if version == 0 {
+		err = store.Get(&p, selectLibraryProfileStmt+" ORDER BY version DESC LIMIT 1", name)
+	} else {
+		err = store.Get(&p, selectLibraryProfileStmt+" AND version = $2", name, version)
+	}
+	if err == sql.ErrNoRows {
+		return nil, ErrLibraryProfileNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore LibraryProfile")
+	}
+	return &p, nil
+}
+
+func (store pgStore) LibraryProfiles() ([]LibraryProfile, error) {
+	var profiles []LibraryProfile
+	err := store.Select(&profiles, selectLatestLibraryProfilesStmt)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore LibraryProfiles")
+	}
+	return profiles, nil
+}
+
+func (store pgStore) DeleteLibraryProfile(name string) error {
+	res, err := store.Exec(deleteLibraryProfileStmt, name)
+	if err != nil {
+		return errors.Wrap(err, "pgStore DeleteLibraryProfile")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrLibraryProfileNotFound
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package profile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestLibraryProfileVersions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := pgStore{DB: sqlx.NewDb(db, "mock")}
+	defer store.Close()
+
+	name := "wifi"
+	now := time.Now().UTC()
+	v1 := []byte("version one")
+	v2 := []byte("version two")
+	columns := []string{"name", "version", "payload_identifier", "payload_uuid", "payload_display_name", "data", "created_at"}
+
+	// upload
+	mock.ExpectQuery("INSERT INTO profile_library").
+		WithArgs(name, "com.example.wifi", "uuid-1", "Wi-Fi", v1, now).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+	// fetch latest
+	mock.ExpectQuery("FROM profile_library (.+) ORDER BY version DESC LIMIT 1").
+		WithArgs(name).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(name, 1, "com.example.wifi", "uuid-1", "Wi-Fi", v1, now))
+	// version bump
+	mock.ExpectQuery("INSERT INTO profile_library").
+		WithArgs(name, "com.example.wifi", "uuid-2", "Wi-Fi", v2, now).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+	mock.ExpectQuery("FROM profile_library (.+) ORDER BY version DESC LIMIT 1").
+		WithArgs(name).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(name, 2, "com.example.wifi", "uuid-2", "Wi-Fi", v2, now))
+	// the first version is still available
+	mock.ExpectQuery("FROM profile_library (.+) AND version = ").
+		WithArgs(name, 1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(name, 1, "com.example.wifi", "uuid-1", "Wi-Fi", v1, now))
+
+	first := &LibraryProfile{Name: name, Identifier: "com.example.wifi", PayloadUUID: "uuid-1", DisplayName: "Wi-Fi", Data: v1, CreatedAt: now}
+	if err := store.AddLibraryProfile(first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != 1 {
+		t.Errorf("expected version 1, got %d", first.Version)
+	}
+	latest, err := store.LibraryProfile(name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Version != 1 || string(latest.Data) != string(v1) {
+		t.Errorf("expected version 1 with data %q, got %d %q", v1, latest.Version, latest.Data)
+	}
+
+	second := &LibraryProfile{Name: name, Identifier: "com.example.wifi", PayloadUUID: "uuid-2", DisplayName: "Wi-Fi", Data: v2, CreatedAt: now}
+	if err := store.AddLibraryProfile(second); err != nil {
+		t.Fatal(err)
+	}
+	if second.Version != 2 {
+		t.Errorf("expected version 2, got %d", second.Version)
+	}
+	latest, err = store.LibraryProfile(name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Version != 2 || string(latest.Data) != string(v2) {
+		t.Errorf("expected version 2 with data %q, got %d %q", v2, latest.Version, latest.Data)
+	}
+	old, err := store.LibraryProfile(name, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Version != 1 || string(old.Data) != string(v1) {
+		t.Errorf("expected version 1 with data %q, got %d %q", v1, old.Version, old.Data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestLibraryProfileNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := pgStore{DB: sqlx.NewDb(db, "mock")}
+	defer store.Close()
+
+	mock.ExpectQuery("FROM profile_library").
+		WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+
+	if _, err := store.LibraryProfile("missing", 0); err != ErrLibraryProfileNotFound {
+		t.Errorf("expected ErrLibraryProfileNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package profile
+
+import "time"
+
+// Profile is a configuration profile reported as installed on a device
+// in response to a ProfileList command.
+type Profile struct {
+	UUID         string `db:"profile_uuid" json:"uuid"`
+	DeviceUUID   string `db:"device_uuid" json:"device_uuid"`
+	Identifier   string `db:"payload_identifier" json:"payload_identifier"`
+	PayloadUUID  string `db:"payload_uuid" json:"payload_uuid"`
+	DisplayName  string `db:"payload_display_name" json:"payload_display_name,omitempty"`
+	Organization string `db:"payload_organization" json:"payload_organization,omitempty"`
+	IsSigned     bool   `db:"is_signed" json:"is_signed"`
+	// Restrictions are known for profiles installed with an InstallProfile command,
+	// the ProfileList response only lists the payload types.
+	Restrictions Restrictions `db:"restrictions" json:"restrictions,omitempty"`
+}
+
+// ProvisioningProfile is an app provisioning profile reported as installed on a device
+// in response to a ProvisioningProfileList command.
+type ProvisioningProfile struct {
+	DeviceUUID string    `db:"device_uuid" json:"device_uuid"`
+	UUID       string    `db:"uuid" json:"uuid"`
+	Name       string    `db:"name" json:"name"`
+	ExpiryDate time.Time `db:"expiry_date" json:"expiry_date"`
+}
+
+// AssignedProfile is a configuration profile the device installed
+// in response to an InstallProfile command.
+type AssignedProfile struct {
+	DeviceUUID  string    `db:"device_uuid" json:"device_uuid"`
+	Identifier  string    `db:"payload_identifier" json:"payload_identifier"`
+	PayloadUUID string    `db:"payload_uuid" json:"payload_uuid"`
+	DisplayName string    `db:"payload_display_name" json:"payload_display_name,omitempty"`
+	AssignedAt  time.Time `db:"assigned_at" json:"assigned_at"`
+	// Restrictions are the keys of the Restrictions payloads in the installed profile.
+	Restrictions Restrictions `db:"restrictions" json:"restrictions,omitempty"`
+}
+
+// LibraryProfile is a version of a named configuration profile in the profile library.
+// InstallProfile commands reference it by name and version instead of inlining the payload.
+type LibraryProfile struct {
+	Name        string    `db:"name" json:"name"`
+	Version     int       `db:"version" json:"version"`
+	Identifier  string    `db:"payload_identifier" json:"payload_identifier"`
+	PayloadUUID string    `db:"payload_uuid" json:"payload_uuid"`
+	DisplayName string    `db:"payload_display_name" json:"payload_display_name,omitempty"`
+	Data        []byte    `db:"data" json:"data,omitempty"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
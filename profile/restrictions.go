@@ -0,0 +1,100 @@
+package profile
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/groob/plist"
+)
+
+// RestrictionsPayloadType is the PayloadType of a Restrictions payload.
+const RestrictionsPayloadType = "com.apple.applicationaccess"
+
+// Restrictions are the keys of the Restrictions payloads in a profile,
+// like allowCamera. They are stored as jsonb.
+// A ProfileList response lists the payload types of a profile but not its keys, so the
+// restrictions are only known for profiles the server pushed with an InstallProfile command.
+type Restrictions map[string]interface{}
+
+// ParseRestrictions returns the keys of the Restrictions payloads in a profile, which may be signed.
+// It returns nil if the profile has no Restrictions payload.
+func ParseRestrictions(data []byte) (Restrictions, error) {
+	data, err := unsign(data)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		PayloadContent []map[string]interface{} `plist:",omitempty"`
+	}
+	if err := plist.NewDecoder(bytes.NewReader(data)).Decode(&config); err != nil {
+		return nil, ErrInvalidProfile
+	}
+	var restrictions Restrictions
+	for _, payload := range config.PayloadContent {
+		if payload["PayloadType"] != RestrictionsPayloadType {
+			continue
+		}
+		if restrictions == nil {
+			restrictions = make(Restrictions)
+		}
+		for key, value := range payload {
+			// the Payload keys describe the payload, not a restriction.
+			if strings.HasPrefix(key, "Payload") {
+				continue
+			}
+			restrictions[key] = value
+		}
+	}
+	return restrictions, nil
+}
+
+// Merge adds the restrictions of other. Like the device, it keeps the most
+// restrictive value of a boolean key set by both: an allow key is only true
+// if both are true, any other key is true if either is.
+// Other values of other replace the value with the same key.
+func (r Restrictions) Merge(other Restrictions) Restrictions {
+	if len(other) == 0 {
+		return r
+	}
+	if r == nil {
+		r = make(Restrictions)
+	}
+	for key, value := range other {
+		have, ok1 := r[key].(bool)
+		want, ok2 := value.(bool)
+		if ok1 && ok2 {
+			if strings.HasPrefix(key, "allow") {
+				value = have && want
+			} else {
+				value = have || want
+			}
+		}
+		r[key] = value
+	}
+	return r
+}
+
+// Value implements driver.Valuer. No restrictions are stored as NULL.
+func (r Restrictions) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+// Scan implements sql.Scanner.
+func (r *Restrictions) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*r = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	}
+	return errors.New("profile: unsupported restrictions value")
+}
@@ -0,0 +1,80 @@
+package profile
+
+import "testing"
+
+const restrictionsPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>PayloadIdentifier</key>
+			<string>com.example.restrictions.camera</string>
+			<key>PayloadType</key>
+			<string>com.apple.applicationaccess</string>
+			<key>PayloadUUID</key>
+			<string>0C5D7C2B-4E0B-4C8B-9F1C-3F4B6A1D2E10</string>
+			<key>PayloadVersion</key>
+			<integer>1</integer>
+			<key>allowCamera</key>
+			<false/>
+			<key>forceEncryptedBackup</key>
+			<true/>
+		</dict>
+		<dict>
+			<key>PayloadIdentifier</key>
+			<string>com.example.wifi</string>
+			<key>PayloadType</key>
+			<string>com.apple.wifi.managed</string>
+			<key>SSID_STR</key>
+			<string>example</string>
+		</dict>
+	</array>
+	<key>PayloadIdentifier</key>
+	<string>com.example.restrictions</string>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadUUID</key>
+	<string>6F1A2B3C-7D8E-4F90-A1B2-C3D4E5F60718</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+</dict>
+</plist>`
+
+func TestParseRestrictions(t *testing.T) {
+	restrictions, err := ParseRestrictions([]byte(restrictionsPlist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 2 {
+		t.Fatalf("have restrictions %v, want allowCamera and forceEncryptedBackup", restrictions)
+	}
+	if allowed, ok := restrictions["allowCamera"].(bool); !ok || allowed {
+		t.Errorf("have allowCamera %v, want false", restrictions["allowCamera"])
+	}
+
+	none, err := ParseRestrictions([]byte(configurationPlist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if none != nil {
+		t.Errorf("have restrictions %v for a profile without a Restrictions payload", none)
+	}
+}
+
+func TestMergeRestrictions(t *testing.T) {
+	var merged Restrictions
+	merged = merged.Merge(Restrictions{"allowCamera": true, "forceEncryptedBackup": true})
+	merged = merged.Merge(Restrictions{"allowCamera": false, "forceEncryptedBackup": false, "ratingRegion": "us"})
+
+	if merged["allowCamera"] != false {
+		t.Errorf("have allowCamera %v, want false", merged["allowCamera"])
+	}
+	if merged["forceEncryptedBackup"] != true {
+		t.Errorf("have forceEncryptedBackup %v, want true", merged["forceEncryptedBackup"])
+	}
+	if merged["ratingRegion"] != "us" {
+		t.Errorf("have ratingRegion %v, want us", merged["ratingRegion"])
+	}
+}
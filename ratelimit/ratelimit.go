@@ -0,0 +1,158 @@
+// Package ratelimit limits how often a single device may connect to the MDM server.
+package ratelimit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/groob/plist"
+)
+
+// DefaultEnrollGrace is how long a device is not throttled after an
+// Authenticate or TokenUpdate message.
+const DefaultEnrollGrace = 15 * time.Minute
+
+// Limiter limits how often a single device may connect.
+// A device in a reboot loop or with a broken MDM client can otherwise
+// keep the server busy with connect requests.
+type Limiter struct {
+	limit  int
+	window time.Duration
+	// Exempt reports whether a device is still enrolling.
+	// Enrolling devices are never throttled, setup sends many commands in a row.
+	Exempt func(udid string) bool
+	// EnrollGrace is how long a device which sent an Authenticate or TokenUpdate
+	// is not throttled. Manually enrolled devices are not awaiting configuration,
+	// but receive as many commands right after enrollment.
+	EnrollGrace time.Duration
+
+	mu        sync.Mutex
+	devices   map[string]*connectWindow
+	enrolled  map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type connectWindow struct {
+	start time.Time
+	count int
+}
+
+// New allows limit connect requests per device within window.
+func New(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		limit:       limit,
+		window:      window,
+		EnrollGrace: DefaultEnrollGrace,
+		devices:     make(map[string]*connectWindow),
+		enrolled:    make(map[string]time.Time),
+		now:         time.Now,
+	}
+}
+
+// Allow records a connect request from udid. If the device is over the limit,
+// Allow returns false and how long the device has to wait.
+func (l *Limiter) Allow(udid string) (bool, time.Duration) {
+	l.mu.Lock()
+	now := l.now()
+	l.sweep(now)
+	w, ok := l.devices[udid]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &connectWindow{start: now}
+		l.devices[udid] = w
+	}
+	w.count++
+	allowed := w.count <= l.limit
+	retryAfter := w.start.Add(l.window).Sub(now)
+	if enrolledAt, ok := l.enrolled[udid]; ok && now.Sub(enrolledAt) < l.EnrollGrace {
+		allowed = true
+	}
+	l.mu.Unlock()
+
+	// only look up the device once it is over the limit.
+	if !allowed && l.Exempt != nil && l.Exempt(udid) {
+		return true, 0
+	}
+	if allowed {
+		return true, 0
+	}
+	return false, retryAfter
+}
+
+// Enrolled exempts the device from the limit for the EnrollGrace period.
+func (l *Limiter) Enrolled(udid string) {
+	l.mu.Lock()
+	l.enrolled[udid] = l.now()
+	l.mu.Unlock()
+}
+
+// sweep forgets devices whose window or grace period has passed. Must be called with l.mu held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	for udid, w := range l.devices {
+		if now.Sub(w.start) >= l.window {
+			delete(l.devices, udid)
+		}
+	}
+	for udid, enrolledAt := range l.enrolled {
+		if now.Sub(enrolledAt) >= l.EnrollGrace {
+			delete(l.enrolled, udid)
+		}
+	}
+	l.lastSweep = now
+}
+
+// peek reads the body of r and decodes the plist into v.
+// The body is restored for the next handler.
+func peek(r *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return plist.NewDecoder(bytes.NewReader(body)).Decode(v)
+}
+
+// Handler wraps the connect handler and responds with 503 and a Retry-After
+// header to devices which connect more often than the limiter allows.
+func Handler(next http.Handler, limiter *Limiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ UDID string }
+		if err := peek(r, &req); err != nil || req.UDID == "" {
+			// let the connect handler report the malformed request.
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, retryAfter := limiter.Allow(req.UDID); !ok {
+			seconds := int(retryAfter/time.Second) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TrackEnrollment wraps the checkin handler and exempts the devices which
+// send an Authenticate or TokenUpdate message for the EnrollGrace period.
+func TrackEnrollment(next http.Handler, limiter *Limiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			MessageType string
+			UDID        string
+		}
+		if err := peek(r, &msg); err == nil && msg.UDID != "" {
+			switch msg.MessageType {
+			case "Authenticate", "TokenUpdate":
+				limiter.Enrolled(msg.UDID)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
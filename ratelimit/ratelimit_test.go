@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(limit int, window time.Duration) (*Limiter, *time.Time) {
+	l := New(limit, window)
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestAllow(t *testing.T) {
+	l, now := newTestLimiter(2, time.Minute)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("udid"); !ok {
+			t.Fatalf("request %d was throttled", i+1)
+		}
+	}
+	ok, retryAfter := l.Allow("udid")
+	if ok {
+		t.Fatal("request over the limit was allowed")
+	}
+	if retryAfter != time.Minute {
+		t.Errorf("retryAfter = %v, want %v", retryAfter, time.Minute)
+	}
+	if ok, _ := l.Allow("other"); !ok {
+		t.Error("another device was throttled")
+	}
+
+	*now = now.Add(time.Minute)
+	if ok, _ := l.Allow("udid"); !ok {
+		t.Error("request in the next window was throttled")
+	}
+}
+
+func TestAllowExempt(t *testing.T) {
+	l, _ := newTestLimiter(1, time.Minute)
+	var lookups int
+	l.Exempt = func(udid string) bool {
+		lookups++
+		return udid == "awaiting"
+	}
+	l.Allow("awaiting")
+	if ok, _ := l.Allow("awaiting"); !ok {
+		t.Error("device awaiting configuration was throttled")
+	}
+	if lookups != 1 {
+		t.Errorf("Exempt was called %d times, want 1", lookups)
+	}
+}
+
+func TestAllowEnrolled(t *testing.T) {
+	l, now := newTestLimiter(1, time.Minute)
+	l.Enrolled("udid")
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("udid"); !ok {
+			t.Fatalf("request %d of an enrolled device was throttled", i+1)
+		}
+	}
+
+	*now = now.Add(l.EnrollGrace)
+	l.Allow("udid")
+	if ok, _ := l.Allow("udid"); ok {
+		t.Error("device was not throttled after the grace period")
+	}
+	if _, ok := l.enrolled["udid"]; ok {
+		t.Error("expired grace period was not swept")
+	}
+}
+
+const connectRequest = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Status</key>
+	<string>Idle</string>
+	<key>UDID</key>
+	<string>udid</string>
+</dict>
+</plist>`
+
+func TestHandler(t *testing.T) {
+	l, _ := newTestLimiter(1, time.Minute)
+	var served int
+	h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	}), l)
+
+	for i, want := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("PUT", "/mdm/connect", strings.NewReader(connectRequest)))
+		if rec.Code != want {
+			t.Errorf("request %d: status = %d, want %d", i+1, rec.Code, want)
+		}
+	}
+	if served != 1 {
+		t.Errorf("connect handler served %d requests, want 1", served)
+	}
+}
+
+const tokenUpdate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>TokenUpdate</string>
+	<key>UDID</key>
+	<string>udid</string>
+</dict>
+</plist>`
+
+func TestTrackEnrollment(t *testing.T) {
+	l, _ := newTestLimiter(1, time.Minute)
+	h := TrackEnrollment(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), l)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/mdm/checkin", strings.NewReader(tokenUpdate)))
+
+	if _, ok := l.enrolled["udid"]; !ok {
+		t.Fatal("TokenUpdate did not start the grace period")
+	}
+}
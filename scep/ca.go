@@ -0,0 +1,221 @@
+// Package scep is a SCEP responder which issues device identity certificates
+// from a CA configured on the server, instead of an external SCEP server.
+package scep
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// DefaultValidity is how long issued identity certificates are valid.
+const DefaultValidity = 365 * 24 * time.Hour
+
+// CA issues certificates for the certificate requests of devices
+// which present one of the SCEP challenges.
+type CA struct {
+	cert     *x509.Certificate
+	key      *rsa.PrivateKey
+	validity time.Duration
+
+	// challenges counts the enrollment profiles which use each challenge.
+	mu         sync.RWMutex
+	challenges map[string]int
+}
+
+// Option configures a CA.
+type Option func(*CA)
+
+// WithValidity sets how long issued certificates are valid.
+func WithValidity(d time.Duration) Option {
+	return func(ca *CA) {
+		if d > 0 {
+			ca.validity = d
+		}
+	}
+}
+
+// WithChallenges sets the challenges a certificate request must present,
+// one for each enrollment profile. Empty challenges are ignored.
+func WithChallenges(challenges ...string) Option {
+	return func(ca *CA) {
+		for _, c := range challenges {
+			if c != "" {
+				ca.challenges[c]++
+			}
+		}
+	}
+}
+
+// ErrNoChallenge is returned by NewCA if no challenge is configured.
+// Without a challenge anyone could request a device identity certificate.
+var ErrNoChallenge = errors.New("the embedded SCEP CA requires a SCEP challenge")
+
+// NewCA creates a CA from a PEM certificate and a PEM RSA private key.
+func NewCA(certPath, keyPath string, opts ...Option) (*CA, error) {
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate found in %s", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SCEP CA certificate: %s", err)
+	}
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key found in %s", keyPath)
+	}
+	key, err := parseRSAKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return newCA(cert, key, opts...)
+}
+
+func newCA(cert *x509.Certificate, key *rsa.PrivateKey, opts ...Option) (*CA, error) {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok || pub.N.Cmp(key.N) != 0 {
+		return nil, errors.New("SCEP CA private key does not match the certificate")
+	}
+	ca := &CA{
+		cert:       cert,
+		key:        key,
+		validity:   DefaultValidity,
+		challenges: make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(ca)
+	}
+	if len(ca.challenges) == 0 {
+		return nil, ErrNoChallenge
+	}
+	return ca, nil
+}
+
+// ReplaceChallenge replaces the challenge of an enrollment profile with challenge.
+// old stays valid while another enrollment profile uses it.
+// It is called when the enrollment profile is regenerated with a new challenge.
+func (ca *CA) ReplaceChallenge(old, challenge string) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if ca.challenges[old] > 1 {
+		ca.challenges[old]--
+	} else {
+		delete(ca.challenges, old)
+	}
+	if challenge != "" {
+		ca.challenges[challenge]++
+	}
+}
+
+func (ca *CA) validChallenge(challenge string) bool {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+	return ca.challenges[challenge] > 0
+}
+
+// issuedByCA reports whether cert is a valid client certificate issued by the CA.
+func (ca *CA) issuedByCA(cert *x509.Certificate) bool {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err == nil
+}
+
+// Certificate returns the CA certificate, which is sent to devices with GetCACert.
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// issue signs a certificate for the public key and subject of the request.
+func (ca *CA) issue(csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      csr.Subject,
+		// allow for devices with a clock which is slightly behind.
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(ca.validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func parseRSAKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("SCEP CA private key is not an RSA key")
+	}
+	return priv, nil
+}
+
+// oidChallengePassword is the PKCS#9 challengePassword attribute of a certificate request.
+var oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+
+// challengePassword returns the challengePassword attribute of a certificate request.
+// crypto/x509 does not parse it, because its value is a string instead of a set of names.
+func challengePassword(csr *x509.CertificateRequest) (string, error) {
+	var tbs struct {
+		Raw           asn1.RawContent
+		Version       int
+		Subject       asn1.RawValue
+		PublicKey     asn1.RawValue
+		RawAttributes []asn1.RawValue `asn1:"tag:0"`
+	}
+	if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &tbs); err != nil {
+		return "", err
+	}
+	for _, raw := range tbs.RawAttributes {
+		var attr struct {
+			Type   asn1.ObjectIdentifier
+			Values []asn1.RawValue `asn1:"set"`
+		}
+		if _, err := asn1.Unmarshal(raw.FullBytes, &attr); err != nil {
+			continue
+		}
+		if !attr.Type.Equal(oidChallengePassword) || len(attr.Values) == 0 {
+			continue
+		}
+		var password string
+		if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &password); err != nil {
+			return "", err
+		}
+		return password, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,52 @@
+package scep
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// caCaps are the capabilities sent with GetCACaps. Devices encrypt requests with
+// triple DES, which the responder can decrypt, and POST them.
+const caCaps = "POSTPKIOperation\nSHA-1\nDES3\n"
+
+// maxMessageSize limits the size of a PKIOperation message.
+const maxMessageSize = 1 << 20
+
+// Handler serves the SCEP operations GetCACert, GetCACaps and PKIOperation.
+func Handler(ca *CA, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("operation") {
+		case "GetCACert":
+			w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+			w.Write(ca.cert.Raw)
+		case "GetCACaps":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(caCaps))
+		case "PKIOperation":
+			var msg []byte
+			var err error
+			if r.Method == "POST" {
+				msg, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+			} else {
+				msg, err = base64.StdEncoding.DecodeString(r.URL.Query().Get("message"))
+			}
+			if err != nil {
+				http.Error(w, "malformed PKIOperation message", http.StatusBadRequest)
+				return
+			}
+			resp, err := ca.PKIOperation(msg)
+			if err != nil {
+				logger.Log("msg", "SCEP PKIOperation", "err", err)
+				http.Error(w, "invalid PKIOperation message", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/x-pki-message")
+			w.Write(resp)
+		default:
+			http.Error(w, "unknown SCEP operation", http.StatusBadRequest)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package scep
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"reflect"
+
+	"github.com/fullsailor/pkcs7"
+)
+
+// SCEP message types, pkiStatus and failInfo values from the SCEP draft.
+const (
+	msgCertRep   = "3"
+	msgPKCSReq   = "19"
+	msgRenewal   = "17"
+	statusOK     = "0"
+	statusFailed = "2"
+	failBadReq   = "2"
+)
+
+// SCEP authenticated attributes.
+var (
+	oidMessageType    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidPKIStatus      = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidFailInfo       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSenderNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidRecipientNonce = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidTransactionID  = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+)
+
+var (
+	// ErrBadChallenge is returned if a certificate request does not present one of the challenges.
+	ErrBadChallenge = errors.New("scep: certificate request has an invalid challenge")
+
+	// ErrUnsupportedMessage is returned for SCEP messages other than PKCSReq and RenewalReq.
+	ErrUnsupportedMessage = errors.New("scep: unsupported message type")
+)
+
+// pkiRequest is a decoded PKCSReq or RenewalReq.
+type pkiRequest struct {
+	messageType   string
+	transactionID string
+	senderNonce   []byte
+	// signer is the self-signed certificate of the device, the response is encrypted for it.
+	// A RenewalReq is signed with the certificate which is renewed.
+	signer *x509.Certificate
+	csr    *x509.CertificateRequest
+}
+
+// PKIOperation handles a PKCSReq or RenewalReq message and returns the CertRep response.
+// A PKCSReq must present one of the challenges, a RenewalReq must be signed with
+// a certificate issued by the CA and request the subject of that certificate. A request which fails either check gets a CertRep
+// with a failure status, a request which cannot be decoded returns an error.
+func (ca *CA) PKIOperation(msg []byte) ([]byte, error) {
+	req, err := ca.decodeRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+	if req.messageType == msgRenewal {
+		if !ca.issuedByCA(req.signer) || !sameSubject(req.csr, req.signer) {
+			return ca.failure(req)
+		}
+	} else {
+		challenge, err := challengePassword(req.csr)
+		if err != nil {
+			return nil, err
+		}
+		if !ca.validChallenge(challenge) {
+			return ca.failure(req)
+		}
+	}
+	cert, err := ca.issue(req.csr)
+	if err != nil {
+		return nil, err
+	}
+	return ca.success(req, cert)
+}
+
+// sameSubject reports whether the certificate request has the subject of cert.
+// The subject of a renewed certificate is copied from the request, so a device
+// could otherwise renew its certificate into one which names another UDID.
+// The names are compared after parsing, a device may encode the strings differently.
+func sameSubject(csr *x509.CertificateRequest, cert *x509.Certificate) bool {
+	return reflect.DeepEqual(csr.Subject.ToRDNSequence(), cert.Subject.ToRDNSequence())
+}
+
+func (ca *CA) decodeRequest(msg []byte) (*pkiRequest, error) {
+	p7, err := pkcs7.Parse(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := p7.Verify(); err != nil {
+		return nil, err
+	}
+	req := &pkiRequest{signer: p7.GetOnlySigner()}
+	if req.signer == nil {
+		return nil, errors.New("scep: request must have exactly one signer")
+	}
+	if err := p7.UnmarshalSignedAttribute(oidMessageType, &req.messageType); err != nil {
+		return nil, err
+	}
+	if req.messageType != msgPKCSReq && req.messageType != msgRenewal {
+		return nil, ErrUnsupportedMessage
+	}
+	if err := p7.UnmarshalSignedAttribute(oidTransactionID, &req.transactionID); err != nil {
+		return nil, err
+	}
+	if err := p7.UnmarshalSignedAttribute(oidSenderNonce, &req.senderNonce); err != nil {
+		return nil, err
+	}
+
+	// the signed content is the certificate request, encrypted for the CA.
+	envelope, err := pkcs7.Parse(p7.Content)
+	if err != nil {
+		return nil, err
+	}
+	der, err := envelope.Decrypt(ca.cert, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	if req.csr, err = x509.ParseCertificateRequest(der); err != nil {
+		return nil, err
+	}
+	if err := req.csr.CheckSignature(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// success returns a CertRep with the issued certificate, encrypted for the device.
+func (ca *CA) success(req *pkiRequest, cert *x509.Certificate) ([]byte, error) {
+	degenerate, err := pkcs7.DegenerateCertificate(cert.Raw)
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := pkcs7.Encrypt(degenerate, []*x509.Certificate{req.signer})
+	if err != nil {
+		return nil, err
+	}
+	return ca.certRep(req, envelope, pkcs7.Attribute{Type: oidPKIStatus, Value: statusOK})
+}
+
+// failure returns a CertRep which rejects the request.
+func (ca *CA) failure(req *pkiRequest) ([]byte, error) {
+	return ca.certRep(req, nil,
+		pkcs7.Attribute{Type: oidPKIStatus, Value: statusFailed},
+		pkcs7.Attribute{Type: oidFailInfo, Value: failBadReq},
+	)
+}
+
+func (ca *CA) certRep(req *pkiRequest, content []byte, status ...pkcs7.Attribute) ([]byte, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sd, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		return nil, err
+	}
+	attrs := append([]pkcs7.Attribute{
+		{Type: oidMessageType, Value: msgCertRep},
+		{Type: oidTransactionID, Value: req.transactionID},
+		{Type: oidSenderNonce, Value: nonce},
+		{Type: oidRecipientNonce, Value: req.senderNonce},
+	}, status...)
+	if err := sd.AddSigner(ca.cert, ca.key, pkcs7.SignerInfoConfig{ExtraSignedAttributes: attrs}); err != nil {
+		return nil, err
+	}
+	return sd.Finish()
+}
@@ -0,0 +1,294 @@
+package scep
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fullsailor/pkcs7"
+	"github.com/go-kit/kit/log"
+)
+
+func newTestCA(t *testing.T) *CA {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "MicroMDM SCEP CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := newCA(cert, key, WithChallenges("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+// csrWithChallenge creates a certificate request with a challengePassword attribute,
+// like the one a device sends. crypto/x509 cannot encode the attribute.
+func csrWithChallenge(t *testing.T, key *rsa.PrivateKey, cn, challenge string) []byte {
+	subject, err := asn1.Marshal(pkix.Name{CommonName: cn}.ToRDNSequence())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := asn1.Marshal(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attr, err := asn1.Marshal(struct {
+		Type   asn1.ObjectIdentifier
+		Values []asn1.RawValue `asn1:"set"`
+	}{oidChallengePassword, []asn1.RawValue{{FullBytes: value}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbs, err := asn1.Marshal(struct {
+		Version       int
+		Subject       asn1.RawValue
+		PublicKey     asn1.RawValue
+		RawAttributes []asn1.RawValue `asn1:"tag:0"`
+	}{0, asn1.RawValue{FullBytes: subject}, asn1.RawValue{FullBytes: spki}, []asn1.RawValue{{FullBytes: attr}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbs)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := asn1.Marshal(struct {
+		TBS       asn1.RawValue
+		Algorithm pkix.AlgorithmIdentifier
+		Signature asn1.BitString
+	}{
+		asn1.RawValue{FullBytes: tbs},
+		pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, Parameters: asn1.NullRawValue},
+		asn1.BitString{Bytes: sig, BitLength: len(sig) * 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+// pkcsReq creates the PKCSReq message of a device, signed with a self-signed certificate.
+func pkcsReq(t *testing.T, ca *CA, challenge string) ([]byte, *x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "MDM Identity Certificate UDID"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signedRequest(t, ca, msgPKCSReq, "MDM Identity Certificate UDID", challenge, self, key), self, key
+}
+
+// signedRequest creates a SCEP request of the message type for the key and common name cn, signed with cert.
+func signedRequest(t *testing.T, ca *CA, messageType, cn, challenge string, cert *x509.Certificate, key *rsa.PrivateKey) []byte {
+	envelope, err := pkcs7.Encrypt(csrWithChallenge(t, key, cn, challenge), []*x509.Certificate{ca.cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := pkcs7.NewSignedData(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sd.AddSigner(cert, key, pkcs7.SignerInfoConfig{ExtraSignedAttributes: []pkcs7.Attribute{
+		{Type: oidMessageType, Value: messageType},
+		{Type: oidTransactionID, Value: "transaction-1"},
+		{Type: oidSenderNonce, Value: []byte("0123456789abcdef")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := sd.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// pkiStatus sends msg to the CA and returns the pkiStatus of the response.
+func pkiStatus(t *testing.T, ca *CA, msg []byte) string {
+	resp, err := ca.PKIOperation(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep, err := pkcs7.Parse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status string
+	if err := rep.UnmarshalSignedAttribute(oidPKIStatus, &status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestPKIOperation(t *testing.T) {
+	ca := newTestCA(t)
+	msg, self, key := pkcsReq(t, ca, "secret")
+
+	server := httptest.NewServer(Handler(ca, log.NewNopLogger()))
+	defer server.Close()
+	resp, err := http.Post(server.URL+"/scep?operation=PKIOperation", "application/x-pki-message", bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("have status %d, want 200", resp.StatusCode)
+	}
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+
+	rep, err := pkcs7.Parse(body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rep.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	var status, transactionID string
+	var recipientNonce []byte
+	rep.UnmarshalSignedAttribute(oidPKIStatus, &status)
+	rep.UnmarshalSignedAttribute(oidTransactionID, &transactionID)
+	rep.UnmarshalSignedAttribute(oidRecipientNonce, &recipientNonce)
+	if status != statusOK {
+		t.Fatalf("have pkiStatus %q, want %q", status, statusOK)
+	}
+	if transactionID != "transaction-1" || string(recipientNonce) != "0123456789abcdef" {
+		t.Errorf("have transactionID %q and recipientNonce %q, want the values of the request", transactionID, recipientNonce)
+	}
+
+	envelope, err := pkcs7.Parse(rep.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	degenerate, err := envelope.Decrypt(self, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := pkcs7.Parse(degenerate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs.Certificates) != 1 {
+		t.Fatalf("have %d certificates, want 1", len(certs.Certificates))
+	}
+	issued := certs.Certificates[0]
+	if err := issued.CheckSignatureFrom(ca.cert); err != nil {
+		t.Errorf("issued certificate is not signed by the CA: %s", err)
+	}
+	if issued.Subject.CommonName != "MDM Identity Certificate UDID" {
+		t.Errorf("have subject %q, want the subject of the request", issued.Subject.CommonName)
+	}
+}
+
+func TestPKIOperationBadChallenge(t *testing.T) {
+	ca := newTestCA(t)
+	msg, _, _ := pkcsReq(t, ca, "guess")
+	if status := pkiStatus(t, ca, msg); status != statusFailed {
+		t.Errorf("have pkiStatus %q, want %q", status, statusFailed)
+	}
+}
+
+func TestReplaceChallenge(t *testing.T) {
+	ca := newTestCA(t)
+	// a second enrollment profile uses the same challenge.
+	WithChallenges("shared", "shared")(ca)
+	ca.ReplaceChallenge("secret", "rotated")
+	ca.ReplaceChallenge("shared", "other")
+
+	var tests = []struct {
+		challenge string
+		status    string
+	}{
+		{"secret", statusFailed},
+		{"rotated", statusOK},
+		{"shared", statusOK},
+		{"other", statusOK},
+	}
+	for _, tt := range tests {
+		msg, _, _ := pkcsReq(t, ca, tt.challenge)
+		if status := pkiStatus(t, ca, msg); status != tt.status {
+			t.Errorf("%s: have pkiStatus %q, want %q", tt.challenge, status, tt.status)
+		}
+	}
+}
+
+func TestRenewal(t *testing.T) {
+	ca := newTestCA(t)
+	msg, self, key := pkcsReq(t, ca, "secret")
+	if status := pkiStatus(t, ca, msg); status != statusOK {
+		t.Fatalf("have pkiStatus %q, want %q", status, statusOK)
+	}
+	const cn = "MDM Identity Certificate 00000000-1111-2222-3333-444455556666"
+	csr, err := x509.ParseCertificateRequest(csrWithChallenge(t, key, cn, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := ca.issue(csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a renewal is signed with the certificate issued by the CA and has no challenge.
+	if status := pkiStatus(t, ca, signedRequest(t, ca, msgRenewal, cn, "", issued, key)); status != statusOK {
+		t.Errorf("renewal: have pkiStatus %q, want %q", status, statusOK)
+	}
+	if status := pkiStatus(t, ca, signedRequest(t, ca, msgRenewal, cn, "secret", self, key)); status != statusFailed {
+		t.Errorf("self-signed renewal: have pkiStatus %q, want %q", status, statusFailed)
+	}
+	// the renewed certificate would name another device.
+	other := "MDM Identity Certificate 99999999-1111-2222-3333-444455556666"
+	if status := pkiStatus(t, ca, signedRequest(t, ca, msgRenewal, other, "", issued, key)); status != statusFailed {
+		t.Errorf("renewal under another common name: have pkiStatus %q, want %q", status, statusFailed)
+	}
+}
+
+func TestNewCARequiresChallenge(t *testing.T) {
+	ca := newTestCA(t)
+	if _, err := newCA(ca.cert, ca.key); err != ErrNoChallenge {
+		t.Errorf("have %v, want %v", err, ErrNoChallenge)
+	}
+}
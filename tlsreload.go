@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	level "github.com/go-kit/kit/log/experimental_level"
+)
+
+// certReloadInterval is how long a loaded TLS certificate is used before
+// the files are read again.
+const certReloadInterval = 30 * time.Second
+
+// certReloader serves the TLS certificate for new connections from disk, so that
+// a certificate rotated by cert-manager or certbot is used without a restart.
+// If the files cannot be loaded, for example because the certificate was replaced
+// but the key not yet, the last good certificate is used.
+type certReloader struct {
+	certPath, keyPath string
+	logger            log.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	checkedAt time.Time
+	now       func() time.Time
+}
+
+// newCertReloader loads the certificate and key. It returns an error if they
+// cannot be loaded, there is no good certificate to fall back to yet.
+func newCertReloader(certPath, keyPath string, logger log.Logger) (*certReloader, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &certReloader{
+		certPath:  certPath,
+		keyPath:   keyPath,
+		logger:    logger,
+		cert:      &cert,
+		checkedAt: time.Now(),
+		now:       time.Now,
+	}, nil
+}
+
+// GetCertificate implements the tls.Config callback.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if now.Sub(r.checkedAt) < certReloadInterval {
+		return r.cert, nil
+	}
+	r.checkedAt = now
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		level.Warn(r.logger).Log("msg", "reloading TLS certificate failed, using the last good certificate", "err", err)
+		return r.cert, nil
+	}
+	if !bytes.Equal(cert.Certificate[0], r.cert.Certificate[0]) {
+		r.logger.Log("msg", "reloaded rotated TLS certificate", "cert", r.certPath)
+	}
+	r.cert = &cert
+	return r.cert, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// writeKeyPair writes a new self-signed certificate and its key to certPath and keyPath
+// and returns the DER encoded certificate.
+func writeKeyPair(t *testing.T, certPath, keyPath string, serial int64) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "micromdm.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsreload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+
+	first := writeKeyPair(t, certPath, keyPath, 1)
+	r, err := newCertReloader(certPath, keyPath, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	served := func() []byte {
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Certificate[0]
+	}
+
+	if !bytes.Equal(served(), first) {
+		t.Fatal("expected the certificate loaded at startup")
+	}
+
+	// the files are not read again within the reload interval.
+	second := writeKeyPair(t, certPath, keyPath, 2)
+	if !bytes.Equal(served(), first) {
+		t.Error("expected the certificate to be reloaded only after the reload interval")
+	}
+
+	now = now.Add(certReloadInterval)
+	if !bytes.Equal(served(), second) {
+		t.Error("expected the rotated certificate after the reload interval")
+	}
+
+	// a certificate replaced without its key falls back to the last good certificate.
+	writeKeyPair(t, certPath, filepath.Join(dir, "other.key"), 3)
+	now = now.Add(certReloadInterval)
+	if !bytes.Equal(served(), second) {
+		t.Error("expected the last good certificate if the key does not match")
+	}
+
+	// once the key is replaced as well, the new certificate is served.
+	third := writeKeyPair(t, certPath, keyPath, 4)
+	now = now.Add(certReloadInterval)
+	if !bytes.Equal(served(), third) {
+		t.Error("expected the certificate rotated with its key")
+	}
+}
@@ -0,0 +1,121 @@
+package user
+
+import (
+	"fmt"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq" // postgres driver
+	"github.com/micromdm/micromdm/dbpool"
+	"github.com/pkg/errors"
+)
+
+var (
+	insertUserStmt = `INSERT INTO devices_shared_users (
+		device_uuid,
+		user_name,
+		full_name,
+		uid,
+		user_guid,
+		is_logged_in,
+		has_data_to_sync,
+		data_quota,
+		data_used
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`
+
+	selectUsersByDeviceUUIDStmt = `SELECT
+		device_uuid,
+		user_name,
+		full_name,
+		uid,
+		user_guid,
+		is_logged_in,
+		has_data_to_sync,
+		data_quota,
+		data_used
+		FROM devices_shared_users
+		WHERE device_uuid = $1
+		ORDER BY user_name`
+)
+
+// This Datastore manages the users of shared iPads.
+type Datastore interface {
+	GetUsersByDeviceUUID(uuid string) ([]User, error)
+	ReplaceUsersByDeviceUUID(uuid string, users []User) error
+}
+
+type pgStore struct {
+	*sqlx.DB
+}
+
+func NewDB(driver, conn string, logger kitlog.Logger, opts ...dbpool.Option) (Datastore, error) {
+	switch driver {
+	case "postgres":
+		db, err := sqlx.Open(driver, conn)
+		if err != nil {
+			return nil, errors.Wrap(err, "users datastore")
+		}
+		dbpool.Configure(db.DB, opts...)
+		var dbError error
+		maxAttempts := 20
+		for attempts := 1; attempts <= maxAttempts; attempts++ {
+			dbError = db.Ping()
+			if dbError == nil {
+				break
+			}
+			logger.Log("msg", fmt.Sprintf("could not connect to postgres: %v", dbError))
+			time.Sleep(time.Duration(attempts) * time.Second)
+		}
+		if dbError != nil {
+			return nil, errors.Wrap(dbError, "users datastore")
+		}
+		return pgStore{DB: db}, nil
+	default:
+		return nil, errors.New("unknown driver")
+	}
+}
+
+func (store pgStore) GetUsersByDeviceUUID(uuid string) ([]User, error) {
+	var users []User
+	err := store.Select(&users, selectUsersByDeviceUUIDStmt, uuid)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore GetUsersByDeviceUUID")
+	}
+	return users, nil
+}
+
+// ReplaceUsersByDeviceUUID replaces the users of a device with the list
+// reported in the latest UserList response.
+func (store pgStore) ReplaceUsersByDeviceUUID(uuid string, users []User) error {
+	tx, err := store.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM devices_shared_users WHERE device_uuid = $1", uuid); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "pgStore ReplaceUsersByDeviceUUID")
+	}
+
+	for _, u := range users {
+		_, err := tx.Exec(
+			insertUserStmt,
+			uuid,
+			u.UserName,
+			u.FullName,
+			u.UID,
+			u.UserGUID,
+			u.IsLoggedIn,
+			u.HasDataToSync,
+			u.DataQuota,
+			u.DataUsed,
+		)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "pgStore ReplaceUsersByDeviceUUID")
+		}
+	}
+
+	return tx.Commit()
+}
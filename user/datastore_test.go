@@ -0,0 +1,43 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestReplaceUsersByDeviceUUID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := pgStore{DB: sqlx.NewDb(db, "mock")}
+	defer store.Close()
+
+	uuid := "00000000-1111-2222-3333-444455556666"
+	users := []User{
+		{UserName: "jappleseed", FullName: "John Appleseed", IsLoggedIn: true},
+		{UserName: "tcook", HasDataToSync: true, DataQuota: 1024, DataUsed: 512},
+	}
+
+	// the previous list is replaced in a single transaction.
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM devices_shared_users").
+		WithArgs(uuid).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("INSERT INTO devices_shared_users").
+		WithArgs(uuid, "jappleseed", "John Appleseed", 0, "", true, false, 0, 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO devices_shared_users").
+		WithArgs(uuid, "tcook", "", 0, "", false, true, 1024, 512).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := store.ReplaceUsersByDeviceUUID(uuid, users); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
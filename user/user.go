@@ -0,0 +1,15 @@
+package user
+
+// User is a user of a shared iPad, reported by the device
+// in response to a UserList command.
+type User struct {
+	DeviceUUID    string `db:"device_uuid" json:"device_uuid"`
+	UserName      string `db:"user_name" json:"user_name"`
+	FullName      string `db:"full_name" json:"full_name,omitempty"`
+	UID           int    `db:"uid" json:"uid,omitempty"`
+	UserGUID      string `db:"user_guid" json:"user_guid,omitempty"`
+	IsLoggedIn    bool   `db:"is_logged_in" json:"is_logged_in"`
+	HasDataToSync bool   `db:"has_data_to_sync" json:"has_data_to_sync"`
+	DataQuota     int64  `db:"data_quota" json:"data_quota,omitempty"`
+	DataUsed      int64  `db:"data_used" json:"data_used,omitempty"`
+}
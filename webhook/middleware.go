@@ -0,0 +1,75 @@
+package webhook
+
+import (
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/checkin"
+	"github.com/micromdm/micromdm/command"
+	"github.com/micromdm/micromdm/connect"
+	"golang.org/x/net/context"
+)
+
+// CheckinMiddleware posts an event when a device enrolls or checks out.
+func CheckinMiddleware(p *Poster) func(checkin.Service) checkin.Service {
+	return func(next checkin.Service) checkin.Service {
+		return checkinMiddleware{Service: next, poster: p}
+	}
+}
+
+type checkinMiddleware struct {
+	checkin.Service
+	poster *Poster
+}
+
+func (mw checkinMiddleware) Authenticate(cmd mdm.CheckinCommand, enrollment string) error {
+	err := mw.Service.Authenticate(cmd, enrollment)
+	if err == nil {
+		mw.poster.Post(Event{Topic: DeviceEnrolled, UDID: cmd.UDID})
+	}
+	return err
+}
+
+func (mw checkinMiddleware) Checkout(cmd mdm.CheckinCommand) error {
+	err := mw.Service.Checkout(cmd)
+	if err == nil {
+		mw.poster.Post(Event{Topic: DeviceCheckedOut, UDID: cmd.UDID})
+	}
+	return err
+}
+
+// ConnectMiddleware posts an event when a device acknowledges a command.
+// Devices do not always send the RequestType, so it is taken from the queued command.
+// Repeated acknowledgements of the same command do not post another event.
+func ConnectMiddleware(p *Poster, commands command.Service) func(connect.Service) connect.Service {
+	return func(next connect.Service) connect.Service {
+		return connectMiddleware{Service: next, poster: p, commands: commands}
+	}
+}
+
+type connectMiddleware struct {
+	connect.Service
+	poster   *Poster
+	commands command.Service
+}
+
+func (mw connectMiddleware) Acknowledge(ctx context.Context, req mdm.Response) (int, error) {
+	// the command leaves the queue once it is acknowledged, so it is looked up first.
+	acked, err := mw.commands.Acknowledged(req.CommandUUID)
+	if err != nil || acked {
+		return mw.Service.Acknowledge(ctx, req)
+	}
+	requestType := req.RequestType
+	if payload, err := mw.commands.Find(req.CommandUUID); err == nil && payload.Command != nil {
+		requestType = payload.Command.RequestType
+	}
+	total, err := mw.Service.Acknowledge(ctx, req)
+	if err == nil {
+		mw.poster.Post(Event{
+			Topic:       CommandAcknowledged,
+			UDID:        req.UDID,
+			CommandUUID: req.CommandUUID,
+			RequestType: requestType,
+			Status:      req.Status,
+		})
+	}
+	return total, err
+}
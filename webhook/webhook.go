@@ -0,0 +1,130 @@
+// Package webhook posts MDM events to an HTTP endpoint.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// Event topics
+const (
+	DeviceEnrolled      = "device.enrolled"
+	DeviceCheckedOut    = "device.checked_out"
+	CommandAcknowledged = "command.acknowledged"
+)
+
+// SignatureHeader holds the hex encoded HMAC-SHA256 of the request body,
+// computed with the shared secret.
+const SignatureHeader = "X-Micromdm-Signature"
+
+// Event is the JSON body posted to the webhook URL.
+type Event struct {
+	Topic       string    `json:"topic"`
+	CreatedAt   time.Time `json:"created_at"`
+	UDID        string    `json:"udid"`
+	CommandUUID string    `json:"command_uuid,omitempty"`
+	RequestType string    `json:"request_type,omitempty"`
+	Status      string    `json:"status,omitempty"`
+}
+
+// Poster sends events to a webhook URL.
+type Poster struct {
+	url     string
+	secret  []byte
+	client  *http.Client
+	logger  kitlog.Logger
+	retries int
+	backoff time.Duration
+}
+
+// New creates a Poster. Requests are signed with secret.
+func New(url, secret string, logger kitlog.Logger) *Poster {
+	return &Poster{
+		url:     url,
+		secret:  []byte(secret),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		logger:  logger,
+		retries: 5,
+		backoff: time.Second,
+	}
+}
+
+// Post sends the event in the background, retrying failed deliveries
+// with exponential backoff.
+func (p *Poster) Post(evt Event) {
+	if evt.CreatedAt.IsZero() {
+		evt.CreatedAt = time.Now().UTC()
+	}
+	body, err := json.Marshal(evt)
+	if err != nil {
+		p.logger.Log("msg", "encoding webhook event", "err", err)
+		return
+	}
+	go p.deliver(evt.Topic, body)
+}
+
+func (p *Poster) deliver(topic string, body []byte) {
+	wait := p.backoff
+	for attempt := 1; attempt <= p.retries; attempt++ {
+		err := p.send(body)
+		if err == nil {
+			return
+		}
+		if _, ok := err.(permanentError); ok || attempt == p.retries {
+			p.logger.Log("msg", "webhook delivery failed", "topic", topic, "attempts", attempt, "err", err)
+			return
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// permanentError is returned for responses which will not succeed on retry.
+type permanentError struct {
+	status int
+}
+
+func (e permanentError) Error() string {
+	return fmt.Sprintf("webhook: unexpected status %d", e.status)
+}
+
+func (p *Poster) send(body []byte) error {
+	req, err := http.NewRequest("POST", p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(p.secret, body))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("webhook: unexpected status %d", resp.StatusCode)
+	default:
+		return permanentError{status: resp.StatusCode}
+	}
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body.
+// Receivers can verify a request by comparing Sign(secret, body)
+// to the SignatureHeader with hmac.Equal.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
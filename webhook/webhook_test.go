@@ -0,0 +1,138 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/command"
+	"github.com/micromdm/micromdm/connect"
+	"golang.org/x/net/context"
+)
+
+func TestDeliverSignsAndRetries(t *testing.T) {
+	secret := "s3cret"
+	received := make(chan *http.Request, 1)
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if got, want := r.Header.Get(SignatureHeader), Sign([]byte(secret), body); got != want {
+			t.Errorf("signature: got %q, want %q", got, want)
+		}
+		var evt Event
+		if err := json.Unmarshal(body, &evt); err != nil {
+			t.Error(err)
+		}
+		if evt.Topic != DeviceEnrolled || evt.UDID != "UDID-1" {
+			t.Errorf("unexpected event %+v", evt)
+		}
+		received <- r
+	}))
+	defer srv.Close()
+
+	p := New(srv.URL, secret, kitlog.NewNopLogger())
+	p.backoff = time.Millisecond
+	p.Post(Event{Topic: DeviceEnrolled, UDID: "UDID-1"})
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestDeliverDoesNotRetryClientErrors(t *testing.T) {
+	attempts := make(chan struct{}, 5)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts <- struct{}{}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	p := New(srv.URL, "", kitlog.NewNopLogger())
+	p.backoff = time.Millisecond
+	p.deliver(DeviceCheckedOut, []byte(`{}`))
+
+	if n := len(attempts); n != 1 {
+		t.Errorf("expected a single attempt, got %d", n)
+	}
+}
+
+// fakeCommands holds a single queued payload until it is acknowledged.
+type fakeCommands struct {
+	command.Service
+	payload *mdm.Payload
+	acked   bool
+}
+
+func (f *fakeCommands) Acknowledged(commandUUID string) (bool, error) {
+	return f.acked, nil
+}
+
+func (f *fakeCommands) Find(commandUUID string) (*mdm.Payload, error) {
+	return f.payload, nil
+}
+
+type fakeConnect struct {
+	connect.Service
+	commands *fakeCommands
+}
+
+func (f fakeConnect) Acknowledge(ctx context.Context, req mdm.Response) (int, error) {
+	f.commands.acked = true
+	return 0, nil
+}
+
+func TestConnectMiddlewareAcknowledge(t *testing.T) {
+	events := make(chan Event, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var evt Event
+		if err := json.NewDecoder(r.Body).Decode(&evt); err != nil {
+			t.Error(err)
+		}
+		events <- evt
+	}))
+	defer srv.Close()
+
+	payload, err := mdm.NewPayload(&mdm.CommandRequest{UDID: "UDID-1", RequestType: "DeviceInformation"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := &fakeCommands{payload: payload}
+	svc := ConnectMiddleware(New(srv.URL, "", kitlog.NewNopLogger()), commands)(fakeConnect{commands: commands})
+
+	// devices do not echo the RequestType in their response.
+	resp := mdm.Response{UDID: "UDID-1", CommandUUID: payload.CommandUUID, Status: "Acknowledged"}
+	for i := 0; i < 2; i++ {
+		if _, err := svc.Acknowledge(context.Background(), resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case evt := <-events:
+		if evt.Topic != CommandAcknowledged || evt.CommandUUID != payload.CommandUUID || evt.RequestType != "DeviceInformation" {
+			t.Errorf("unexpected event %+v", evt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+	select {
+	case evt := <-events:
+		t.Errorf("expected a single event for a repeated acknowledgement, got %+v", evt)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,96 @@
+package workflow
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/micromdm/device"
+)
+
+// ErrInvalidCondition is returned when a step condition has an unknown attribute or operator.
+var ErrInvalidCondition = errors.New("workflow step condition has an unknown attribute or operator")
+
+// Condition operators
+const (
+	OpEquals         = "equals"
+	OpPrefix         = "prefix"
+	OpContains       = "contains"
+	OpVersionAtLeast = "version_at_least"
+	OpVersionBelow   = "version_below"
+)
+
+// Condition gates a workflow step on an attribute of the device, for example
+// {"attribute": "model", "operator": "prefix", "value": "iPad"}.
+// String comparisons ignore case.
+type Condition struct {
+	Attribute string `json:"attribute"`
+	Operator  string `json:"operator"`
+	Value     string `json:"value"`
+}
+
+// deviceAttributes returns the device attributes a condition can test.
+func deviceAttributes(dev *device.Device) map[string]string {
+	return map[string]string{
+		"model":            dev.Model,
+		"product_name":     dev.ProductName,
+		"os_version":       dev.OSVersion,
+		"build_version":    dev.BuildVersion,
+		"serial_number":    dev.SerialNumber.String,
+		"device_name":      dev.DeviceName,
+		"enrollment":       dev.Enrollment,
+		"dep_profile_uuid": dev.DEPProfileUUID,
+	}
+}
+
+// Validate checks the attribute and operator of the condition.
+func (c Condition) Validate() error {
+	if _, ok := deviceAttributes(&device.Device{})[c.Attribute]; !ok {
+		return ErrInvalidCondition
+	}
+	switch c.Operator {
+	case OpEquals, OpPrefix, OpContains, OpVersionAtLeast, OpVersionBelow:
+		return nil
+	}
+	return ErrInvalidCondition
+}
+
+// Matches reports whether the device satisfies the condition.
+func (c Condition) Matches(dev *device.Device) bool {
+	have := deviceAttributes(dev)[c.Attribute]
+	switch c.Operator {
+	case OpEquals:
+		return strings.EqualFold(have, c.Value)
+	case OpPrefix:
+		return strings.HasPrefix(strings.ToLower(have), strings.ToLower(c.Value))
+	case OpContains:
+		return strings.Contains(strings.ToLower(have), strings.ToLower(c.Value))
+	case OpVersionAtLeast:
+		return have != "" && compareVersions(have, c.Value) >= 0
+	case OpVersionBelow:
+		return have != "" && compareVersions(have, c.Value) < 0
+	}
+	return false
+}
+
+// compareVersions compares dotted version numbers like 10.3.1,
+// missing components count as 0.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
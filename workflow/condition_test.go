@@ -0,0 +1,90 @@
+package workflow
+
+import (
+	"testing"
+
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/device"
+)
+
+func TestConditionMatches(t *testing.T) {
+	ipad := &device.Device{Model: "iPad6,11", ProductName: "iPad6,11", OSVersion: "10.3.1"}
+	iphone := &device.Device{Model: "iPhone9,3", ProductName: "iPhone9,3", OSVersion: "9.3.5"}
+
+	var tests = []struct {
+		name  string
+		when  Condition
+		dev   *device.Device
+		match bool
+	}{
+		{"ipad model", Condition{"model", OpPrefix, "iPad"}, ipad, true},
+		{"ipad model, ignoring case", Condition{"model", OpPrefix, "ipad"}, ipad, true},
+		{"iphone model", Condition{"model", OpPrefix, "iPad"}, iphone, false},
+		{"product name", Condition{"product_name", OpEquals, "iPhone9,3"}, iphone, true},
+		{"os at least, newer", Condition{"os_version", OpVersionAtLeast, "10.3"}, ipad, true},
+		{"os at least, older", Condition{"os_version", OpVersionAtLeast, "10.3"}, iphone, false},
+		{"os at least, same", Condition{"os_version", OpVersionAtLeast, "10.3.1"}, ipad, true},
+		{"os below, older", Condition{"os_version", OpVersionBelow, "10"}, iphone, true},
+		{"os below, newer", Condition{"os_version", OpVersionBelow, "10"}, ipad, false},
+		{"os unknown", Condition{"os_version", OpVersionBelow, "10"}, &device.Device{}, false},
+	}
+
+	for _, tt := range tests {
+		if have := tt.when.Matches(tt.dev); have != tt.match {
+			t.Errorf("%s: expected match %v, got %v", tt.name, tt.match, have)
+		}
+	}
+}
+
+func TestRunNextSkipsSteps(t *testing.T) {
+	steps := []Step{
+		{Position: 1, Command: mdm.CommandRequest{RequestType: "InstallProfile"}, LibraryProfile: "kiosk",
+			When: &Condition{"model", OpPrefix, "iPad"}},
+		{Position: 2, Command: mdm.CommandRequest{RequestType: "ScheduleOSUpdate"},
+			When: &Condition{"os_version", OpVersionBelow, "10.3"}},
+		{Position: 3, Command: mdm.CommandRequest{RequestType: "DeviceInformation"}},
+	}
+
+	var tests = []struct {
+		name      string
+		dev       *device.Device
+		positions []int
+	}{
+		{"up to date ipad", &device.Device{Model: "iPad6,11", OSVersion: "10.3.1"}, []int{1, 3}},
+		{"old iphone", &device.Device{Model: "iPhone9,3", OSVersion: "10.2"}, []int{2, 3}},
+		{"up to date iphone", &device.Device{Model: "iPhone9,3", OSVersion: "10.3"}, []int{3}},
+	}
+
+	for _, tt := range tests {
+		var run Run
+		var positions []int
+		for {
+			step, ok := run.Next(steps, tt.dev)
+			if !ok {
+				break
+			}
+			positions = append(positions, step.Position)
+			run.Position = step.Position
+		}
+		if len(positions) != len(tt.positions) {
+			t.Errorf("%s: expected steps %v, got %v", tt.name, tt.positions, positions)
+			continue
+		}
+		for i := range positions {
+			if positions[i] != tt.positions[i] {
+				t.Errorf("%s: expected steps %v, got %v", tt.name, tt.positions, positions)
+				break
+			}
+		}
+	}
+}
+
+func TestValidateCondition(t *testing.T) {
+	wf := Workflow{Steps: []Step{{
+		Command: mdm.CommandRequest{RequestType: "Settings"},
+		When:    &Condition{"color", OpEquals, "gold"},
+	}}}
+	if err := wf.Validate(library{}); err != ErrInvalidCondition {
+		t.Errorf("expected ErrInvalidCondition, got %v", err)
+	}
+}
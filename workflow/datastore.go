@@ -8,6 +8,7 @@ import (
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq" // postgres driver
+	"github.com/micromdm/micromdm/dbpool"
 	"github.com/pkg/errors"
 )
 
@@ -24,6 +25,23 @@ type Datastore interface {
 	// UpdateWorkflow saves changes to a workflow in the datastore
 	UpdateWorkflow(wf *Workflow) (*Workflow, error)
 
+	// AssignWorkflow selects the workflow for devices enrolled with a DEP profile
+	// or an enrollment profile, replacing an earlier assignment.
+	AssignWorkflow(a Assignment) error
+	Assignments() ([]Assignment, error)
+	// AssignedWorkflow returns the UUID of the workflow for a device enrolled with
+	// the DEP profile or enrollment profile, or an empty string.
+	// An assignment to the DEP profile is preferred.
+	AssignedWorkflow(depProfileUUID, enrollment string) (string, error)
+
+	// SaveRun records the progress of a device through a workflow.
+	SaveRun(r *Run) error
+	// RunByCommand returns the running workflow which waits for the command,
+	// or ErrNoRun.
+	RunByCommand(commandUUID string) (*Run, error)
+	// RunByDevice returns the last workflow run of the device, or ErrNoRun.
+	RunByDevice(udid string) (*Run, error)
+
 	// CreateProfile adds a new profile to the datastore,
 	// If a profile already exists, an error will be returned
 	CreateProfile(p *Profile) (*Profile, error)
@@ -63,13 +81,14 @@ func addWhereFilters(stmt string, params ...interface{}) string {
 }
 
 //NewDB creates a Datastore
-func NewDB(driver, conn string, logger kitlog.Logger) (Datastore, error) {
+func NewDB(driver, conn string, logger kitlog.Logger, opts ...dbpool.Option) (Datastore, error) {
 	switch driver {
 	case "postgres":
 		db, err := sqlx.Open(driver, conn)
 		if err != nil {
 			return nil, errors.Wrap(err, "workflow datastore")
 		}
+		dbpool.Configure(db.DB, opts...)
 		var dbError error
 		maxAttempts := 20
 		for attempts := 1; attempts <= maxAttempts; attempts++ {
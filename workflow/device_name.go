@@ -0,0 +1,79 @@
+package workflow
+
+import (
+	"bytes"
+	"errors"
+	"text/template"
+
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/device"
+)
+
+// ErrInvalidDeviceName is returned when the device_name of a Settings step is not a valid template.
+var ErrInvalidDeviceName = errors.New("workflow step device_name must be a valid template, like {{.SerialNumber}}-iPad")
+
+// deviceNameData is the data a device_name template is rendered with.
+type deviceNameData struct {
+	UDID         string
+	SerialNumber string
+	AssetTag     string
+	Model        string
+	ProductName  string
+}
+
+// Request returns the command of the step for the device.
+// The DeviceName of a Settings command is rendered as a template,
+// so a step can name each device after its serial number or asset tag.
+func (s Step) Request(dev *device.Device) (mdm.CommandRequest, error) {
+	request := s.Command
+	request.UDID = dev.UDID.String
+	if request.RequestType != "Settings" {
+		return request, nil
+	}
+	data := deviceNameData{
+		UDID:         dev.UDID.String,
+		SerialNumber: dev.SerialNumber.String,
+		AssetTag:     dev.AssetTag,
+		Model:        dev.Model,
+		ProductName:  dev.ProductName,
+	}
+	// copy the settings, the step is shared by every device which runs the workflow.
+	settings := make([]mdm.Setting, len(s.Command.Settings.Settings))
+	for i, setting := range s.Command.Settings.Settings {
+		if setting.DeviceName != nil {
+			name, err := renderDeviceName(*setting.DeviceName, data)
+			if err != nil {
+				return request, err
+			}
+			setting.DeviceName = &name
+		}
+		settings[i] = setting
+	}
+	request.Settings.Settings = settings
+	return request, nil
+}
+
+func renderDeviceName(text string, data deviceNameData) (string, error) {
+	tmpl, err := template.New("device_name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", ErrInvalidDeviceName
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", ErrInvalidDeviceName
+	}
+	return buf.String(), nil
+}
+
+// validateDeviceNames checks the device_name templates of a Settings step.
+func (s Step) validateDeviceNames() error {
+	for _, setting := range s.Command.Settings.Settings {
+		if setting.DeviceName == nil {
+			continue
+		}
+		if _, err := renderDeviceName(*setting.DeviceName, deviceNameData{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package workflow
+
+import (
+	"errors"
+	"time"
+
+	"github.com/micromdm/micromdm/device"
+)
+
+// ErrNoRun is returned when no workflow run is waiting for a command,
+// or the device has not run a workflow.
+var ErrNoRun = errors.New("no workflow run for the command")
+
+// Run status
+const (
+	RunRunning   = "running"
+	RunCompleted = "completed"
+	RunFailed    = "failed"
+)
+
+// Run is the progress of a device through the steps of a workflow.
+// A device runs its workflow once, after the first DeviceConfigured.
+type Run struct {
+	DeviceUDID   string `json:"device_udid" db:"device_udid"`
+	WorkflowUUID string `json:"workflow_uuid" db:"workflow_uuid"`
+	// Position is the step which was queued last,
+	// CommandUUID is the command the run waits for.
+	Position    int       `json:"position" db:"position"`
+	CommandUUID string    `json:"command_uuid" db:"command_uuid"`
+	Status      string    `json:"status" db:"status"`
+	StartedAt   time.Time `json:"started_at" db:"started_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Next returns the first step after the last queued one which the device matches,
+// if there is one. Steps with a condition the device does not match are skipped.
+func (r Run) Next(steps []Step, dev *device.Device) (Step, bool) {
+	for _, step := range steps {
+		if step.Position <= r.Position {
+			continue
+		}
+		if step.When != nil && !step.When.Matches(dev) {
+			continue
+		}
+		return step, true
+	}
+	return Step{}, false
+}
@@ -0,0 +1,52 @@
+package workflow
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// sql statements
+var (
+	saveRunStmt = `INSERT INTO workflow_runs (device_udid, workflow_uuid, position, command_uuid, status, started_at, updated_at)
+				   VALUES (:device_udid, :workflow_uuid, :position, :command_uuid, :status, :started_at, :updated_at)
+				   ON CONFLICT (device_udid) DO UPDATE SET
+				   workflow_uuid = EXCLUDED.workflow_uuid, position = EXCLUDED.position,
+				   command_uuid = EXCLUDED.command_uuid, status = EXCLUDED.status,
+				   started_at = EXCLUDED.started_at, updated_at = EXCLUDED.updated_at`
+	selectRunByCommandStmt = `SELECT device_udid, workflow_uuid, position, command_uuid, status, started_at, updated_at
+							  FROM workflow_runs WHERE command_uuid = $1 AND status = 'running'`
+	selectRunByDeviceStmt = `SELECT device_udid, workflow_uuid, position, command_uuid, status, started_at, updated_at
+							 FROM workflow_runs WHERE device_udid = $1`
+)
+
+func (store pgStore) SaveRun(r *Run) error {
+	r.UpdatedAt = time.Now().UTC()
+	_, err := store.NamedExec(saveRunStmt, r)
+	return errors.Wrap(err, "pgStore save workflow run")
+}
+
+func (store pgStore) RunByCommand(commandUUID string) (*Run, error) {
+	var r Run
+	err := store.Get(&r, selectRunByCommandStmt, commandUUID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoRun
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore workflow run by command")
+	}
+	return &r, nil
+}
+
+func (store pgStore) RunByDevice(udid string) (*Run, error) {
+	var r Run
+	err := store.Get(&r, selectRunByDeviceStmt, udid)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoRun
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "pgStore workflow run by device")
+	}
+	return &r, nil
+}
@@ -0,0 +1,101 @@
+package workflow
+
+import (
+	"errors"
+
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/profile"
+)
+
+var (
+	// ErrInvalidStep is returned when a workflow step has no command,
+	// or names a library profile for a command other than InstallProfile.
+	ErrInvalidStep = errors.New("workflow step must have a request_type, library_profile is only valid with InstallProfile")
+	// ErrInvalidAssignment is returned when a workflow is assigned to something
+	// other than a DEP profile or an enrollment profile.
+	ErrInvalidAssignment = errors.New("workflow assignment type must be dep_profile or enrollment")
+	// ErrEraseStep is returned for an EraseDevice step. Erasing a device needs the erase scope
+	// and the serial number as confirmation, it cannot be part of a workflow.
+	ErrEraseStep = errors.New("workflow steps can not erase devices")
+	// ErrUnknownLibraryProfile is returned when a workflow step names a profile
+	// which is not in the profile library.
+	ErrUnknownLibraryProfile = errors.New("workflow step library_profile is not in the profile library")
+)
+
+// ProfileLibrary finds the profiles named by the steps of a workflow.
+// It is implemented by profile.Datastore.
+type ProfileLibrary interface {
+	LibraryProfile(name string, version int) (*profile.LibraryProfile, error)
+}
+
+// Step is a command queued for a device which runs the workflow.
+// Steps run in the order of Position, each one after the previous was acknowledged.
+type Step struct {
+	Position int `json:"position" db:"position"`
+	// Command is queued for the device, the UDID is filled in when the step runs.
+	// The DeviceName of a Settings command is a template, see Request.
+	Command mdm.CommandRequest `json:"command"`
+	// LibraryProfile installs the latest version of the named profile
+	// in the profile library, instead of the payload of an InstallProfile command.
+	LibraryProfile string `json:"library_profile,omitempty" db:"library_profile"`
+	// When skips the step if the device does not match when the step would run.
+	When *Condition `json:"when,omitempty"`
+}
+
+// Validate checks the steps of a workflow, and that the profiles they name are in library.
+func (wf Workflow) Validate(library ProfileLibrary) error {
+	for _, step := range wf.Steps {
+		if step.Command.RequestType == "" {
+			return ErrInvalidStep
+		}
+		if step.LibraryProfile != "" && step.Command.RequestType != "InstallProfile" {
+			return ErrInvalidStep
+		}
+		if step.Command.RequestType == "EraseDevice" {
+			return ErrEraseStep
+		}
+		if step.LibraryProfile != "" {
+			_, err := library.LibraryProfile(step.LibraryProfile, 0)
+			if err == profile.ErrLibraryProfileNotFound {
+				return ErrUnknownLibraryProfile
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err := step.validateDeviceNames(); err != nil {
+			return err
+		}
+		if step.When != nil {
+			if err := step.When.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Assignment types
+const (
+	// AssignDEPProfile runs the workflow on devices enrolled with a DEP profile UUID.
+	AssignDEPProfile = "dep_profile"
+	// AssignEnrollment runs the workflow on devices enrolled with a named enrollment profile.
+	// An empty value is the default enrollment profile.
+	AssignEnrollment = "enrollment"
+)
+
+// Assignment selects the workflow a device runs after enrollment.
+type Assignment struct {
+	WorkflowUUID string `json:"workflow_uuid" db:"workflow_uuid"`
+	Type         string `json:"type" db:"type"`
+	Value        string `json:"value" db:"value"`
+}
+
+// Validate checks the type of the assignment.
+func (a Assignment) Validate() error {
+	switch a.Type {
+	case AssignDEPProfile, AssignEnrollment:
+		return nil
+	}
+	return ErrInvalidAssignment
+}
@@ -0,0 +1,103 @@
+package workflow
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// sql statements
+var (
+	insertStepStmt = `INSERT INTO workflow_steps (workflow_uuid, position, command, library_profile, condition)
+					  VALUES ($1, $2, $3, $4, $5)`
+	selectStepsStmt = `SELECT position, command, library_profile, condition FROM workflow_steps
+					   WHERE workflow_uuid = $1 ORDER BY position`
+	assignWorkflowStmt = `INSERT INTO workflow_assignments (workflow_uuid, type, value) VALUES ($1, $2, $3)
+						  ON CONFLICT (type, value) DO UPDATE SET workflow_uuid = EXCLUDED.workflow_uuid`
+	selectAssignmentsStmt = `SELECT workflow_uuid, type, value FROM workflow_assignments ORDER BY type, value`
+	// a DEP profile assignment is more specific than the enrollment profile.
+	selectAssignedWorkflowStmt = `SELECT workflow_uuid FROM workflow_assignments
+								  WHERE (type = 'dep_profile' AND value = $1 AND $1 <> '')
+								  OR (type = 'enrollment' AND value = $2)
+								  ORDER BY type = 'dep_profile' DESC
+								  LIMIT 1`
+)
+
+// replaceSteps replaces the steps of a workflow, numbering them in order.
+func (store pgStore) replaceSteps(wfUUID string, steps []Step) error {
+	tx, err := store.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "pgStore replace workflow steps")
+	}
+	if _, err := tx.Exec(`DELETE FROM workflow_steps WHERE workflow_uuid = $1`, wfUUID); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "pgStore replace workflow steps")
+	}
+	for i := range steps {
+		steps[i].Position = i + 1
+		command, err := json.Marshal(steps[i].Command)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		var condition []byte
+		if steps[i].When != nil {
+			if condition, err = json.Marshal(steps[i].When); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if _, err := tx.Exec(insertStepStmt, wfUUID, steps[i].Position, command, steps[i].LibraryProfile, condition); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "pgStore replace workflow steps")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "pgStore replace workflow steps")
+}
+
+// find steps for a workflow
+func (store pgStore) findStepsForWorkflow(wfUUID string) ([]Step, error) {
+	var rows []struct {
+		Position       int    `db:"position"`
+		Command        []byte `db:"command"`
+		LibraryProfile string `db:"library_profile"`
+		Condition      []byte `db:"condition"`
+	}
+	if err := store.Select(&rows, selectStepsStmt, wfUUID); err != nil {
+		return nil, errors.Wrap(err, "pgStore find workflow steps")
+	}
+	steps := make([]Step, len(rows))
+	for i, row := range rows {
+		steps[i] = Step{Position: row.Position, LibraryProfile: row.LibraryProfile}
+		if err := json.Unmarshal(row.Command, &steps[i].Command); err != nil {
+			return nil, err
+		}
+		if row.Condition != nil {
+			if err := json.Unmarshal(row.Condition, &steps[i].When); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return steps, nil
+}
+
+func (store pgStore) AssignWorkflow(a Assignment) error {
+	_, err := store.Exec(assignWorkflowStmt, a.WorkflowUUID, a.Type, a.Value)
+	return errors.Wrap(err, "pgStore assign workflow")
+}
+
+func (store pgStore) Assignments() ([]Assignment, error) {
+	var assignments []Assignment
+	err := store.Select(&assignments, selectAssignmentsStmt)
+	return assignments, errors.Wrap(err, "pgStore workflow assignments")
+}
+
+func (store pgStore) AssignedWorkflow(depProfileUUID, enrollment string) (string, error) {
+	var wfUUID string
+	err := store.Get(&wfUUID, selectAssignedWorkflowStmt, depProfileUUID, enrollment)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return wfUUID, errors.Wrap(err, "pgStore assigned workflow")
+}
@@ -0,0 +1,85 @@
+package workflow
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/micromdm/mdm"
+	"github.com/micromdm/micromdm/device"
+	"github.com/micromdm/micromdm/profile"
+)
+
+// library is a profile library with the named profiles.
+type library map[string]bool
+
+func (l library) LibraryProfile(name string, version int) (*profile.LibraryProfile, error) {
+	if !l[name] {
+		return nil, profile.ErrLibraryProfileNotFound
+	}
+	return &profile.LibraryProfile{}, nil
+}
+
+func settingsStep(name string) Step {
+	return Step{Command: mdm.CommandRequest{
+		RequestType: "Settings",
+		Settings:    mdm.Settings{Settings: []mdm.Setting{{Item: "DeviceName", DeviceName: &name}}},
+	}}
+}
+
+func TestValidateSteps(t *testing.T) {
+	var tests = []struct {
+		name string
+		step Step
+		err  error
+	}{
+		{"command", Step{Command: mdm.CommandRequest{RequestType: "Settings"}}, nil},
+		{"library profile", Step{Command: mdm.CommandRequest{RequestType: "InstallProfile"}, LibraryProfile: "wifi"}, nil},
+		{"no request type", Step{}, ErrInvalidStep},
+		{"library profile for other command", Step{Command: mdm.CommandRequest{RequestType: "Settings"}, LibraryProfile: "wifi"}, ErrInvalidStep},
+		{"unknown library profile", Step{Command: mdm.CommandRequest{RequestType: "InstallProfile"}, LibraryProfile: "vpn"}, ErrUnknownLibraryProfile},
+		{"erase device", Step{Command: mdm.CommandRequest{RequestType: "EraseDevice"}}, ErrEraseStep},
+		{"device name template", settingsStep("{{.SerialNumber}}-iPad"), nil},
+		{"device name unknown field", settingsStep("{{.Serial}}-iPad"), ErrInvalidDeviceName},
+		{"device name bad template", settingsStep("{{.SerialNumber-iPad"), ErrInvalidDeviceName},
+	}
+
+	for _, tt := range tests {
+		wf := Workflow{Name: "onboarding", Steps: []Step{tt.step}}
+		if err := wf.Validate(library{"wifi": true}); err != tt.err {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestValidateAssignment(t *testing.T) {
+	for _, typ := range []string{AssignDEPProfile, AssignEnrollment} {
+		if err := (Assignment{Type: typ}).Validate(); err != nil {
+			t.Errorf("%s: expected a valid assignment, got %v", typ, err)
+		}
+	}
+	if err := (Assignment{Type: "group"}).Validate(); err != ErrInvalidAssignment {
+		t.Errorf("expected ErrInvalidAssignment, got %v", err)
+	}
+}
+
+func TestStepRequestDeviceName(t *testing.T) {
+	step := settingsStep("{{.SerialNumber}}-iPad")
+	dev := &device.Device{
+		UDID:         device.JsonNullString{NullString: sql.NullString{String: "udid-1", Valid: true}},
+		SerialNumber: device.JsonNullString{NullString: sql.NullString{String: "DMPQ1234ABCD", Valid: true}},
+	}
+	request, err := step.Request(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.UDID != "udid-1" {
+		t.Errorf("expected udid-1, got %q", request.UDID)
+	}
+	if have, want := *request.Settings.Settings[0].DeviceName, "DMPQ1234ABCD-iPad"; have != want {
+		t.Errorf("expected device name %q, got %q", want, have)
+	}
+	// the step is shared by every device which runs the workflow.
+	if have := *step.Command.Settings.Settings[0].DeviceName; have != "{{.SerialNumber}}-iPad" {
+		t.Errorf("expected the step template to be unchanged, got %q", have)
+	}
+}
@@ -7,11 +7,13 @@ var ErrExists = errors.New("resource already exists in the datastore")
 
 // Workflow describes a workflow that a device will execute
 // A workflow contains a list of configuration profiles,
-// Applications and included workflows
+// Applications and included workflows, and the ordered steps
+// which run after enrollment.
 type Workflow struct {
 	UUID     string    `json:"uuid" db:"workflow_uuid"`
 	Name     string    `json:"name" db:"name"`
 	Profiles []Profile `json:"profiles"`
+	Steps    []Step    `json:"steps,omitempty"`
 	// Applications      []application
 	// IncludedWorkflows []Workflow
 }
@@ -41,6 +41,9 @@ func (store pgStore) CreateWorkflow(wf *Workflow) (*Workflow, error) {
 	if err := store.addProfiles(wf.UUID, profiles...); err != nil {
 		return nil, err
 	}
+	if err := store.replaceSteps(wf.UUID, wf.Steps); err != nil {
+		return nil, err
+	}
 	return wf, nil
 }
 
@@ -63,6 +66,10 @@ func (store pgStore) UpdateWorkflow(wf *Workflow) (*Workflow, error) {
 		return nil, err
 	}
 	retWf.Profiles = wf.Profiles
+	if err := store.replaceSteps(retWf.UUID, wf.Steps); err != nil {
+		return nil, err
+	}
+	retWf.Steps = wf.Steps
 	return &retWf, nil
 }
 
@@ -142,6 +149,10 @@ func (store pgStore) Workflows(params ...interface{}) ([]Workflow, error) {
 		if err != nil {
 			return nil, err
 		}
+		wf.Steps, err = store.findStepsForWorkflow(wf.UUID)
+		if err != nil {
+			return nil, err
+		}
 		withProfiles = append(withProfiles, wf)
 	}
 	return withProfiles, nil